@@ -1,13 +1,20 @@
 package api
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/mustur/mockgrid/app/api/middleware"
+	"github.com/mustur/mockgrid/app/api/store"
+	"github.com/mustur/mockgrid/internal/clock"
+	"github.com/mustur/mockgrid/internal/httpjson"
+	"github.com/mustur/mockgrid/internal/metrics"
 )
 
 // Service defines the interface that all services must implement.
@@ -17,29 +24,330 @@ type Service interface {
 	Chain() middleware.Middleware
 }
 
+// PatternLister is implemented by services that can list the patterns
+// registered on their mux, relative to their root.
+type PatternLister interface {
+	Patterns() []string
+}
+
+// Mux is an http.ServeMux that records the patterns registered on it, so a
+// service can implement PatternLister from the same registrations that
+// build its GetMux.
+type Mux struct {
+	*http.ServeMux
+	patterns []string
+}
+
+// NewMux returns an empty Mux.
+func NewMux() *Mux {
+	return &Mux{ServeMux: http.NewServeMux()}
+}
+
+// Handle registers handler for pattern and records the pattern.
+func (m *Mux) Handle(pattern string, handler http.Handler) {
+	m.ServeMux.Handle(pattern, handler)
+	m.patterns = append(m.patterns, pattern)
+}
+
+// HandleFunc registers handler for pattern and records the pattern.
+func (m *Mux) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	m.ServeMux.HandleFunc(pattern, handler)
+	m.patterns = append(m.patterns, pattern)
+}
+
+// Patterns returns the registered patterns in registration order.
+func (m *Mux) Patterns() []string {
+	return m.patterns
+}
+
+// MessageObserver is implemented by services that can report each message
+// they store, such as the mail send service.
+type MessageObserver interface {
+	OnMessage(fn func(*store.Message))
+}
+
+// Route describes a service registered on a listener.
+type Route struct {
+	Address  string   `json:"address"`
+	Root     string   `json:"root"`
+	Patterns []string `json:"patterns,omitempty"` // only for services implementing PatternLister
+}
+
+// Pinger reports whether a dependency is ready to serve requests.
+type Pinger interface {
+	Ping() error
+}
+
+// MessageCounter reports how many messages are stored.
+type MessageCounter interface {
+	Count() (int, error)
+}
+
+// Drainer finishes background work, such as pending webhook deliveries,
+// before the server exits.
+type Drainer interface {
+	Drain(ctx context.Context) error
+}
+
+// readyPollInterval is the delay between readiness probes during startup.
+const readyPollInterval = 200 * time.Millisecond
+
+// shutdownTimeout bounds how long a failed Start or Stop waits for
+// listeners to drain.
+const shutdownTimeout = 5 * time.Second
+
 // MockGrid is the main application server.
 type MockGrid struct {
-	services   []Service
-	listenAddr string
+	services     []Service
+	listenAddr   string
+	listeners    []listener
+	ready        Pinger
+	readyTimeout time.Duration
+	maxInFlight  int
+	middleware   []middleware.Middleware // wraps every service, outside its own chain
+
+	// TLS serving; empty certFile serves plain HTTP
+	certFile   string
+	keyFile    string
+	minVersion uint16
+
+	// health details reported by GET /health?verbose=true
+	clock       clock.Clock
+	startedAt   time.Time
+	counter     MessageCounter
+	storageType string
+
+	drainers []Drainer // drained by Shutdown once the servers have stopped
+
+	mu      sync.Mutex
+	servers []*http.Server
+	stopped bool          // set by Shutdown; a Start still waiting for readiness then serves nothing
+	stop    chan struct{} // closed by Shutdown to end the readiness wait
+}
+
+// listener is an additional address serving its own set of services.
+type listener struct {
+	addr     string
+	services []Service
 }
 
 // New creates a new MockGrid instance with the given services.
 func New(listenAddr string, services ...Service) *MockGrid {
+	clk := clock.RealClock{}
 	return &MockGrid{
 		listenAddr: listenAddr,
 		services:   services,
+		clock:      clk,
+		startedAt:  clk.Now(),
+		stop:       make(chan struct{}),
 	}
 }
 
-// Start initializes and starts the HTTP server.
+// WithReadiness makes Start wait until p responds to Ping, for at most
+// timeout, before registering routes and accepting connections.
+func (m *MockGrid) WithReadiness(p Pinger, timeout time.Duration) *MockGrid {
+	m.ready = p
+	m.readyTimeout = timeout
+	return m
+}
+
+// WithMaxInFlight limits the number of requests served concurrently across
+// all services. Requests over the limit receive a 503. Zero means unlimited.
+func (m *MockGrid) WithMaxInFlight(n int) *MockGrid {
+	m.maxInFlight = n
+	return m
+}
+
+// WithMiddleware wraps every service's handler with mws, outermost first.
+// The global stack runs before each service's own Chain.
+func (m *MockGrid) WithMiddleware(mws ...middleware.Middleware) *MockGrid {
+	m.middleware = append(m.middleware, mws...)
+	return m
+}
+
+// WithTLS serves every listener over HTTPS with the given certificate and
+// key, refusing clients below minVersion (e.g. tls.VersionTLS12). A zero
+// minVersion means TLS 1.2.
+func (m *MockGrid) WithTLS(certFile, keyFile string, minVersion uint16) *MockGrid {
+	if minVersion == 0 {
+		minVersion = tls.VersionTLS12
+	}
+	m.certFile = certFile
+	m.keyFile = keyFile
+	m.minVersion = minVersion
+	return m
+}
+
+// WithHealthDetails adds uptime, the message count and the storage type to
+// GET /health?verbose=true. Uptime is measured on clk from this call; a nil
+// clk means the real clock. A nil counter omits the message count.
+func (m *MockGrid) WithHealthDetails(counter MessageCounter, storageType string, clk clock.Clock) *MockGrid {
+	if clk == nil {
+		clk = clock.RealClock{}
+	}
+	m.clock = clk
+	m.startedAt = clk.Now()
+	m.counter = counter
+	m.storageType = storageType
+	return m
+}
+
+// WithDrainer makes Shutdown drain d after the servers have stopped, so
+// work started by the last requests can finish within the same deadline.
+func (m *MockGrid) WithDrainer(d Drainer) *MockGrid {
+	m.drainers = append(m.drainers, d)
+	return m
+}
+
+// WithListener serves services on an additional address, separate from the
+// main listen address, e.g. to expose admin endpoints only on localhost.
+// Each listener gets its own /health endpoint and in-flight limit.
+func (m *MockGrid) WithListener(addr string, services ...Service) *MockGrid {
+	m.listeners = append(m.listeners, listener{addr: addr, services: services})
+	return m
+}
+
+// OnMessage registers fn with every service, on any listener, that
+// implements MessageObserver. Embedders use it to observe sent mail in-process
+// instead of polling the store; fn runs synchronously before the send
+// responds.
+func (m *MockGrid) OnMessage(fn func(*store.Message)) {
+	register := func(services []Service) {
+		for _, svc := range services {
+			if obs, ok := svc.(MessageObserver); ok {
+				obs.OnMessage(fn)
+			}
+		}
+	}
+	register(m.services)
+	for _, l := range m.listeners {
+		register(l.services)
+	}
+}
+
+// Routes lists every registered service root by listener, the main address
+// first, in registration order.
+func (m *MockGrid) Routes() []Route {
+	var routes []Route
+	add := func(addr string, services []Service) {
+		for _, svc := range services {
+			r := Route{Address: addr, Root: svc.GetRoot()}
+			if pl, ok := svc.(PatternLister); ok {
+				r.Patterns = pl.Patterns()
+			}
+			routes = append(routes, r)
+		}
+	}
+	add(m.listenAddr, m.services)
+	for _, l := range m.listeners {
+		add(l.addr, l.services)
+	}
+	return routes
+}
+
+// Start initializes and starts an HTTP server for the main address and each
+// additional listener, blocking until all have stopped. If any server fails,
+// the others are shut down and the first error is returned. A Shutdown while
+// Start is still waiting for readiness makes it return nil without serving.
 func (m *MockGrid) Start() error {
 	if len(m.services) == 0 {
 		return errors.New("no services registered")
 	}
 
+	if err := m.waitReady(); err != nil {
+		return err
+	}
+
+	servers := []*http.Server{m.newServer(m.listenAddr, m.services)}
+	for _, l := range m.listeners {
+		servers = append(servers, m.newServer(l.addr, l.services))
+	}
+	m.mu.Lock()
+	if m.stopped {
+		m.mu.Unlock()
+		slog.Info("mockgrid server stopped before serving")
+		return nil
+	}
+	m.servers = servers
+	m.mu.Unlock()
+
+	errCh := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func() {
+			if m.certFile != "" {
+				slog.Info("starting mockgrid HTTPS server", "address", srv.Addr)
+				errCh <- srv.ListenAndServeTLS(m.certFile, m.keyFile)
+				return
+			}
+			slog.Info("starting mockgrid HTTP server", "address", srv.Addr)
+			errCh <- srv.ListenAndServe()
+		}()
+	}
+
+	var firstErr error
+	for range servers {
+		err := <-errCh
+		if err == nil || errors.Is(err, http.ErrServerClosed) || firstErr != nil {
+			continue
+		}
+		firstErr = fmt.Errorf("failed to start server: %w", err)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		if err := m.Shutdown(ctx); err != nil {
+			slog.Error("failed to shut down servers", "err", err)
+		}
+		cancel()
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+	slog.Info("mockgrid server shutdown")
+	return nil
+}
+
+// Shutdown gracefully stops every running server, waiting for in-flight
+// requests until ctx expires. Connections still open by then are closed.
+// Each drainer is then drained until ctx expires. Called before the servers
+// have started, it stops Start from starting them.
+func (m *MockGrid) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	servers := m.servers
+	if !m.stopped {
+		m.stopped = true
+		close(m.stop)
+	}
+	m.mu.Unlock()
+
+	var errs []error
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("shutdown %s: %w", srv.Addr, err))
+			if err := srv.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("close %s: %w", srv.Addr, err))
+			}
+		}
+	}
+	for _, d := range m.drainers {
+		if err := d.Drain(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("drain: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Stop gracefully stops every running server, waiting up to five seconds
+// for in-flight requests, so a blocked Start returns. Callers needing a
+// different deadline use Shutdown.
+func (m *MockGrid) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return m.Shutdown(ctx)
+}
+
+// newServer builds an HTTP server routing to the given services.
+func (m *MockGrid) newServer(addr string, services []Service) *http.Server {
 	mux := http.NewServeMux()
 
-	for _, svc := range m.services {
+	for _, svc := range services {
 		root := svc.GetRoot()
 		handler := svc.Chain()(svc.GetMux())
 		// StripPrefix needs the path without trailing slash to avoid redirect issues
@@ -48,35 +356,106 @@ func (m *MockGrid) Start() error {
 		if len(stripPath) > 1 && stripPath[len(stripPath)-1] == '/' {
 			stripPath = stripPath[:len(stripPath)-1]
 		}
-		mux.Handle(root, http.StripPrefix(stripPath, handler))
-		slog.Info("registered service", "root", root)
+		global := middleware.Chain(m.middleware...)
+		svcHandler := middleware.Metrics()(global(http.StripPrefix(stripPath, handler)))
+		mux.Handle(root, svcHandler)
+		if stripPath != root {
+			// The mux would answer the bare collection path with a 301 to
+			// the slashed root, which clients follow as a GET without the
+			// body, so serve it as the root directly
+			mux.Handle(stripPath, servedAs(root, svcHandler))
+		}
+		slog.Info("registered service", "root", root, "address", addr)
 	}
 
-	// health and root endpoints
-	mux.HandleFunc("GET /health", handleHealth)
+	// health, metrics and root endpoints
+	mux.HandleFunc("GET /health", m.handleHealth)
+	mux.Handle("GET /metrics", metrics.Handler())
 
 	srv := &http.Server{
-		Addr:         m.listenAddr,
-		Handler:      mux,
+		Addr:         addr,
+		Handler:      middleware.BallAndChain(middleware.Recover(), middleware.MaxInFlight(m.maxInFlight))(mux),
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 20 * time.Second,
 		IdleTimeout:  120 * time.Second,
 	}
+	if m.certFile != "" {
+		srv.TLSConfig = &tls.Config{MinVersion: m.minVersion}
+	}
+	return srv
+}
+
+// servedAs serves requests through next as if they had matched root.
+func servedAs(root string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = root
+		r2.URL.RawPath = ""
+		r2.Pattern = root
+		next.ServeHTTP(w, r2)
+	})
+}
+
+// waitReady polls the readiness check until it succeeds, the timeout elapses
+// or Shutdown is called.
+func (m *MockGrid) waitReady() error {
+	if m.ready == nil {
+		return nil
+	}
 
-	slog.Info("starting mockgrid HTTP server", "address", m.listenAddr)
-	if err := srv.ListenAndServe(); err != nil {
-		if err == http.ErrServerClosed {
-			slog.Info("mockgrid server shutdown")
+	deadline := time.Now().Add(m.readyTimeout)
+	for attempt := 1; ; attempt++ {
+		err := m.ready.Ping()
+		if err == nil {
+			slog.Info("store is ready", "attempts", attempt)
+			return nil
+		}
+		if time.Now().Add(readyPollInterval).After(deadline) {
+			return fmt.Errorf("store not ready after %s: %w", m.readyTimeout, err)
+		}
+		slog.Warn("store not ready, retrying", "attempt", attempt, "err", err)
+		select {
+		case <-time.After(readyPollInterval):
+		case <-m.stop:
 			return nil
 		}
-		return fmt.Errorf("failed to start server: %w", err)
 	}
-	return nil
 }
 
-// handleHealth returns a simple health check response.
-func handleHealth(w http.ResponseWriter, _ *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte(`{"status":"healthy"}`))
+// healthResponse is the verbose health payload.
+type healthResponse struct {
+	Status        string `json:"status"`
+	Uptime        string `json:"uptime"`
+	UptimeSeconds int64  `json:"uptime_seconds"`
+	Messages      *int   `json:"messages,omitempty"`
+	Storage       string `json:"storage,omitempty"`
+}
+
+// handleHealth returns a simple health check response. With ?verbose=true
+// it also reports uptime, the stored message count and the storage type.
+func (m *MockGrid) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("verbose") != "true" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"status":"healthy"}`))
+		return
+	}
+
+	uptime := m.clock.Now().Sub(m.startedAt).Truncate(time.Second)
+	resp := healthResponse{
+		Status:        "healthy",
+		Uptime:        uptime.String(),
+		UptimeSeconds: int64(uptime / time.Second),
+		Storage:       m.storageType,
+	}
+	if m.counter != nil {
+		n, err := m.counter.Count()
+		if err != nil {
+			slog.Error("failed to count messages", "err", err)
+		} else {
+			resp.Messages = &n
+		}
+	}
+
+	httpjson.Write(w, http.StatusOK, resp)
 }
@@ -0,0 +1,554 @@
+package api_test
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mustur/mockgrid/app/api"
+	"github.com/mustur/mockgrid/app/api/store"
+	"github.com/mustur/mockgrid/app/api/svc/sendmail"
+	"github.com/mustur/mockgrid/internal/clock"
+	"github.com/mustur/mockgrid/internal/testutil"
+)
+
+func TestStart_WaitsForReadiness(t *testing.T) {
+	addr := freeAddr(t)
+	p := &delayedPinger{readyAt: time.Now().Add(500 * time.Millisecond)}
+
+	svc := testutil.NewMockService("/api/").
+		HandleFunc("GET /ping", func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+	mg := api.New(addr, svc).WithReadiness(p, 5*time.Second)
+
+	go func() { _ = mg.Start() }()
+
+	// Before the store is ready nothing should be listening
+	time.Sleep(100 * time.Millisecond)
+	if _, err := http.Get("http://" + addr + "/api/ping"); err == nil {
+		t.Fatal("expected server not to accept connections before store is ready")
+	}
+
+	resp := waitForServer(t, "http://"+addr+"/api/ping")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200, got %d", resp.StatusCode)
+	}
+	if p.Calls() < 2 {
+		t.Errorf("expected multiple readiness probes, got %d", p.Calls())
+	}
+}
+
+func TestStart_ReadinessTimeout_ReturnsError(t *testing.T) {
+	p := &delayedPinger{readyAt: time.Now().Add(time.Hour)}
+	mg := api.New(freeAddr(t), testutil.NewMockService("/api/")).
+		WithReadiness(p, 300*time.Millisecond)
+
+	if err := mg.Start(); err == nil {
+		t.Fatal("expected error when store never becomes ready")
+	}
+}
+
+func TestStart_MultipleListeners_ServeSeparateRoutes(t *testing.T) {
+	apiAddr, adminAddr := freeAddr(t), freeAddr(t)
+
+	apiSvc := testutil.NewMockService("/api/").
+		HandleFunc("GET /ping", func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+	uiSvc := testutil.NewMockService("/ui/").
+		HandleFunc("GET /", func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+	mg := api.New(apiAddr, apiSvc).WithListener(adminAddr, uiSvc)
+
+	done := make(chan error, 1)
+	go func() { done <- mg.Start() }()
+
+	for _, tc := range []struct {
+		url  string
+		want int
+	}{
+		{"http://" + apiAddr + "/api/ping", http.StatusOK},
+		{"http://" + adminAddr + "/ui/", http.StatusOK},
+		{"http://" + apiAddr + "/ui/", http.StatusNotFound},
+		{"http://" + adminAddr + "/api/ping", http.StatusNotFound},
+	} {
+		resp := waitForServer(t, tc.url)
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("GET %s: expected %d, got %d", tc.url, tc.want, resp.StatusCode)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := mg.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected clean stop, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start did not return after Shutdown")
+	}
+	for _, addr := range []string{apiAddr, adminAddr} {
+		if _, err := http.Get("http://" + addr + "/health"); err == nil {
+			t.Errorf("expected %s to stop listening", addr)
+		}
+	}
+}
+
+func TestStop_StartReturnsCleanly(t *testing.T) {
+	addr := freeAddr(t)
+	svc := testutil.NewMockService("/api/").
+		HandleFunc("GET /", func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+	mg := api.New(addr, svc)
+
+	done := make(chan error, 1)
+	go func() { done <- mg.Start() }()
+	waitForServer(t, "http://"+addr+"/api/").Body.Close()
+
+	if err := mg.Stop(); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected clean stop, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start did not return after Stop")
+	}
+	if _, err := http.Get("http://" + addr + "/health"); err == nil {
+		t.Error("expected the server to stop listening")
+	}
+}
+
+func TestStop_WhileWaitingForReadiness_StartServesNothing(t *testing.T) {
+	addr := freeAddr(t)
+	p := &delayedPinger{readyAt: time.Now().Add(time.Hour)}
+	mg := api.New(addr, testutil.NewMockService("/api/")).WithReadiness(p, time.Minute)
+
+	done := make(chan error, 1)
+	go func() { done <- mg.Start() }()
+	for p.Calls() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := mg.Stop(); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected clean stop, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start did not return after Stop")
+	}
+	if _, err := http.Get("http://" + addr + "/health"); err == nil {
+		t.Error("expected the server never to listen")
+	}
+}
+
+func TestStart_ListenerFailure_StopsOthers(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer busy.Close()
+
+	apiAddr := freeAddr(t)
+	mg := api.New(apiAddr, testutil.NewMockService("/api/")).
+		WithListener(busy.Addr().String(), testutil.NewMockService("/ui/"))
+
+	done := make(chan error, 1)
+	go func() { done <- mg.Start() }()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected error when a listener cannot bind")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Start did not return after a listener failed")
+	}
+	if _, err := http.Get("http://" + apiAddr + "/health"); err == nil {
+		t.Error("expected the main listener to be shut down")
+	}
+}
+
+func TestShutdown_ClosesSlowRequestAfterTimeout(t *testing.T) {
+	addr := freeAddr(t)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	svc := testutil.NewMockService("/api/").
+		HandleFunc("GET /slow", func(w http.ResponseWriter, _ *http.Request) {
+			close(started)
+			<-release
+			w.WriteHeader(http.StatusOK)
+		})
+	mg := api.New(addr, svc)
+	go func() { _ = mg.Start() }()
+	waitForServer(t, "http://"+addr+"/health").Body.Close()
+
+	reqErr := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/api/slow")
+		if err == nil {
+			resp.Body.Close()
+		}
+		reqErr <- err
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- mg.Shutdown(ctx) }()
+
+	select {
+	case err := <-shutdownErr:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected the shutdown deadline to be reported, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown hung on the in-flight request")
+	}
+	select {
+	case err := <-reqErr:
+		if err == nil {
+			t.Error("expected the in-flight request to be cut off")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("in-flight request was not closed after the shutdown timeout")
+	}
+}
+
+func TestShutdown_DrainsAfterServersStop(t *testing.T) {
+	addr := freeAddr(t)
+	var handled atomic.Bool
+	svc := testutil.NewMockService("/api/").
+		HandleFunc("GET /ping", func(w http.ResponseWriter, _ *http.Request) {
+			time.Sleep(100 * time.Millisecond)
+			handled.Store(true)
+			w.WriteHeader(http.StatusOK)
+		})
+	var drainedAfterRequest atomic.Bool
+	mg := api.New(addr, svc).WithDrainer(drainFunc(func(ctx context.Context) error {
+		drainedAfterRequest.Store(handled.Load())
+		<-ctx.Done()
+		return ctx.Err()
+	}))
+	go func() { _ = mg.Start() }()
+	waitForServer(t, "http://"+addr+"/health").Body.Close()
+
+	reqDone := make(chan struct{})
+	go func() {
+		defer close(reqDone)
+		if resp, err := http.Get("http://" + addr + "/api/ping"); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	time.Sleep(20 * time.Millisecond) // let the request reach the handler
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := mg.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the drain deadline to be reported, got %v", err)
+	}
+	<-reqDone
+	if !drainedAfterRequest.Load() {
+		t.Error("expected the drainer to run after the in-flight request finished")
+	}
+}
+
+func TestHealth_DefaultIsBare(t *testing.T) {
+	addr := freeAddr(t)
+	mg := api.New(addr, testutil.NewMockService("/api/")).
+		WithHealthDetails(testutil.NewMockMessageStore(), "sqlite", nil)
+	go func() { _ = mg.Start() }()
+	defer mg.Shutdown(context.Background())
+
+	resp := waitForServer(t, "http://"+addr+"/health")
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != `{"status":"healthy"}` {
+		t.Errorf("expected bare health payload, got %s", body)
+	}
+}
+
+func TestHealth_VerboseIncludesUptimeAndCount(t *testing.T) {
+	addr := freeAddr(t)
+	clk := clock.NewMockClock(time.Unix(1700000000, 0))
+	st := testutil.NewMockMessageStore()
+	mg := api.New(addr, testutil.NewMockService("/api/")).
+		WithHealthDetails(st, "sqlite", clk)
+	go func() { _ = mg.Start() }()
+	defer mg.Shutdown(context.Background())
+
+	if err := st.SaveMSG(testutil.NewTestMessage("msg-1")); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	clk.Add(90 * time.Second)
+
+	resp := waitForServer(t, "http://"+addr+"/health?verbose=true")
+	defer resp.Body.Close()
+
+	var got struct {
+		Status        string `json:"status"`
+		Uptime        string `json:"uptime"`
+		UptimeSeconds int64  `json:"uptime_seconds"`
+		Messages      int    `json:"messages"`
+		Storage       string `json:"storage"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if got.Status != "healthy" || got.UptimeSeconds != 90 || got.Uptime != "1m30s" {
+		t.Errorf("unexpected status/uptime: %+v", got)
+	}
+	if got.Messages != 1 {
+		t.Errorf("expected 1 message, got %d", got.Messages)
+	}
+	if got.Storage != "sqlite" {
+		t.Errorf("expected storage sqlite, got %q", got.Storage)
+	}
+}
+
+func TestMetrics_CountsServiceRequests(t *testing.T) {
+	addr := freeAddr(t)
+	svc := testutil.NewMockService("/api/").
+		HandleFunc("GET /teapot", func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		})
+	mg := api.New(addr, svc)
+	go func() { _ = mg.Start() }()
+	defer mg.Shutdown(context.Background())
+
+	waitForServer(t, "http://"+addr+"/api/teapot").Body.Close()
+
+	resp, err := http.Get("http://" + addr + "/metrics")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("expected text/plain, got %q", ct)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	for _, want := range []string{
+		`mockgrid_http_requests_total{code="418",method="GET",route="/api/"} `,
+		`mockgrid_http_request_duration_seconds_count{method="GET",route="/api/"} `,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("expected metrics to contain %q, got:\n%s", want, body)
+		}
+	}
+}
+
+func TestRouting_CollectionPathWithAndWithoutSlash(t *testing.T) {
+	addr := freeAddr(t)
+	svc := testutil.NewMockService("/api/").
+		HandleFunc("POST /", func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write(body)
+		})
+	mg := api.New(addr, svc)
+	go func() { _ = mg.Start() }()
+	defer mg.Shutdown(context.Background())
+	waitForServer(t, "http://"+addr+"/health").Body.Close()
+
+	// Redirects are not followed so a 301 shows up as a failure
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	for _, path := range []string{"/api/", "/api"} {
+		resp, err := client.Post("http://"+addr+path, "application/json", strings.NewReader(`{"n":1}`))
+		if err != nil {
+			t.Fatalf("POST %s failed: %v", path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Errorf("POST %s: expected 201, got %d", path, resp.StatusCode)
+		}
+		if string(body) != `{"n":1}` {
+			t.Errorf("POST %s: expected the body to reach the handler, got %q", path, body)
+		}
+	}
+}
+
+func TestRecover_ServicePanicReturns500(t *testing.T) {
+	addr := freeAddr(t)
+	svc := testutil.NewMockService("/api/").HandleFunc("GET /boom", func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	})
+	mg := api.New(addr, svc)
+	go func() { _ = mg.Start() }()
+	defer mg.Shutdown(context.Background())
+
+	resp := waitForServer(t, "http://"+addr+"/api/boom")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d", resp.StatusCode)
+	}
+}
+
+func TestTLS_EnforcesMinVersion(t *testing.T) {
+	addr := freeAddr(t)
+	certFile, keyFile := testutil.WriteSelfSignedCert(t)
+	mg := api.New(addr, testutil.NewMockService("/api/")).
+		WithTLS(certFile, keyFile, tls.VersionTLS12)
+	go func() { _ = mg.Start() }()
+	defer mg.Shutdown(context.Background())
+
+	// TLS 1.2 client succeeds
+	modern := tlsClient(tls.VersionTLS12, tls.VersionTLS12)
+	var resp *http.Response
+	var err error
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if resp, err = modern.Get("https://" + addr + "/health"); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("TLS 1.2 request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200, got %d", resp.StatusCode)
+	}
+
+	// TLS 1.1 client is rejected during the handshake
+	legacy := tlsClient(tls.VersionTLS10, tls.VersionTLS11)
+	if resp, err := legacy.Get("https://" + addr + "/health"); err == nil {
+		resp.Body.Close()
+		t.Fatal("expected TLS 1.1 client to be rejected")
+	}
+}
+
+func TestOnMessage_ReceivesSentMessage(t *testing.T) {
+	host, port := testutil.StartSMTPServer(t)
+	mailSvc := sendmail.New(sendmail.Config{
+		SMTPServer:    host,
+		SMTPPort:      port,
+		ListenAddr:    ":0",
+		AttachmentDir: t.TempDir(),
+	}, testutil.NewMockTemplater(), testutil.NewMockMessageStore())
+	t.Cleanup(func() { _ = mailSvc.Close() })
+
+	addr := freeAddr(t)
+	mg := api.New(addr, mailSvc)
+	var got []*store.Message
+	mg.OnMessage(func(msg *store.Message) { got = append(got, msg) })
+	go func() { _ = mg.Start() }()
+	defer mg.Shutdown(context.Background())
+	waitForServer(t, "http://"+addr+"/health").Body.Close()
+
+	body := `{"from":{"email":"from@example.com"},"personalizations":[{"to":[{"email":"to@example.com"}]}],` +
+		`"subject":"Hi","content":[{"type":"text/plain","value":"Hello"}]}`
+	resp, err := http.Post("http://"+addr+"/v3/mail/send", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", resp.StatusCode)
+	}
+
+	// The hook runs before the send responds, so no waiting is needed
+	if len(got) != 1 {
+		t.Fatalf("expected 1 message from the hook, got %d", len(got))
+	}
+	if got[0].ToEmail != "to@example.com" || got[0].Status != store.StatusDelivered {
+		t.Errorf("expected a delivered message to to@example.com, got %s to %q", got[0].Status, got[0].ToEmail)
+	}
+}
+
+// --- Test Helpers ---
+
+// drainFunc adapts a function to api.Drainer.
+type drainFunc func(ctx context.Context) error
+
+func (f drainFunc) Drain(ctx context.Context) error { return f(ctx) }
+
+// tlsClient returns a client limited to TLS versions [minVer, maxVer] that
+// trusts any certificate.
+func tlsClient(minVer, maxVer uint16) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{
+			MinVersion:         minVer,
+			MaxVersion:         maxVer,
+			InsecureSkipVerify: true, // self-signed test certificate
+		},
+	}}
+}
+
+// delayedPinger fails until readyAt.
+type delayedPinger struct {
+	mu      sync.Mutex
+	readyAt time.Time
+	calls   int
+}
+
+func (p *delayedPinger) Ping() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	if time.Now().Before(p.readyAt) {
+		return errors.New("not ready")
+	}
+	return nil
+}
+
+func (p *delayedPinger) Calls() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to reserve port: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func waitForServer(t *testing.T, url string) *http.Response {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		resp, err := http.Get(url)
+		if err == nil {
+			return resp
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("server at %s did not start", url)
+	return nil
+}
@@ -0,0 +1,119 @@
+// Package engagement simulates recipient opens and clicks for delivered messages.
+package engagement
+
+import (
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/mustur/mockgrid/app/api/store"
+	"github.com/mustur/mockgrid/internal/clock"
+)
+
+// Config holds configuration for the engagement Simulator.
+type Config struct {
+	// OpenRate and ClickRate are the probabilities, in [0, 1], that a
+	// delivered message is opened or clicked.
+	OpenRate  float64
+	ClickRate float64
+
+	// Delay is how long after delivery the simulated events occur.
+	Delay time.Duration
+
+	// Seed makes the simulated outcomes reproducible. Zero picks a random seed.
+	Seed uint64
+
+	// Clock schedules the simulated events. Nil means the real clock.
+	Clock clock.Clock
+}
+
+// Simulator is a store.EventDispatcher that forwards every event to the next
+// dispatcher and, for delivered messages, schedules simulated open and click
+// events. A simulated event increments the message's counters in the store
+// and is dispatched like any other event.
+type Simulator struct {
+	next      store.EventDispatcher
+	store     store.MessageStore
+	clock     clock.Clock
+	openRate  float64
+	clickRate float64
+	delay     time.Duration
+
+	mu  sync.Mutex // guards rng and read-modify-write of message counters
+	rng *rand.Rand
+}
+
+// New creates a Simulator that records engagement in msgStore and forwards
+// events to next.
+func New(cfg Config, msgStore store.MessageStore, next store.EventDispatcher) *Simulator {
+	clk := cfg.Clock
+	if clk == nil {
+		clk = clock.RealClock{}
+	}
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	return &Simulator{
+		next:      next,
+		store:     msgStore,
+		clock:     clk,
+		openRate:  cfg.OpenRate,
+		clickRate: cfg.ClickRate,
+		delay:     cfg.Delay,
+		rng:       rand.New(rand.NewPCG(seed, seed)),
+	}
+}
+
+// DispatchMessageEvent forwards the event and schedules simulated engagement
+// when the message was delivered.
+func (s *Simulator) DispatchMessageEvent(msgID, email, from, subject string, status string, reason string, pool string) {
+	s.next.DispatchMessageEvent(msgID, email, from, subject, status, reason, pool)
+
+	if status != string(store.StatusDelivered) {
+		return
+	}
+
+	// Roll both outcomes now so results depend only on the seed and the
+	// order of deliveries, not on when the timers fire.
+	s.mu.Lock()
+	open := s.rng.Float64() < s.openRate
+	click := s.rng.Float64() < s.clickRate
+	s.mu.Unlock()
+
+	if open {
+		s.clock.AfterFunc(s.delay, func() { s.record(msgID, store.EventOpen) })
+	}
+	if click {
+		s.clock.AfterFunc(s.delay, func() { s.record(msgID, store.EventClick) })
+	}
+}
+
+// record increments the message's counter for event and dispatches it.
+func (s *Simulator) record(msgID, event string) {
+	s.mu.Lock()
+	msgs, err := s.store.GetMSG(store.GetQuery{ID: msgID})
+	if err != nil || len(msgs) == 0 {
+		s.mu.Unlock()
+		slog.Warn("simulated engagement for unknown message", "msg_id", msgID, "event", event, "err", err)
+		return
+	}
+	msg := msgs[0]
+	switch event {
+	case store.EventOpen:
+		msg.OpensCount++
+	case store.EventClick:
+		msg.ClicksCount++
+	}
+	msg.LastEventTime = s.clock.Now().Unix()
+	err = s.store.SaveMSG(msg)
+	s.mu.Unlock()
+
+	if err != nil {
+		slog.Error("failed to save simulated engagement", "msg_id", msgID, "event", event, "err", err)
+		return
+	}
+	slog.Debug("simulated engagement", "msg_id", msgID, "event", event)
+	s.next.DispatchMessageEvent(msg.MsgID, msg.ToEmail, msg.FromEmail, msg.Subject, event, "", msg.IPPool)
+}
@@ -0,0 +1,125 @@
+package engagement_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mustur/mockgrid/app/api/engagement"
+	"github.com/mustur/mockgrid/app/api/store"
+	"github.com/mustur/mockgrid/internal/clock"
+	"github.com/mustur/mockgrid/internal/testutil"
+)
+
+// --- Simulator Tests ---
+
+func TestSimulator_OpenRateOne_RecordsOpenAfterDelay(t *testing.T) {
+	clk := clock.NewMockClock(time.Unix(1700000000, 0))
+	st := testutil.NewMockMessageStore()
+	next := testutil.NewRecordingDispatcher()
+	sim := engagement.New(engagement.Config{
+		OpenRate: 1.0,
+		Delay:    time.Second,
+		Seed:     1,
+		Clock:    clk,
+	}, st, next)
+
+	deliver(t, st, sim, "msg-1")
+
+	if got := opensCount(t, st, "msg-1"); got != 0 {
+		t.Fatalf("expected no opens before the delay, got %d", got)
+	}
+
+	clk.Add(time.Second)
+
+	if got := opensCount(t, st, "msg-1"); got != 1 {
+		t.Errorf("expected 1 open, got %d", got)
+	}
+	events := next.Events()
+	if len(events) != 2 || events[1].Status != store.EventOpen || events[1].MsgID != "msg-1" {
+		t.Errorf("expected delivered then open events, got %+v", events)
+	}
+}
+
+func TestSimulator_ZeroRates_NoEngagement(t *testing.T) {
+	clk := clock.NewMockClock(time.Unix(1700000000, 0))
+	st := testutil.NewMockMessageStore()
+	next := testutil.NewRecordingDispatcher()
+	sim := engagement.New(engagement.Config{Seed: 1, Clock: clk}, st, next)
+
+	deliver(t, st, sim, "msg-1")
+	clk.Add(time.Hour)
+
+	if got := len(next.Events()); got != 1 {
+		t.Errorf("expected only the delivered event, got %d events", got)
+	}
+}
+
+func TestSimulator_NotDelivered_NoEngagement(t *testing.T) {
+	clk := clock.NewMockClock(time.Unix(1700000000, 0))
+	st := testutil.NewMockMessageStore()
+	next := testutil.NewRecordingDispatcher()
+	sim := engagement.New(engagement.Config{OpenRate: 1, ClickRate: 1, Seed: 1, Clock: clk}, st, next)
+
+	msg := testutil.NewMessageBuilder("msg-1").WithStatus(store.StatusBounce).Build()
+	if err := st.SaveMSG(msg); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	sim.DispatchMessageEvent(msg.MsgID, msg.ToEmail, msg.FromEmail, msg.Subject, string(msg.Status), "", "")
+	clk.Add(time.Hour)
+
+	if got := len(next.Events()); got != 1 {
+		t.Errorf("expected only the bounce event, got %d events", got)
+	}
+}
+
+func TestSimulator_SameSeed_SameOutcomes(t *testing.T) {
+	run := func() []string {
+		clk := clock.NewMockClock(time.Unix(1700000000, 0))
+		st := testutil.NewMockMessageStore()
+		next := testutil.NewRecordingDispatcher()
+		sim := engagement.New(engagement.Config{OpenRate: 0.5, ClickRate: 0.5, Seed: 42, Clock: clk}, st, next)
+		for _, id := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+			deliver(t, st, sim, id)
+		}
+		clk.Add(time.Second)
+
+		var got []string
+		for _, e := range next.Events() {
+			got = append(got, e.MsgID+":"+e.Status)
+		}
+		return got
+	}
+
+	first, second := run(), run()
+	if len(first) != len(second) {
+		t.Fatalf("runs differ in length: %v vs %v", first, second)
+	}
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("runs differ at %d: %v vs %v", i, first, second)
+		}
+	}
+}
+
+// --- Test Helpers ---
+
+// deliver stores a delivered message and notifies the simulator, as the
+// store wrapper would.
+func deliver(t *testing.T, st *testutil.MockMessageStore, sim *engagement.Simulator, id string) {
+	t.Helper()
+	msg := testutil.NewMessageBuilder(id).WithStatus(store.StatusDelivered).Build()
+	if err := st.SaveMSG(msg); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	sim.DispatchMessageEvent(msg.MsgID, msg.ToEmail, msg.FromEmail, msg.Subject, string(msg.Status), "", "")
+}
+
+// opensCount returns the stored opens count for a message.
+func opensCount(t *testing.T, st *testutil.MockMessageStore, id string) int {
+	t.Helper()
+	msgs, err := st.GetMSG(store.GetQuery{ID: id})
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	return msgs[0].OpensCount
+}
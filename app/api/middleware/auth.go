@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/mustur/mockgrid/app/api/objects"
+	"github.com/mustur/mockgrid/internal/httpjson"
+)
+
+// BearerAuth returns a middleware that rejects requests whose Authorization
+// header is not "Bearer <key>" with a SendGrid-style 401. An empty key
+// disables the check.
+func BearerAuth(key string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key != "" && r.Header.Get("Authorization") != "Bearer "+key {
+				slog.Warn("authorization failed", "path", r.URL.Path)
+				httpjson.Write(w, http.StatusUnauthorized, objects.GetErrorResponse(objects.UnauthorizedMessage, nil, nil))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults used by CORSWithConfig for an empty methods or headers list.
+var (
+	DefaultCORSMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	DefaultCORSHeaders = []string{"Authorization", "Content-Type"}
+)
+
+// CORSConfig configures CORSWithConfig.
+type CORSConfig struct {
+	AllowedOrigins []string      // origins allowed to call the API; "*" allows any
+	AllowedMethods []string      // methods allowed in preflight; empty uses DefaultCORSMethods
+	AllowedHeaders []string      // request headers allowed in preflight; empty uses DefaultCORSHeaders
+	MaxAge         time.Duration // how long browsers may cache a preflight; 0 omits the header
+}
+
+// CORS returns a middleware that allows cross-origin requests from origin
+// ("*" for any) and answers preflight OPTIONS requests directly.
+func CORS(origin string) Middleware {
+	return CORSWithConfig(CORSConfig{AllowedOrigins: []string{origin}})
+}
+
+// CORSWithConfig returns a middleware that allows cross-origin requests from
+// the configured origins and answers their preflight OPTIONS requests
+// directly. Requests from other origins pass through without CORS headers,
+// so browsers block them.
+func CORSWithConfig(c CORSConfig) Middleware {
+	anyOrigin := slices.Contains(c.AllowedOrigins, "*")
+	methods := strings.Join(orDefault(c.AllowedMethods, DefaultCORSMethods), ", ")
+	headers := strings.Join(orDefault(c.AllowedHeaders, DefaultCORSHeaders), ", ")
+	maxAge := ""
+	if c.MaxAge > 0 {
+		maxAge = strconv.Itoa(int(c.MaxAge.Seconds()))
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			origin := r.Header.Get("Origin")
+			switch {
+			case anyOrigin:
+				h.Set("Access-Control-Allow-Origin", "*")
+			case origin != "" && slices.Contains(c.AllowedOrigins, origin):
+				h.Set("Access-Control-Allow-Origin", origin)
+				h.Add("Vary", "Origin")
+			default:
+				h.Add("Vary", "Origin")
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				h.Set("Access-Control-Allow-Methods", methods)
+				h.Set("Access-Control-Allow-Headers", headers)
+				if maxAge != "" {
+					h.Set("Access-Control-Max-Age", maxAge)
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// orDefault returns list, or def when list is empty.
+func orDefault(list, def []string) []string {
+	if len(list) == 0 {
+		return def
+	}
+	return list
+}
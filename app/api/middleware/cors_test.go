@@ -0,0 +1,121 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mustur/mockgrid/app/api/middleware"
+)
+
+// --- CORS Tests ---
+
+func TestCORS_PreflightFromAllowedOrigin(t *testing.T) {
+	handler := middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowedOrigins: []string{"http://localhost:3000", "http://dashboard.test"},
+		AllowedMethods: []string{"GET", "POST"},
+		AllowedHeaders: []string{"Authorization"},
+		MaxAge:         10 * time.Minute,
+	})(unreachable(t))
+
+	req := httptest.NewRequest(http.MethodOptions, "/v3/messages/", nil)
+	req.Header.Set("Origin", "http://dashboard.test")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+	for header, want := range map[string]string{
+		"Access-Control-Allow-Origin":  "http://dashboard.test",
+		"Access-Control-Allow-Methods": "GET, POST",
+		"Access-Control-Allow-Headers": "Authorization",
+		"Access-Control-Max-Age":       "600",
+		"Vary":                         "Origin",
+	} {
+		if got := rec.Header().Get(header); got != want {
+			t.Errorf("%s: expected %q, got %q", header, want, got)
+		}
+	}
+}
+
+func TestCORS_SimpleRequestFromAllowedOrigin(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowedOrigins: []string{"http://localhost:3000"},
+	})(next)
+
+	req := httptest.NewRequest(http.MethodGet, "/v3/messages/", nil)
+	req.Header.Set("Origin", "http://localhost:3000")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the request to reach the handler, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "http://localhost:3000" {
+		t.Errorf("expected the origin to be allowed, got %q", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Methods"); got != "" {
+		t.Errorf("expected no preflight headers on a simple request, got %q", got)
+	}
+}
+
+func TestCORS_OtherOriginGetsNoHeaders(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	handler := middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowedOrigins: []string{"http://localhost:3000"},
+	})(next)
+
+	req := httptest.NewRequest(http.MethodOptions, "/v3/messages/", nil)
+	req.Header.Set("Origin", "http://evil.test")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected the preflight to fall through to the handler, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("expected no allowed origin, got %q", got)
+	}
+}
+
+func TestCORS_WildcardUsesDefaults(t *testing.T) {
+	handler := middleware.CORS("*")(unreachable(t))
+
+	req := httptest.NewRequest(http.MethodOptions, "/v3/mail/send", nil)
+	req.Header.Set("Origin", "http://anywhere.test")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("expected wildcard origin, got %q", got)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Headers"); got != "Authorization, Content-Type" {
+		t.Errorf("expected default headers, got %q", got)
+	}
+	if got := rec.Header().Get("Access-Control-Max-Age"); got != "" {
+		t.Errorf("expected no max age, got %q", got)
+	}
+}
+
+// --- Test Helpers ---
+
+// unreachable returns a handler that fails the test when it is called.
+func unreachable(t *testing.T) http.Handler {
+	t.Helper()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("request unexpectedly reached the handler: %s %s", r.Method, r.URL.Path)
+	})
+}
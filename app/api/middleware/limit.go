@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/mustur/mockgrid/app/api/objects"
+	"github.com/mustur/mockgrid/internal/httpjson"
+)
+
+// MaxInFlight returns a middleware that allows at most n requests to be
+// served concurrently. Requests arriving while n are in flight are rejected
+// with a 503. The slot is released when the handler returns, including by
+// panic, so it composes with Recover in either order. n <= 0 disables the limit.
+func MaxInFlight(n int) Middleware {
+	return func(next http.Handler) http.Handler {
+		if n <= 0 {
+			return next
+		}
+		sem := make(chan struct{}, n)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case sem <- struct{}{}:
+			default:
+				slog.Warn("too many in-flight requests", "limit", n, "path", r.URL.Path)
+				httpjson.Write(w, http.StatusServiceUnavailable, objects.GetErrorResponse("too many concurrent requests", nil, nil))
+				return
+			}
+			defer func() { <-sem }()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/mustur/mockgrid/app/api/middleware"
+)
+
+// --- MaxInFlight Tests ---
+
+func TestMaxInFlight_RejectsOverLimit(t *testing.T) {
+	const limit = 3
+
+	started := make(chan struct{}, limit)
+	release := make(chan struct{})
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+
+	srv := httptest.NewServer(middleware.MaxInFlight(limit)(slow))
+	defer srv.Close()
+
+	codes := make(chan int, limit+1)
+	var wg sync.WaitGroup
+	for i := 0; i < limit; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- get(t, srv.URL)
+		}()
+	}
+	for i := 0; i < limit; i++ {
+		<-started
+	}
+
+	// All slots are held; the extra request must be rejected
+	if code := get(t, srv.URL); code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 for request over limit, got %d", code)
+	}
+
+	close(release)
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("expected 200 for request within limit, got %d", code)
+		}
+	}
+}
+
+func TestMaxInFlight_ReleasesOnPanic(t *testing.T) {
+	panicking := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	})
+	handler := middleware.Chain(
+		middleware.Recover(),
+		middleware.MaxInFlight(1),
+	)(panicking)
+
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	// With a leaked slot the second request would get 503 instead of 500
+	for i := 0; i < 2; i++ {
+		if code := get(t, srv.URL); code != http.StatusInternalServerError {
+			t.Fatalf("request %d: expected 500, got %d", i+1, code)
+		}
+	}
+}
+
+func TestMaxInFlight_ZeroIsUnlimited(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	srv := httptest.NewServer(middleware.MaxInFlight(0)(ok))
+	defer srv.Close()
+
+	if code := get(t, srv.URL); code != http.StatusOK {
+		t.Errorf("expected 200, got %d", code)
+	}
+}
+
+// --- Test Helpers ---
+
+// get issues a GET request and returns the response status code.
+func get(t *testing.T, url string) int {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Errorf("request failed: %v", err)
+		return 0
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
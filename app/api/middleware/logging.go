@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RequestIDHeader carries the request ID logged for each request. A client
+// may set it; otherwise one is generated. It is echoed in the response.
+const RequestIDHeader = "X-Request-ID"
+
+// statusRecorder captures the status code and body size written by the
+// wrapped handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.size += int64(n)
+	return n, err
+}
+
+// loggedKey marks a request context as already logged by an outer Logging.
+type loggedKey struct{}
+
+// Logging returns a middleware that logs the method, path, status, response
+// size, duration and request ID of every request. A request already logged
+// by an outer Logging, such as one in the global stack, is passed through so
+// it is logged once. A nil logger means slog.Default().
+func Logging(logger *slog.Logger) Middleware {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Context().Value(loggedKey{}) != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			start := time.Now()
+			id := ensureRequestID(w, r)
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), loggedKey{}, true)))
+			logger.Info("request",
+				"method", r.Method,
+				"path", requestPath(r),
+				"status", rec.status,
+				"size", rec.size,
+				"duration", time.Since(start),
+				"request_id", id,
+			)
+		})
+	}
+}
+
+// ensureRequestID returns the request's X-Request-ID, generating one when it
+// is missing. The ID is set on the request for later handlers and echoed in
+// the response.
+func ensureRequestID(w http.ResponseWriter, r *http.Request) string {
+	id := r.Header.Get(RequestIDHeader)
+	if id == "" {
+		var b [16]byte
+		_, _ = rand.Read(b[:]) // crypto/rand.Read never returns an error
+		id = hex.EncodeToString(b[:])
+		r.Header.Set(RequestIDHeader, id)
+	}
+	w.Header().Set(RequestIDHeader, id)
+	return id
+}
+
+// requestPath returns the path the client requested. Services see their
+// path with the root stripped, so it is taken from the request URI.
+func requestPath(r *http.Request) string {
+	if r.RequestURI == "" {
+		return r.URL.Path
+	}
+	path, _, _ := strings.Cut(r.RequestURI, "?")
+	return path
+}
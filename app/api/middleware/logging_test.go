@@ -0,0 +1,91 @@
+package middleware_test
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mustur/mockgrid/app/api/middleware"
+)
+
+// --- Logging Tests ---
+
+func TestLogging_ImplicitStatusAndSize(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	// Writing a body without WriteHeader sends an implicit 200
+	handler := middleware.Logging(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v3/messages/?limit=1", nil))
+
+	out := logs.String()
+	for _, want := range []string{"method=GET", "path=/v3/messages/ ", "status=200", "size=5"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected log line with %q, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestLogging_GeneratesRequestID(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	var seen string
+	handler := middleware.Logging(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Get(middleware.RequestIDHeader)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/v3/user/webhooks/event/settings/wh_1", nil))
+
+	id := rec.Header().Get(middleware.RequestIDHeader)
+	if id == "" {
+		t.Fatal("expected a generated request ID in the response")
+	}
+	if seen != id {
+		t.Errorf("expected the handler to see request ID %q, got %q", id, seen)
+	}
+	if out := logs.String(); !strings.Contains(out, "status=204") || !strings.Contains(out, "request_id="+id) {
+		t.Errorf("expected status and request ID in the log line, got:\n%s", out)
+	}
+}
+
+func TestLogging_KeepsClientRequestID(t *testing.T) {
+	handler := middleware.Logging(slog.New(slog.DiscardHandler))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "/v3/stats", nil)
+	req.Header.Set(middleware.RequestIDHeader, "req-123")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get(middleware.RequestIDHeader); got != "req-123" {
+		t.Errorf("expected the client's request ID to be echoed, got %q", got)
+	}
+}
+
+func TestLogging_Nested_LogsOnce(t *testing.T) {
+	var outer, inner bytes.Buffer
+	handler := middleware.Chain(
+		middleware.Logging(slog.New(slog.NewTextHandler(&outer, nil))),
+		middleware.Logging(slog.New(slog.NewTextHandler(&inner, nil))),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v3/stats", nil))
+
+	if n := strings.Count(outer.String(), "msg=request"); n != 1 {
+		t.Errorf("expected one line from the outer logger, got %d:\n%s", n, outer.String())
+	}
+	if out := inner.String(); out != "" {
+		t.Errorf("expected no line from the inner logger, got:\n%s", out)
+	}
+	if rec.Header().Get(middleware.RequestIDHeader) == "" {
+		t.Error("expected a request ID in the response")
+	}
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mustur/mockgrid/app/api/objects"
+	"github.com/mustur/mockgrid/internal/clock"
+	"github.com/mustur/mockgrid/internal/httpjson"
+)
+
+// Headers reporting the rate limit on every response, as SendGrid sets them.
+const (
+	RateLimitHeader          = "X-RateLimit-Limit"
+	RateLimitRemainingHeader = "X-RateLimit-Remaining"
+	RateLimitResetHeader     = "X-RateLimit-Reset" // Unix time at which the full limit is available again
+)
+
+// RateLimit returns a middleware that allows limit requests per window
+// across every handler it wraps, refilling continuously like a token bucket.
+// Requests over the limit are rejected with a 429. Every response carries
+// the rate-limit headers so clients can throttle themselves. A nil clk means
+// the real clock; limit <= 0 or window <= 0 disables the limit.
+func RateLimit(limit int, window time.Duration, clk clock.Clock) Middleware {
+	if limit <= 0 || window <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	if clk == nil {
+		clk = clock.RealClock{}
+	}
+	b := &rateBucket{
+		clock:  clk,
+		limit:  float64(limit),
+		rate:   float64(limit) / window.Seconds(),
+		tokens: float64(limit),
+		last:   clk.Now(),
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ok, remaining, reset := b.take()
+			w.Header().Set(RateLimitHeader, strconv.Itoa(limit))
+			w.Header().Set(RateLimitRemainingHeader, strconv.Itoa(remaining))
+			w.Header().Set(RateLimitResetHeader, strconv.FormatInt(reset, 10))
+			if !ok {
+				slog.Warn("rate limit exceeded", "limit", limit, "window", window, "path", r.URL.Path)
+				httpjson.Write(w, http.StatusTooManyRequests, objects.GetErrorResponse("too many requests", nil, nil))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateBucket is the token bucket behind RateLimit.
+type rateBucket struct {
+	mu     sync.Mutex
+	clock  clock.Clock
+	limit  float64 // bucket capacity
+	rate   float64 // tokens added per second
+	tokens float64
+	last   time.Time
+}
+
+// take spends a token if one is available. It returns whether it did, the
+// whole tokens left, and the Unix time at which the bucket is full again.
+func (b *rateBucket) take() (ok bool, remaining int, reset int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock.Now()
+	b.tokens = math.Min(b.limit, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		ok = true
+	}
+	full := now.Add(time.Duration((b.limit - b.tokens) / b.rate * float64(time.Second)))
+	reset = full.Unix()
+	if full.Nanosecond() > 0 {
+		reset++ // round up so the limit is never reported full too early
+	}
+	return ok, int(b.tokens), reset
+}
@@ -0,0 +1,74 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mustur/mockgrid/app/api/middleware"
+	"github.com/mustur/mockgrid/internal/clock"
+)
+
+// --- RateLimit Tests ---
+
+func TestRateLimit_HeadersDecrementAndReset(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	clk := clock.NewMockClock(start)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	handler := middleware.RateLimit(3, time.Minute, clk)(ok)
+
+	check := func(wantCode, wantRemaining int, wantReset time.Time) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != wantCode {
+			t.Errorf("expected %d, got %d", wantCode, rec.Code)
+		}
+		if got := rec.Header().Get(middleware.RateLimitHeader); got != "3" {
+			t.Errorf("expected limit 3, got %q", got)
+		}
+		if got := rec.Header().Get(middleware.RateLimitRemainingHeader); got != strconv.Itoa(wantRemaining) {
+			t.Errorf("expected remaining %d, got %q", wantRemaining, got)
+		}
+		if got := rec.Header().Get(middleware.RateLimitResetHeader); got != strconv.FormatInt(wantReset.Unix(), 10) {
+			t.Errorf("expected reset %d, got %q", wantReset.Unix(), got)
+		}
+	}
+
+	// Each token takes 20s to refill
+	check(http.StatusOK, 2, start.Add(20*time.Second))
+	check(http.StatusOK, 1, start.Add(40*time.Second))
+	check(http.StatusOK, 0, start.Add(time.Minute))
+	check(http.StatusTooManyRequests, 0, start.Add(time.Minute))
+
+	clk.Add(time.Minute)
+	check(http.StatusOK, 2, start.Add(time.Minute+20*time.Second))
+}
+
+func TestRateLimit_SharedAcrossHandlers(t *testing.T) {
+	mw := middleware.RateLimit(1, time.Minute, clock.NewMockClock(time.Unix(1700000000, 0)))
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	first, second := mw(ok), mw(ok)
+
+	rec := httptest.NewRecorder()
+	first.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	second.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the second service to share the limit and return 429, got %d", rec.Code)
+	}
+}
+
+func TestRateLimit_ZeroDisables(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	rec := httptest.NewRecorder()
+	middleware.RateLimit(0, time.Minute, nil)(ok).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := rec.Header().Get(middleware.RateLimitHeader); got != "" {
+		t.Errorf("expected no rate-limit headers, got limit %q", got)
+	}
+}
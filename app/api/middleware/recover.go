@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/mustur/mockgrid/app/api/objects"
+	"github.com/mustur/mockgrid/internal/httpjson"
+)
+
+// Recover returns a middleware that converts a handler panic into a
+// SendGrid-style 500 response instead of dropping the connection. The panic
+// is logged with its stack. Install it outermost so it covers every other
+// middleware, e.g. as the ball of BallAndChain.
+func Recover() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				rec := recover()
+				if rec == nil {
+					return
+				}
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+				slog.Error("handler panicked", "path", r.URL.Path, "panic", rec, "stack", string(debug.Stack()))
+				httpjson.Write(w, http.StatusInternalServerError, objects.GetErrorResponse("internal server error", nil, nil))
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
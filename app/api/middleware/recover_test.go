@@ -0,0 +1,53 @@
+package middleware_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mustur/mockgrid/app/api/middleware"
+	"github.com/mustur/mockgrid/app/api/objects"
+)
+
+// --- Recover Tests ---
+
+func TestRecover_PanicReturnsJSON500(t *testing.T) {
+	var nilMap map[string]*struct{ n int }
+	panicking := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		_ = nilMap["missing"].n // nil dereference
+	})
+	srv := httptest.NewServer(middleware.BallAndChain(middleware.Recover(), middleware.Logging(nil))(panicking))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("expected a response instead of a dropped connection: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected application/json, got %q", ct)
+	}
+	var errResp objects.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil || len(errResp.Errors) != 1 {
+		t.Errorf("expected a SendGrid-style error body, got %+v (%v)", errResp, err)
+	}
+}
+
+func TestRecover_AbortHandlerIsRepanicked(t *testing.T) {
+	aborting := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+	srv := httptest.NewServer(middleware.Recover()(aborting))
+	defer srv.Close()
+
+	// http.ErrAbortHandler deliberately drops the connection
+	if resp, err := http.Get(srv.URL); err == nil {
+		resp.Body.Close()
+		t.Errorf("expected the connection to be aborted, got %d", resp.StatusCode)
+	}
+}
@@ -1,5 +1,8 @@
 package objects
 
+// UnauthorizedMessage is SendGrid's error message for a missing or invalid API key.
+const UnauthorizedMessage = "the provided authorization grant is invalid, expired, or revoked"
+
 // ErrorResponse represents the structure of an error response.
 type ErrorResponse struct {
 	Errors []struct {
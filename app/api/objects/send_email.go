@@ -3,6 +3,8 @@ package objects
 import (
 	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"gopkg.in/go-playground/validator.v9"
 )
@@ -21,6 +23,7 @@ type Personalization struct {
 	Bcc                 []EmailAddress         `json:"bcc"`
 	Substitutions       map[string]string      `json:"substitutions"`
 	Subject             string                 `json:"subject"`
+	Headers             map[string]string      `json:"headers"`
 }
 
 // Content represents an email content block.
@@ -36,6 +39,40 @@ type Attachment struct {
 	Filename    string `json:"filename"`
 	Disposition string `json:"disposition"`
 	ContentId   string `json:"content_id"`
+	// Encoding is the content transfer encoding used in the sent message:
+	// base64 (the default) or quoted-printable. Content is base64 either way.
+	Encoding string `json:"encoding"`
+}
+
+// Setting is a mail setting that is switched on or off.
+type Setting struct {
+	Enable bool `json:"enable"`
+}
+
+// MailSettings holds the request's mail_settings.
+type MailSettings struct {
+	// SandboxMode validates the request without delivering it.
+	SandboxMode Setting `json:"sandbox_mode"`
+}
+
+// TrackingSetting is a tracking setting that is switched on or off. A nil
+// Enable keeps the server's default.
+type TrackingSetting struct {
+	Enable *bool `json:"enable"`
+}
+
+// OpenTracking holds the request's open_tracking setting.
+type OpenTracking struct {
+	Enable *bool `json:"enable"`
+	// SubstitutionTag marks where the tracking pixel goes in the HTML body,
+	// instead of before </body>.
+	SubstitutionTag string `json:"substitution_tag"`
+}
+
+// TrackingSettings holds the request's tracking_settings.
+type TrackingSettings struct {
+	ClickTracking TrackingSetting `json:"click_tracking"`
+	OpenTracking  OpenTracking    `json:"open_tracking"`
 }
 
 // PostRequest represents the structure of the email request body in SendGrid format.
@@ -47,6 +84,21 @@ type PostRequest struct {
 	Content          []Content         `json:"content"`
 	Attachments      []Attachment      `json:"attachments"`
 	TemplateID       string            `json:"template_id"`
+	Headers          map[string]string `json:"headers"`
+
+	// SubstitutionWrappers holds the opening and closing characters that wrap
+	// substitution keys in the content, e.g. ["%", "%"] for %name%.
+	SubstitutionWrappers []string `json:"substitution_wrappers"`
+
+	// IPPoolName selects the IP pool to send from; events echo it as pool.
+	IPPoolName string `json:"ip_pool_name"`
+
+	MailSettings     MailSettings     `json:"mail_settings"`
+	TrackingSettings TrackingSettings `json:"tracking_settings"`
+
+	// SendAt schedules delivery for a Unix time; zero or a past time sends
+	// immediately.
+	SendAt int64 `json:"send_at"`
 }
 
 // Validate validates the PostRequest fields and returns appropriate error responses.
@@ -74,12 +126,7 @@ func (p *PostRequest) Validate() (int, ErrorResponse) {
 								"http://sendgrid.com/docs/API_Reference/Web_API_v3/Mail/errors.html#message.from",
 							)
 					case "Content":
-						return http.StatusBadRequest,
-							GetErrorResponse(
-								"Unless a valid template_id is provided, the content parameter is required. There must be at least one defined content block. We typically suggest both text/plain and text/html blocks are included, but only one block is required.",
-								"content",
-								"http://sendgrid.com/docs/API_Reference/Web_API_v3/Mail/errors.html#message.content",
-							)
+						return http.StatusBadRequest, contentRequiredError()
 					}
 				}
 			}
@@ -87,5 +134,64 @@ func (p *PostRequest) Validate() (int, ErrorResponse) {
 			return http.StatusBadRequest, GetErrorResponse("Validation failed: "+err.Error(), nil, nil)
 		}
 	}
+	if n := len(p.SubstitutionWrappers); n != 0 && n != 2 {
+		return http.StatusBadRequest, GetErrorResponse(
+			"substitution_wrappers must contain exactly two elements: the opening and closing wrapper.",
+			"substitution_wrappers",
+			nil,
+		)
+	}
+	return http.StatusAccepted, GetErrorResponse("", nil, nil)
+}
+
+// ValidateBody rejects a request that has neither content blocks nor a
+// template_id, since it would produce an email with an empty body.
+func (p *PostRequest) ValidateBody() (int, ErrorResponse) {
+	if len(p.Content) == 0 && p.TemplateID == "" {
+		return http.StatusBadRequest, contentRequiredError()
+	}
+	return http.StatusAccepted, GetErrorResponse("", nil, nil)
+}
+
+// ValidateRecipients rejects a request with a personalization that has no to
+// recipients. With allowCcOnly, a personalization with only cc or bcc
+// recipients is accepted.
+func (p *PostRequest) ValidateRecipients(allowCcOnly bool) (int, ErrorResponse) {
+	for i, pers := range p.Personalizations {
+		if len(pers.To) > 0 || (allowCcOnly && len(pers.Cc)+len(pers.Bcc) > 0) {
+			continue
+		}
+		return http.StatusBadRequest, GetErrorResponse(
+			"The to array is required for all personalization objects, and must have at least one email object with a valid email address.",
+			"personalizations."+strconv.Itoa(i)+".to",
+			"http://sendgrid.com/docs/API_Reference/Web_API_v3/Mail/errors.html#message.personalizations.to",
+		)
+	}
 	return http.StatusAccepted, GetErrorResponse("", nil, nil)
 }
+
+// ValidateFromDomain rejects a request whose from.email domain is not in
+// allowed, matched case-insensitively, the way SendGrid rejects senders
+// without a verified identity.
+func (p *PostRequest) ValidateFromDomain(allowed []string) (int, ErrorResponse) {
+	_, domain, _ := strings.Cut(p.From.Email, "@")
+	for _, d := range allowed {
+		if strings.EqualFold(d, domain) {
+			return http.StatusAccepted, GetErrorResponse("", nil, nil)
+		}
+	}
+	return http.StatusForbidden, GetErrorResponse(
+		"The from address does not match a verified Sender Identity. Mail cannot be sent until this error is resolved.",
+		"from.email",
+		"https://sendgrid.com/docs/for-developers/sending-email/sender-identity/",
+	)
+}
+
+// contentRequiredError is SendGrid's error for a missing content parameter.
+func contentRequiredError() ErrorResponse {
+	return GetErrorResponse(
+		"Unless a valid template_id is provided, the content parameter is required. There must be at least one defined content block. We typically suggest both text/plain and text/html blocks are included, but only one block is required.",
+		"content",
+		"http://sendgrid.com/docs/API_Reference/Web_API_v3/Mail/errors.html#message.content",
+	)
+}
@@ -0,0 +1,34 @@
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+)
+
+// CompressBody gzip-compresses a message body for storage.
+func CompressBody(body string) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := io.WriteString(zw, body); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecompressBody reverses CompressBody.
+func DecompressBody(data []byte) (string, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
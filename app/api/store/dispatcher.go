@@ -5,6 +5,7 @@ package store
 // Implementations should handle event delivery asynchronously to avoid blocking message operations.
 type EventDispatcher interface {
 	// DispatchMessageEvent is called when a message status changes.
+	// pool is the message's IP pool name, empty when none was requested.
 	// Implementations should not block the caller.
-	DispatchMessageEvent(msgID, email, from, subject string, status string, reason string)
+	DispatchMessageEvent(msgID, email, from, subject string, status string, reason string, pool string)
 }
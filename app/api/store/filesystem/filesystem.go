@@ -4,18 +4,42 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
+
+	"github.com/mustur/mockgrid/app/api/store"
 )
 
-// Store persists messages as individual JSON files.
+// Store persists messages as individual JSON files. An index of every
+// message's status and timestamp lets list queries read only the files on
+// the requested page.
 type Store struct {
-	dir string
+	dir      string
+	compress bool // gzip message bodies in new files
+
+	indexMu sync.Mutex // guards index and orders message writes with their index lines
+	index   map[string]indexEntry
+
+	webhookMu sync.Mutex // makes UpdateWebhook's compare and write atomic
 }
 
+var _ store.BackendStore = (*Store)(nil)
+
 func New(dir string) (*Store, error) {
 	if err := os.MkdirAll(dir, 0o750); err != nil {
 		return nil, fmt.Errorf("create store directory: %w", err)
 	}
-	return &Store{dir: dir}, nil
+	s := &Store{dir: dir}
+	if err := s.loadIndex(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// WithCompression gzip-compresses the HTML and text bodies of messages saved
+// from now on. Compressed and plain files are both read transparently.
+func (s *Store) WithCompression(on bool) *Store {
+	s.compress = on
+	return s
 }
 
 // Close is a no-op for filesystem store.
@@ -28,6 +52,18 @@ func (s *Store) Connect() error {
 	return nil
 }
 
+// Ping checks that the store directory is still accessible.
+func (s *Store) Ping() error {
+	info, err := os.Stat(s.dir)
+	if err != nil {
+		return fmt.Errorf("stat store directory: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("store path %s is not a directory", s.dir)
+	}
+	return nil
+}
+
 func (s *Store) filename(id string) string {
 	safeID := filepath.Base(id)
 	return filepath.Join(s.dir, safeID+".json")
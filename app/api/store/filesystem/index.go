@@ -0,0 +1,188 @@
+package filesystem
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mustur/mockgrid/app/api/store"
+)
+
+// indexName is the message index file. Its extension keeps it apart from
+// the message files, which all end in .json.
+const indexName = "messages.idx"
+
+// indexEntry is one line of the index: what a list query needs to filter
+// and order a message without reading its file.
+type indexEntry struct {
+	Key       string              `json:"key"` // message file name without .json
+	Status    store.MessageStatus `json:"status"`
+	Timestamp int64               `json:"timestamp"`
+	APIKeyID  string              `json:"api_key_id,omitempty"`
+	SendAt    int64               `json:"send_at,omitempty"`
+}
+
+// loadIndex reads the index into memory. The index is an append-only log in
+// which a message's last line wins. A missing or unreadable index is rebuilt
+// from the message files, and message files saved after their index line
+// was lost (e.g. in a crash) are added. The log is then compacted to one
+// line per message.
+func (s *Store) loadIndex() error {
+	entries, err := readIndex(s.indexFile())
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("rebuilding filesystem store index", "dir", s.dir, "err", err)
+		}
+		entries = make(map[string]indexEntry)
+	}
+
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("read store directory: %w", err)
+	}
+	present := make(map[string]bool, len(files))
+	for _, file := range files {
+		key, ok := strings.CutSuffix(file.Name(), ".json")
+		if file.IsDir() || !ok {
+			continue
+		}
+		present[key] = true
+		if _, ok := entries[key]; ok {
+			continue
+		}
+		msg, err := s.readMessageFile(file.Name())
+		if err != nil {
+			continue
+		}
+		entries[key] = newIndexEntry(key, msg)
+	}
+	for key := range entries {
+		if !present[key] {
+			delete(entries, key)
+		}
+	}
+
+	s.index = entries
+	return s.compactIndex()
+}
+
+// readIndex replays the index log at path.
+func readIndex(path string) (map[string]indexEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]indexEntry)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		var e indexEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil || e.Key == "" {
+			return nil, fmt.Errorf("corrupt index line %q", sc.Text())
+		}
+		entries[e.Key] = e
+	}
+	return entries, sc.Err()
+}
+
+// compactIndex rewrites the index with one line per message. The new index
+// replaces the old one in a single rename, so a crash leaves either intact.
+func (s *Store) compactIndex() error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range s.index {
+		if err := enc.Encode(e); err != nil {
+			return fmt.Errorf("encode index entry: %w", err)
+		}
+	}
+	tmp := s.indexFile() + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("write index file: %w", err)
+	}
+	if err := os.Rename(tmp, s.indexFile()); err != nil {
+		return fmt.Errorf("replace index file: %w", err)
+	}
+	return nil
+}
+
+// indexMessage records a saved message in the index. The caller holds
+// s.indexMu.
+func (s *Store) indexMessage(key string, msg *store.Message) error {
+	e := newIndexEntry(key, msg)
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("encode index entry: %w", err)
+	}
+
+	f, err := os.OpenFile(s.indexFile(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("open index file: %w", err)
+	}
+	_, err = f.Write(append(line, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("append to index file: %w", err)
+	}
+	s.index[key] = e
+	return nil
+}
+
+// indexedPage returns the file keys of the messages a list query selects,
+// filtered by status, API key, time and send_at and paginated, in the
+// order GetMSG returns them.
+func (s *Store) indexedPage(query store.GetQuery, limit int) []string {
+	s.indexMu.Lock()
+	var matched []indexEntry
+	for _, e := range s.index {
+		if query.Status != "" && e.Status != query.Status {
+			continue
+		}
+		if query.APIKeyID != "" && e.APIKeyID != query.APIKeyID {
+			continue
+		}
+		if !store.InTimeRange(e.Timestamp, query.Since, query.Until) {
+			continue
+		}
+		if !store.DueBy(e.SendAt, query.SendBefore) {
+			continue
+		}
+		matched = append(matched, e)
+	}
+	s.indexMu.Unlock()
+
+	// Same order as store.SortNewestFirst; the key is the message ID for
+	// every ID that is a valid file name
+	msgs := make([]*store.Message, len(matched))
+	for i, e := range matched {
+		msgs[i] = &store.Message{MsgID: e.Key, Timestamp: e.Timestamp}
+	}
+	store.SortNewestFirst(msgs)
+
+	if query.Offset >= len(msgs) {
+		return nil
+	}
+	msgs = msgs[query.Offset:]
+	if len(msgs) > limit {
+		msgs = msgs[:limit]
+	}
+	keys := make([]string, len(msgs))
+	for i, msg := range msgs {
+		keys[i] = msg.MsgID
+	}
+	return keys
+}
+
+func newIndexEntry(key string, msg *store.Message) indexEntry {
+	return indexEntry{Key: key, Status: msg.Status, Timestamp: msg.Timestamp, APIKeyID: msg.APIKeyID, SendAt: msg.SendAt}
+}
+
+func (s *Store) indexFile() string {
+	return filepath.Join(s.dir, indexName)
+}
@@ -0,0 +1,145 @@
+package filesystem
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/mustur/mockgrid/app/api/store"
+)
+
+// --- Index Tests ---
+
+func TestIndex_RebuiltWhenMissingOrCorrupt(t *testing.T) {
+	for name, damage := range map[string]func(path string) error{
+		"missing": os.Remove,
+		"corrupt": func(path string) error { return os.WriteFile(path, []byte("{not json\n"), 0o600) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := newTestStore(t, dir)
+			saveMessages(t, s, 3)
+			if err := damage(s.indexFile()); err != nil {
+				t.Fatalf("damage index: %v", err)
+			}
+
+			reopened := newTestStore(t, dir)
+			msgs, err := reopened.GetMSG(store.GetQuery{Status: store.StatusDelivered})
+			if err != nil {
+				t.Fatalf("GetMSG failed: %v", err)
+			}
+			if len(msgs) != 2 || msgs[0].MsgID != "msg-2" || msgs[1].MsgID != "msg-0" {
+				t.Errorf("expected msg-2 and msg-0 from the rebuilt index, got %v", msgIDs(msgs))
+			}
+		})
+	}
+}
+
+func TestIndex_AddsFilesMissingFromIndex(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir)
+	saveMessages(t, s, 2)
+
+	// A message file written without its index line, as after a crash
+	data := []byte(`{"msg_id":"late","status":"delivered","timestamp":1700000100}`)
+	if err := os.WriteFile(s.filename("late"), data, 0o600); err != nil {
+		t.Fatalf("write message file: %v", err)
+	}
+
+	msgs, err := newTestStore(t, dir).GetMSG(store.GetQuery{})
+	if err != nil {
+		t.Fatalf("GetMSG failed: %v", err)
+	}
+	if len(msgs) != 3 || msgs[0].MsgID != "late" {
+		t.Errorf("expected late first among 3 messages, got %v", msgIDs(msgs))
+	}
+}
+
+func TestIndex_KeepsLatestStatus(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir)
+	msg := &store.Message{MsgID: "m", Status: store.StatusProcessed, Timestamp: 1}
+	if err := s.SaveMSG(msg); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	msg.Status = store.StatusBounce
+	if err := s.SaveMSG(msg); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	for _, st := range []*Store{s, newTestStore(t, dir)} {
+		if msgs, _ := st.GetMSG(store.GetQuery{Status: store.StatusProcessed}); len(msgs) != 0 {
+			t.Errorf("expected no processed messages, got %v", msgIDs(msgs))
+		}
+		if msgs, _ := st.GetMSG(store.GetQuery{Status: store.StatusBounce}); len(msgs) != 1 {
+			t.Errorf("expected 1 bounced message, got %v", msgIDs(msgs))
+		}
+	}
+}
+
+// --- Benchmarks ---
+
+func BenchmarkFilesystem_List(b *testing.B) {
+	s, err := New(b.TempDir())
+	if err != nil {
+		b.Fatalf("New failed: %v", err)
+	}
+	saveMessages(b, s, 5000)
+	query := store.GetQuery{Status: store.StatusDelivered, Limit: 50, Offset: 100}
+
+	for name, list := range map[string]func(store.GetQuery) ([]*store.Message, error){
+		"indexed":   s.listMSG,
+		"full-scan": s.scanMSG,
+	} {
+		b.Run(name, func(b *testing.B) {
+			for b.Loop() {
+				if _, err := list(query); err != nil {
+					b.Fatalf("list failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// --- Test Helpers ---
+
+func newTestStore(t testing.TB, dir string) *Store {
+	t.Helper()
+	s, err := New(dir)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return s
+}
+
+// saveMessages saves n messages msg-0 to msg-(n-1) with rising timestamps;
+// even ones are delivered and odd ones bounced.
+func saveMessages(t testing.TB, s *Store, n int) {
+	t.Helper()
+	for i := range n {
+		status := store.StatusDelivered
+		if i%2 == 1 {
+			status = store.StatusBounce
+		}
+		msg := &store.Message{
+			MsgID:     fmt.Sprintf("msg-%d", i),
+			FromEmail: "a@b.com",
+			ToEmail:   "c@d.com",
+			Subject:   "Index",
+			HTMLBody:  "<p>Test</p>",
+			Status:    status,
+			Timestamp: 1700000000 + int64(i),
+		}
+		if err := s.SaveMSG(msg); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+}
+
+func msgIDs(msgs []*store.Message) []string {
+	ids := make([]string, len(msgs))
+	for i, msg := range msgs {
+		ids[i] = msg.MsgID
+	}
+	return ids
+}
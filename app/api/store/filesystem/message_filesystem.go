@@ -18,17 +18,19 @@ func (s *Store) SaveMSG(msg *store.Message) error {
 		return fmt.Errorf("message ID is required")
 	}
 
-	data, err := json.MarshalIndent(msg, "", "  ")
+	data, err := s.encodeMessage(msg)
 	if err != nil {
 		return fmt.Errorf("marshal message: %w", err)
 	}
 
 	filename := s.filename(msg.MsgID)
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
 	if err := os.WriteFile(filename, data, 0o600); err != nil {
 		return fmt.Errorf("write message file: %w", err)
 	}
 
-	return nil
+	return s.indexMessage(strings.TrimSuffix(filepath.Base(filename), ".json"), msg)
 }
 
 // Get retrieves messages based on query parameters.
@@ -51,15 +53,39 @@ func (s *Store) getMSGByID(id string) ([]*store.Message, error) {
 		return nil, fmt.Errorf("read message file: %w", err)
 	}
 
-	var msg store.Message
-	if err := json.Unmarshal(data, &msg); err != nil {
+	msg, err := decodeMessage(data)
+	if err != nil {
 		return nil, fmt.Errorf("unmarshal message: %w", err)
 	}
 
-	return []*store.Message{&msg}, nil
+	return []*store.Message{msg}, nil
 }
 
+// listMSG serves a list query from the index, reading only the files on the
+// page. Header matches need the message files, so they fall back to a scan.
 func (s *Store) listMSG(query store.GetQuery) ([]*store.Message, error) {
+	if len(query.HeaderMatch) > 0 {
+		return s.scanMSG(query)
+	}
+
+	limit := query.Limit
+	if limit == 0 {
+		limit = 100
+	}
+
+	var messages []*store.Message
+	for _, key := range s.indexedPage(query, limit) {
+		msg, err := s.readMessageFile(key + ".json")
+		if err != nil {
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// scanMSG serves a list query by reading every message file.
+func (s *Store) scanMSG(query store.GetQuery) ([]*store.Message, error) {
 	entries, err := os.ReadDir(s.dir)
 	if err != nil {
 		return nil, fmt.Errorf("read store directory: %w", err)
@@ -71,8 +97,6 @@ func (s *Store) listMSG(query store.GetQuery) ([]*store.Message, error) {
 	}
 
 	var messages []*store.Message
-	skipped := 0
-
 	for _, entry := range entries {
 		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
 			continue
@@ -86,21 +110,96 @@ func (s *Store) listMSG(query store.GetQuery) ([]*store.Message, error) {
 		if query.Status != "" && msg.Status != query.Status {
 			continue
 		}
-
-		if skipped < query.Offset {
-			skipped++
+		if query.APIKeyID != "" && msg.APIKeyID != query.APIKeyID {
 			continue
 		}
-
-		messages = append(messages, msg)
-		if len(messages) >= limit {
-			break
+		if !store.InTimeRange(msg.Timestamp, query.Since, query.Until) {
+			continue
+		}
+		if !store.DueBy(msg.SendAt, query.SendBefore) {
+			continue
+		}
+		if !store.MatchesHeaders(msg, query.HeaderMatch) {
+			continue
 		}
+		messages = append(messages, msg)
 	}
 
+	// Every file is read so the page is taken from the sorted list
+	store.SortNewestFirst(messages)
+	if query.Offset >= len(messages) {
+		return nil, nil
+	}
+	messages = messages[query.Offset:]
+	if len(messages) > limit {
+		messages = messages[:limit]
+	}
 	return messages, nil
 }
 
+// DistinctRecipients counts messages per recipient address across all message files.
+func (s *Store) DistinctRecipients() (map[string]int, error) {
+	counts := make(map[string]int)
+	err := s.Iterate(func(msg *store.Message) bool {
+		counts[msg.ToEmail]++
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// DailyStats aggregates all message files by day.
+func (s *Store) DailyStats(from, to int64) ([]store.DailyStat, error) {
+	var msgs []*store.Message
+	err := s.Iterate(func(msg *store.Message) bool {
+		msgs = append(msgs, msg)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return store.AggregateDaily(msgs, from, to), nil
+}
+
+// Count returns the number of message files.
+func (s *Store) Count() (int, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, fmt.Errorf("read store directory: %w", err)
+	}
+	n := 0
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Iterate reads each message file in turn, skipping unreadable files.
+func (s *Store) Iterate(fn func(*store.Message) bool) error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("read store directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		msg, err := s.readMessageFile(entry.Name())
+		if err != nil {
+			continue
+		}
+		if !fn(msg) {
+			return nil
+		}
+	}
+	return nil
+}
+
 func (s *Store) readMessageFile(name string) (*store.Message, error) {
 	fsys := os.DirFS(s.dir)
 
@@ -109,10 +208,60 @@ func (s *Store) readMessageFile(name string) (*store.Message, error) {
 		return nil, err
 	}
 
-	var msg store.Message
-	if err := json.Unmarshal(data, &msg); err != nil {
-		return nil, err
+	return decodeMessage(data)
+}
+
+// diskMessage is a message as written to its file. Compressed bodies are
+// kept in the gzip fields, leaving the plain ones empty. The scheduled mail,
+// which the message does not marshal, is written alongside it.
+type diskMessage struct {
+	*store.Message
+	HTMLBodyGz []byte               `json:"html_body_gz,omitempty"`
+	TextBodyGz []byte               `json:"text_body_gz,omitempty"`
+	Scheduled  *store.ScheduledMail `json:"scheduled,omitempty"`
+}
+
+// encodeMessage returns the file content for msg, with its bodies
+// compressed when compression is on.
+func (s *Store) encodeMessage(msg *store.Message) ([]byte, error) {
+	cp := *msg
+	d := diskMessage{Message: &cp, Scheduled: msg.Scheduled}
+	if !s.compress {
+		return json.MarshalIndent(d, "", "  ")
+	}
+	var err error
+	if cp.HTMLBody != "" {
+		if d.HTMLBodyGz, err = store.CompressBody(cp.HTMLBody); err != nil {
+			return nil, err
+		}
+		cp.HTMLBody = ""
+	}
+	if cp.TextBody != "" {
+		if d.TextBodyGz, err = store.CompressBody(cp.TextBody); err != nil {
+			return nil, err
+		}
+		cp.TextBody = ""
 	}
+	return json.MarshalIndent(d, "", "  ")
+}
 
-	return &msg, nil
+// decodeMessage parses a message file, decompressing its bodies if needed.
+func decodeMessage(data []byte) (*store.Message, error) {
+	d := diskMessage{Message: &store.Message{}}
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, err
+	}
+	d.Message.Scheduled = d.Scheduled
+	var err error
+	if len(d.HTMLBodyGz) > 0 {
+		if d.HTMLBody, err = store.DecompressBody(d.HTMLBodyGz); err != nil {
+			return nil, fmt.Errorf("decompress html body: %w", err)
+		}
+	}
+	if len(d.TextBodyGz) > 0 {
+		if d.TextBody, err = store.DecompressBody(d.TextBodyGz); err != nil {
+			return nil, fmt.Errorf("decompress text body: %w", err)
+		}
+	}
+	return d.Message, nil
 }
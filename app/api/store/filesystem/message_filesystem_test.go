@@ -22,6 +22,51 @@ func TestFilesystem_Contract(t *testing.T) {
 	})
 }
 
+func TestFilesystem_Contract_Compressed(t *testing.T) {
+	testutil.RunStoreContractTests(t, "filesystem", func(t *testing.T) store.MessageStore {
+		s, err := filesystem.New(t.TempDir())
+		if err != nil {
+			t.Fatalf("failed to create filesystem store: %v", err)
+		}
+		return s.WithCompression(true)
+	})
+}
+
+func TestFilesystem_Compression_ShrinksStoredFile(t *testing.T) {
+	dir := t.TempDir()
+	s, err := filesystem.New(dir)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	s.WithCompression(true)
+
+	msg := &store.Message{MsgID: "large", Status: store.StatusProcessed, Timestamp: 1, HTMLBody: testutil.LargeBody}
+	if err := s.SaveMSG(msg); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "large.json"))
+	if err != nil {
+		t.Fatalf("stat message file: %v", err)
+	}
+	if info.Size() >= int64(len(testutil.LargeBody)) {
+		t.Errorf("expected the file to be smaller than the %d byte body, got %d bytes", len(testutil.LargeBody), info.Size())
+	}
+
+	// Files saved without compression stay readable alongside compressed ones
+	s.WithCompression(false)
+	if err := s.SaveMSG(&store.Message{MsgID: "plain", Timestamp: 2, HTMLBody: "<p>plain</p>"}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	got, err := s.GetMSG(store.GetQuery{})
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if len(got) != 2 || got[0].HTMLBody != "<p>plain</p>" || got[1].HTMLBody != testutil.LargeBody {
+		t.Errorf("expected both bodies read back, got %d messages", len(got))
+	}
+}
+
 func TestFilesystem_New_CreatesDirectory(t *testing.T) {
 	dir := filepath.Join(t.TempDir(), "subdir", "nested")
 	s, err := filesystem.New(dir)
@@ -115,16 +115,49 @@ func (s *Store) ListEnabledWebhooks() ([]*store.WebhookConfig, error) {
 
 // UpdateWebhook updates an existing webhook file.
 func (s *Store) UpdateWebhook(hook *store.WebhookConfig) error {
-	file := s.webhookFile(hook.ID)
-	if _, err := os.Stat(file); os.IsNotExist(err) {
-		return store.ErrNotFound
+	s.webhookMu.Lock()
+	defer s.webhookMu.Unlock()
+
+	current, err := s.GetWebhook(hook.ID)
+	if err != nil {
+		return err
 	}
-	hook.UpdatedAt = time.Now().Unix()
-	data, err := json.MarshalIndent(hook, "", "  ")
+	if current.UpdatedAt != hook.UpdatedAt {
+		return store.ErrConflict
+	}
+
+	updated := *hook
+	updated.UpdatedAt = store.NextUpdatedAt(hook.UpdatedAt)
+	data, err := json.MarshalIndent(&updated, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(s.webhookFile(hook.ID), data, 0o600); err != nil {
+		return err
+	}
+	hook.UpdatedAt = updated.UpdatedAt
+	return nil
+}
+
+// DeleteAllWebhooks removes every webhook file, leaving the directory in place.
+func (s *Store) DeleteAllWebhooks() error {
+	dir := filepath.Join(s.dir, "webhooks")
+	entries, err := os.ReadDir(dir)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
 		return err
 	}
-	return os.WriteFile(file, data, 0o600)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
 }
 
 // DeleteWebhook removes a webhook file.
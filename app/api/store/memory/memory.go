@@ -0,0 +1,259 @@
+// Package memory provides an in-memory message and webhook store. Data lives
+// only as long as the process, for ephemeral setups that still need to read
+// back what was sent.
+package memory
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mustur/mockgrid/app/api/store"
+)
+
+// Store keeps messages and webhooks in maps guarded by a mutex. Values are
+// copied on the way in and out, so callers never share state with the store.
+type Store struct {
+	mu       sync.RWMutex
+	messages map[string]*store.Message
+	webhooks map[string]*store.WebhookConfig
+}
+
+var _ store.BackendStore = (*Store)(nil)
+
+// New creates an empty in-memory store.
+func New() *Store {
+	return &Store{
+		messages: make(map[string]*store.Message),
+		webhooks: make(map[string]*store.WebhookConfig),
+	}
+}
+
+// Connect is a no-op; the store is ready once created.
+func (s *Store) Connect() error {
+	return nil
+}
+
+// Ping always succeeds.
+func (s *Store) Ping() error {
+	return nil
+}
+
+// Close is a no-op; stored data stays readable until the process exits.
+func (s *Store) Close() error {
+	return nil
+}
+
+// SaveMSG inserts or replaces a message.
+func (s *Store) SaveMSG(msg *store.Message) error {
+	if msg.MsgID == "" {
+		return fmt.Errorf("message ID is required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages[msg.MsgID] = copyMessage(msg)
+	return nil
+}
+
+// GetMSG retrieves messages based on query parameters.
+func (s *Store) GetMSG(query store.GetQuery) ([]*store.Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if query.ID != "" {
+		msg, ok := s.messages[query.ID]
+		if !ok {
+			return nil, store.ErrNotFound
+		}
+		return []*store.Message{copyMessage(msg)}, nil
+	}
+
+	limit := query.Limit
+	if limit == 0 {
+		limit = 100
+	}
+
+	var messages []*store.Message
+	for _, msg := range s.messages {
+		if query.Status != "" && msg.Status != query.Status {
+			continue
+		}
+		if query.APIKeyID != "" && msg.APIKeyID != query.APIKeyID {
+			continue
+		}
+		if !store.InTimeRange(msg.Timestamp, query.Since, query.Until) {
+			continue
+		}
+		if !store.DueBy(msg.SendAt, query.SendBefore) {
+			continue
+		}
+		if !store.MatchesHeaders(msg, query.HeaderMatch) {
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	store.SortNewestFirst(messages)
+
+	if query.Offset >= len(messages) {
+		return []*store.Message{}, nil
+	}
+	messages = messages[query.Offset:]
+	if limit > 0 && limit < len(messages) {
+		messages = messages[:limit]
+	}
+
+	page := make([]*store.Message, len(messages))
+	for i, msg := range messages {
+		page[i] = copyMessage(msg)
+	}
+	return page, nil
+}
+
+// DistinctRecipients counts messages per recipient address.
+func (s *Store) DistinctRecipients() (map[string]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	counts := make(map[string]int)
+	for _, msg := range s.messages {
+		counts[msg.ToEmail]++
+	}
+	return counts, nil
+}
+
+// DailyStats aggregates messages per UTC day.
+func (s *Store) DailyStats(from, to int64) ([]store.DailyStat, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return store.AggregateDaily(slices.Collect(maps.Values(s.messages)), from, to), nil
+}
+
+// Count returns the number of stored messages.
+func (s *Store) Count() (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.messages), nil
+}
+
+// Iterate calls fn with a copy of every stored message. It works on a
+// snapshot, so fn may save messages without deadlocking.
+func (s *Store) Iterate(fn func(*store.Message) bool) error {
+	s.mu.RLock()
+	snapshot := make([]*store.Message, 0, len(s.messages))
+	for _, msg := range s.messages {
+		snapshot = append(snapshot, copyMessage(msg))
+	}
+	s.mu.RUnlock()
+
+	for _, msg := range snapshot {
+		if !fn(msg) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// WebhookStore implementation
+func (s *Store) Create(hook *store.WebhookConfig) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.webhooks[hook.ID]; ok {
+		return fmt.Errorf("webhook %s already exists", hook.ID)
+	}
+	if hook.CreatedAt == 0 {
+		hook.CreatedAt = time.Now().Unix()
+	}
+	if hook.UpdatedAt == 0 {
+		hook.UpdatedAt = hook.CreatedAt
+	}
+	s.webhooks[hook.ID] = copyWebhook(hook)
+	return nil
+}
+
+func (s *Store) GetWebhook(id string) (*store.WebhookConfig, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hook, ok := s.webhooks[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return copyWebhook(hook), nil
+}
+
+// ListWebhooks returns every webhook, newest first.
+func (s *Store) ListWebhooks() ([]*store.WebhookConfig, error) {
+	return s.listWebhooks(false), nil
+}
+
+// ListEnabledWebhooks returns the enabled webhooks, newest first.
+func (s *Store) ListEnabledWebhooks() ([]*store.WebhookConfig, error) {
+	return s.listWebhooks(true), nil
+}
+
+func (s *Store) listWebhooks(enabledOnly bool) []*store.WebhookConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var res []*store.WebhookConfig
+	for _, hook := range s.webhooks {
+		if enabledOnly && !hook.Enabled {
+			continue
+		}
+		res = append(res, copyWebhook(hook))
+	}
+	slices.SortFunc(res, func(a, b *store.WebhookConfig) int {
+		if c := cmp.Compare(b.CreatedAt, a.CreatedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return res
+}
+
+func (s *Store) UpdateWebhook(hook *store.WebhookConfig) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.webhooks[hook.ID]
+	if !ok {
+		return store.ErrNotFound
+	}
+	// The webhook only changes if nobody updated it since hook was read
+	if current.UpdatedAt != hook.UpdatedAt {
+		return store.ErrConflict
+	}
+	hook.UpdatedAt = store.NextUpdatedAt(hook.UpdatedAt)
+	updated := copyWebhook(hook)
+	updated.CreatedAt = current.CreatedAt
+	s.webhooks[hook.ID] = updated
+	return nil
+}
+
+func (s *Store) DeleteWebhook(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.webhooks, id)
+	return nil
+}
+
+func (s *Store) DeleteAllWebhooks() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.webhooks)
+	return nil
+}
+
+// copyMessage returns a copy of msg that shares no slices or maps with it.
+func copyMessage(msg *store.Message) *store.Message {
+	cp := *msg
+	cp.Attachments = slices.Clone(msg.Attachments)
+	cp.RequestHeaders = maps.Clone(msg.RequestHeaders)
+	return &cp
+}
+
+// copyWebhook returns a copy of hook that shares no slices with it.
+func copyWebhook(hook *store.WebhookConfig) *store.WebhookConfig {
+	cp := *hook
+	cp.Events = slices.Clone(hook.Events)
+	return &cp
+}
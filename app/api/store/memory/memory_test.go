@@ -0,0 +1,70 @@
+package memory_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mustur/mockgrid/app/api/store"
+	"github.com/mustur/mockgrid/app/api/store/memory"
+	"github.com/mustur/mockgrid/internal/testutil"
+)
+
+func TestMemory_Contract(t *testing.T) {
+	testutil.RunStoreContractTests(t, "memory", func(t *testing.T) store.MessageStore {
+		return memory.New()
+	})
+}
+
+func TestMemory_SaveMSG_CopiesMessage(t *testing.T) {
+	s := memory.New()
+	msg := &store.Message{MsgID: "m1", Status: store.StatusProcessed, RequestHeaders: map[string]string{"X-Tenant": "a"}}
+	if err := s.SaveMSG(msg); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// Changing the caller's value must not reach the stored message
+	msg.Status = store.StatusDelivered
+	msg.RequestHeaders["X-Tenant"] = "b"
+
+	got, err := s.GetMSG(store.GetQuery{ID: "m1"})
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got[0].Status != store.StatusProcessed || got[0].RequestHeaders["X-Tenant"] != "a" {
+		t.Errorf("expected the stored copy unchanged, got %+v", got[0])
+	}
+}
+
+func TestMemory_Webhooks_CreateUpdateDelete(t *testing.T) {
+	s := memory.New()
+	hook := &store.WebhookConfig{ID: "wh-1", URL: "http://example.com/hook", Enabled: true, Events: []string{"delivered"}}
+	if err := s.Create(hook); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := s.Create(hook); err == nil {
+		t.Error("expected an error creating a duplicate ID")
+	}
+
+	got, err := s.GetWebhook("wh-1")
+	if err != nil {
+		t.Fatalf("GetWebhook failed: %v", err)
+	}
+	stale := *got
+	got.Enabled = false
+	if err := s.UpdateWebhook(got); err != nil {
+		t.Fatalf("UpdateWebhook failed: %v", err)
+	}
+	if err := s.UpdateWebhook(&stale); !errors.Is(err, store.ErrConflict) {
+		t.Errorf("expected ErrConflict for a stale update, got %v", err)
+	}
+	if enabled, _ := s.ListEnabledWebhooks(); len(enabled) != 0 {
+		t.Errorf("expected no enabled webhooks, got %d", len(enabled))
+	}
+
+	if err := s.DeleteWebhook("wh-1"); err != nil {
+		t.Fatalf("DeleteWebhook failed: %v", err)
+	}
+	if _, err := s.GetWebhook("wh-1"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected ErrNotFound after delete, got %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package store
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -12,6 +13,7 @@ import (
 // Common errors for store implementations.
 var (
 	ErrNotFound = errors.New("record not found")
+	ErrConflict = errors.New("record was modified concurrently")
 )
 
 // MessageStatus represents the delivery status of a message.
@@ -27,21 +29,84 @@ const (
 	StatusDropped   MessageStatus = "dropped"   // Message dropped before sending
 )
 
+// Statuses lists every MessageStatus in delivery order.
+var Statuses = []MessageStatus{
+	StatusProcessed, StatusDelivered, StatusDeferred, StatusBounce, StatusBlocked, StatusDropped,
+}
+
+// SendGrid's canonical reasons carried by dropped events.
+const (
+	DropReasonInvalidSMTPAPIHeader = "Invalid SMTPAPI header"
+	DropReasonSpamContent          = "Spam Content"
+	DropReasonUnsubscribed         = "Unsubscribed Address"
+	DropReasonBounced              = "Bounced Address"
+	DropReasonSpamReporting        = "Spam Reporting Address"
+	DropReasonInvalid              = "Invalid"
+	DropReasonQuota                = "Recipient List over Package Quota"
+)
+
+// Engagement event types. Unlike MessageStatus values they do not change a
+// message's status; they increment its counters.
+const (
+	EventOpen  = "open"
+	EventClick = "click"
+)
+
 // Message represents a stored email message with its delivery status.
 type Message struct {
-	MsgID         string        `json:"msg_id"`
-	FromEmail     string        `json:"from_email"`
-	ToEmail       string        `json:"to_email"`
-	Subject       string        `json:"subject"`
-	HTMLBody      string        `json:"html_body,omitempty"`
-	TextBody      string        `json:"text_body,omitempty"`
-	Status        MessageStatus `json:"status"`
-	SMTPResponse  string        `json:"smtp_response,omitempty"`
-	Reason        string        `json:"reason,omitempty"`
-	Timestamp     int64         `json:"timestamp"`
-	LastEventTime int64         `json:"last_event_time,omitempty"`
-	OpensCount    int           `json:"opens_count,omitempty"`
-	ClicksCount   int           `json:"clicks_count,omitempty"`
+	MsgID         string           `json:"msg_id"`
+	FromEmail     string           `json:"from_email"`
+	FromName      string           `json:"from_name,omitempty"`
+	ToEmail       string           `json:"to_email"`
+	ToName        string           `json:"to_name,omitempty"`
+	Subject       string           `json:"subject"`
+	HTMLBody      string           `json:"html_body,omitempty"`
+	TextBody      string           `json:"text_body,omitempty"`
+	AMPBody       string           `json:"amp_body,omitempty"`
+	Status        MessageStatus    `json:"status"`
+	SMTPResponse  string           `json:"smtp_response,omitempty"`
+	Reason        string           `json:"reason,omitempty"`
+	Timestamp     int64            `json:"timestamp"`
+	LastEventTime int64            `json:"last_event_time,omitempty"`
+	OpensCount    int              `json:"opens_count,omitempty"`
+	ClicksCount   int              `json:"clicks_count,omitempty"`
+	Attachments   []AttachmentMeta `json:"attachments,omitempty"`
+	ThreadKey     string           `json:"thread_key,omitempty"`
+	// IPPool is the ip_pool_name the message was sent with.
+	IPPool string `json:"ip_pool_name,omitempty"`
+	// RequestHeaders holds the incoming request headers selected by
+	// capture_request_headers, keyed by canonical header name.
+	RequestHeaders map[string]string `json:"request_headers,omitempty"`
+	// SendAt is the Unix time a scheduled message is delivered at. It stays
+	// set after delivery; only processed messages are still pending.
+	SendAt int64 `json:"send_at,omitempty"`
+	// APIKeyID identifies the API key the message was sent with. It is a
+	// short hash of the key (see KeyID); the key itself is never stored.
+	APIKeyID string `json:"api_key_id,omitempty"`
+	// Scheduled holds the mail a scheduled message is delivered from. It is
+	// internal to the store and cleared once the message is delivered.
+	Scheduled *ScheduledMail `json:"-"`
+}
+
+// ScheduledMail is a rendered message waiting for its send_at. It keeps what
+// the stored fields leave out, such as cc, bcc, attachments and custom
+// headers, so the mail is delivered exactly as it was sent. The messages of
+// one personalization share it and are delivered together, once per Group.
+type ScheduledMail struct {
+	Group      string   `json:"group"`
+	From       string   `json:"from"`       // envelope sender
+	Recipients []string `json:"recipients"` // envelope recipients, including cc and bcc
+	Raw        []byte   `json:"raw"`        // the MIME message
+}
+
+// AttachmentMeta describes an attachment sent with a message.
+// The attachment content itself is never stored.
+type AttachmentMeta struct {
+	Filename    string `json:"filename"`
+	Type        string `json:"type,omitempty"`
+	Size        int    `json:"size"` // decoded size in bytes
+	Disposition string `json:"disposition,omitempty"`
+	ContentID   string `json:"content_id,omitempty"`
 }
 
 // GetQuery defines query parameters for fetching messages.
@@ -50,21 +115,85 @@ type GetQuery struct {
 	Status MessageStatus
 	Limit  int
 	Offset int
+
+	// HeaderMatch restricts results to messages whose RequestHeaders hold
+	// every given value, keyed by canonical header name.
+	HeaderMatch map[string]string
+
+	// APIKeyID restricts results to messages sent with the API key of that
+	// identifier.
+	APIKeyID string
+
+	// Since and Until restrict results to messages with
+	// Since <= Timestamp <= Until, in Unix seconds. Zero leaves that end open.
+	Since int64
+	Until int64
+
+	// SendBefore restricts results to scheduled messages with
+	// 0 < SendAt <= SendBefore, in Unix seconds. Zero applies no bound.
+	SendBefore int64
+}
+
+// DueBy reports whether a message with the given send_at is selected by a
+// SendBefore bound of before.
+func DueBy(sendAt, before int64) bool {
+	return before == 0 || (sendAt > 0 && sendAt <= before)
+}
+
+// InTimeRange reports whether ts lies within since and until, inclusive.
+// A zero bound leaves that end open.
+func InTimeRange(ts, since, until int64) bool {
+	return (since == 0 || ts >= since) && (until == 0 || ts <= until)
+}
+
+// MatchesHeaders reports whether msg carries every header value in match.
+func MatchesHeaders(msg *Message, match map[string]string) bool {
+	for k, v := range match {
+		got, ok := msg.RequestHeaders[k]
+		if !ok || got != v {
+			return false
+		}
+	}
+	return true
 }
 
 // MessageStore defines the interface for message persistence.
 type MessageStore interface {
-	// Save persists a message to the store.
+	// SaveMSG persists a message to the store.
 	SaveMSG(msg *Message) error
 
-	// Get retrieves messages based on query parameters.
+	// GetMSG retrieves messages based on query parameters.
 	// If query.ID is set, returns a single message or ErrNotFound.
+	// Lists are ordered newest first, ties broken by MsgID descending
+	// (see SortNewestFirst).
 	GetMSG(query GetQuery) ([]*Message, error)
 
+	// DistinctRecipients returns each distinct recipient address with the
+	// number of messages sent to it.
+	DistinctRecipients() (map[string]int, error)
+
+	// DailyStats returns per-day message counts for messages with
+	// from <= Timestamp < to, ordered by date. A to of zero means no upper bound.
+	DailyStats(from, to int64) ([]DailyStat, error)
+
+	// Count returns the total number of stored messages.
+	Count() (int, error)
+
+	// Iterate calls fn for every stored message, in no particular order,
+	// until fn returns false.
+	Iterate(fn func(*Message) bool) error
+
 	// Close releases any resources held by the store.
 	Close() error
 }
 
+// KeyID returns the identifier recorded for an API key: the first 8 bytes of
+// its SHA-256 hash, in hex. The key cannot be recovered from it.
+func KeyID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
 // GenerateMessageID creates a unique message ID using timestamp and random bytes.
 func GenerateMessageID() (string, error) {
 	b := make([]byte, 8)
@@ -9,6 +9,8 @@ import (
 // It discards all messages and returns empty results.
 type Store struct{}
 
+var _ store.BackendStore = (*Store)(nil)
+
 // New creates a new no-op store.
 func New() *Store {
 	return &Store{}
@@ -24,6 +26,26 @@ func (s *Store) GetMSG(_ store.GetQuery) ([]*store.Message, error) {
 	return []*store.Message{}, nil
 }
 
+// DistinctRecipients always returns an empty map.
+func (s *Store) DistinctRecipients() (map[string]int, error) {
+	return map[string]int{}, nil
+}
+
+// DailyStats always returns an empty slice.
+func (s *Store) DailyStats(_, _ int64) ([]store.DailyStat, error) {
+	return []store.DailyStat{}, nil
+}
+
+// Count always returns zero.
+func (s *Store) Count() (int, error) {
+	return 0, nil
+}
+
+// Iterate never calls fn since nothing is stored.
+func (s *Store) Iterate(_ func(*store.Message) bool) error {
+	return nil
+}
+
 // Close is a no-op.
 func (s *Store) Close() error {
 	return nil
@@ -34,6 +56,11 @@ func (s *Store) Connect() error {
 	return nil
 }
 
+// Ping always succeeds.
+func (s *Store) Ping() error {
+	return nil
+}
+
 // Webhook store no-op implementations
 func (s *Store) Create(_ *store.WebhookConfig) error               { return nil }
 func (s *Store) GetWebhook(_ string) (*store.WebhookConfig, error) { return nil, store.ErrNotFound }
@@ -43,3 +70,4 @@ func (s *Store) ListEnabledWebhooks() ([]*store.WebhookConfig, error) {
 }
 func (s *Store) UpdateWebhook(_ *store.WebhookConfig) error { return store.ErrNotFound }
 func (s *Store) DeleteWebhook(_ string) error               { return store.ErrNotFound }
+func (s *Store) DeleteAllWebhooks() error                   { return nil }
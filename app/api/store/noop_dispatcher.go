@@ -5,6 +5,6 @@ package store
 type NoOpDispatcher struct{}
 
 // DispatchMessageEvent discards the event and returns immediately.
-func (n *NoOpDispatcher) DispatchMessageEvent(msgID, email, from, subject string, status string, reason string) {
+func (n *NoOpDispatcher) DispatchMessageEvent(msgID, email, from, subject string, status string, reason string, pool string) {
 	// no-op
 }
@@ -0,0 +1,15 @@
+package store
+
+import "sort"
+
+// SortNewestFirst orders msgs the way every store returns GetMSG lists:
+// newest Timestamp first, with equal timestamps ordered by MsgID descending
+// so the order is stable across stores and runs.
+func SortNewestFirst(msgs []*Message) {
+	sort.Slice(msgs, func(i, j int) bool {
+		if msgs[i].Timestamp != msgs[j].Timestamp {
+			return msgs[i].Timestamp > msgs[j].Timestamp
+		}
+		return msgs[i].MsgID > msgs[j].MsgID
+	})
+}
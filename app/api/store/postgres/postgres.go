@@ -0,0 +1,566 @@
+// Package postgres provides a PostgreSQL-based message and webhook store
+// implementation, for deployments where several replicas share one store.
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/mustur/mockgrid/app/api/store"
+)
+
+// messageColumns lists the messages table columns in the order scanned by
+// scanMessage.
+const messageColumns = `msg_id, from_email, to_email, subject, html_body, text_body,
+status, smtp_response, reason, timestamp, last_event_time,
+opens_count, clicks_count, attachments, thread_key, amp_body, request_headers,
+from_name, to_name, ip_pool_name, send_at, api_key_id, scheduled`
+
+// webhookColumns lists the webhooks table columns in the order scanned by
+// scanWebhook.
+const webhookColumns = `id, url, events, enabled, secret, created_at, updated_at, timeout_ms, envelope, signature_mode`
+
+// Pool settings read from the DSN's query string and removed before it is
+// handed to the driver, e.g.
+// postgres://mockgrid@db/mockgrid?max_open_conns=20&conn_max_lifetime=30m.
+const (
+	paramMaxOpenConns    = "max_open_conns"
+	paramMaxIdleConns    = "max_idle_conns"
+	paramConnMaxLifetime = "conn_max_lifetime"
+)
+
+// Store persists messages and webhooks in a PostgreSQL database.
+type Store struct {
+	db *sql.DB
+}
+
+var _ store.BackendStore = (*Store)(nil)
+
+// New opens the database at dsn. URL-form DSNs may carry max_open_conns,
+// max_idle_conns and conn_max_lifetime to tune the connection pool; without
+// them database/sql's defaults apply.
+func New(dsn string) (*Store, error) {
+	dsn, pool, err := splitPoolParams(dsn)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	pool(db)
+	return &Store{db: db}, nil
+}
+
+// splitPoolParams removes the pool settings from a URL-form DSN and returns
+// a function applying them. Keyword/value DSNs are returned unchanged.
+func splitPoolParams(dsn string) (string, func(*sql.DB), error) {
+	noop := func(*sql.DB) {}
+	if !strings.HasPrefix(dsn, "postgres://") && !strings.HasPrefix(dsn, "postgresql://") {
+		return dsn, noop, nil
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", nil, fmt.Errorf("parse dsn: %w", err)
+	}
+	q := u.Query()
+
+	var setters []func(*sql.DB)
+	for _, p := range []struct {
+		name string
+		set  func(*sql.DB, int)
+	}{
+		{paramMaxOpenConns, (*sql.DB).SetMaxOpenConns},
+		{paramMaxIdleConns, (*sql.DB).SetMaxIdleConns},
+	} {
+		if !q.Has(p.name) {
+			continue
+		}
+		n, err := strconv.Atoi(q.Get(p.name))
+		if err != nil || n < 0 {
+			return "", nil, fmt.Errorf("dsn %s must be a non-negative integer", p.name)
+		}
+		set := p.set
+		setters = append(setters, func(db *sql.DB) { set(db, n) })
+		q.Del(p.name)
+	}
+	if q.Has(paramConnMaxLifetime) {
+		d, err := time.ParseDuration(q.Get(paramConnMaxLifetime))
+		if err != nil {
+			return "", nil, fmt.Errorf("dsn %s: %w", paramConnMaxLifetime, err)
+		}
+		setters = append(setters, func(db *sql.DB) { db.SetConnMaxLifetime(d) })
+		q.Del(paramConnMaxLifetime)
+	}
+
+	u.RawQuery = q.Encode()
+	return u.String(), func(db *sql.DB) {
+		for _, set := range setters {
+			set(db)
+		}
+	}, nil
+}
+
+// Connect creates the tables and indexes if they do not exist yet.
+func (s *Store) Connect() error {
+	if err := s.migrate(); err != nil {
+		return fmt.Errorf("migrate database: %w", err)
+	}
+	return nil
+}
+
+// Stats returns the database connection pool statistics.
+func (s *Store) Stats() sql.DBStats {
+	return s.db.Stats()
+}
+
+// Ping verifies the database connection is alive.
+func (s *Store) Ping() error {
+	return s.db.Ping()
+}
+
+// SaveMSG inserts or updates a message in the database.
+func (s *Store) SaveMSG(msg *store.Message) error {
+	if msg.MsgID == "" {
+		return fmt.Errorf("message ID is required")
+	}
+
+	attachmentsJSON, err := marshalJSON(msg.Attachments, len(msg.Attachments) == 0)
+	if err != nil {
+		return fmt.Errorf("marshal attachments: %w", err)
+	}
+	headersJSON, err := marshalJSON(msg.RequestHeaders, len(msg.RequestHeaders) == 0)
+	if err != nil {
+		return fmt.Errorf("marshal request headers: %w", err)
+	}
+	scheduledJSON, err := marshalJSON(msg.Scheduled, msg.Scheduled == nil)
+	if err != nil {
+		return fmt.Errorf("marshal scheduled mail: %w", err)
+	}
+
+	query := `
+INSERT INTO messages (` + messageColumns + `
+) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23)
+ON CONFLICT (msg_id) DO UPDATE SET
+status = excluded.status,
+smtp_response = excluded.smtp_response,
+reason = excluded.reason,
+last_event_time = excluded.last_event_time,
+opens_count = excluded.opens_count,
+clicks_count = excluded.clicks_count,
+scheduled = excluded.scheduled
+`
+
+	_, err = s.db.Exec(query,
+		msg.MsgID, msg.FromEmail, msg.ToEmail, msg.Subject,
+		msg.HTMLBody, msg.TextBody, msg.Status, msg.SMTPResponse,
+		msg.Reason, msg.Timestamp, msg.LastEventTime,
+		msg.OpensCount, msg.ClicksCount, attachmentsJSON, msg.ThreadKey,
+		msg.AMPBody, headersJSON, msg.FromName, msg.ToName, msg.IPPool, msg.SendAt,
+		msg.APIKeyID, scheduledJSON,
+	)
+	if err != nil {
+		return fmt.Errorf("insert message: %w", err)
+	}
+
+	return nil
+}
+
+// GetMSG retrieves messages based on query parameters.
+func (s *Store) GetMSG(query store.GetQuery) ([]*store.Message, error) {
+	if query.ID != "" {
+		return s.getMSGByID(query.ID)
+	}
+	return s.listMSG(query)
+}
+
+// DistinctRecipients counts messages per recipient address.
+func (s *Store) DistinctRecipients() (map[string]int, error) {
+	rows, err := s.db.Query(`SELECT to_email, COUNT(*) FROM messages GROUP BY to_email`)
+	if err != nil {
+		return nil, fmt.Errorf("query recipients: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var email string
+		var n int
+		if err := rows.Scan(&email, &n); err != nil {
+			return nil, fmt.Errorf("scan recipient: %w", err)
+		}
+		counts[email] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate recipients: %w", err)
+	}
+	return counts, nil
+}
+
+// DailyStats aggregates messages per UTC day in SQL.
+func (s *Store) DailyStats(from, to int64) ([]store.DailyStat, error) {
+	rows, err := s.db.Query(`
+SELECT to_char(to_timestamp(timestamp) AT TIME ZONE 'UTC', 'YYYY-MM-DD') AS day,
+COUNT(*),
+SUM(CASE WHEN status = $1 THEN 1 ELSE 0 END),
+SUM(CASE WHEN status = $2 THEN 1 ELSE 0 END),
+SUM(opens_count),
+SUM(clicks_count)
+FROM messages
+WHERE timestamp >= $3 AND ($4::BIGINT = 0 OR timestamp < $4)
+GROUP BY day
+ORDER BY day`,
+		store.StatusDelivered, store.StatusBounce, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("query daily stats: %w", err)
+	}
+	defer rows.Close()
+
+	stats := []store.DailyStat{}
+	for rows.Next() {
+		var st store.DailyStat
+		if err := rows.Scan(&st.Date, &st.Processed, &st.Delivered, &st.Bounces, &st.Opens, &st.Clicks); err != nil {
+			return nil, fmt.Errorf("scan daily stats: %w", err)
+		}
+		stats = append(stats, st)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate daily stats: %w", err)
+	}
+	return stats, nil
+}
+
+// Count returns the number of rows in the messages table.
+func (s *Store) Count() (int, error) {
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM messages`).Scan(&n); err != nil {
+		return 0, fmt.Errorf("count messages: %w", err)
+	}
+	return n, nil
+}
+
+// Iterate streams every message from the database to fn.
+func (s *Store) Iterate(fn func(*store.Message) bool) error {
+	rows, err := s.db.Query(`SELECT ` + messageColumns + ` FROM messages`)
+	if err != nil {
+		return fmt.Errorf("query messages: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return fmt.Errorf("scan message: %w", err)
+		}
+		if !fn(msg) {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate messages: %w", err)
+	}
+	return nil
+}
+
+// Close closes the database connection pool.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// migrate creates the schema. It mirrors the sqlite store's tables, with
+// JSON kept in TEXT columns and Unix times in BIGINT.
+func (s *Store) migrate() error {
+	_, err := s.db.Exec(`
+CREATE TABLE IF NOT EXISTS messages (
+msg_id TEXT PRIMARY KEY,
+from_email TEXT NOT NULL,
+to_email TEXT NOT NULL,
+subject TEXT,
+html_body TEXT,
+text_body TEXT,
+status TEXT NOT NULL,
+smtp_response TEXT,
+reason TEXT,
+timestamp BIGINT NOT NULL,
+last_event_time BIGINT,
+opens_count INTEGER DEFAULT 0,
+clicks_count INTEGER DEFAULT 0,
+attachments TEXT,
+thread_key TEXT NOT NULL DEFAULT '',
+amp_body TEXT NOT NULL DEFAULT '',
+request_headers TEXT,
+from_name TEXT NOT NULL DEFAULT '',
+to_name TEXT NOT NULL DEFAULT '',
+ip_pool_name TEXT NOT NULL DEFAULT '',
+send_at BIGINT NOT NULL DEFAULT 0,
+api_key_id TEXT NOT NULL DEFAULT '',
+scheduled TEXT
+);
+-- Columns added after the initial schema
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS api_key_id TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_messages_status ON messages(status);
+CREATE INDEX IF NOT EXISTS idx_messages_timestamp ON messages(timestamp);
+CREATE INDEX IF NOT EXISTS idx_messages_status_send_at ON messages(status, send_at);
+CREATE TABLE IF NOT EXISTS webhooks (
+	id TEXT PRIMARY KEY,
+	url TEXT NOT NULL,
+	events TEXT NOT NULL,
+	enabled BOOLEAN NOT NULL DEFAULT TRUE,
+	secret TEXT,
+	created_at BIGINT,
+	updated_at BIGINT,
+	timeout_ms INTEGER NOT NULL DEFAULT 0,
+	envelope BOOLEAN NOT NULL DEFAULT FALSE,
+	signature_mode TEXT NOT NULL DEFAULT ''
+);
+ALTER TABLE webhooks ADD COLUMN IF NOT EXISTS signature_mode TEXT NOT NULL DEFAULT '';
+`)
+	return err
+}
+
+// WebhookStore implementation
+func (s *Store) Create(hook *store.WebhookConfig) error {
+	eventsJSON, err := json.Marshal(hook.Events)
+	if err != nil {
+		return err
+	}
+	if hook.CreatedAt == 0 {
+		hook.CreatedAt = time.Now().Unix()
+	}
+	if hook.UpdatedAt == 0 {
+		hook.UpdatedAt = hook.CreatedAt
+	}
+	_, err = s.db.Exec(`INSERT INTO webhooks (`+webhookColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		hook.ID, hook.URL, string(eventsJSON), hook.Enabled, hook.Secret, hook.CreatedAt, hook.UpdatedAt, hook.TimeoutMS, hook.Envelope, hook.SignatureMode)
+	return err
+}
+
+func (s *Store) GetWebhook(id string) (*store.WebhookConfig, error) {
+	cfg, err := scanWebhook(s.db.QueryRow(`SELECT `+webhookColumns+` FROM webhooks WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, store.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (s *Store) ListWebhooks() ([]*store.WebhookConfig, error) {
+	return s.listWebhooks(`SELECT ` + webhookColumns + ` FROM webhooks ORDER BY created_at DESC`)
+}
+
+func (s *Store) ListEnabledWebhooks() ([]*store.WebhookConfig, error) {
+	return s.listWebhooks(`SELECT ` + webhookColumns + ` FROM webhooks WHERE enabled ORDER BY created_at DESC`)
+}
+
+func (s *Store) listWebhooks(query string) ([]*store.WebhookConfig, error) {
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []*store.WebhookConfig
+	for rows.Next() {
+		cfg, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, cfg)
+	}
+	return res, rows.Err()
+}
+
+func (s *Store) UpdateWebhook(hook *store.WebhookConfig) error {
+	eventsJSON, err := json.Marshal(hook.Events)
+	if err != nil {
+		return err
+	}
+	// The row only changes if nobody updated it since hook was read
+	next := store.NextUpdatedAt(hook.UpdatedAt)
+	res, err := s.db.Exec(`UPDATE webhooks SET url = $1, events = $2, enabled = $3, secret = $4, updated_at = $5, timeout_ms = $6, envelope = $7, signature_mode = $8 WHERE id = $9 AND updated_at = $10`,
+		hook.URL, string(eventsJSON), hook.Enabled, hook.Secret, next, hook.TimeoutMS, hook.Envelope, hook.SignatureMode, hook.ID, hook.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		var exists bool
+		if err := s.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM webhooks WHERE id = $1)`, hook.ID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return store.ErrNotFound
+		}
+		return store.ErrConflict
+	}
+	hook.UpdatedAt = next
+	return nil
+}
+
+func (s *Store) DeleteWebhook(id string) error {
+	_, err := s.db.Exec(`DELETE FROM webhooks WHERE id = $1`, id)
+	return err
+}
+
+func (s *Store) DeleteAllWebhooks() error {
+	_, err := s.db.Exec(`DELETE FROM webhooks`)
+	return err
+}
+
+func (s *Store) getMSGByID(id string) ([]*store.Message, error) {
+	row := s.db.QueryRow(`SELECT `+messageColumns+` FROM messages WHERE msg_id = $1`, id)
+	msg, err := scanMessage(row)
+	if err == sql.ErrNoRows {
+		return nil, store.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query message: %w", err)
+	}
+
+	return []*store.Message{msg}, nil
+}
+
+func (s *Store) listMSG(query store.GetQuery) ([]*store.Message, error) {
+	limit := query.Limit
+	if limit == 0 {
+		limit = 100
+	}
+
+	var where []string
+	var args []interface{}
+	// arg appends v and returns its placeholder
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if query.Status != "" {
+		where = append(where, "status = "+arg(query.Status))
+	}
+	if query.APIKeyID != "" {
+		where = append(where, "api_key_id = "+arg(query.APIKeyID))
+	}
+	if query.Since != 0 {
+		where = append(where, "timestamp >= "+arg(query.Since))
+	}
+	if query.Until != 0 {
+		where = append(where, "timestamp <= "+arg(query.Until))
+	}
+	if query.SendBefore != 0 {
+		where = append(where, "send_at > 0 AND send_at <= "+arg(query.SendBefore))
+	}
+	for _, k := range slices.Sorted(maps.Keys(query.HeaderMatch)) {
+		where = append(where, "request_headers::jsonb ->> "+arg(k)+" = "+arg(query.HeaderMatch[k]))
+	}
+
+	q := `SELECT ` + messageColumns + ` FROM messages`
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	q += " ORDER BY timestamp DESC, msg_id DESC LIMIT " + arg(limit) + " OFFSET " + arg(query.Offset)
+
+	rows, err := s.db.Query(q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []*store.Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan message: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate messages: %w", err)
+	}
+
+	return messages, nil
+}
+
+// scanner is satisfied by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanMessage(row scanner) (*store.Message, error) {
+	var msg store.Message
+	var subject, htmlBody, textBody, smtpResponse, reason sql.NullString
+	var attachmentsJSON, headersJSON, scheduledJSON sql.NullString
+	var lastEvent sql.NullInt64
+	var opens, clicks sql.NullInt64
+	err := row.Scan(
+		&msg.MsgID, &msg.FromEmail, &msg.ToEmail, &subject,
+		&htmlBody, &textBody, &msg.Status, &smtpResponse,
+		&reason, &msg.Timestamp, &lastEvent,
+		&opens, &clicks, &attachmentsJSON, &msg.ThreadKey, &msg.AMPBody,
+		&headersJSON, &msg.FromName, &msg.ToName, &msg.IPPool, &msg.SendAt,
+		&msg.APIKeyID, &scheduledJSON,
+	)
+	if err != nil {
+		return &msg, err
+	}
+	msg.Subject, msg.HTMLBody, msg.TextBody = subject.String, htmlBody.String, textBody.String
+	msg.SMTPResponse, msg.Reason = smtpResponse.String, reason.String
+	msg.LastEventTime = lastEvent.Int64
+	msg.OpensCount, msg.ClicksCount = int(opens.Int64), int(clicks.Int64)
+
+	if attachmentsJSON.Valid && attachmentsJSON.String != "" {
+		if err := json.Unmarshal([]byte(attachmentsJSON.String), &msg.Attachments); err != nil {
+			return &msg, fmt.Errorf("unmarshal attachments: %w", err)
+		}
+	}
+	if headersJSON.Valid && headersJSON.String != "" {
+		if err := json.Unmarshal([]byte(headersJSON.String), &msg.RequestHeaders); err != nil {
+			return &msg, fmt.Errorf("unmarshal request headers: %w", err)
+		}
+	}
+	if scheduledJSON.Valid && scheduledJSON.String != "" {
+		if err := json.Unmarshal([]byte(scheduledJSON.String), &msg.Scheduled); err != nil {
+			return &msg, fmt.Errorf("unmarshal scheduled mail: %w", err)
+		}
+	}
+	return &msg, nil
+}
+
+func scanWebhook(row scanner) (*store.WebhookConfig, error) {
+	var cfg store.WebhookConfig
+	var eventsJSON string
+	var secret sql.NullString
+	var createdAt, updatedAt sql.NullInt64
+	if err := row.Scan(&cfg.ID, &cfg.URL, &eventsJSON, &cfg.Enabled, &secret, &createdAt, &updatedAt, &cfg.TimeoutMS, &cfg.Envelope, &cfg.SignatureMode); err != nil {
+		return nil, err
+	}
+	cfg.Secret = secret.String
+	cfg.CreatedAt, cfg.UpdatedAt = createdAt.Int64, updatedAt.Int64
+	if err := json.Unmarshal([]byte(eventsJSON), &cfg.Events); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// marshalJSON encodes v for a JSON TEXT column, storing NULL when empty.
+func marshalJSON(v any, empty bool) (sql.NullString, error) {
+	if empty {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
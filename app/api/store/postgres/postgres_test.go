@@ -0,0 +1,73 @@
+package postgres_test
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/mustur/mockgrid/app/api/store"
+	"github.com/mustur/mockgrid/app/api/store/postgres"
+	"github.com/mustur/mockgrid/internal/testutil"
+)
+
+// dsnEnv names the variable holding a DSN for a disposable test database.
+// Contract tests are skipped without it; they drop and recreate the tables.
+const dsnEnv = "MOCKGRID_TEST_POSTGRES_DSN"
+
+func TestPostgres_Contract(t *testing.T) {
+	dsn := os.Getenv(dsnEnv)
+	if dsn == "" {
+		t.Skipf("%s not set", dsnEnv)
+	}
+	testutil.RunStoreContractTests(t, "postgres", func(t *testing.T) store.MessageStore {
+		return newTestStore(t, dsn)
+	})
+}
+
+func TestPostgres_New_AppliesPoolParamsFromDSN(t *testing.T) {
+	// Opening does not connect, so no server is needed
+	s, err := postgres.New("postgres://mockgrid@localhost/mockgrid?sslmode=disable&max_open_conns=7&max_idle_conns=2&conn_max_lifetime=30m")
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	if got := s.Stats().MaxOpenConnections; got != 7 {
+		t.Errorf("expected max open connections 7, got %d", got)
+	}
+}
+
+func TestPostgres_New_InvalidPoolParam(t *testing.T) {
+	for _, dsn := range []string{
+		"postgres://localhost/mockgrid?max_open_conns=many",
+		"postgres://localhost/mockgrid?conn_max_lifetime=soon",
+	} {
+		if _, err := postgres.New(dsn); err == nil {
+			t.Errorf("expected error for %q", dsn)
+		}
+	}
+}
+
+// --- Test Helpers ---
+
+// newTestStore connects a store to dsn after dropping any tables left by a
+// previous test, so every store starts empty.
+func newTestStore(t *testing.T, dsn string) *postgres.Store {
+	t.Helper()
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`DROP TABLE IF EXISTS messages, webhooks`); err != nil {
+		t.Fatalf("failed to reset database: %v", err)
+	}
+
+	s, err := postgres.New(dsn)
+	if err != nil {
+		t.Fatalf("failed to create postgres store: %v", err)
+	}
+	if err := s.Connect(); err != nil {
+		t.Fatalf("failed to connect postgres store: %v", err)
+	}
+	return s
+}
@@ -0,0 +1,63 @@
+// Package store provides message storage interfaces.
+package store
+
+import (
+	"container/list"
+	"sync"
+)
+
+// RecentLister returns the most recently saved messages, newest first.
+type RecentLister interface {
+	Recent() []*Message
+}
+
+// recentCache keeps the N most recently saved messages in memory.
+// Re-saving a cached message refreshes it and moves it to the front.
+type recentCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List               // front is newest; values are *Message
+	byID  map[string]*list.Element // msg id -> element in order
+}
+
+func newRecentCache(size int) *recentCache {
+	return &recentCache{
+		size:  size,
+		order: list.New(),
+		byID:  make(map[string]*list.Element, size),
+	}
+}
+
+// add records a copy of msg as the most recent message, evicting the oldest
+// entry when the cache is full.
+func (c *recentCache) add(msg *Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cp := *msg
+	if el, ok := c.byID[msg.MsgID]; ok {
+		el.Value = &cp
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.byID[msg.MsgID] = c.order.PushFront(&cp)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.byID, oldest.Value.(*Message).MsgID)
+	}
+}
+
+// list returns copies of the cached messages, newest first.
+func (c *recentCache) list() []*Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := make([]*Message, 0, c.order.Len())
+	for el := c.order.Front(); el != nil; el = el.Next() {
+		cp := *el.Value.(*Message)
+		result = append(result, &cp)
+	}
+	return result
+}
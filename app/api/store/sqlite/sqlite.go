@@ -5,26 +5,79 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/mustur/mockgrid/app/api/store"
 )
 
+// messageColumns lists the messages table columns in the order scanned by
+// scanMessage and scanMessageRows.
+const messageColumns = `msg_id, from_email, to_email, subject, html_body, text_body,
+status, smtp_response, reason, timestamp, last_event_time,
+opens_count, clicks_count, attachments, thread_key, amp_body, request_headers,
+from_name, to_name, ip_pool_name, send_at, api_key_id, scheduled`
+
+// MemoryPath opens a private in-memory database. It lives only as long as
+// the connection that created it.
+const MemoryPath = ":memory:"
+
+// Connection pool defaults. SQLite allows a single writer at a time, so a
+// small pool is enough and keeps file descriptors bounded under read load.
+const (
+	DefaultMaxOpenConns = 4
+	DefaultMaxIdleConns = 2
+)
+
+// PoolConfig limits the database connection pool. Zero values use the
+// defaults; a zero ConnMaxLifetime keeps connections open indefinitely.
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
 // Store persists messages in a SQLite database.
 type Store struct {
-	path string
-	db   *sql.DB
+	path     string
+	db       *sql.DB
+	compress bool // gzip message bodies on insert
 }
 
-func New(path string) (*Store, error) {
+var _ store.BackendStore = (*Store)(nil)
+
+// New opens the database at path with the given connection pool limits.
+func New(path string, pool PoolConfig) (*Store, error) {
 	db, err := sql.Open("sqlite3", path)
 	if err != nil {
 		return nil, fmt.Errorf("open database: %w", err)
 	}
 
+	if pool.MaxOpenConns <= 0 {
+		pool.MaxOpenConns = DefaultMaxOpenConns
+	}
+	if pool.MaxIdleConns <= 0 {
+		pool.MaxIdleConns = DefaultMaxIdleConns
+	}
+	db.SetMaxOpenConns(pool.MaxOpenConns)
+	db.SetMaxIdleConns(pool.MaxIdleConns)
+	db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+
 	return &Store{path: path, db: db}, nil
 }
 
+// WithCompression gzip-compresses the HTML and text bodies of messages
+// inserted from now on, storing them as BLOBs. Compressed and plain bodies
+// are both read transparently.
+func (s *Store) WithCompression(on bool) *Store {
+	s.compress = on
+	return s
+}
+
 // Connect creates a new SQLite store at the given path.
 func (s *Store) Connect() error {
 
@@ -34,32 +87,67 @@ func (s *Store) Connect() error {
 	return nil
 }
 
+// Stats returns the database connection pool statistics.
+func (s *Store) Stats() sql.DBStats {
+	return s.db.Stats()
+}
+
+// Ping verifies the database connection is alive.
+func (s *Store) Ping() error {
+	return s.db.Ping()
+}
+
 // Save inserts or updates a message in the database.
 func (s *Store) SaveMSG(msg *store.Message) error {
 	if msg.MsgID == "" {
 		return fmt.Errorf("message ID is required")
 	}
 
+	attachmentsJSON, err := marshalAttachments(msg.Attachments)
+	if err != nil {
+		return fmt.Errorf("marshal attachments: %w", err)
+	}
+	headersJSON, err := marshalHeaders(msg.RequestHeaders)
+	if err != nil {
+		return fmt.Errorf("marshal request headers: %w", err)
+	}
+	htmlBody, err := s.bodyArg(msg.HTMLBody)
+	if err != nil {
+		return fmt.Errorf("compress html body: %w", err)
+	}
+	textBody, err := s.bodyArg(msg.TextBody)
+	if err != nil {
+		return fmt.Errorf("compress text body: %w", err)
+	}
+	scheduledJSON, err := marshalScheduled(msg.Scheduled)
+	if err != nil {
+		return fmt.Errorf("marshal scheduled mail: %w", err)
+	}
+
 	query := `
 INSERT INTO messages (
 msg_id, from_email, to_email, subject, html_body, text_body,
 status, smtp_response, reason, timestamp, last_event_time,
-opens_count, clicks_count
-) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+opens_count, clicks_count, attachments, thread_key, amp_body, request_headers,
+from_name, to_name, ip_pool_name, send_at, api_key_id, scheduled
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 ON CONFLICT(msg_id) DO UPDATE SET
 status = excluded.status,
 smtp_response = excluded.smtp_response,
 reason = excluded.reason,
 last_event_time = excluded.last_event_time,
 opens_count = excluded.opens_count,
-clicks_count = excluded.clicks_count
+clicks_count = excluded.clicks_count,
+scheduled = excluded.scheduled
 `
 
-	_, err := s.db.Exec(query,
+	_, err = s.db.Exec(query,
 		msg.MsgID, msg.FromEmail, msg.ToEmail, msg.Subject,
-		msg.HTMLBody, msg.TextBody, msg.Status, msg.SMTPResponse,
+		htmlBody, textBody, msg.Status, msg.SMTPResponse,
 		msg.Reason, msg.Timestamp, msg.LastEventTime,
-		msg.OpensCount, msg.ClicksCount,
+		msg.OpensCount, msg.ClicksCount, attachmentsJSON, msg.ThreadKey,
+		msg.AMPBody, headersJSON, msg.FromName, msg.ToName, msg.IPPool, msg.SendAt,
+		msg.APIKeyID, scheduledJSON,
 	)
 	if err != nil {
 		return fmt.Errorf("insert message: %w", err)
@@ -76,6 +164,94 @@ func (s *Store) GetMSG(query store.GetQuery) ([]*store.Message, error) {
 	return s.listMSG(query)
 }
 
+// DistinctRecipients counts messages per recipient address.
+func (s *Store) DistinctRecipients() (map[string]int, error) {
+	rows, err := s.db.Query(`SELECT to_email, COUNT(*) FROM messages GROUP BY to_email`)
+	if err != nil {
+		return nil, fmt.Errorf("query recipients: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var email string
+		var n int
+		if err := rows.Scan(&email, &n); err != nil {
+			return nil, fmt.Errorf("scan recipient: %w", err)
+		}
+		counts[email] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate recipients: %w", err)
+	}
+	return counts, nil
+}
+
+// DailyStats aggregates messages per UTC day in SQL.
+func (s *Store) DailyStats(from, to int64) ([]store.DailyStat, error) {
+	rows, err := s.db.Query(`
+SELECT date(timestamp, 'unixepoch') AS day,
+COUNT(*),
+SUM(CASE WHEN status = ? THEN 1 ELSE 0 END),
+SUM(CASE WHEN status = ? THEN 1 ELSE 0 END),
+SUM(opens_count),
+SUM(clicks_count)
+FROM messages
+WHERE timestamp >= ? AND (? = 0 OR timestamp < ?)
+GROUP BY day
+ORDER BY day`,
+		store.StatusDelivered, store.StatusBounce, from, to, to)
+	if err != nil {
+		return nil, fmt.Errorf("query daily stats: %w", err)
+	}
+	defer rows.Close()
+
+	stats := []store.DailyStat{}
+	for rows.Next() {
+		var st store.DailyStat
+		if err := rows.Scan(&st.Date, &st.Processed, &st.Delivered, &st.Bounces, &st.Opens, &st.Clicks); err != nil {
+			return nil, fmt.Errorf("scan daily stats: %w", err)
+		}
+		stats = append(stats, st)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate daily stats: %w", err)
+	}
+	return stats, nil
+}
+
+// Count returns the number of rows in the messages table.
+func (s *Store) Count() (int, error) {
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM messages`).Scan(&n); err != nil {
+		return 0, fmt.Errorf("count messages: %w", err)
+	}
+	return n, nil
+}
+
+// Iterate streams every message from the database to fn.
+func (s *Store) Iterate(fn func(*store.Message) bool) error {
+	rows, err := s.db.Query(`SELECT ` + messageColumns + ` FROM messages`)
+	if err != nil {
+		return fmt.Errorf("query messages: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		msg, err := s.scanMessageRows(rows)
+		if err != nil {
+			return fmt.Errorf("scan message: %w", err)
+		}
+		if !fn(msg) {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterate messages: %w", err)
+	}
+	return nil
+}
+
 // Close closes the database connection.
 func (s *Store) Close() error {
 	return s.db.Close()
@@ -96,7 +272,17 @@ reason TEXT,
 timestamp INTEGER NOT NULL,
 last_event_time INTEGER,
 opens_count INTEGER DEFAULT 0,
-clicks_count INTEGER DEFAULT 0
+clicks_count INTEGER DEFAULT 0,
+attachments TEXT,
+thread_key TEXT NOT NULL DEFAULT '',
+amp_body TEXT NOT NULL DEFAULT '',
+request_headers TEXT,
+from_name TEXT NOT NULL DEFAULT '',
+to_name TEXT NOT NULL DEFAULT '',
+ip_pool_name TEXT NOT NULL DEFAULT '',
+send_at INTEGER NOT NULL DEFAULT 0,
+api_key_id TEXT NOT NULL DEFAULT '',
+scheduled TEXT
 );
 CREATE INDEX IF NOT EXISTS idx_messages_status ON messages(status);
 CREATE INDEX IF NOT EXISTS idx_messages_timestamp ON messages(timestamp);
@@ -107,10 +293,84 @@ CREATE TABLE IF NOT EXISTS webhooks (
 	enabled BOOLEAN NOT NULL DEFAULT 1,
 	secret TEXT,
 	created_at INTEGER,
-	updated_at INTEGER
+	updated_at INTEGER,
+	timeout_ms INTEGER NOT NULL DEFAULT 0,
+	envelope BOOLEAN NOT NULL DEFAULT 0,
+	signature_mode TEXT NOT NULL DEFAULT ''
 );
 `
-	_, err := s.db.Exec(query)
+	if _, err := s.db.Exec(query); err != nil {
+		return err
+	}
+
+	// Columns added after the initial schema; CREATE TABLE IF NOT EXISTS
+	// leaves existing databases without them.
+	if err := s.addColumnIfMissing("messages", "attachments", "TEXT"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("messages", "thread_key", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("messages", "amp_body", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("messages", "request_headers", "TEXT"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("messages", "from_name", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("messages", "to_name", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("messages", "ip_pool_name", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("messages", "send_at", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("messages", "api_key_id", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("messages", "scheduled", "TEXT"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("webhooks", "timeout_ms", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("webhooks", "envelope", "BOOLEAN NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := s.addColumnIfMissing("webhooks", "signature_mode", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	// Created after the columns it covers, which older databases lack
+	// until the migrations above
+	_, err := s.db.Exec(`CREATE INDEX IF NOT EXISTS idx_messages_status_send_at ON messages(status, send_at)`)
+	return err
+}
+
+// addColumnIfMissing adds a column to an existing table unless it is already present.
+func (s *Store) addColumnIfMissing(table, column, decl string) error {
+	rows, err := s.db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return fmt.Errorf("inspect table %s: %w", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return fmt.Errorf("inspect table %s: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("inspect table %s: %w", table, err)
+	}
+
+	_, err = s.db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, decl))
 	return err
 }
 
@@ -120,16 +380,22 @@ func (s *Store) Create(hook *store.WebhookConfig) error {
 	if err != nil {
 		return err
 	}
-	_, err = s.db.Exec(`INSERT INTO webhooks (id, url, events, enabled, secret, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
-		hook.ID, hook.URL, string(eventsJSON), hook.Enabled, hook.Secret, hook.CreatedAt, hook.UpdatedAt)
+	if hook.CreatedAt == 0 {
+		hook.CreatedAt = time.Now().Unix()
+	}
+	if hook.UpdatedAt == 0 {
+		hook.UpdatedAt = hook.CreatedAt
+	}
+	_, err = s.db.Exec(`INSERT INTO webhooks (id, url, events, enabled, secret, created_at, updated_at, timeout_ms, envelope, signature_mode) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		hook.ID, hook.URL, string(eventsJSON), hook.Enabled, hook.Secret, hook.CreatedAt, hook.UpdatedAt, hook.TimeoutMS, hook.Envelope, hook.SignatureMode)
 	return err
 }
 
 func (s *Store) GetWebhook(id string) (*store.WebhookConfig, error) {
 	var cfg store.WebhookConfig
 	var eventsJSON string
-	err := s.db.QueryRow(`SELECT id, url, events, enabled, secret, created_at, updated_at FROM webhooks WHERE id = ?`, id).
-		Scan(&cfg.ID, &cfg.URL, &eventsJSON, &cfg.Enabled, &cfg.Secret, &cfg.CreatedAt, &cfg.UpdatedAt)
+	err := s.db.QueryRow(`SELECT id, url, events, enabled, secret, created_at, updated_at, timeout_ms, envelope, signature_mode FROM webhooks WHERE id = ?`, id).
+		Scan(&cfg.ID, &cfg.URL, &eventsJSON, &cfg.Enabled, &cfg.Secret, &cfg.CreatedAt, &cfg.UpdatedAt, &cfg.TimeoutMS, &cfg.Envelope, &cfg.SignatureMode)
 	if err == sql.ErrNoRows {
 		return nil, store.ErrNotFound
 	}
@@ -143,7 +409,7 @@ func (s *Store) GetWebhook(id string) (*store.WebhookConfig, error) {
 }
 
 func (s *Store) ListWebhooks() ([]*store.WebhookConfig, error) {
-	rows, err := s.db.Query(`SELECT id, url, events, enabled, secret, created_at, updated_at FROM webhooks ORDER BY created_at DESC`)
+	rows, err := s.db.Query(`SELECT id, url, events, enabled, secret, created_at, updated_at, timeout_ms, envelope, signature_mode FROM webhooks ORDER BY created_at DESC`)
 	if err != nil {
 		return nil, err
 	}
@@ -152,7 +418,7 @@ func (s *Store) ListWebhooks() ([]*store.WebhookConfig, error) {
 	for rows.Next() {
 		var cfg store.WebhookConfig
 		var eventsJSON string
-		if err := rows.Scan(&cfg.ID, &cfg.URL, &eventsJSON, &cfg.Enabled, &cfg.Secret, &cfg.CreatedAt, &cfg.UpdatedAt); err != nil {
+		if err := rows.Scan(&cfg.ID, &cfg.URL, &eventsJSON, &cfg.Enabled, &cfg.Secret, &cfg.CreatedAt, &cfg.UpdatedAt, &cfg.TimeoutMS, &cfg.Envelope, &cfg.SignatureMode); err != nil {
 			return nil, err
 		}
 		if err := json.Unmarshal([]byte(eventsJSON), &cfg.Events); err != nil {
@@ -164,7 +430,7 @@ func (s *Store) ListWebhooks() ([]*store.WebhookConfig, error) {
 }
 
 func (s *Store) ListEnabledWebhooks() ([]*store.WebhookConfig, error) {
-	rows, err := s.db.Query(`SELECT id, url, events, enabled, secret, created_at, updated_at FROM webhooks WHERE enabled = 1 ORDER BY created_at DESC`)
+	rows, err := s.db.Query(`SELECT id, url, events, enabled, secret, created_at, updated_at, timeout_ms, envelope, signature_mode FROM webhooks WHERE enabled = 1 ORDER BY created_at DESC`)
 	if err != nil {
 		return nil, err
 	}
@@ -173,7 +439,7 @@ func (s *Store) ListEnabledWebhooks() ([]*store.WebhookConfig, error) {
 	for rows.Next() {
 		var cfg store.WebhookConfig
 		var eventsJSON string
-		if err := rows.Scan(&cfg.ID, &cfg.URL, &eventsJSON, &cfg.Enabled, &cfg.Secret, &cfg.CreatedAt, &cfg.UpdatedAt); err != nil {
+		if err := rows.Scan(&cfg.ID, &cfg.URL, &eventsJSON, &cfg.Enabled, &cfg.Secret, &cfg.CreatedAt, &cfg.UpdatedAt, &cfg.TimeoutMS, &cfg.Envelope, &cfg.SignatureMode); err != nil {
 			return nil, err
 		}
 		if err := json.Unmarshal([]byte(eventsJSON), &cfg.Events); err != nil {
@@ -189,9 +455,29 @@ func (s *Store) UpdateWebhook(hook *store.WebhookConfig) error {
 	if err != nil {
 		return err
 	}
-	_, err = s.db.Exec(`UPDATE webhooks SET url = ?, events = ?, enabled = ?, secret = ?, updated_at = ? WHERE id = ?`,
-		hook.URL, string(eventsJSON), hook.Enabled, hook.Secret, hook.UpdatedAt, hook.ID)
-	return err
+	// The row only changes if nobody updated it since hook was read
+	next := store.NextUpdatedAt(hook.UpdatedAt)
+	res, err := s.db.Exec(`UPDATE webhooks SET url = ?, events = ?, enabled = ?, secret = ?, updated_at = ?, timeout_ms = ?, envelope = ?, signature_mode = ? WHERE id = ? AND updated_at = ?`,
+		hook.URL, string(eventsJSON), hook.Enabled, hook.Secret, next, hook.TimeoutMS, hook.Envelope, hook.SignatureMode, hook.ID, hook.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		var exists bool
+		if err := s.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM webhooks WHERE id = ?)`, hook.ID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return store.ErrNotFound
+		}
+		return store.ErrConflict
+	}
+	hook.UpdatedAt = next
+	return nil
 }
 
 func (s *Store) DeleteWebhook(id string) error {
@@ -199,13 +485,13 @@ func (s *Store) DeleteWebhook(id string) error {
 	return err
 }
 
+func (s *Store) DeleteAllWebhooks() error {
+	_, err := s.db.Exec(`DELETE FROM webhooks`)
+	return err
+}
+
 func (s *Store) getMSGByID(id string) ([]*store.Message, error) {
-	query := `
-SELECT msg_id, from_email, to_email, subject, html_body, text_body,
-       status, smtp_response, reason, timestamp, last_event_time,
-       opens_count, clicks_count
-FROM messages WHERE msg_id = ?
-`
+	query := `SELECT ` + messageColumns + ` FROM messages WHERE msg_id = ?`
 
 	row := s.db.QueryRow(query, id)
 	msg, err := s.scanMessage(row)
@@ -225,28 +511,39 @@ func (s *Store) listMSG(query store.GetQuery) ([]*store.Message, error) {
 		limit = 100
 	}
 
-	var rows *sql.Rows
-	var err error
-
-	baseQuery := `
-SELECT msg_id, from_email, to_email, subject, html_body, text_body,
-       status, smtp_response, reason, timestamp, last_event_time,
-       opens_count, clicks_count
-FROM messages
-`
-
+	var where []string
+	var args []interface{}
 	if query.Status != "" {
-		rows, err = s.db.Query(
-			baseQuery+" WHERE status = ? ORDER BY timestamp DESC LIMIT ? OFFSET ?",
-			query.Status, limit, query.Offset,
-		)
-	} else {
-		rows, err = s.db.Query(
-			baseQuery+" ORDER BY timestamp DESC LIMIT ? OFFSET ?",
-			limit, query.Offset,
-		)
+		where = append(where, "status = ?")
+		args = append(args, query.Status)
+	}
+	if query.APIKeyID != "" {
+		where = append(where, "api_key_id = ?")
+		args = append(args, query.APIKeyID)
+	}
+	if query.Since != 0 || query.Until != 0 {
+		where = append(where, "timestamp BETWEEN ? AND ?")
+		args = append(args, query.Since, untilOrMax(query.Until))
+	}
+	if query.SendBefore != 0 {
+		where = append(where, "send_at > 0 AND send_at <= ?")
+		args = append(args, query.SendBefore)
+	}
+	for _, k := range slices.Sorted(maps.Keys(query.HeaderMatch)) {
+		// Matching on json_each keys takes the name as a parameter, where a
+		// JSON path would have to quote it
+		where = append(where, "EXISTS (SELECT 1 FROM json_each(request_headers) WHERE key = ? AND value = ?)")
+		args = append(args, k, query.HeaderMatch[k])
 	}
 
+	q := `SELECT ` + messageColumns + ` FROM messages`
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	q += " ORDER BY timestamp DESC, msg_id DESC LIMIT ? OFFSET ?"
+	args = append(args, limit, query.Offset)
+
+	rows, err := s.db.Query(q, args...)
 	if err != nil {
 		return nil, fmt.Errorf("query messages: %w", err)
 	}
@@ -268,24 +565,176 @@ FROM messages
 	return messages, nil
 }
 
+// untilOrMax returns until, or the largest timestamp when it is zero, so a
+// BETWEEN on it leaves the upper end open.
+func untilOrMax(until int64) int64 {
+	if until == 0 {
+		return math.MaxInt64
+	}
+	return until
+}
+
 func (s *Store) scanMessage(row *sql.Row) (*store.Message, error) {
 	var msg store.Message
+	var attachmentsJSON, headersJSON, scheduledJSON sql.NullString
+	var htmlBody, textBody any
 	err := row.Scan(
 		&msg.MsgID, &msg.FromEmail, &msg.ToEmail, &msg.Subject,
-		&msg.HTMLBody, &msg.TextBody, &msg.Status, &msg.SMTPResponse,
+		&htmlBody, &textBody, &msg.Status, &msg.SMTPResponse,
 		&msg.Reason, &msg.Timestamp, &msg.LastEventTime,
-		&msg.OpensCount, &msg.ClicksCount,
+		&msg.OpensCount, &msg.ClicksCount, &attachmentsJSON, &msg.ThreadKey, &msg.AMPBody,
+		&headersJSON, &msg.FromName, &msg.ToName, &msg.IPPool, &msg.SendAt,
+		&msg.APIKeyID, &scheduledJSON,
 	)
+	if err != nil {
+		return &msg, err
+	}
+	if msg.HTMLBody, err = bodyValue(htmlBody); err != nil {
+		return &msg, err
+	}
+	if msg.TextBody, err = bodyValue(textBody); err != nil {
+		return &msg, err
+	}
+	if msg.Attachments, err = unmarshalAttachments(attachmentsJSON); err != nil {
+		return &msg, err
+	}
+	if msg.RequestHeaders, err = unmarshalHeaders(headersJSON); err != nil {
+		return &msg, err
+	}
+	msg.Scheduled, err = unmarshalScheduled(scheduledJSON)
 	return &msg, err
 }
 
 func (s *Store) scanMessageRows(rows *sql.Rows) (*store.Message, error) {
 	var msg store.Message
+	var attachmentsJSON, headersJSON, scheduledJSON sql.NullString
+	var htmlBody, textBody any
 	err := rows.Scan(
 		&msg.MsgID, &msg.FromEmail, &msg.ToEmail, &msg.Subject,
-		&msg.HTMLBody, &msg.TextBody, &msg.Status, &msg.SMTPResponse,
+		&htmlBody, &textBody, &msg.Status, &msg.SMTPResponse,
 		&msg.Reason, &msg.Timestamp, &msg.LastEventTime,
-		&msg.OpensCount, &msg.ClicksCount,
+		&msg.OpensCount, &msg.ClicksCount, &attachmentsJSON, &msg.ThreadKey, &msg.AMPBody,
+		&headersJSON, &msg.FromName, &msg.ToName, &msg.IPPool, &msg.SendAt,
+		&msg.APIKeyID, &scheduledJSON,
 	)
+	if err != nil {
+		return &msg, err
+	}
+	if msg.HTMLBody, err = bodyValue(htmlBody); err != nil {
+		return &msg, err
+	}
+	if msg.TextBody, err = bodyValue(textBody); err != nil {
+		return &msg, err
+	}
+	if msg.Attachments, err = unmarshalAttachments(attachmentsJSON); err != nil {
+		return &msg, err
+	}
+	if msg.RequestHeaders, err = unmarshalHeaders(headersJSON); err != nil {
+		return &msg, err
+	}
+	msg.Scheduled, err = unmarshalScheduled(scheduledJSON)
 	return &msg, err
 }
+
+// bodyArg returns the value inserted for a message body: its gzip
+// compression, stored as a BLOB, when compression is on, else the text.
+func (s *Store) bodyArg(body string) (any, error) {
+	if !s.compress || body == "" {
+		return body, nil
+	}
+	return store.CompressBody(body)
+}
+
+// bodyValue returns the message body scanned into v. Compressed bodies are
+// the BLOBs; plain ones are TEXT, or NULL in rows from older versions.
+func bodyValue(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case []byte:
+		body, err := store.DecompressBody(v)
+		if err != nil {
+			return "", fmt.Errorf("decompress body: %w", err)
+		}
+		return body, nil
+	default:
+		return "", fmt.Errorf("unexpected body type %T", v)
+	}
+}
+
+// marshalAttachments encodes attachment metadata for the attachments column.
+// Messages without attachments are stored as NULL.
+func marshalAttachments(atts []store.AttachmentMeta) (sql.NullString, error) {
+	if len(atts) == 0 {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(atts)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// unmarshalAttachments decodes the attachments column.
+func unmarshalAttachments(col sql.NullString) ([]store.AttachmentMeta, error) {
+	if !col.Valid || col.String == "" {
+		return nil, nil
+	}
+	var atts []store.AttachmentMeta
+	if err := json.Unmarshal([]byte(col.String), &atts); err != nil {
+		return nil, fmt.Errorf("unmarshal attachments: %w", err)
+	}
+	return atts, nil
+}
+
+// marshalHeaders encodes captured request headers for the request_headers
+// column. Messages without captured headers are stored as NULL.
+func marshalHeaders(headers map[string]string) (sql.NullString, error) {
+	if len(headers) == 0 {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(headers)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// unmarshalHeaders decodes the request_headers column.
+func unmarshalHeaders(col sql.NullString) (map[string]string, error) {
+	if !col.Valid || col.String == "" {
+		return nil, nil
+	}
+	var headers map[string]string
+	if err := json.Unmarshal([]byte(col.String), &headers); err != nil {
+		return nil, fmt.Errorf("unmarshal request headers: %w", err)
+	}
+	return headers, nil
+}
+
+// marshalScheduled encodes a scheduled message's mail for the scheduled
+// column. Messages that are not waiting to be delivered store NULL.
+func marshalScheduled(sm *store.ScheduledMail) (sql.NullString, error) {
+	if sm == nil {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(sm)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// unmarshalScheduled decodes the scheduled column.
+func unmarshalScheduled(col sql.NullString) (*store.ScheduledMail, error) {
+	if !col.Valid || col.String == "" {
+		return nil, nil
+	}
+	var sm store.ScheduledMail
+	if err := json.Unmarshal([]byte(col.String), &sm); err != nil {
+		return nil, fmt.Errorf("unmarshal scheduled mail: %w", err)
+	}
+	return &sm, nil
+}
@@ -0,0 +1,124 @@
+package sqlite_test
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mustur/mockgrid/app/api/store"
+	"github.com/mustur/mockgrid/app/api/store/sqlite"
+	"github.com/mustur/mockgrid/internal/testutil"
+)
+
+func TestSqlite_Contract(t *testing.T) {
+	testutil.RunStoreContractTests(t, "sqlite", func(t *testing.T) store.MessageStore {
+		return newTestStore(t)
+	})
+}
+
+func TestSqlite_Contract_Compressed(t *testing.T) {
+	testutil.RunStoreContractTests(t, "sqlite", func(t *testing.T) store.MessageStore {
+		return newTestStore(t).WithCompression(true)
+	})
+}
+
+func TestSqlite_Compression_ShrinksDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.db")
+	s, err := sqlite.New(path, sqlite.PoolConfig{})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	if err := s.WithCompression(true).Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+
+	msg := &store.Message{MsgID: "large", Status: store.StatusProcessed, Timestamp: 1, HTMLBody: testutil.LargeBody}
+	if err := s.SaveMSG(msg); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat database: %v", err)
+	}
+	if info.Size() >= int64(len(testutil.LargeBody)) {
+		t.Errorf("expected the database to be smaller than the %d byte body, got %d bytes", len(testutil.LargeBody), info.Size())
+	}
+}
+
+func TestSqlite_Connect_MigratesExistingDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.db")
+
+	// Connecting twice must be idempotent, including added columns
+	for i := 0; i < 2; i++ {
+		s, err := sqlite.New(path, sqlite.PoolConfig{})
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		if err := s.Connect(); err != nil {
+			t.Fatalf("Connect %d failed: %v", i+1, err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+	}
+}
+
+func TestSqlite_New_AppliesPoolLimits(t *testing.T) {
+	s, err := sqlite.New(filepath.Join(t.TempDir(), "messages.db"), sqlite.PoolConfig{MaxOpenConns: 3, MaxIdleConns: 1})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close()
+	if err := s.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+
+	// Hold more concurrent reads than the pool allows
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			_ = s.Iterate(func(*store.Message) bool {
+				time.Sleep(10 * time.Millisecond)
+				return true
+			})
+		})
+	}
+	if err := s.SaveMSG(&store.Message{MsgID: "m1", Status: store.StatusProcessed, Timestamp: 1}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	wg.Wait()
+
+	stats := s.Stats()
+	if stats.MaxOpenConnections != 3 {
+		t.Errorf("expected max open connections 3, got %d", stats.MaxOpenConnections)
+	}
+	if stats.OpenConnections > 1 || stats.Idle > 1 {
+		t.Errorf("expected at most 1 idle connection kept, got open=%d idle=%d", stats.OpenConnections, stats.Idle)
+	}
+}
+
+func TestSqlite_New_DefaultPool(t *testing.T) {
+	s := newTestStore(t)
+	defer s.Close()
+	if got := s.Stats().MaxOpenConnections; got != sqlite.DefaultMaxOpenConns {
+		t.Errorf("expected default max open connections %d, got %d", sqlite.DefaultMaxOpenConns, got)
+	}
+}
+
+// --- Test Helpers ---
+
+func newTestStore(t *testing.T) *sqlite.Store {
+	t.Helper()
+	s, err := sqlite.New(filepath.Join(t.TempDir(), "messages.db"), sqlite.PoolConfig{})
+	if err != nil {
+		t.Fatalf("failed to create sqlite store: %v", err)
+	}
+	if err := s.Connect(); err != nil {
+		t.Fatalf("failed to connect sqlite store: %v", err)
+	}
+	return s
+}
@@ -0,0 +1,50 @@
+package store
+
+import (
+	"sort"
+	"time"
+)
+
+// DailyStat holds message counts for a single UTC day.
+type DailyStat struct {
+	Date      string // YYYY-MM-DD
+	Processed int
+	Delivered int
+	Bounces   int
+	Opens     int
+	Clicks    int
+}
+
+// AggregateDaily buckets messages by the UTC day of their Timestamp, keeping
+// those with from <= Timestamp < to. A to of zero means no upper bound.
+// The result is ordered by date. Stores that cannot aggregate natively use it.
+func AggregateDaily(msgs []*Message, from, to int64) []DailyStat {
+	byDay := make(map[string]*DailyStat)
+	for _, m := range msgs {
+		if m.Timestamp < from || (to != 0 && m.Timestamp >= to) {
+			continue
+		}
+		day := time.Unix(m.Timestamp, 0).UTC().Format(time.DateOnly)
+		st, ok := byDay[day]
+		if !ok {
+			st = &DailyStat{Date: day}
+			byDay[day] = st
+		}
+		st.Processed++
+		switch m.Status {
+		case StatusDelivered:
+			st.Delivered++
+		case StatusBounce:
+			st.Bounces++
+		}
+		st.Opens += m.OpensCount
+		st.Clicks += m.ClicksCount
+	}
+
+	stats := make([]DailyStat, 0, len(byDay))
+	for _, st := range byDay {
+		stats = append(stats, *st)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Date < stats[j].Date })
+	return stats
+}
@@ -3,6 +3,9 @@ package store
 type Storer interface {
 	Close() error
 	Connect() error
+
+	// Ping reports whether the store is reachable and ready to serve requests.
+	Ping() error
 }
 
 // BackendStore combines message and webhook stores and provides connection lifecycle
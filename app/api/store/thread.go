@@ -0,0 +1,63 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+)
+
+// replyPrefixes are the subject prefixes stripped when normalizing, lowercased.
+var replyPrefixes = []string{"re:", "fwd:", "fw:"}
+
+// NormalizeSubject strips any leading reply/forward prefixes (Re:, Fwd:, Fw:,
+// repeated and in any case) and surrounding whitespace from subject.
+func NormalizeSubject(subject string) string {
+	s := strings.TrimSpace(subject)
+	for {
+		stripped := false
+		for _, p := range replyPrefixes {
+			if len(s) >= len(p) && strings.EqualFold(s[:len(p)], p) {
+				s = strings.TrimSpace(s[len(p):])
+				stripped = true
+			}
+		}
+		if !stripped {
+			return s
+		}
+	}
+}
+
+// ThreadKey identifies the conversation a message belongs to: messages with
+// the same normalized subject between the same set of participants share a
+// key, regardless of who sent which message.
+func ThreadKey(subject string, participants ...string) string {
+	seen := make(map[string]struct{}, len(participants))
+	addrs := make([]string, 0, len(participants))
+	for _, p := range participants {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if _, dup := seen[p]; dup || p == "" {
+			continue
+		}
+		seen[p] = struct{}{}
+		addrs = append(addrs, p)
+	}
+	sort.Strings(addrs)
+
+	h := sha256.New()
+	h.Write([]byte(strings.ToLower(NormalizeSubject(subject))))
+	for _, a := range addrs {
+		h.Write([]byte{0})
+		h.Write([]byte(a))
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// ThreadKeyOf returns msg's stored thread key, computing it from the subject,
+// sender and recipient for messages saved before thread keys were recorded.
+func ThreadKeyOf(msg *Message) string {
+	if msg.ThreadKey != "" {
+		return msg.ThreadKey
+	}
+	return ThreadKey(msg.Subject, msg.FromEmail, msg.ToEmail)
+}
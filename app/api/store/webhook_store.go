@@ -1,5 +1,7 @@
 package store
 
+import "time"
+
 // WebhookConfig holds webhook registration data
 type WebhookConfig struct {
 	ID        string   `json:"id"`
@@ -9,6 +11,10 @@ type WebhookConfig struct {
 	Secret    string   `json:"secret,omitempty"`
 	CreatedAt int64    `json:"created_at"`
 	UpdatedAt int64    `json:"updated_at"`
+	TimeoutMS int      `json:"timeout_ms,omitempty"` // per-request timeout; 0 uses the dispatcher default
+	Envelope  bool     `json:"envelope,omitempty"`   // wrap events in {"events":[...],"webhook_id":...}
+	// SignatureMode is "ecdsa" or "hmac"; empty uses the dispatcher's default
+	SignatureMode string `json:"signature_mode,omitempty"`
 }
 
 // WebhookStore defines persistence for webhook configurations
@@ -24,12 +30,23 @@ type WebhookStore interface {
 	// ListEnabledWebhooks lists all enabled webhooks
 	ListEnabledWebhooks() ([]*WebhookConfig, error)
 
-	// UpdateWebhook modifies a webhook
+	// UpdateWebhook modifies a webhook. hook.UpdatedAt must be the stored
+	// value the update was based on; ErrConflict is returned if the webhook
+	// changed since. On success hook.UpdatedAt is advanced.
 	UpdateWebhook(hook *WebhookConfig) error
 
 	// DeleteWebhook removes a webhook by ID
 	DeleteWebhook(id string) error
 
+	// DeleteAllWebhooks removes every webhook
+	DeleteAllWebhooks() error
+
 	// Close releases resources
 	Close() error
 }
+
+// NextUpdatedAt returns the updated_at for a webhook updated from prev. It is
+// always later than prev, so two updates within a second stay distinguishable.
+func NextUpdatedAt(prev int64) int64 {
+	return max(time.Now().Unix(), prev+1)
+}
@@ -11,8 +11,11 @@ import (
 type StoreWrapper struct {
 	wrapped    MessageStore
 	dispatcher EventDispatcher
+	recent     *recentCache
 }
 
+var _ MessageStore = (*StoreWrapper)(nil)
+
 // NewStoreWrapper creates a new wrapper.
 // dispatcher must not be nil; use NoOpDispatcher if no event dispatch is desired.
 func NewStoreWrapper(baseStore MessageStore, dispatcher EventDispatcher) *StoreWrapper {
@@ -22,6 +25,25 @@ func NewStoreWrapper(baseStore MessageStore, dispatcher EventDispatcher) *StoreW
 	}
 }
 
+// WithRecentCache keeps the size most recently saved messages in memory so
+// they can be listed via Recent without querying the wrapped store.
+// A size of zero or less disables the cache.
+func (w *StoreWrapper) WithRecentCache(size int) *StoreWrapper {
+	if size > 0 {
+		w.recent = newRecentCache(size)
+	}
+	return w
+}
+
+// Recent returns the cached recent messages, newest first.
+// It returns an empty slice when the cache is disabled.
+func (w *StoreWrapper) Recent() []*Message {
+	if w.recent == nil {
+		return []*Message{}
+	}
+	return w.recent.list()
+}
+
 // Save persists a message and dispatches webhook if status changed
 func (w *StoreWrapper) SaveMSG(msg *Message) error {
 	// Check if this is an update (message already exists)
@@ -36,6 +58,10 @@ func (w *StoreWrapper) SaveMSG(msg *Message) error {
 		return err
 	}
 
+	if w.recent != nil {
+		w.recent.add(msg)
+	}
+
 	// Dispatch webhook for new message or status change
 	if wasNew || (len(oldMsgs) > 0 && oldMsgs[0].Status != msg.Status) {
 		slog.Debug("dispatching webhook event", "msg_id", msg.MsgID, "status", msg.Status)
@@ -46,6 +72,7 @@ func (w *StoreWrapper) SaveMSG(msg *Message) error {
 			msg.Subject,
 			string(msg.Status),
 			msg.Reason,
+			msg.IPPool,
 		)
 	}
 
@@ -57,6 +84,26 @@ func (w *StoreWrapper) GetMSG(query GetQuery) ([]*Message, error) {
 	return w.wrapped.GetMSG(query)
 }
 
+// DistinctRecipients delegates to wrapped store
+func (w *StoreWrapper) DistinctRecipients() (map[string]int, error) {
+	return w.wrapped.DistinctRecipients()
+}
+
+// DailyStats delegates to wrapped store
+func (w *StoreWrapper) DailyStats(from, to int64) ([]DailyStat, error) {
+	return w.wrapped.DailyStats(from, to)
+}
+
+// Count delegates to wrapped store
+func (w *StoreWrapper) Count() (int, error) {
+	return w.wrapped.Count()
+}
+
+// Iterate delegates to wrapped store
+func (w *StoreWrapper) Iterate(fn func(*Message) bool) error {
+	return w.wrapped.Iterate(fn)
+}
+
 // Close delegates to wrapped store
 func (w *StoreWrapper) Close() error {
 	return w.wrapped.Close()
@@ -0,0 +1,105 @@
+// Package admin provides administrative endpoints for inspecting mockgrid state.
+package admin
+
+import (
+	"cmp"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/mustur/mockgrid/app/api"
+	"github.com/mustur/mockgrid/app/api/objects"
+	"github.com/mustur/mockgrid/app/api/store"
+	"github.com/mustur/mockgrid/internal/httpjson"
+)
+
+// Config holds configuration for the admin service.
+type Config struct {
+	AuthKey   string
+	AccessLog *slog.Logger // logs each request; nil means slog.Default()
+}
+
+// RouteLister reports the services registered on the server.
+type RouteLister interface {
+	Routes() []api.Route
+}
+
+// WebhookValidator checks a webhook before it is imported, e.g. the webhook
+// service enforcing its signing rules.
+type WebhookValidator interface {
+	ValidateWebhook(hook *store.WebhookConfig) error
+}
+
+// Service serves administrative endpoints.
+type Service struct {
+	authKey   string
+	accessLog *slog.Logger
+	store     store.MessageStore
+	routes    RouteLister        // nil reports no routes
+	webhooks  store.WebhookStore // nil exports nothing and refuses imports
+	validate  WebhookValidator   // nil only checks imported IDs and URLs
+}
+
+// RecipientCount is a distinct recipient address and its message count.
+type RecipientCount struct {
+	Email string `json:"email"`
+	Count int    `json:"count"`
+}
+
+// RecipientsResponse wraps the distinct recipients list.
+type RecipientsResponse struct {
+	Recipients []RecipientCount `json:"recipients"`
+}
+
+// RoutesResponse wraps the registered service routes.
+type RoutesResponse struct {
+	Routes []api.Route `json:"routes"`
+}
+
+// New creates a new admin service reading from msgStore.
+func New(cfg Config, msgStore store.MessageStore) *Service {
+	return &Service{
+		authKey:   cfg.AuthKey,
+		accessLog: cfg.AccessLog,
+		store:     msgStore,
+	}
+}
+
+// WithRoutes enables GET /v3/admin/routes, reporting the routes of r.
+func (s *Service) WithRoutes(r RouteLister) *Service {
+	s.routes = r
+	return s
+}
+
+// handleRoutes processes GET /v3/admin/routes.
+func (s *Service) handleRoutes(w http.ResponseWriter, _ *http.Request) {
+	resp := RoutesResponse{Routes: []api.Route{}}
+	if s.routes != nil {
+		resp.Routes = append(resp.Routes, s.routes.Routes()...)
+	}
+	httpjson.Write(w, http.StatusOK, resp)
+}
+
+// handleRecipients processes GET /v3/admin/recipients.
+// Recipients are ordered by count (descending), then address.
+func (s *Service) handleRecipients(w http.ResponseWriter, _ *http.Request) {
+	counts, err := s.store.DistinctRecipients()
+	if err != nil {
+		slog.Error("failed to list recipients", "err", err)
+		httpjson.Write(w, http.StatusInternalServerError, objects.GetErrorResponse("Failed to list recipients", nil, nil))
+		return
+	}
+
+	resp := RecipientsResponse{Recipients: make([]RecipientCount, 0, len(counts))}
+	for email, n := range counts {
+		resp.Recipients = append(resp.Recipients, RecipientCount{Email: email, Count: n})
+	}
+	slices.SortFunc(resp.Recipients, func(a, b RecipientCount) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Email, b.Email)
+	})
+
+	httpjson.Write(w, http.StatusOK, resp)
+}
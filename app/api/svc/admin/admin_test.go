@@ -0,0 +1,312 @@
+package admin_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mustur/mockgrid/app/api"
+	"github.com/mustur/mockgrid/app/api/store"
+	"github.com/mustur/mockgrid/app/api/store/filesystem"
+	"github.com/mustur/mockgrid/app/api/svc/admin"
+	"github.com/mustur/mockgrid/app/api/svc/stats"
+	"github.com/mustur/mockgrid/app/api/svc/webhook"
+	"github.com/mustur/mockgrid/internal/testutil"
+)
+
+// --- Recipients Tests ---
+
+func TestRecipients_ReturnsDistinctCounts(t *testing.T) {
+	st := testutil.NewMockMessageStore()
+	for id, to := range map[string]string{
+		"m1": "x@example.com",
+		"m2": "y@example.com",
+		"m3": "x@example.com",
+		"m4": "z@example.com",
+		"m5": "x@example.com",
+		"m6": "y@example.com",
+	} {
+		if err := st.SaveMSG(testutil.NewMessageBuilder(id).WithTo(to).Build()); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+
+	srv := httptest.NewServer(buildServiceMux(admin.New(admin.Config{}, st)))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/recipients")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	var got admin.RecipientsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	want := []admin.RecipientCount{
+		{Email: "x@example.com", Count: 3},
+		{Email: "y@example.com", Count: 2},
+		{Email: "z@example.com", Count: 1},
+	}
+	if len(got.Recipients) != len(want) {
+		t.Fatalf("expected %d recipients, got %+v", len(want), got.Recipients)
+	}
+	for i := range want {
+		if got.Recipients[i] != want[i] {
+			t.Errorf("position %d: expected %+v, got %+v", i, want[i], got.Recipients[i])
+		}
+	}
+}
+
+func TestRecipients_RequiresAuth(t *testing.T) {
+	srv := httptest.NewServer(buildServiceMux(admin.New(admin.Config{AuthKey: "secret"}, testutil.NewMockMessageStore())))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/recipients")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", resp.StatusCode)
+	}
+}
+
+// --- Routes Tests ---
+
+func TestRoutes_ListsRegisteredServiceRoots(t *testing.T) {
+	st := testutil.NewMockMessageStore()
+	adminSvc := admin.New(admin.Config{}, st)
+	statsSvc := stats.New(stats.Config{}, st)
+	mg := api.New("127.0.0.1:5900", statsSvc).WithListener("127.0.0.1:5901", adminSvc)
+	adminSvc.WithRoutes(mg)
+
+	srv := httptest.NewServer(buildServiceMux(adminSvc))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/routes")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var got admin.RoutesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	want := []api.Route{
+		{Address: "127.0.0.1:5900", Root: "/v3/stats/", Patterns: []string{"GET /{$}", "GET /daily"}},
+		{Address: "127.0.0.1:5901", Root: "/v3/admin/", Patterns: []string{
+			"GET /recipients", "GET /routes", "GET /webhooks/export", "POST /webhooks/import",
+		}},
+	}
+	if !reflect.DeepEqual(got.Routes, want) {
+		t.Errorf("expected routes %+v, got %+v", want, got.Routes)
+	}
+}
+
+// --- Webhook Export Tests ---
+
+func TestWebhooks_ExportThenImport_CopiesEveryWebhook(t *testing.T) {
+	src := testutil.NewMockWebhookStore(
+		&store.WebhookConfig{ID: "wh-1", URL: "http://a.example/hook", Enabled: true, Events: []string{"delivered"}, Secret: "s3cret", CreatedAt: 100, UpdatedAt: 150, TimeoutMS: 2500},
+		&store.WebhookConfig{ID: "wh-2", URL: "http://b.example/hook", Events: []string{"bounce", "open"}, CreatedAt: 200, UpdatedAt: 200, Envelope: true},
+	)
+	dst, err := filesystem.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	if err := dst.Connect(); err != nil {
+		t.Fatalf("failed to connect store: %v", err)
+	}
+	defer dst.Close()
+
+	srcSrv := httptest.NewServer(buildServiceMux(admin.New(admin.Config{}, nil).WithWebhooks(src, nil)))
+	defer srcSrv.Close()
+	dstSrv := httptest.NewServer(buildServiceMux(admin.New(admin.Config{}, nil).WithWebhooks(dst, nil)))
+	defer dstSrv.Close()
+
+	exported := exportWebhooks(t, srcSrv.URL)
+	if got := importWebhooks(t, dstSrv.URL, "", exported); got != (admin.ImportResponse{Created: 2}) {
+		t.Errorf("expected 2 created, got %+v", got)
+	}
+
+	want, got := sortedByID(exported), sortedByID(exportWebhooks(t, dstSrv.URL))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("imported webhooks differ:\nwant %+v\ngot  %+v", want, got)
+	}
+	if want[0].Secret != "s3cret" {
+		t.Errorf("expected the export to include secrets, got %q", want[0].Secret)
+	}
+}
+
+func TestWebhooks_Import_SkipsOrOverwritesExisting(t *testing.T) {
+	hooks := testutil.NewMockWebhookStore(&store.WebhookConfig{ID: "wh-1", URL: "http://old.example/hook", CreatedAt: 100, UpdatedAt: 100})
+	srv := httptest.NewServer(buildServiceMux(admin.New(admin.Config{}, nil).WithWebhooks(hooks, nil)))
+	defer srv.Close()
+
+	incoming := []*store.WebhookConfig{{ID: "wh-1", URL: "http://new.example/hook", CreatedAt: 500, UpdatedAt: 500}}
+
+	if got := importWebhooks(t, srv.URL, "", incoming); got != (admin.ImportResponse{Skipped: 1}) {
+		t.Errorf("expected 1 skipped, got %+v", got)
+	}
+	if hook, _ := hooks.GetWebhook("wh-1"); hook.URL != "http://old.example/hook" {
+		t.Errorf("expected skip to keep the stored webhook, got %q", hook.URL)
+	}
+
+	if got := importWebhooks(t, srv.URL, "overwrite", incoming); got != (admin.ImportResponse{Overwritten: 1}) {
+		t.Errorf("expected 1 overwritten, got %+v", got)
+	}
+	hook, _ := hooks.GetWebhook("wh-1")
+	if hook.URL != "http://new.example/hook" || hook.CreatedAt != 100 {
+		t.Errorf("expected the new URL with the original created_at, got %+v", hook)
+	}
+}
+
+func TestWebhooks_Import_RejectsInvalidBatch(t *testing.T) {
+	hooks := testutil.NewMockWebhookStore()
+	srv := httptest.NewServer(buildServiceMux(admin.New(admin.Config{}, nil).WithWebhooks(hooks, nil)))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		name, query, body string
+	}{
+		{"missing url", "", `[{"id":"wh-1","url":"http://a.example"},{"id":"wh-2"}]`},
+		{"duplicate id", "", `[{"id":"wh-1","url":"http://a.example"},{"id":"wh-1","url":"http://b.example"}]`},
+		{"not an array", "", `{"id":"wh-1"}`},
+		{"bad mode", "?on_conflict=merge", `[]`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := http.Post(srv.URL+"/webhooks/import"+tc.query, "application/json", strings.NewReader(tc.body))
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("expected 400, got %d", resp.StatusCode)
+			}
+		})
+	}
+	if list, _ := hooks.ListWebhooks(); len(list) != 0 {
+		t.Errorf("expected nothing imported, got %d webhooks", len(list))
+	}
+}
+
+func TestWebhooks_Import_AppliesWebhookServiceRules(t *testing.T) {
+	hooks := testutil.NewMockWebhookStore()
+	rules := webhook.NewService(hooks, nil).WithRequireSigned(true)
+	srv := httptest.NewServer(buildServiceMux(admin.New(admin.Config{}, nil).WithWebhooks(hooks, rules)))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		name, body string
+	}{
+		{"unsigned", `[{"id":"wh-1","url":"http://a.example","secret":"s3cret"},{"id":"wh-2","url":"http://b.example"}]`},
+		{"ecdsa without key", `[{"id":"wh-1","url":"http://a.example","signature_mode":"ecdsa"}]`},
+		{"unknown mode", `[{"id":"wh-1","url":"http://a.example","secret":"s3cret","signature_mode":"rsa"}]`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := http.Post(srv.URL+"/webhooks/import", "application/json", strings.NewReader(tc.body))
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("expected 400, got %d", resp.StatusCode)
+			}
+		})
+	}
+	if list, _ := hooks.ListWebhooks(); len(list) != 0 {
+		t.Errorf("expected nothing imported, got %d webhooks", len(list))
+	}
+
+	signed := []*store.WebhookConfig{{ID: "wh-1", URL: "http://a.example", Secret: "s3cret"}}
+	if got := importWebhooks(t, srv.URL, "", signed); got != (admin.ImportResponse{Created: 1}) {
+		t.Errorf("expected the signed webhook to be created, got %+v", got)
+	}
+}
+
+func TestWebhooks_Export_RequiresAuth(t *testing.T) {
+	svc := admin.New(admin.Config{AuthKey: "secret"}, nil).WithWebhooks(testutil.NewMockWebhookStore(), nil)
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/webhooks/export")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", resp.StatusCode)
+	}
+}
+
+// --- Test Helpers ---
+
+func exportWebhooks(t *testing.T, baseURL string) []*store.WebhookConfig {
+	t.Helper()
+	resp, err := http.Get(baseURL + "/webhooks/export")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var hooks []*store.WebhookConfig
+	if err := json.NewDecoder(resp.Body).Decode(&hooks); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return hooks
+}
+
+func importWebhooks(t *testing.T, baseURL, onConflict string, hooks []*store.WebhookConfig) admin.ImportResponse {
+	t.Helper()
+	body, err := json.Marshal(hooks)
+	if err != nil {
+		t.Fatalf("failed to encode webhooks: %v", err)
+	}
+	url := baseURL + "/webhooks/import"
+	if onConflict != "" {
+		url += "?on_conflict=" + onConflict
+	}
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var got admin.ImportResponse
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return got
+}
+
+func sortedByID(hooks []*store.WebhookConfig) []*store.WebhookConfig {
+	return slices.SortedFunc(slices.Values(hooks), func(a, b *store.WebhookConfig) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+}
+
+// buildServiceMux applies the service's middleware chain to the mux.
+func buildServiceMux(svc *admin.Service) *http.ServeMux {
+	wrappedMux := http.NewServeMux()
+	wrappedMux.Handle("/", svc.Chain()(svc.GetMux()))
+	return wrappedMux
+}
@@ -0,0 +1,125 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/mustur/mockgrid/app/api/objects"
+	"github.com/mustur/mockgrid/app/api/store"
+	"github.com/mustur/mockgrid/internal/httpjson"
+)
+
+// maxImportBytes caps the body of POST /v3/admin/webhooks/import.
+const maxImportBytes = 10 << 20
+
+// Import conflict modes, chosen with ?on_conflict=.
+const (
+	ConflictSkip      = "skip"
+	ConflictOverwrite = "overwrite"
+)
+
+// ImportResponse counts what an import did with each webhook.
+type ImportResponse struct {
+	Created     int `json:"created"`
+	Overwritten int `json:"overwritten"`
+	Skipped     int `json:"skipped"`
+}
+
+// WithWebhooks enables exporting and importing the webhooks in ws. Every
+// imported webhook must pass v, so an import cannot store a webhook the
+// webhook service would refuse to create.
+func (s *Service) WithWebhooks(ws store.WebhookStore, v WebhookValidator) *Service {
+	s.webhooks = ws
+	s.validate = v
+	return s
+}
+
+// handleExportWebhooks processes GET /v3/admin/webhooks/export, returning
+// every webhook including its signing secret.
+func (s *Service) handleExportWebhooks(w http.ResponseWriter, _ *http.Request) {
+	hooks := []*store.WebhookConfig{}
+	if s.webhooks != nil {
+		list, err := s.webhooks.ListWebhooks()
+		if err != nil {
+			slog.Error("failed to list webhooks", "err", err)
+			httpjson.Write(w, http.StatusInternalServerError, objects.GetErrorResponse("Failed to list webhooks", nil, nil))
+			return
+		}
+		hooks = append(hooks, list...)
+	}
+	httpjson.Write(w, http.StatusOK, hooks)
+}
+
+// handleImportWebhooks processes POST /v3/admin/webhooks/import. The body is
+// an export; webhooks whose ID already exists are skipped, or replaced with
+// ?on_conflict=overwrite. Nothing is written unless every webhook is valid.
+func (s *Service) handleImportWebhooks(w http.ResponseWriter, r *http.Request) {
+	if s.webhooks == nil {
+		httpjson.Write(w, http.StatusNotFound, objects.GetErrorResponse("Webhook storage is not configured", nil, nil))
+		return
+	}
+
+	mode := r.URL.Query().Get("on_conflict")
+	if mode == "" {
+		mode = ConflictSkip
+	}
+	if mode != ConflictSkip && mode != ConflictOverwrite {
+		httpjson.Write(w, http.StatusBadRequest, objects.GetErrorResponse("on_conflict must be skip or overwrite", "on_conflict", nil))
+		return
+	}
+
+	var hooks []*store.WebhookConfig
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxImportBytes)).Decode(&hooks); err != nil {
+		httpjson.Write(w, http.StatusBadRequest, objects.GetErrorResponse("Body must be a JSON array of webhooks", nil, nil))
+		return
+	}
+	seen := make(map[string]bool, len(hooks))
+	for i, hook := range hooks {
+		var msg string
+		switch {
+		case hook == nil || hook.ID == "":
+			msg = "id is required"
+		case hook.URL == "":
+			msg = "url is required"
+		case seen[hook.ID]:
+			msg = "id " + hook.ID + " appears more than once"
+		case s.validate != nil:
+			if err := s.validate.ValidateWebhook(hook); err != nil {
+				msg = err.Error()
+			}
+		}
+		if msg != "" {
+			httpjson.Write(w, http.StatusBadRequest, objects.GetErrorResponse(msg, fmt.Sprintf("[%d]", i), nil))
+			return
+		}
+		seen[hook.ID] = true
+	}
+
+	var resp ImportResponse
+	for _, hook := range hooks {
+		existing, err := s.webhooks.GetWebhook(hook.ID)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			err = s.webhooks.Create(hook)
+			resp.Created++
+		case err != nil:
+		case mode == ConflictSkip:
+			resp.Skipped++
+			continue
+		default:
+			// Overwriting keeps the stored creation time and bumps updated_at
+			hook.CreatedAt, hook.UpdatedAt = existing.CreatedAt, existing.UpdatedAt
+			err = s.webhooks.UpdateWebhook(hook)
+			resp.Overwritten++
+		}
+		if err != nil {
+			slog.Error("failed to import webhook", "webhook_id", hook.ID, "err", err)
+			httpjson.Write(w, http.StatusInternalServerError, objects.GetErrorResponse("Failed to import webhook "+hook.ID, nil, nil))
+			return
+		}
+	}
+	httpjson.Write(w, http.StatusOK, resp)
+}
@@ -0,0 +1,291 @@
+// Package messages provides read access to stored messages.
+package messages
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mustur/mockgrid/app/api/objects"
+	"github.com/mustur/mockgrid/app/api/store"
+	"github.com/mustur/mockgrid/internal/httpjson"
+)
+
+// Config holds configuration for the messages service.
+type Config struct {
+	AuthKey   string
+	AccessLog *slog.Logger // logs each request; nil means slog.Default()
+}
+
+// Resender delivers a stored message again and returns it with its new status.
+type Resender interface {
+	Resend(msg *store.Message) (*store.Message, error)
+}
+
+// Service serves stored messages.
+type Service struct {
+	authKey   string
+	accessLog *slog.Logger
+	store     store.MessageStore
+	recent    store.RecentLister
+	resender  Resender // nil disables POST /{id}/resend
+}
+
+// ListResponse wraps a list of messages (SendGrid format).
+type ListResponse struct {
+	Messages []*store.Message `json:"messages"`
+}
+
+// Thread is a conversation: messages sharing a thread key.
+type Thread struct {
+	ThreadKey string           `json:"thread_key"`
+	Subject   string           `json:"subject"`
+	Messages  []*store.Message `json:"messages"` // oldest first
+}
+
+// Engagement summarizes the tracked activity on a message.
+type Engagement struct {
+	Opens         int                 `json:"opens"`
+	Clicks        int                 `json:"clicks"`
+	LastEventTime int64               `json:"last_event_time"`
+	Status        store.MessageStatus `json:"status"`
+}
+
+// ThreadsResponse wraps a list of threads.
+type ThreadsResponse struct {
+	Threads []*Thread `json:"threads"`
+}
+
+// New creates a new messages service reading from msgStore.
+// recent serves the fast recent-messages listing.
+func New(cfg Config, msgStore store.MessageStore, recent store.RecentLister) *Service {
+	return &Service{
+		authKey:   cfg.AuthKey,
+		accessLog: cfg.AccessLog,
+		store:     msgStore,
+		recent:    recent,
+	}
+}
+
+// WithResender enables POST /v3/messages/{id}/resend using r.
+func (s *Service) WithResender(r Resender) *Service {
+	s.resender = r
+	return s
+}
+
+// headerParamPrefix marks list query params that filter on a captured
+// request header, e.g. header.X-Campaign-Id=spring.
+const headerParamPrefix = "header."
+
+// handleList processes GET /v3/messages/, returning stored messages newest
+// first. limit and offset page through the results, since and until keep
+// only messages sent within that window (Unix seconds, inclusive), status
+// keeps only messages in that delivery state, and api_key_id keeps only
+// messages sent with the API key of that identifier. Each header.<Name>=value
+// param keeps only messages whose captured request header Name equals value;
+// with several such params all must match.
+func (s *Service) handleList(w http.ResponseWriter, r *http.Request) {
+	query, errResp, ok := parseListQuery(r)
+	if !ok {
+		httpjson.Write(w, http.StatusBadRequest, errResp)
+		return
+	}
+	msgs, err := s.store.GetMSG(query)
+	if err != nil {
+		slog.Error("failed to list messages", "err", err)
+		httpjson.Write(w, http.StatusInternalServerError, objects.GetErrorResponse("Failed to list messages", nil, nil))
+		return
+	}
+	if msgs == nil {
+		msgs = []*store.Message{}
+	}
+	httpjson.Write(w, http.StatusOK, ListResponse{Messages: msgs})
+}
+
+// handleGet processes GET /v3/messages/{id}, returning a single message.
+func (s *Service) handleGet(w http.ResponseWriter, r *http.Request) {
+	if msg, ok := s.lookup(w, r.PathValue("id")); ok {
+		httpjson.Write(w, http.StatusOK, msg)
+	}
+}
+
+// handleEngagement processes GET /v3/messages/{id}/engagement, returning the
+// message's open and click counts without its bodies.
+func (s *Service) handleEngagement(w http.ResponseWriter, r *http.Request) {
+	msg, ok := s.lookup(w, r.PathValue("id"))
+	if !ok {
+		return
+	}
+	httpjson.Write(w, http.StatusOK, Engagement{
+		Opens:         msg.OpensCount,
+		Clicks:        msg.ClicksCount,
+		LastEventTime: msg.LastEventTime,
+		Status:        msg.Status,
+	})
+}
+
+// lookup fetches the message with the given id, writing a 404 or 500
+// response and reporting false when it cannot be returned.
+func (s *Service) lookup(w http.ResponseWriter, id string) (*store.Message, bool) {
+	msgs, err := s.store.GetMSG(store.GetQuery{ID: id})
+	if errors.Is(err, store.ErrNotFound) || (err == nil && len(msgs) == 0) {
+		httpjson.Write(w, http.StatusNotFound, objects.GetErrorResponse("Message not found", "id", nil))
+		return nil, false
+	}
+	if err != nil {
+		slog.Error("failed to fetch message", "id", id, "err", err)
+		httpjson.Write(w, http.StatusInternalServerError, objects.GetErrorResponse("Failed to fetch message", nil, nil))
+		return nil, false
+	}
+	return msgs[0], true
+}
+
+// handleRecent processes GET /v3/messages/recent from the in-memory cache.
+func (s *Service) handleRecent(w http.ResponseWriter, _ *http.Request) {
+	httpjson.Write(w, http.StatusOK, ListResponse{Messages: s.recent.Recent()})
+}
+
+// handleThreads processes GET /v3/messages/threads, grouping every stored
+// message by thread key. Threads are ordered by their latest message, newest first.
+func (s *Service) handleThreads(w http.ResponseWriter, _ *http.Request) {
+	byKey := make(map[string]*Thread)
+	err := s.store.Iterate(func(msg *store.Message) bool {
+		key := store.ThreadKeyOf(msg)
+		th, ok := byKey[key]
+		if !ok {
+			th = &Thread{ThreadKey: key}
+			byKey[key] = th
+		}
+		th.Messages = append(th.Messages, msg)
+		return true
+	})
+	if err != nil {
+		slog.Error("failed to list messages", "err", err)
+		httpjson.Write(w, http.StatusInternalServerError, objects.GetErrorResponse("Failed to list threads", nil, nil))
+		return
+	}
+
+	resp := ThreadsResponse{Threads: make([]*Thread, 0, len(byKey))}
+	for _, th := range byKey {
+		sort.SliceStable(th.Messages, func(i, j int) bool {
+			return th.Messages[i].Timestamp < th.Messages[j].Timestamp
+		})
+		th.Subject = store.NormalizeSubject(th.Messages[0].Subject)
+		resp.Threads = append(resp.Threads, th)
+	}
+	sort.Slice(resp.Threads, func(i, j int) bool {
+		return lastTimestamp(resp.Threads[i]) > lastTimestamp(resp.Threads[j])
+	})
+
+	httpjson.Write(w, http.StatusOK, resp)
+}
+
+// handleResend processes POST /v3/messages/{id}/resend, delivering the stored
+// message again and responding with its updated state.
+func (s *Service) handleResend(w http.ResponseWriter, r *http.Request) {
+	if s.resender == nil {
+		httpjson.Write(w, http.StatusNotImplemented, objects.GetErrorResponse("Resending is not enabled", nil, nil))
+		return
+	}
+
+	id := r.PathValue("id")
+	msg, ok := s.lookup(w, id)
+	if !ok {
+		return
+	}
+
+	updated, err := s.resender.Resend(msg)
+	if err != nil {
+		slog.Error("failed to resend message", "id", id, "err", err)
+		httpjson.Write(w, http.StatusInternalServerError, objects.GetErrorResponse("Failed to resend message", nil, nil))
+		return
+	}
+	httpjson.Write(w, http.StatusOK, updated)
+}
+
+// parseListQuery reads the limit, offset, since, until, status and
+// api_key_id params of a list request.
+// It returns the error response to send when one of them is invalid.
+func parseListQuery(r *http.Request) (store.GetQuery, objects.ErrorResponse, bool) {
+	var query store.GetQuery
+	params := r.URL.Query()
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"limit", &query.Limit}, {"offset", &query.Offset}} {
+		v := params.Get(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return query, objects.GetErrorResponse(p.name+" must be a non-negative integer", p.name, nil), false
+		}
+		*p.dst = n
+	}
+	for _, p := range []struct {
+		name string
+		dst  *int64
+	}{{"since", &query.Since}, {"until", &query.Until}} {
+		v := params.Get(p.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return query, objects.GetErrorResponse(p.name+" must be a Unix timestamp in seconds", p.name, nil), false
+		}
+		*p.dst = n
+	}
+	if query.Until != 0 && query.Until < query.Since {
+		return query, objects.GetErrorResponse("until must not be before since", "until", nil), false
+	}
+
+	if v := params.Get("status"); v != "" {
+		status := store.MessageStatus(v)
+		if !slices.Contains(store.Statuses, status) {
+			return query, objects.GetErrorResponse("Unknown message status: "+v, "status", nil), false
+		}
+		query.Status = status
+	}
+	query.APIKeyID = params.Get("api_key_id")
+
+	for key, values := range params {
+		name, ok := strings.CutPrefix(key, headerParamPrefix)
+		if !ok || name == "" {
+			continue
+		}
+		if !isHeaderName(name) {
+			return query, objects.GetErrorResponse("Invalid header name: "+name, key, nil), false
+		}
+		if query.HeaderMatch == nil {
+			query.HeaderMatch = make(map[string]string)
+		}
+		query.HeaderMatch[textproto.CanonicalMIMEHeaderKey(name)] = values[len(values)-1]
+	}
+	return query, objects.ErrorResponse{}, true
+}
+
+// isHeaderName reports whether name is a valid HTTP header field name, an
+// RFC 9110 token.
+func isHeaderName(name string) bool {
+	for _, c := range []byte(name) {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// lastTimestamp returns the timestamp of a thread's newest message.
+func lastTimestamp(th *Thread) int64 {
+	return th.Messages[len(th.Messages)-1].Timestamp
+}
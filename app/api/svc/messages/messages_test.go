@@ -0,0 +1,557 @@
+package messages_test
+
+import (
+	"encoding/json"
+	"errors"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mustur/mockgrid/app/api/store"
+	"github.com/mustur/mockgrid/app/api/svc/messages"
+	"github.com/mustur/mockgrid/app/api/svc/sendmail"
+	"github.com/mustur/mockgrid/internal/testutil"
+)
+
+// --- List Tests ---
+
+func TestList_FilterByHeader(t *testing.T) {
+	backing := testutil.NewMockMessageStore()
+	campaigns := map[string]string{"msg-spring-1": "spring", "msg-spring-2": "spring", "msg-autumn": "autumn"}
+	for id, campaign := range campaigns {
+		msg := testutil.NewTestMessage(id)
+		msg.RequestHeaders = map[string]string{"X-Campaign-Id": campaign, "User-Agent": "client/" + id}
+		if err := backing.SaveMSG(msg); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+	if err := backing.SaveMSG(testutil.NewTestMessage("msg-plain")); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	svc := messages.New(messages.Config{}, backing, store.NewStoreWrapper(backing, &store.NoOpDispatcher{}))
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	if got := getMessages(t, srv.URL+"/", ""); len(got.Messages) != 4 {
+		t.Errorf("expected 4 messages without a filter, got %d", len(got.Messages))
+	}
+
+	got := getMessages(t, srv.URL+"/?header.x-campaign-id=spring", "")
+	if len(got.Messages) != 2 {
+		t.Fatalf("expected 2 spring messages, got %d", len(got.Messages))
+	}
+	for _, msg := range got.Messages {
+		if campaigns[msg.MsgID] != "spring" {
+			t.Errorf("unexpected message %q in spring results", msg.MsgID)
+		}
+	}
+
+	got = getMessages(t, srv.URL+"/?header.X-Campaign-Id=spring&header.User-Agent=client/msg-spring-2", "")
+	if len(got.Messages) != 1 || got.Messages[0].MsgID != "msg-spring-2" {
+		t.Errorf("expected only msg-spring-2 when both headers match, got %+v", got.Messages)
+	}
+
+	if got := getMessages(t, srv.URL+"/?header.X-Campaign-Id=winter", ""); len(got.Messages) != 0 {
+		t.Errorf("expected no messages for an unknown campaign, got %d", len(got.Messages))
+	}
+
+	for _, qs := range []string{`header.a%22b=x`, `header.a%5Cb=x`, `header.a%20b=x`} {
+		resp, err := http.Get(srv.URL + "/?" + qs)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", qs, resp.StatusCode)
+		}
+	}
+}
+
+func TestList_FilterByAPIKeyID(t *testing.T) {
+	backing := testutil.NewMockMessageStore()
+	for id, key := range map[string]string{"msg-one": "key-one", "msg-two": "key-two"} {
+		msg := testutil.NewTestMessage(id)
+		msg.APIKeyID = store.KeyID(key)
+		if err := backing.SaveMSG(msg); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+
+	svc := messages.New(messages.Config{}, backing, store.NewStoreWrapper(backing, &store.NoOpDispatcher{}))
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	got := getMessages(t, srv.URL+"/?api_key_id="+store.KeyID("key-two"), "")
+	if len(got.Messages) != 1 || got.Messages[0].MsgID != "msg-two" {
+		t.Errorf("expected only msg-two, got %+v", got.Messages)
+	}
+}
+
+func TestList_LimitOffsetAndStatus(t *testing.T) {
+	backing := testutil.NewMockMessageStore()
+	for i := range 5 {
+		status := store.StatusDelivered
+		if i%2 == 1 {
+			status = store.StatusBounce
+		}
+		msg := testutil.NewMessageBuilder("msg-" + strconv.Itoa(i)).
+			WithStatus(status).
+			WithTimestamp(int64(1000 + i)).
+			Build()
+		if err := backing.SaveMSG(msg); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+
+	svc := messages.New(messages.Config{}, backing, store.NewStoreWrapper(backing, &store.NoOpDispatcher{}))
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	got := getMessages(t, srv.URL+"/?limit=2&offset=1", "")
+	if ids := messageIDs(got.Messages); len(ids) != 2 || ids[0] != "msg-3" || ids[1] != "msg-2" {
+		t.Errorf("expected [msg-3 msg-2], got %v", ids)
+	}
+
+	got = getMessages(t, srv.URL+"/?status=bounce", "")
+	if ids := messageIDs(got.Messages); len(ids) != 2 || ids[0] != "msg-3" || ids[1] != "msg-1" {
+		t.Errorf("expected [msg-3 msg-1], got %v", ids)
+	}
+
+	for _, qs := range []string{"limit=-1", "offset=x", "status=lost"} {
+		resp, err := http.Get(srv.URL + "/?" + qs)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", qs, resp.StatusCode)
+		}
+	}
+}
+
+// --- Get Tests ---
+
+func TestList_SinceUntil(t *testing.T) {
+	backing := testutil.NewMockMessageStore()
+	for i := range 5 {
+		status := store.StatusDelivered
+		if i%2 == 1 {
+			status = store.StatusBounce
+		}
+		msg := testutil.NewMessageBuilder("msg-" + strconv.Itoa(i)).
+			WithStatus(status).
+			WithTimestamp(int64(1000 + i)).
+			Build()
+		if err := backing.SaveMSG(msg); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+
+	svc := messages.New(messages.Config{}, backing, store.NewStoreWrapper(backing, &store.NoOpDispatcher{}))
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	got := getMessages(t, srv.URL+"/?since=1001&until=1003", "")
+	if ids := messageIDs(got.Messages); len(ids) != 3 || ids[0] != "msg-3" || ids[2] != "msg-1" {
+		t.Errorf("expected [msg-3 msg-2 msg-1], got %v", ids)
+	}
+
+	got = getMessages(t, srv.URL+"/?since=1002&status=delivered", "")
+	if ids := messageIDs(got.Messages); len(ids) != 2 || ids[0] != "msg-4" || ids[1] != "msg-2" {
+		t.Errorf("expected [msg-4 msg-2], got %v", ids)
+	}
+
+	for _, qs := range []string{"since=-1", "until=yesterday", "since=1003&until=1001"} {
+		resp, err := http.Get(srv.URL + "/?" + qs)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", qs, resp.StatusCode)
+		}
+	}
+}
+
+func TestGet_ReturnsMessageOr404(t *testing.T) {
+	backing := testutil.NewMockMessageStore()
+	if err := backing.SaveMSG(testutil.NewTestMessage("msg-1")); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	svc := messages.New(messages.Config{}, backing, store.NewStoreWrapper(backing, &store.NoOpDispatcher{}))
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/msg-1")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var msg store.Message
+	if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if msg.MsgID != "msg-1" {
+		t.Errorf("expected msg-1, got %q", msg.MsgID)
+	}
+
+	missing, err := http.Get(srv.URL + "/missing")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	missing.Body.Close()
+	if missing.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown id, got %d", missing.StatusCode)
+	}
+}
+
+// --- Engagement Tests ---
+
+func TestEngagement_ReflectsTrackedOpen(t *testing.T) {
+	backing := testutil.NewMockMessageStore()
+	host, port := testutil.StartSMTPServer(t)
+	mailSvc := sendmail.New(sendmail.Config{
+		SMTPServer:    host,
+		SMTPPort:      port,
+		AttachmentDir: t.TempDir(),
+	}, nil, backing)
+	t.Cleanup(func() { _ = mailSvc.Close() })
+	mailSrv := httptest.NewServer(mailSvc.Chain()(mailSvc.GetMux()))
+	defer mailSrv.Close()
+
+	payload := `{"from":{"email":"from@example.com"},"personalizations":[{"to":[{"email":"to@example.com"}]}],` +
+		`"subject":"Hello","content":[{"type":"text/html","value":"<p>Hi</p>"}]}`
+	resp, err := http.Post(mailSrv.URL+"/send", "application/json", strings.NewReader(payload))
+	if err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", resp.StatusCode)
+	}
+	sent := backing.Messages()
+	if len(sent) != 1 {
+		t.Fatalf("expected 1 stored message, got %d", len(sent))
+	}
+	msgID := sent[0].MsgID
+
+	svc := messages.New(messages.Config{}, backing, store.NewStoreWrapper(backing, &store.NoOpDispatcher{}))
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	before := getEngagement(t, srv.URL+"/"+msgID+"/engagement")
+	if before.Opens != 0 {
+		t.Fatalf("expected no opens before the pixel is fetched, got %+v", before)
+	}
+
+	pixel := regexp.MustCompile(`/v3/mail/track/open\?([^"]+)`).FindStringSubmatch(sent[0].HTMLBody)
+	if pixel == nil {
+		t.Fatalf("expected a tracking pixel in %q", sent[0].HTMLBody)
+	}
+	open, err := http.Get(mailSrv.URL + "/track/open?" + html.UnescapeString(pixel[1]))
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	open.Body.Close()
+
+	got := getEngagement(t, srv.URL+"/"+msgID+"/engagement")
+	if got.Opens != 1 || got.Clicks != 0 {
+		t.Errorf("expected 1 open and no clicks, got %+v", got)
+	}
+	if got.LastEventTime == 0 || got.LastEventTime < before.LastEventTime {
+		t.Errorf("expected last_event_time to advance with the open, got %d after %d", got.LastEventTime, before.LastEventTime)
+	}
+	if got.Status != store.StatusDelivered {
+		t.Errorf("expected status %q, got %q", store.StatusDelivered, got.Status)
+	}
+
+	missing, err := http.Get(srv.URL + "/missing/engagement")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	missing.Body.Close()
+	if missing.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown id, got %d", missing.StatusCode)
+	}
+}
+
+// --- Recent Tests ---
+
+func TestRecent_ReturnsLatestNInOrder(t *testing.T) {
+	backing := testutil.NewMockMessageStore()
+	wrapper := store.NewStoreWrapper(backing, &store.NoOpDispatcher{}).WithRecentCache(3)
+
+	for i := 1; i <= 5; i++ {
+		msg := testutil.NewMessageBuilder("msg-" + strconv.Itoa(i)).WithTimestamp(int64(i)).Build()
+		if err := wrapper.SaveMSG(msg); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+
+	// The recent endpoint must not touch the backing store
+	backing.GetErr = errors.New("backing store queried")
+
+	svc := messages.New(messages.Config{}, backing, wrapper)
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	got := getMessages(t, srv.URL+"/recent", "")
+	want := []string{"msg-5", "msg-4", "msg-3"}
+	if len(got.Messages) != len(want) {
+		t.Fatalf("expected %d messages, got %d", len(want), len(got.Messages))
+	}
+	for i, id := range want {
+		if got.Messages[i].MsgID != id {
+			t.Errorf("position %d: expected %q, got %q", i, id, got.Messages[i].MsgID)
+		}
+	}
+}
+
+func TestRecent_ResaveMovesToFront(t *testing.T) {
+	wrapper := store.NewStoreWrapper(testutil.NewMockMessageStore(), &store.NoOpDispatcher{}).WithRecentCache(3)
+
+	for _, id := range []string{"a", "b", "c"} {
+		if err := wrapper.SaveMSG(testutil.NewTestMessage(id)); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+	updated := testutil.NewMessageBuilder("a").WithStatus(store.StatusDelivered).Build()
+	if err := wrapper.SaveMSG(updated); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	recent := wrapper.Recent()
+	if len(recent) != 3 || recent[0].MsgID != "a" || recent[0].Status != store.StatusDelivered {
+		t.Errorf("expected updated message a first, got %+v", recent)
+	}
+}
+
+func TestRecent_RequiresAuth(t *testing.T) {
+	backing := testutil.NewMockMessageStore()
+	wrapper := store.NewStoreWrapper(backing, &store.NoOpDispatcher{})
+	svc := messages.New(messages.Config{AuthKey: "secret"}, backing, wrapper)
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/recent")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", resp.StatusCode)
+	}
+}
+
+// --- Thread Tests ---
+
+func TestThreads_RepliesGroupWithOriginal(t *testing.T) {
+	st := testutil.NewMockMessageStore()
+	for _, m := range []*store.Message{
+		threadMessage("orig", "Quarterly report", "alice@example.com", "bob@example.com", 100),
+		threadMessage("reply-1", "Re: Quarterly report", "bob@example.com", "alice@example.com", 200),
+		threadMessage("reply-2", "RE: Re: Quarterly report", "alice@example.com", "bob@example.com", 300),
+		threadMessage("other", "Lunch", "alice@example.com", "bob@example.com", 150),
+	} {
+		if err := st.SaveMSG(m); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+
+	svc := messages.New(messages.Config{}, st, store.NewStoreWrapper(st, &store.NoOpDispatcher{}))
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/threads")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	var got messages.ThreadsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(got.Threads) != 2 {
+		t.Fatalf("expected 2 threads, got %d", len(got.Threads))
+	}
+
+	// The report thread has the newest message so it comes first
+	report := got.Threads[0]
+	if report.Subject != "Quarterly report" {
+		t.Errorf("expected normalized subject, got %q", report.Subject)
+	}
+	want := []string{"orig", "reply-1", "reply-2"}
+	if len(report.Messages) != len(want) {
+		t.Fatalf("expected %d messages in thread, got %d", len(want), len(report.Messages))
+	}
+	for i, id := range want {
+		if report.Messages[i].MsgID != id {
+			t.Errorf("position %d: expected %q, got %q", i, id, report.Messages[i].MsgID)
+		}
+	}
+	if len(got.Threads[1].Messages) != 1 || got.Threads[1].Messages[0].MsgID != "other" {
+		t.Errorf("expected Lunch thread with one message, got %+v", got.Threads[1])
+	}
+}
+
+// --- Resend Tests ---
+
+func TestResend_FailedMessageIsDelivered(t *testing.T) {
+	backing := testutil.NewMockMessageStore()
+	events := testutil.NewRecordingDispatcher()
+	wrapper := store.NewStoreWrapper(backing, events)
+
+	failed := testutil.NewMessageBuilder("msg-1").
+		WithStatus(store.StatusBounce).
+		WithTextBody("hello").
+		Build()
+	failed.Reason = "dial tcp: connection refused"
+	if err := backing.SaveMSG(failed); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	host, port := testutil.StartSMTPServer(t)
+	mailSvc := sendmail.New(sendmail.Config{
+		SMTPServer:    host,
+		SMTPPort:      port,
+		AttachmentDir: t.TempDir(),
+	}, nil, wrapper)
+	t.Cleanup(func() { _ = mailSvc.Close() })
+
+	svc := messages.New(messages.Config{}, backing, wrapper).WithResender(mailSvc)
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/msg-1/resend", "application/json", nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	var got store.Message
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if got.MsgID != "msg-1" || got.Status != store.StatusDelivered || got.Reason != "" {
+		t.Errorf("expected msg-1 delivered with no reason, got %+v", got)
+	}
+
+	stored, _ := backing.GetMSG(store.GetQuery{ID: "msg-1"})
+	if len(stored) != 1 || stored[0].Status != store.StatusDelivered {
+		t.Errorf("expected stored status delivered, got %+v", stored)
+	}
+
+	dispatched := events.Events()
+	if len(dispatched) != 1 || dispatched[0].MsgID != "msg-1" || dispatched[0].Status != string(store.StatusDelivered) {
+		t.Errorf("expected one delivered event for msg-1, got %+v", dispatched)
+	}
+}
+
+func TestResend_UnknownMessage_Returns404(t *testing.T) {
+	backing := testutil.NewMockMessageStore()
+	mailSvc := sendmail.New(sendmail.Config{}, nil, backing)
+	t.Cleanup(func() { _ = mailSvc.Close() })
+	svc := messages.New(messages.Config{}, backing, store.NewStoreWrapper(backing, &store.NoOpDispatcher{})).
+		WithResender(mailSvc)
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/missing/resend", "application/json", nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", resp.StatusCode)
+	}
+}
+
+// --- Test Helpers ---
+
+// threadMessage builds a message with its thread key set as the send path would.
+func threadMessage(id, subject, from, to string, ts int64) *store.Message {
+	msg := testutil.NewMessageBuilder(id).
+		WithSubject(subject).
+		WithFrom(from).
+		WithTo(to).
+		WithTimestamp(ts).
+		Build()
+	msg.ThreadKey = store.ThreadKey(subject, from, to)
+	return msg
+}
+
+// buildServiceMux applies the service's middleware chain to the mux.
+func buildServiceMux(svc *messages.Service) *http.ServeMux {
+	wrappedMux := http.NewServeMux()
+	wrappedMux.Handle("/", svc.Chain()(svc.GetMux()))
+	return wrappedMux
+}
+
+func messageIDs(msgs []*store.Message) []string {
+	ids := make([]string, len(msgs))
+	for i, msg := range msgs {
+		ids[i] = msg.MsgID
+	}
+	return ids
+}
+
+func getEngagement(t *testing.T, url string) messages.Engagement {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var e messages.Engagement
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return e
+}
+
+func getMessages(t *testing.T, url, authHeader string) messages.ListResponse {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	if authHeader != "" {
+		req.Header.Set("Authorization", authHeader)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var lr messages.ListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&lr); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return lr
+}
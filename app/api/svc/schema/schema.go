@@ -0,0 +1,80 @@
+package schema
+
+import (
+	"reflect"
+	"strings"
+)
+
+// draft is the JSON Schema dialect of generated schemas.
+const draft = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is the subset of JSON Schema needed to describe API types.
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Type                 string             `json:"type"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+}
+
+// Generate builds the schema of v's type from its json struct tags. Fields
+// tagged omitempty are optional, the others required. enums lists the
+// allowed values of string types such as store.MessageStatus.
+func Generate(v any, enums map[reflect.Type][]string) *Schema {
+	t := reflect.TypeOf(v)
+	s := generate(t, enums)
+	s.Schema = draft
+	s.Title = t.Name()
+	return s
+}
+
+func generate(t reflect.Type, enums map[reflect.Type][]string) *Schema {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string", Enum: enums[t]}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: generate(t.Elem(), enums)}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: generate(t.Elem(), enums)}
+	case reflect.Struct:
+		return generateStruct(t, enums)
+	default:
+		return &Schema{}
+	}
+}
+
+// generateStruct describes each exported field under its json name.
+func generateStruct(t reflect.Type, enums map[reflect.Type][]string) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = generate(f.Type, enums)
+		if !strings.Contains(opts, "omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+	return s
+}
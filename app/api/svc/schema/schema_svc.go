@@ -0,0 +1,49 @@
+// Package schema serves JSON Schemas of the API's response types.
+package schema
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"reflect"
+
+	"github.com/mustur/mockgrid/app/api/store"
+)
+
+// Config holds configuration for the schema service.
+type Config struct {
+	AuthKey   string
+	AccessLog *slog.Logger // logs each request; nil means slog.Default()
+}
+
+// Service serves JSON Schemas generated from the stored types.
+type Service struct {
+	authKey   string
+	accessLog *slog.Logger
+	message   *Schema
+}
+
+// New creates a new schema service.
+func New(cfg Config) *Service {
+	statuses := make([]string, len(store.Statuses))
+	for i, st := range store.Statuses {
+		statuses[i] = string(st)
+	}
+	return &Service{
+		authKey:   cfg.AuthKey,
+		accessLog: cfg.AccessLog,
+		message: Generate(store.Message{}, map[reflect.Type][]string{
+			reflect.TypeFor[store.MessageStatus](): statuses,
+		}),
+	}
+}
+
+// handleMessage processes GET /v3/schema/message, describing the messages
+// returned by /v3/messages.
+func (s *Service) handleMessage(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(s.message); err != nil {
+		slog.Error("failed to encode response", "err", err)
+	}
+}
@@ -0,0 +1,99 @@
+package schema_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/mustur/mockgrid/app/api/store"
+	"github.com/mustur/mockgrid/app/api/svc/schema"
+)
+
+// --- Message Schema Tests ---
+
+func TestMessage_StatusEnumListsKnownStatuses(t *testing.T) {
+	got := getMessageSchema(t)
+
+	status, ok := got.Properties["status"]
+	if !ok {
+		t.Fatal("expected a status property")
+	}
+	if status.Type != "string" {
+		t.Errorf("expected status to be a string, got %q", status.Type)
+	}
+	want := make([]string, len(store.Statuses))
+	for i, st := range store.Statuses {
+		want[i] = string(st)
+	}
+	if !slices.Equal(status.Enum, want) {
+		t.Errorf("expected status enum %v, got %v", want, status.Enum)
+	}
+	if !slices.Contains(got.Required, "status") {
+		t.Errorf("expected status to be required, got %v", got.Required)
+	}
+}
+
+func TestMessage_FieldsFollowJSONTags(t *testing.T) {
+	got := getMessageSchema(t)
+
+	if got.Title != "Message" || got.Type != "object" {
+		t.Errorf("expected an object titled Message, got %q %q", got.Title, got.Type)
+	}
+	for name, typ := range map[string]string{
+		"msg_id":          "string",
+		"timestamp":       "integer",
+		"opens_count":     "integer",
+		"attachments":     "array",
+		"request_headers": "object",
+	} {
+		p, ok := got.Properties[name]
+		if !ok {
+			t.Errorf("expected property %q", name)
+			continue
+		}
+		if p.Type != typ {
+			t.Errorf("%s: expected type %q, got %q", name, typ, p.Type)
+		}
+	}
+	if slices.Contains(got.Required, "html_body") {
+		t.Error("expected omitempty field html_body to be optional")
+	}
+	if att := got.Properties["attachments"].Items; att == nil || att.Properties["size"] == nil {
+		t.Errorf("expected attachments items to describe AttachmentMeta, got %+v", att)
+	}
+	if h := got.Properties["request_headers"].AdditionalProperties; h == nil || h.Type != "string" {
+		t.Errorf("expected request_headers values to be strings, got %+v", h)
+	}
+}
+
+// --- Test Helpers ---
+
+func buildServiceMux(svc *schema.Service) *http.ServeMux {
+	wrappedMux := http.NewServeMux()
+	wrappedMux.Handle("/", svc.Chain()(svc.GetMux()))
+	return wrappedMux
+}
+
+// getMessageSchema fetches /message and decodes the 200 response.
+func getMessageSchema(t *testing.T) schema.Schema {
+	t.Helper()
+	srv := httptest.NewServer(buildServiceMux(schema.New(schema.Config{})))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/message")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	var got schema.Schema
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return got
+}
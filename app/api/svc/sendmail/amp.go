@@ -0,0 +1,267 @@
+package sendmail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"slices"
+
+	"github.com/jordan-wright/email"
+)
+
+// ampContentType is SendGrid's content type for AMP for Email bodies.
+const ampContentType = "text/x-amp-html"
+
+// ampEmail is an email with an optional AMP body. The email package only
+// knows text and HTML alternatives and always writes attachments as base64,
+// so a message with an AMP body or a quoted-printable attachment is built
+// here part by part. Any other message is left to the email package.
+type ampEmail struct {
+	*email.Email
+	AMP []byte
+}
+
+// partWriter starts a MIME part with header and returns the writer for its
+// body, like multipart.Writer.CreatePart.
+type partWriter func(header textproto.MIMEHeader) (io.Writer, error)
+
+// custom reports whether the message needs more than the email package can
+// write.
+func (a *ampEmail) custom() bool {
+	return len(a.AMP) > 0 || hasQuotedPrintable(a.Attachments)
+}
+
+// Bytes renders the message, adding the AMP alternative when present and
+// writing each attachment in its content transfer encoding.
+func (a *ampEmail) Bytes() ([]byte, error) {
+	if !a.custom() {
+		return a.Email.Bytes()
+	}
+	header, err := messageHeader(a.Email)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	err = a.writeMessage(func(content textproto.MIMEHeader) (io.Writer, error) {
+		for k, v := range content {
+			header[k] = v
+		}
+		writeHeader(&buf, header)
+		return &buf, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Send delivers the message like email.Email.Send. A message the email
+// package cannot write goes out with the same envelope and the bytes of Bytes.
+func (a *ampEmail) Send(addr string, auth smtp.Auth) error {
+	if !a.custom() {
+		return a.Email.Send(addr, auth)
+	}
+	from, to, err := envelope(a.Email)
+	if err != nil {
+		return err
+	}
+	raw, err := a.Bytes()
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(addr, auth, from, to, raw)
+}
+
+// writeMessage writes the body, inside multipart/mixed followed by the
+// attachments when there are any besides those related to the HTML body.
+// AMP clients require an HTML fallback, so one is derived from the text
+// body when the message has none.
+func (a *ampEmail) writeMessage(create partWriter) error {
+	if len(a.AMP) > 0 {
+		ensureHTMLBody(a.Email)
+	}
+	var related, mixed []*email.Attachment
+	for _, att := range a.Attachments {
+		if att.HTMLRelated {
+			related = append(related, att)
+		} else {
+			mixed = append(mixed, att)
+		}
+	}
+	if len(related) > 0 && len(a.HTML) == 0 {
+		return errors.New("there are HTML attachments, but no HTML body")
+	}
+
+	if len(mixed) == 0 {
+		return a.writeBody(create, related)
+	}
+	return writeMultipart(create, "multipart/mixed", func(mw *multipart.Writer) error {
+		if err := a.writeBody(mw.CreatePart, related); err != nil {
+			return err
+		}
+		for _, att := range mixed {
+			if err := writeAttachment(mw.CreatePart, att); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// writeBody writes the text, AMP and HTML bodies, as alternatives in that
+// order when there is more than one. A message without any body gets an
+// empty text part, as the email package writes it.
+func (a *ampEmail) writeBody(create partWriter, related []*email.Attachment) error {
+	var alts []func(partWriter) error
+	if len(a.Text) > 0 || len(a.HTML) == 0 {
+		alts = append(alts, func(create partWriter) error {
+			return writeText(create, "text/plain", a.Text)
+		})
+	}
+	if len(a.AMP) > 0 {
+		alts = append(alts, func(create partWriter) error {
+			return writeText(create, ampContentType, a.AMP)
+		})
+	}
+	if len(a.HTML) > 0 {
+		alts = append(alts, func(create partWriter) error {
+			return writeHTML(create, a.HTML, related)
+		})
+	}
+
+	if len(alts) == 1 {
+		return alts[0](create)
+	}
+	return writeMultipart(create, "multipart/alternative", func(mw *multipart.Writer) error {
+		for _, alt := range alts {
+			if err := alt(mw.CreatePart); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// writeHTML writes the HTML body, inside multipart/related followed by the
+// related attachments when there are any.
+func writeHTML(create partWriter, html []byte, related []*email.Attachment) error {
+	if len(related) == 0 {
+		return writeText(create, "text/html", html)
+	}
+	return writeMultipart(create, "multipart/related", func(mw *multipart.Writer) error {
+		if err := writeText(mw.CreatePart, "text/html", html); err != nil {
+			return err
+		}
+		for _, att := range related {
+			if err := writeAttachment(mw.CreatePart, att); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// writeText writes body as a quoted-printable UTF-8 part of mediaType.
+func writeText(create partWriter, mediaType string, body []byte) error {
+	w, err := create(textproto.MIMEHeader{
+		"Content-Type":              {mediaType + "; charset=UTF-8"},
+		"Content-Transfer-Encoding": {encodingQuotedPrintable},
+	})
+	if err != nil {
+		return err
+	}
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write(body); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// writeMultipart writes a part of the multipart mediaType whose own parts
+// are written by parts.
+func writeMultipart(create partWriter, mediaType string, parts func(*multipart.Writer) error) error {
+	// The boundary goes into the header, before the writer for the body
+	// exists
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+	w, err := create(textproto.MIMEHeader{
+		"Content-Type": {mime.FormatMediaType(mediaType, map[string]string{"boundary": boundary})},
+	})
+	if err != nil {
+		return err
+	}
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return err
+	}
+	if err := parts(mw); err != nil {
+		return err
+	}
+	return mw.Close()
+}
+
+// messageHeader returns the top-level header the email package writes for
+// e, such as From, Subject, Date and Message-Id, without its content headers.
+func messageHeader(e *email.Email) (textproto.MIMEHeader, error) {
+	bare := *e
+	bare.Text, bare.HTML, bare.Attachments = nil, nil, nil
+	raw, err := bare.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	header := textproto.MIMEHeader(msg.Header)
+	header.Del("Content-Type")
+	header.Del("Content-Transfer-Encoding")
+	return header, nil
+}
+
+// writeHeader writes header in a stable order, followed by the blank line
+// that ends it. The values are written as they are: those from
+// messageHeader are already encoded.
+func writeHeader(w *bytes.Buffer, header textproto.MIMEHeader) {
+	for _, k := range slices.Sorted(maps.Keys(header)) {
+		for _, v := range header[k] {
+			w.WriteString(k + ": " + v + "\r\n")
+		}
+	}
+	w.WriteString("\r\n")
+}
+
+// envelope returns the SMTP envelope of e the way email.Email.Send builds
+// it: the Sender address, or the From address without one, and the
+// addresses of every To, Cc and Bcc recipient.
+func envelope(e *email.Email) (from string, to []string, err error) {
+	for _, list := range [][]string{e.To, e.Cc, e.Bcc} {
+		for _, s := range list {
+			rcpt, err := mail.ParseAddress(s)
+			if err != nil {
+				return "", nil, fmt.Errorf("parse recipient %q: %w", s, err)
+			}
+			to = append(to, rcpt.Address)
+		}
+	}
+	if len(to) == 0 {
+		return "", nil, errors.New("must specify at least one To address")
+	}
+
+	from = e.From
+	if e.Sender != "" {
+		from = e.Sender
+	}
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return "", nil, fmt.Errorf("parse sender %q: %w", from, err)
+	}
+	return sender.Address, to, nil
+}
@@ -0,0 +1,104 @@
+package sendmail
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mustur/mockgrid/app/api/objects"
+)
+
+func TestAMPEmail_ThreeAlternativesInOrder(t *testing.T) {
+	parts := buildAlternatives(t, []objects.Content{
+		{Type: "text/html", Value: "<p>Hello</p>"},
+		{Type: ampContentType, Value: "<html amp4email><body>Hello</body></html>"},
+		{Type: "text/plain", Value: "Hello"},
+	})
+
+	want := []string{"text/plain", ampContentType, "text/html"}
+	if got := partTypes(parts); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected parts %v, got %v", want, got)
+	}
+	if parts[1].body != "<html amp4email><body>Hello</body></html>" {
+		t.Errorf("unexpected AMP body %q", parts[1].body)
+	}
+}
+
+func TestAMPEmail_WithoutText(t *testing.T) {
+	parts := buildAlternatives(t, []objects.Content{
+		{Type: "text/html", Value: "<p>Hello</p>"},
+		{Type: ampContentType, Value: "<html amp4email><body>Hello</body></html>"},
+	})
+
+	want := []string{ampContentType, "text/html"}
+	if got := partTypes(parts); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected parts %v, got %v", want, got)
+	}
+}
+
+// --- Test Helpers ---
+
+type mimePart struct {
+	contentType string
+	body        string
+}
+
+// buildAlternatives builds the email for content and returns the parts of
+// its multipart/alternative body.
+func buildAlternatives(t *testing.T, content []objects.Content) []mimePart {
+	t.Helper()
+	pr := &objects.PostRequest{
+		From:    objects.EmailAddress{Email: "sender@example.com"},
+		Subject: "AMP",
+		Content: content,
+	}
+	p := objects.Personalization{To: []objects.EmailAddress{{Email: "to@example.com"}}}
+	m := mergePersonalization(pr, p, nil)
+
+	svc := New(Config{}, nil, nil)
+	raw, err := (&ampEmail{Email: svc.buildEmail(pr, p, m), AMP: []byte(m.AMP)}).Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("failed to parse message: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("expected multipart/alternative, got %q (%v)", mediaType, err)
+	}
+
+	var parts []mimePart
+	r := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read part: %v", err)
+		}
+		body, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("failed to read part body: %v", err)
+		}
+		ct, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		parts = append(parts, mimePart{contentType: ct, body: strings.TrimSpace(string(body))})
+	}
+	return parts
+}
+
+func partTypes(parts []mimePart) []string {
+	types := make([]string, len(parts))
+	for i, p := range parts {
+		types[i] = p.contentType
+	}
+	return types
+}
@@ -0,0 +1,277 @@
+package sendmail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jordan-wright/email"
+	"github.com/mustur/mockgrid/app/api/objects"
+)
+
+func TestSaveAttachment_StreamsLargeContent(t *testing.T) {
+	data := make([]byte, 3<<20) // 3 MiB
+	if _, err := rand.Read(data); err != nil {
+		t.Fatalf("failed to generate content: %v", err)
+	}
+	svc := New(Config{AttachmentDir: t.TempDir(), MaxAttachmentBytes: 4 << 20}, nil, nil)
+
+	dir, err := svc.saveAttachment("blob.bin", base64.StdEncoding.EncodeToString(data))
+	if err != nil {
+		t.Fatalf("saveAttachment failed: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "blob.bin"))
+	if err != nil {
+		t.Fatalf("failed to read saved attachment: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("saved content differs from the original (%d vs %d bytes)", len(got), len(data))
+	}
+}
+
+func TestSaveAttachment_SizeGuardAbortsMidStream(t *testing.T) {
+	const limit = 1024
+	root := t.TempDir()
+	svc := New(Config{AttachmentDir: root, MaxAttachmentBytes: limit}, nil, nil)
+
+	content := base64.StdEncoding.EncodeToString(make([]byte, 1<<20))
+	_, err := svc.saveAttachment("big.bin", content)
+	if !errors.Is(err, errAttachmentTooLarge) {
+		t.Fatalf("expected errAttachmentTooLarge, got %v", err)
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatalf("failed to read attachment dir: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected partial attachment to be removed, found %d entries", len(entries))
+	}
+}
+
+func TestSaveAttachment_InvalidBase64LeavesNothing(t *testing.T) {
+	root := t.TempDir()
+	svc := New(Config{AttachmentDir: root}, nil, nil)
+
+	if _, err := svc.saveAttachment("bad.bin", "QUJD!!!!"); err == nil {
+		t.Fatal("expected decode error")
+	}
+	if entries, _ := os.ReadDir(root); len(entries) != 0 {
+		t.Errorf("expected no files after a decode error, found %d entries", len(entries))
+	}
+}
+
+func TestAttachFiles_QuotedPrintableEncoding(t *testing.T) {
+	text := "Grüße aus München\r\nline two"
+	svc := New(Config{AttachmentDir: t.TempDir()}, nil, nil)
+	pr := &objects.PostRequest{
+		From:    objects.EmailAddress{Email: "sender@example.com"},
+		Subject: "Attachment",
+		Content: []objects.Content{{Type: "text/plain", Value: "See attached"}},
+	}
+	p := objects.Personalization{To: []objects.EmailAddress{{Email: "to@example.com"}}}
+	e := svc.buildEmail(pr, p, mergePersonalization(pr, p, nil))
+
+	res := svc.attachFiles(e, []objects.Attachment{
+		{Content: base64.StdEncoding.EncodeToString([]byte(text)), Type: "text/plain", Filename: "notes.txt", Encoding: "quoted-printable"},
+		{Content: base64.StdEncoding.EncodeToString([]byte(text)), Type: "text/plain", Filename: "copy.txt"},
+	})
+	if !res.OK() {
+		t.Fatalf("attachFiles failed: %+v", res)
+	}
+	raw, err := (&ampEmail{Email: e}).Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+
+	parts := attachmentParts(t, raw)
+	if got := parts["notes.txt"].header.Get("Content-Transfer-Encoding"); got != "quoted-printable" {
+		t.Errorf("expected notes.txt to be quoted-printable, got %q", got)
+	}
+	body, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(parts["notes.txt"].body)))
+	if err != nil || string(body) != text {
+		t.Errorf("expected decoded body %q, got %q (%v)", text, body, err)
+	}
+	if got := parts["copy.txt"].header.Get("Content-Transfer-Encoding"); got != "base64" {
+		t.Errorf("expected copy.txt to stay base64, got %q", got)
+	}
+}
+
+func TestAttachFiles_QuotedPrintableWithAMPAndInlinePart(t *testing.T) {
+	text := "Grüße aus München"
+	svc := New(Config{AttachmentDir: t.TempDir()}, nil, nil)
+	pr := &objects.PostRequest{
+		From:    objects.EmailAddress{Email: "sender@example.com"},
+		Subject: "Attachment",
+		Content: []objects.Content{
+			{Type: "text/html", Value: `<p><img src="cid:logo"></p>`},
+			{Type: ampContentType, Value: "<html amp4email><body>Hello</body></html>"},
+		},
+	}
+	p := objects.Personalization{To: []objects.EmailAddress{{Email: "to@example.com"}}}
+	m := mergePersonalization(pr, p, nil)
+	e := svc.buildEmail(pr, p, m)
+
+	res := svc.attachFiles(e, []objects.Attachment{
+		{Content: base64.StdEncoding.EncodeToString([]byte(text)), Type: "text/plain", Filename: "logo.txt", Disposition: "inline", ContentId: "logo", Encoding: "quoted-printable"},
+		{Content: base64.StdEncoding.EncodeToString([]byte(text)), Type: "text/plain", Filename: "notes.txt", Encoding: "quoted-printable"},
+	})
+	if !res.OK() {
+		t.Fatalf("attachFiles failed: %+v", res)
+	}
+	raw, err := (&ampEmail{Email: e, AMP: []byte(m.AMP)}).Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+
+	parts := attachmentParts(t, raw)
+	body, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(parts["notes.txt"].body)))
+	if err != nil || string(body) != text {
+		t.Errorf("expected decoded notes.txt %q, got %q (%v)", text, body, err)
+	}
+	parent, header := findPart(t, raw, "<logo>")
+	if parent != "multipart/related" || header.Get("Content-Transfer-Encoding") != "quoted-printable" {
+		t.Errorf("expected a quoted-printable part in multipart/related, got %q in %q", header.Get("Content-Transfer-Encoding"), parent)
+	}
+	if !strings.Contains(string(raw), "Content-Type: "+ampContentType) {
+		t.Errorf("expected an AMP part in:\n%s", raw)
+	}
+}
+
+func TestAttachFiles_RejectsUnknownEncoding(t *testing.T) {
+	svc := New(Config{AttachmentDir: t.TempDir()}, nil, nil)
+	res := svc.attachFiles(email.NewEmail(), []objects.Attachment{
+		{Content: "aGVsbG8=", Filename: "a.txt", Encoding: "7bit"},
+	})
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", res.StatusCode)
+	}
+}
+
+func TestAttachFiles_InlineImageEmbeddedWithContentID(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\nfake image")
+	svc := New(Config{AttachmentDir: t.TempDir()}, nil, nil)
+	pr := &objects.PostRequest{
+		From:    objects.EmailAddress{Email: "sender@example.com"},
+		Subject: "Inline",
+		Content: []objects.Content{{Type: "text/html", Value: `<p><img src="cid:logo"></p>`}},
+	}
+	p := objects.Personalization{To: []objects.EmailAddress{{Email: "to@example.com"}}}
+	e := svc.buildEmail(pr, p, mergePersonalization(pr, p, nil))
+
+	res := svc.attachFiles(e, []objects.Attachment{
+		{Content: base64.StdEncoding.EncodeToString(png), Type: "image/png", Filename: "logo.png", Disposition: "inline", ContentId: "logo"},
+		{Content: "aGVsbG8=", Type: "text/plain", Filename: "notes.txt"},
+	})
+	if !res.OK() {
+		t.Fatalf("attachFiles failed: %+v", res)
+	}
+	raw, err := (&ampEmail{Email: e}).Bytes()
+	if err != nil {
+		t.Fatalf("Bytes failed: %v", err)
+	}
+
+	parent, header := findPart(t, raw, "<logo>")
+	if header == nil {
+		t.Fatalf("no part with Content-ID <logo> in:\n%s", raw)
+	}
+	if parent != "multipart/related" {
+		t.Errorf("expected the image inside multipart/related, got %q", parent)
+	}
+	if d, _, _ := mime.ParseMediaType(header.Get("Content-Disposition")); d != "inline" {
+		t.Errorf("expected inline disposition, got %q", header.Get("Content-Disposition"))
+	}
+	if ct := header.Get("Content-Type"); ct != "image/png" {
+		t.Errorf("expected image/png, got %q", ct)
+	}
+	if parent, _ := findPart(t, raw, "<notes.txt>"); parent != "multipart/mixed" {
+		t.Errorf("expected the regular attachment in multipart/mixed, got %q", parent)
+	}
+}
+
+// --- Test Helpers ---
+
+type rawPart struct {
+	header textproto.MIMEHeader
+	body   string
+}
+
+// attachmentParts returns the undecoded parts of a multipart/mixed message,
+// keyed by attachment filename.
+func attachmentParts(t *testing.T, raw []byte) map[string]rawPart {
+	t.Helper()
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("failed to parse message: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("expected multipart/mixed, got %q (%v)", mediaType, err)
+	}
+
+	parts := make(map[string]rawPart)
+	r := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		// NextRawPart keeps the transfer encoding that NextPart would hide
+		part, err := r.NextRawPart()
+		if err == io.EOF {
+			return parts
+		}
+		if err != nil {
+			t.Fatalf("failed to read part: %v", err)
+		}
+		body, _ := io.ReadAll(part)
+		if name := part.FileName(); name != "" {
+			parts[name] = rawPart{header: part.Header, body: string(body)}
+		}
+	}
+}
+
+// findPart searches the MIME tree of raw for the part with the given
+// Content-ID, returning the media type of its enclosing multipart and its
+// header. The header is nil when no part matches.
+func findPart(t *testing.T, raw []byte, contentID string) (string, textproto.MIMEHeader) {
+	t.Helper()
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("failed to parse message: %v", err)
+	}
+	return searchParts(t, textproto.MIMEHeader(msg.Header), msg.Body, contentID)
+}
+
+func searchParts(t *testing.T, header textproto.MIMEHeader, body io.Reader, contentID string) (string, textproto.MIMEHeader) {
+	t.Helper()
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return "", nil
+	}
+	r := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := r.NextRawPart()
+		if err == io.EOF {
+			return "", nil
+		}
+		if err != nil {
+			t.Fatalf("failed to read part: %v", err)
+		}
+		if part.Header.Get("Content-ID") == contentID {
+			return mediaType, part.Header
+		}
+		if parent, found := searchParts(t, part.Header, part, contentID); found != nil {
+			return parent, found
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package sendmail
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"html"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/jordan-wright/email"
+	"github.com/mustur/mockgrid/app/api/objects"
+	"github.com/mustur/mockgrid/app/api/store"
+	"github.com/mustur/mockgrid/internal/httpjson"
+)
+
+// anchorHref matches the quoted href attribute of an <a> tag, capturing
+// everything up to the value and the value itself, double or single quoted.
+var anchorHref = regexp.MustCompile(`(?i)(<a\b[^>]*?\bhref\s*=\s*)(?:"([^"]*)"|'([^']*)')`)
+
+// rewriteLinks points every http(s) link in body at the click tracking
+// endpoint, carrying the original URL, message ID and recipient signed with
+// key. mailto:, in-page anchors and other schemes are left alone, as are
+// links that already go through the tracking endpoints.
+func rewriteLinks(body, base string, key []byte, msgID, to string) string {
+	return anchorHref.ReplaceAllStringFunc(body, func(tag string) string {
+		m := anchorHref.FindStringSubmatch(tag)
+		quote, value := `"`, m[2]
+		if strings.HasSuffix(tag, "'") {
+			quote, value = "'", m[3]
+		}
+		target := html.UnescapeString(strings.TrimSpace(value))
+		if !isTrackableLink(target, base) {
+			return tag
+		}
+		return m[1] + quote + html.EscapeString(buildClickURL(base, key, target, msgID, to)) + quote
+	})
+}
+
+// isTrackableLink reports whether target is an absolute http(s) URL outside
+// the tracking endpoints.
+func isTrackableLink(target, base string) bool {
+	return isHTTPURL(target) && !strings.HasPrefix(target, base+"/v3/mail/track/")
+}
+
+// isHTTPURL reports whether target is an absolute http or https URL.
+func isHTTPURL(target string) bool {
+	u, err := url.Parse(target)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// buildClickURL constructs the click tracking URL for target, signed with
+// key so the endpoint only redirects to links it wrote itself.
+func buildClickURL(base string, key []byte, target, msgID, to string) string {
+	vals := url.Values{}
+	vals.Set("url", target)
+	vals.Set("msg_id", msgID)
+	vals.Set("to", to)
+	vals.Set("sig", clickSignature(key, target, msgID, to))
+	return base + "/v3/mail/track/click?" + vals.Encode()
+}
+
+// clickSignature returns the hex HMAC-SHA256 of a tracked link's URL,
+// message ID and recipient. The fields are NUL-separated, which none of
+// them can contain, so no other tuple signs the same.
+func clickSignature(key []byte, target, msgID, to string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(target + "\x00" + msgID + "\x00" + to))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// defaultClickKey derives the key signing tracked links when no secret is
+// configured. It depends only on authKey, so links sent before a restart
+// still redirect; without an auth key it is a fixed, public key.
+func defaultClickKey(authKey string) []byte {
+	mac := hmac.New(sha256.New, []byte(authKey))
+	mac.Write([]byte("mockgrid click tracking"))
+	return mac.Sum(nil)
+}
+
+// trackClicks rewrites the links in the email's HTML body for click tracking.
+// The recipients of a personalization share one body, so clicks are
+// attributed to msgID, the message of its first recipient. The request's
+// setting ct overrides the global click tracking setting.
+func (s *Service) trackClicks(e *email.Email, ct objects.TrackingSetting, msgID, to string) {
+	if !enabled(ct.Enable, s.clickTracking) || len(e.HTML) == 0 {
+		return
+	}
+	e.HTML = []byte(rewriteLinks(string(e.HTML), s.trackingBaseURL(), s.clickKey, msgID, to))
+}
+
+// handleTrackClick records a click on the message and redirects to the
+// original link. Only links signed by buildClickURL are followed, so the
+// endpoint cannot be used as an open redirect.
+func (s *Service) handleTrackClick(w http.ResponseWriter, r *http.Request) {
+	qry := r.URL.Query()
+	target := qry.Get("url")
+	if !isHTTPURL(target) {
+		httpjson.Write(w, http.StatusBadRequest, objects.GetErrorResponse("url must be an absolute http or https URL", "url", nil))
+		return
+	}
+	msgID, to := qry.Get("msg_id"), qry.Get("to")
+	want := clickSignature(s.clickKey, target, msgID, to)
+	if !hmac.Equal([]byte(qry.Get("sig")), []byte(want)) {
+		httpjson.Write(w, http.StatusBadRequest, objects.GetErrorResponse("missing or invalid link signature", "sig", nil))
+		return
+	}
+
+	slog.Info("email click tracked", "msg_id", msgID, "to", to, "url", target)
+	if err := s.recordEvent(msgID, func(msg *store.Message) { msg.ClicksCount++ }); err != nil {
+		// The reader still reaches the link when the click cannot be stored
+		slog.Warn("failed to record click", "msg_id", msgID, "err", err)
+	}
+
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	http.Redirect(w, r, target, http.StatusFound)
+}
@@ -0,0 +1,75 @@
+package sendmail
+
+import (
+	"errors"
+	"net"
+	"net/textproto"
+	"regexp"
+	"strconv"
+
+	"github.com/mustur/mockgrid/app/api/store"
+	"github.com/mustur/mockgrid/internal/metrics"
+)
+
+// smtpReplyCode matches the reply code that starts an SMTP status line, as
+// in "550 5.1.1 User unknown" or the "452-4.2.2 ..." line of a multiline
+// reply.
+var smtpReplyCode = regexp.MustCompile(`(?m)^([2-5][0-9]{2})(?:[ -]|$)`)
+
+// deferredCodes are temporary failures recorded as deferred rather than
+// blocked: the server asks to retry later instead of refusing the message.
+var deferredCodes = map[int]bool{
+	421: true, // service not available, e.g. too many connections
+}
+
+// classifyDeliveryResult determines the message status based on SMTP response
+// and counts the outcome in the emails metric.
+func classifyDeliveryResult(err error) (store.MessageStatus, string) {
+	status, reason := deliveryStatus(err)
+	metrics.Emails.WithLabelValues(string(status)).Inc()
+	return status, reason
+}
+
+// deliveryStatus maps an SMTP send error to a message status and reason.
+// Errors carrying an SMTP reply are classified by its code: 5xx is a bounce
+// and 4xx is blocked, except for deferredCodes. Otherwise a server that could
+// not be reached is deferred, and anything else bounces.
+func deliveryStatus(err error) (store.MessageStatus, string) {
+	if err == nil {
+		return store.StatusDelivered, ""
+	}
+
+	errStr := err.Error()
+	if code, ok := replyCode(err); ok {
+		switch {
+		case deferredCodes[code]:
+			return store.StatusDeferred, errStr
+		case code >= 500:
+			return store.StatusBounce, errStr
+		case code >= 400:
+			return store.StatusBlocked, errStr
+		}
+	}
+
+	// Dial failures, resets and timeouts
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return store.StatusDeferred, errStr
+	}
+
+	return store.StatusBounce, errStr
+}
+
+// replyCode returns the SMTP reply code carried by err, if any.
+func replyCode(err error) (int, bool) {
+	var tpErr *textproto.Error
+	if errors.As(err, &tpErr) {
+		return tpErr.Code, true
+	}
+	m := smtpReplyCode.FindStringSubmatch(err.Error())
+	if m == nil {
+		return 0, false
+	}
+	code, _ := strconv.Atoi(m[1]) // three digits always parse
+	return code, true
+}
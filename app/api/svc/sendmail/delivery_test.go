@@ -0,0 +1,47 @@
+package sendmail
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/mustur/mockgrid/app/api/store"
+)
+
+func TestDeliveryStatus_ClassifiesByReplyCode(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want store.MessageStatus
+	}{
+		{"nil", nil, store.StatusDelivered},
+		{"user unknown", &textproto.Error{Code: 550, Msg: "5.1.1 <bob@example.com>: Recipient address rejected: User unknown"}, store.StatusBounce},
+		{"code outside the old list", &textproto.Error{Code: 521, Msg: "5.3.2 mx.example.com does not accept mail"}, store.StatusBounce},
+		{"mailbox full", &textproto.Error{Code: 452, Msg: "4.2.2 The email account that you tried to reach is over quota"}, store.StatusBlocked},
+		{"greylisted", &textproto.Error{Code: 451, Msg: "4.7.1 Greylisting in action, please come back later"}, store.StatusBlocked},
+		{"code outside the old list, temporary", &textproto.Error{Code: 447, Msg: "4.4.7 Message delayed"}, store.StatusBlocked},
+		{"too many connections", &textproto.Error{Code: 421, Msg: "4.7.0 mx.example.com: too many connections from 10.0.55.0"}, store.StatusDeferred},
+		{"multiline reply", &textproto.Error{Code: 452, Msg: "4.2.2 The email account that you tried to reach is over quota.\n4.2.2 Please direct the recipient to https://support.example.com/550"}, store.StatusBlocked},
+		{"reply as text", errors.New("554 5.7.1 Service unavailable; Client host [10.1.2.3] blocked"), store.StatusBounce},
+		{"multiline reply as text", errors.New("452-4.2.2 The email account is over quota\n452 4.2.2 Try again later"), store.StatusBlocked},
+		{"550 in a byte count", errors.New("message size 5504 bytes exceeds the limit"), store.StatusBounce},
+		{"550 in a port", &net.OpError{Op: "dial", Net: "tcp", Addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 35501}, Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, store.StatusDeferred},
+		{"timeout", fmt.Errorf("send: %w", &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}), store.StatusDeferred},
+		{"unknown", errors.New("smtp: server doesn't support AUTH"), store.StatusBounce},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, reason := deliveryStatus(tc.err)
+			if got != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+			if tc.err != nil && reason != tc.err.Error() {
+				t.Errorf("expected the error as reason, got %q", reason)
+			}
+		})
+	}
+}
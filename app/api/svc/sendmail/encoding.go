@@ -0,0 +1,89 @@
+package sendmail
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/textproto"
+
+	"github.com/jordan-wright/email"
+)
+
+// Attachment content transfer encodings accepted in attachments[].encoding.
+const (
+	encodingBase64          = "base64"
+	encodingQuotedPrintable = "quoted-printable"
+)
+
+// isQuotedPrintable reports whether att is marked for quoted-printable.
+func isQuotedPrintable(att *email.Attachment) bool {
+	return att.Header.Get("Content-Transfer-Encoding") == encodingQuotedPrintable
+}
+
+// hasQuotedPrintable reports whether any attachment is marked for
+// quoted-printable, which the email package cannot write.
+func hasQuotedPrintable(atts []*email.Attachment) bool {
+	for _, att := range atts {
+		if isQuotedPrintable(att) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeAttachment writes att as a part in its content transfer encoding,
+// base64 unless it is marked quoted-printable. Headers att leaves unset get
+// the email package's defaults.
+func writeAttachment(create partWriter, att *email.Attachment) error {
+	header := make(textproto.MIMEHeader, len(att.Header)+4)
+	for k, v := range att.Header {
+		header[k] = v
+	}
+	contentType := att.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header.Set("Content-Type", contentType)
+	if header.Get("Content-Disposition") == "" {
+		disposition := "attachment"
+		if att.HTMLRelated {
+			disposition = "inline"
+		}
+		header.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": att.Filename}))
+	}
+	if header.Get("Content-ID") == "" {
+		header.Set("Content-ID", fmt.Sprintf("<%s>", att.Filename))
+	}
+	if header.Get("Content-Transfer-Encoding") == "" {
+		header.Set("Content-Transfer-Encoding", encodingBase64)
+	}
+
+	w, err := create(header)
+	if err != nil {
+		return err
+	}
+	if !isQuotedPrintable(att) {
+		_, err = w.Write(base64Lines(att.Content))
+		return err
+	}
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write(att.Content); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// base64Lines encodes b the way the email package writes attachment bodies:
+// base64 in lines of 76 characters, each ending in CRLF.
+func base64Lines(b []byte) []byte {
+	const maxRaw = 57 // raw bytes per 76-character line
+	var out []byte
+	for len(b) > 0 {
+		n := min(len(b), maxRaw)
+		out = base64.StdEncoding.AppendEncode(out, b[:n])
+		out = append(out, "\r\n"...)
+		b = b[n:]
+	}
+	return out
+}
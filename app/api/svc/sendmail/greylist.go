@@ -0,0 +1,46 @@
+package sendmail
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mustur/mockgrid/internal/clock"
+)
+
+// greylistReason mimics the SMTP reply a greylisting server gives on first contact.
+const greylistReason = "451 4.7.1 Greylisted, please try again later"
+
+// greylist simulates recipient greylisting: the first send to an address is
+// deferred, and sends within window of that first contact are accepted.
+// Once the window has passed the address is treated as unseen again.
+type greylist struct {
+	mu     sync.Mutex
+	clock  clock.Clock
+	window time.Duration
+	seen   map[string]time.Time // lowercased address -> first contact
+}
+
+// newGreylist creates a greylist whose entries expire after window.
+func newGreylist(clk clock.Clock, window time.Duration) *greylist {
+	return &greylist{
+		clock:  clk,
+		window: window,
+		seen:   make(map[string]time.Time),
+	}
+}
+
+// shouldDefer reports whether a send to addr should be deferred, recording the
+// first contact if so.
+func (g *greylist) shouldDefer(addr string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	key := strings.ToLower(addr)
+	now := g.clock.Now()
+	if first, ok := g.seen[key]; ok && now.Sub(first) <= g.window {
+		return false
+	}
+	g.seen[key] = now
+	return true
+}
@@ -0,0 +1,58 @@
+package sendmail
+
+import "github.com/mustur/mockgrid/app/api/objects"
+
+// mergedPersonalization is the effective content of a single personalization
+// after request-level values have been merged in.
+type mergedPersonalization struct {
+	Subject       string
+	HTML          string
+	Text          string
+	AMP           string
+	Headers       map[string]string
+	Substitutions map[string]string
+}
+
+// mergePersonalization computes the effective subject, content, headers and
+// substitutions for p following SendGrid's precedence rules:
+//   - subject: the personalization's subject wins over the request's
+//   - content: always taken from the request; substitutions are applied
+//   - headers: request headers, overridden per key by the personalization's
+//   - substitutions: the personalization's substitutions over defaults,
+//     wrapped with the request's substitution_wrappers when given
+func mergePersonalization(pr *objects.PostRequest, p objects.Personalization, defaults map[string]string) mergedPersonalization {
+	m := mergedPersonalization{
+		Headers:       make(map[string]string, len(pr.Headers)+len(p.Headers)),
+		Substitutions: make(map[string]string, len(p.Substitutions)),
+	}
+	for k, v := range p.Substitutions {
+		m.Substitutions[k] = v
+	}
+	replacer := buildReplacer(m.Substitutions, defaults, pr.SubstitutionWrappers)
+
+	m.Subject = pr.Subject
+	if p.Subject != "" {
+		m.Subject = p.Subject
+	}
+	m.Subject = replacer.Replace(m.Subject)
+
+	for _, c := range pr.Content {
+		switch c.Type {
+		case "text/html":
+			m.HTML = replacer.Replace(c.Value)
+		case ampContentType:
+			m.AMP = replacer.Replace(c.Value)
+		default:
+			m.Text = replacer.Replace(c.Value)
+		}
+	}
+
+	for k, v := range pr.Headers {
+		m.Headers[k] = v
+	}
+	for k, v := range p.Headers {
+		m.Headers[k] = v
+	}
+
+	return m
+}
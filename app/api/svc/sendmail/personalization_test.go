@@ -0,0 +1,117 @@
+package sendmail
+
+import (
+	"testing"
+
+	"github.com/mustur/mockgrid/app/api/objects"
+)
+
+func TestMergePersonalization_SubjectPrecedence(t *testing.T) {
+	pr := &objects.PostRequest{Subject: "Global -name-"}
+
+	m := mergePersonalization(pr, objects.Personalization{
+		Substitutions: map[string]string{"-name-": "Ada"},
+	}, nil)
+	if m.Subject != "Global Ada" {
+		t.Errorf("expected request subject with substitutions, got %q", m.Subject)
+	}
+
+	m = mergePersonalization(pr, objects.Personalization{
+		Subject:       "Personal -name-",
+		Substitutions: map[string]string{"-name-": "Ada"},
+	}, nil)
+	if m.Subject != "Personal Ada" {
+		t.Errorf("expected personalization subject to win, got %q", m.Subject)
+	}
+}
+
+func TestMergePersonalization_ContentFromRequest(t *testing.T) {
+	pr := &objects.PostRequest{
+		Content: []objects.Content{
+			{Type: "text/plain", Value: "Hi -name-"},
+			{Type: "text/html", Value: "<p>Hi -name-</p>"},
+		},
+	}
+
+	m := mergePersonalization(pr, objects.Personalization{
+		Substitutions: map[string]string{"-name-": "Grace"},
+	}, nil)
+	if m.Text != "Hi Grace" {
+		t.Errorf("Text: expected %q, got %q", "Hi Grace", m.Text)
+	}
+	if m.HTML != "<p>Hi Grace</p>" {
+		t.Errorf("HTML: expected %q, got %q", "<p>Hi Grace</p>", m.HTML)
+	}
+}
+
+func TestMergePersonalization_SubstitutionWrappers(t *testing.T) {
+	pr := &objects.PostRequest{
+		Subject:              "Hello %name%",
+		SubstitutionWrappers: []string{"%", "%"},
+		Content: []objects.Content{
+			{Type: "text/plain", Value: "Hi %name%, your name is name and city %city"},
+		},
+	}
+
+	m := mergePersonalization(pr, objects.Personalization{
+		Substitutions: map[string]string{"name": "Ada", "city": "London"},
+	}, nil)
+	if m.Subject != "Hello Ada" {
+		t.Errorf("Subject: expected %q, got %q", "Hello Ada", m.Subject)
+	}
+	// Bare and half-wrapped keys are left alone
+	if want := "Hi Ada, your name is name and city %city"; m.Text != want {
+		t.Errorf("Text: expected %q, got %q", want, m.Text)
+	}
+}
+
+func TestMergePersonalization_DefaultSubstitutions(t *testing.T) {
+	pr := &objects.PostRequest{
+		Subject: "[-env-] Hi -name-",
+		Content: []objects.Content{{Type: "text/plain", Value: "Sent from -env-"}},
+	}
+	defaults := map[string]string{"-env-": "staging", "-name-": "there"}
+
+	m := mergePersonalization(pr, objects.Personalization{
+		Substitutions: map[string]string{"-name-": "Ada"},
+	}, defaults)
+	if want := "[staging] Hi Ada"; m.Subject != want {
+		t.Errorf("Subject: expected %q, got %q", want, m.Subject)
+	}
+	if want := "Sent from staging"; m.Text != want {
+		t.Errorf("Text: expected %q, got %q", want, m.Text)
+	}
+
+	m = mergePersonalization(pr, objects.Personalization{
+		Substitutions: map[string]string{"-env-": "production"},
+	}, defaults)
+	if want := "[production] Hi there"; m.Subject != want {
+		t.Errorf("expected personalization value to override default, got %q", m.Subject)
+	}
+}
+
+func TestMergePersonalization_HeadersPrecedence(t *testing.T) {
+	pr := &objects.PostRequest{
+		Headers: map[string]string{"X-Campaign": "global", "X-Env": "test"},
+	}
+
+	m := mergePersonalization(pr, objects.Personalization{
+		Headers: map[string]string{"X-Campaign": "personal"},
+	}, nil)
+	if m.Headers["X-Campaign"] != "personal" {
+		t.Errorf("expected personalization header to win, got %q", m.Headers["X-Campaign"])
+	}
+	if m.Headers["X-Env"] != "test" {
+		t.Errorf("expected request header to be kept, got %q", m.Headers["X-Env"])
+	}
+}
+
+func TestMergePersonalization_DoesNotMutateRequest(t *testing.T) {
+	pr := &objects.PostRequest{Headers: map[string]string{"X-A": "1"}}
+	p := objects.Personalization{Headers: map[string]string{"X-A": "2"}}
+
+	_ = mergePersonalization(pr, p, nil)
+	if pr.Headers["X-A"] != "1" {
+		t.Errorf("request headers were mutated: %v", pr.Headers)
+	}
+}
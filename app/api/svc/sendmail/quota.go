@@ -0,0 +1,37 @@
+package sendmail
+
+import (
+	"sync"
+
+	"github.com/mustur/mockgrid/internal/clock"
+)
+
+// quota simulates a daily sending limit: once limit sends have been counted
+// in the current UTC day, further sends are refused until the day rolls over.
+type quota struct {
+	mu    sync.Mutex
+	clock clock.Clock
+	limit int
+	day   string // UTC date the count belongs to
+	used  int
+}
+
+// newQuota creates a quota allowing limit sends per UTC day.
+func newQuota(clk clock.Clock, limit int) *quota {
+	return &quota{clock: clk, limit: limit}
+}
+
+// take reports whether another send fits in today's quota, counting it if so.
+func (q *quota) take() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if day := q.clock.Now().UTC().Format("2006-01-02"); day != q.day {
+		q.day, q.used = day, 0
+	}
+	if q.used >= q.limit {
+		return false
+	}
+	q.used++
+	return true
+}
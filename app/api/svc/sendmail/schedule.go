@@ -0,0 +1,157 @@
+package sendmail
+
+import (
+	"log/slog"
+	"net/smtp"
+	"sync"
+	"time"
+
+	"github.com/jordan-wright/email"
+	"github.com/mustur/mockgrid/app/api/objects"
+	"github.com/mustur/mockgrid/app/api/store"
+)
+
+// DefaultSchedulePollInterval is how often scheduled sends are checked when
+// Config.SchedulePollInterval is unset.
+const DefaultSchedulePollInterval = time.Second
+
+// scheduler delivers messages saved with a future send_at once they are due.
+// Pending messages live only in the store, along with the mail rendered for
+// them, so nothing is lost on shutdown: they are picked up again by the next
+// poll after a restart. Each poll queries only the due messages.
+type scheduler struct {
+	mu      sync.Mutex
+	stopped bool
+	polling sync.WaitGroup // the poll in progress, if any
+}
+
+// startScheduler polls the store for due messages every interval on the
+// service's clock, so a mock clock triggers polls as it is advanced.
+func (s *Service) startScheduler(interval time.Duration) *scheduler {
+	if interval <= 0 {
+		interval = DefaultSchedulePollInterval
+	}
+	sc := &scheduler{}
+	var poll func()
+	poll = func() {
+		sc.mu.Lock()
+		if sc.stopped {
+			sc.mu.Unlock()
+			return
+		}
+		sc.polling.Add(1)
+		sc.mu.Unlock()
+		defer sc.polling.Done()
+
+		s.deliverDue()
+		s.clock.AfterFunc(interval, poll)
+	}
+	s.clock.AfterFunc(interval, poll)
+	return sc
+}
+
+// scheduled reports whether pr asks for delivery at a later time.
+func (s *Service) scheduled(pr *objects.PostRequest) bool {
+	return pr.SendAt > s.clock.Now().Unix()
+}
+
+// duePageSize is how many due messages deliverDue reads from the store at a
+// time.
+const duePageSize = 100
+
+// scheduledMail renders e for delivery at sendAt, dating it then. The
+// messages of its personalization keep the result until the scheduler
+// sends it.
+func scheduledMail(e *email.Email, amp string, sendAt int64) (*store.ScheduledMail, error) {
+	from, to, err := envelope(e)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := e.Headers["Date"]; !ok {
+		e.Headers.Set("Date", time.Unix(sendAt, 0).Format(time.RFC1123Z))
+	}
+	raw, err := (&ampEmail{Email: e, AMP: []byte(amp)}).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	group, err := store.GenerateMessageID()
+	if err != nil {
+		return nil, err
+	}
+	return &store.ScheduledMail{Group: group, From: from, Recipients: to, Raw: raw}, nil
+}
+
+// deliverDue delivers every processed message whose send_at has passed, a
+// page at a time. Delivered messages leave the processed status, so each
+// page is read from the start; a page holding only messages already tried,
+// e.g. because recording their delivery failed, is skipped past. Messages of
+// one personalization share their scheduled mail, which is sent once for all
+// of them.
+func (s *Service) deliverDue() {
+	query := store.GetQuery{
+		Status:     store.StatusProcessed,
+		SendBefore: s.clock.Now().Unix(),
+		Limit:      duePageSize,
+	}
+	tried := make(map[string]bool)
+	sent := make(map[string]error) // send error by scheduled mail group
+	for {
+		page, err := s.store.GetMSG(query)
+		if err != nil {
+			slog.Error("failed to list scheduled messages", "err", err)
+			return
+		}
+		progress := false
+		for _, msg := range page {
+			if tried[msg.MsgID] {
+				continue
+			}
+			tried[msg.MsgID] = true
+			progress = true
+			if err := s.deliverScheduled(msg, sent); err != nil {
+				slog.Error("failed to deliver scheduled message", "msg_id", msg.MsgID, "err", err)
+			}
+		}
+		if len(page) < duePageSize {
+			return
+		}
+		if !progress {
+			query.Offset += len(page)
+		}
+	}
+}
+
+// deliverScheduled delivers a due message from its scheduled mail, unless
+// another message of the same group already did, and records the outcome.
+// Messages stored without one are resent from their stored fields.
+func (s *Service) deliverScheduled(msg *store.Message, sent map[string]error) error {
+	sm := msg.Scheduled
+	if sm == nil {
+		_, err := s.Resend(msg)
+		return err
+	}
+	sendErr, ok := sent[sm.Group]
+	if !ok {
+		addr := s.smtpAddr(msg.IPPool)
+		sendErr = smtp.SendMail(addr, s.smtpAuth(addr), sm.From, sm.Recipients, sm.Raw)
+		if sendErr != nil {
+			slog.Warn("scheduled send failed", "msg_id", msg.MsgID, "err", sendErr)
+		}
+		sent[sm.Group] = sendErr
+	}
+	_, err := s.recordDelivery(msg, sendErr)
+	return err
+}
+
+// Close stops the scheduler, waiting for a delivery in progress to finish.
+// It is safe to call more than once.
+func (s *Service) Close() error {
+	if s.scheduler == nil {
+		return nil
+	}
+	s.scheduler.mu.Lock()
+	s.scheduler.stopped = true
+	s.scheduler.mu.Unlock()
+	s.scheduler.polling.Wait()
+	return nil
+}
@@ -0,0 +1,83 @@
+package sendmail
+
+import (
+	"net/http"
+
+	"github.com/mustur/mockgrid/app/api/objects"
+	"github.com/mustur/mockgrid/app/api/store"
+	"github.com/mustur/mockgrid/internal/httpjson"
+)
+
+// SendResult is the outcome of processing (part of) a send request.
+type SendResult struct {
+	// StatusCode is the HTTP status to report; http.StatusAccepted on success.
+	StatusCode int
+	// Error is the SendGrid-style error body, set when StatusCode is not 202.
+	Error objects.ErrorResponse
+	// Recipients holds the outcome for each recipient processed so far.
+	Recipients []RecipientResult
+	// Sandbox marks a successful sandbox mode send, which SendGrid answers
+	// with 200 instead of 202.
+	Sandbox bool
+}
+
+// RecipientResult is the delivery outcome for a single recipient.
+type RecipientResult struct {
+	Email  string              `json:"email"`
+	MsgID  string              `json:"msg_id"`
+	Status store.MessageStatus `json:"status"`
+	Reason string              `json:"reason,omitempty"`
+}
+
+// verboseBody is the 202 body written by WriteVerbose.
+type verboseBody struct {
+	Message    string            `json:"message"`
+	Recipients []RecipientResult `json:"recipients"`
+}
+
+// acceptedResult returns a successful SendResult.
+func acceptedResult() SendResult {
+	return SendResult{StatusCode: http.StatusAccepted}
+}
+
+// errorResult returns a failed SendResult with the given status and error body.
+func errorResult(code int, errResp objects.ErrorResponse) SendResult {
+	return SendResult{StatusCode: code, Error: errResp}
+}
+
+// OK reports whether the result is a success.
+func (r SendResult) OK() bool {
+	return r.StatusCode == http.StatusAccepted
+}
+
+// successCode returns the HTTP status written for a successful result.
+func (r SendResult) successCode() int {
+	if r.Sandbox {
+		return http.StatusOK
+	}
+	return http.StatusAccepted
+}
+
+// Write writes the result to the response writer: the error body on failure,
+// or SendGrid's acknowledgement on success.
+func (r SendResult) Write(w http.ResponseWriter) {
+	if !r.OK() {
+		httpjson.Write(w, r.StatusCode, r.Error)
+		return
+	}
+	httpjson.Write(w, r.successCode(), map[string]string{"message": "Email sent successfully"})
+}
+
+// WriteVerbose is like Write, but the success body also lists the outcome of
+// every recipient so partial drops are visible to the client.
+func (r SendResult) WriteVerbose(w http.ResponseWriter) {
+	if !r.OK() {
+		r.Write(w)
+		return
+	}
+	recipients := r.Recipients
+	if recipients == nil {
+		recipients = []RecipientResult{}
+	}
+	httpjson.Write(w, r.successCode(), verboseBody{Message: "Email sent successfully", Recipients: recipients})
+}
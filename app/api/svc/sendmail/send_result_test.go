@@ -0,0 +1,82 @@
+package sendmail
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mustur/mockgrid/app/api/objects"
+	"github.com/mustur/mockgrid/app/api/store"
+	"github.com/mustur/mockgrid/internal/testutil"
+)
+
+func TestSendMail_ResultCarriesPerRecipientStatuses(t *testing.T) {
+	st := testutil.NewMockMessageStore()
+	svc := New(Config{
+		SMTPServer:    "127.0.0.1",
+		SMTPPort:      closedPort(t),
+		ListenAddr:    ":0",
+		AttachmentDir: t.TempDir(),
+	}, nil, st)
+
+	pr := testutil.NewTestPostRequest()
+	pr.Personalizations[0].To = []objects.EmailAddress{
+		{Email: "a@example.com"},
+		{Email: "b@example.com"},
+	}
+
+	res := svc.sendMail(pr, nil)
+	if res.OK() {
+		t.Fatal("expected failure with unreachable SMTP server")
+	}
+	if len(res.Recipients) != 2 {
+		t.Fatalf("expected 2 recipient results, got %d", len(res.Recipients))
+	}
+
+	stored := map[string]*store.Message{}
+	for _, m := range st.Messages() {
+		stored[m.MsgID] = m
+	}
+	for i, want := range []string{"a@example.com", "b@example.com"} {
+		r := res.Recipients[i]
+		if r.Email != want {
+			t.Errorf("recipient %d: expected %q, got %q", i, want, r.Email)
+		}
+		if r.Status != store.StatusDeferred {
+			t.Errorf("recipient %d: expected status deferred, got %q", i, r.Status)
+		}
+		if m, ok := stored[r.MsgID]; !ok || m.ToEmail != want {
+			t.Errorf("recipient %d: msg id %q does not match a stored message", i, r.MsgID)
+		}
+	}
+}
+
+func TestSendResult_Write(t *testing.T) {
+	rec := httptest.NewRecorder()
+	acceptedResult().Write(rec)
+	if rec.Code != http.StatusAccepted {
+		t.Errorf("expected 202, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	errorResult(http.StatusBadRequest, objects.GetErrorResponse("bad", "field", nil)).Write(rec)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected JSON error body, got Content-Type %q", ct)
+	}
+}
+
+// closedPort returns a local TCP port with nothing listening on it.
+func closedPort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to reserve port: %v", err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	return port
+}
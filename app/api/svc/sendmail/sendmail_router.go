@@ -3,14 +3,26 @@ package sendmail
 import (
 	"net/http"
 
+	"github.com/mustur/mockgrid/app/api"
 	"github.com/mustur/mockgrid/app/api/middleware"
 )
 
 // GetMux returns the service's HTTP multiplexer.
 func (s *Service) GetMux() *http.ServeMux {
-	mux := http.NewServeMux()
+	return s.mux().ServeMux
+}
+
+// Patterns lists the patterns registered on the service's mux.
+func (s *Service) Patterns() []string {
+	return s.mux().Patterns()
+}
+
+// mux registers the service's handlers.
+func (s *Service) mux() *api.Mux {
+	mux := api.NewMux()
 	mux.HandleFunc("POST /send", s.handleSend)
 	mux.HandleFunc("GET /track/open", s.handleTrackOpen)
+	mux.HandleFunc("GET /track/click", s.handleTrackClick)
 	return mux
 }
 
@@ -22,6 +34,7 @@ func (s *Service) GetRoot() string {
 // Chain returns the middleware chain for this service.
 func (s *Service) Chain() middleware.Middleware {
 	return middleware.Chain(
+		middleware.Logging(s.accessLog),
 		s.authMiddleware(),
 	)
 }
@@ -3,15 +3,21 @@ package sendmail
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/mail"
 	"net/smtp"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jordan-wright/email"
@@ -19,6 +25,8 @@ import (
 	"github.com/mustur/mockgrid/app/api/objects"
 	"github.com/mustur/mockgrid/app/api/store"
 	"github.com/mustur/mockgrid/app/template"
+	"github.com/mustur/mockgrid/internal/clock"
+	"github.com/mustur/mockgrid/internal/httpjson"
 )
 
 // Config holds configuration for the SendMail service.
@@ -30,6 +38,94 @@ type Config struct {
 	AuthKey       string
 	SMTPUser      string
 	SMTPPass      string
+
+	// AccessLog logs each request; nil means slog.Default().
+	AccessLog *slog.Logger
+
+	// DisableOpenTracking skips tracking-pixel injection for sends whose
+	// tracking_settings do not enable it.
+	DisableOpenTracking bool
+
+	// DisableClickTracking leaves links in HTML bodies pointing at their
+	// original targets instead of GET /v3/mail/track/click, unless a send's
+	// tracking_settings enable click tracking.
+	DisableClickTracking bool
+
+	// ClickSecret signs click tracking links, which are only redirected
+	// with a valid signature. Empty derives a secret from AuthKey, so links
+	// keep redirecting across restarts.
+	ClickSecret string
+
+	// SkipPlainTextTracking skips the pixel for sends without an HTML body,
+	// instead of wrapping the text in HTML to carry it.
+	SkipPlainTextTracking bool
+
+	// MaxAttachmentBytes caps the decoded size of a single attachment.
+	// Zero means unlimited.
+	MaxAttachmentBytes int64
+
+	// AllowEmptyBody accepts sends with neither content nor a template_id.
+	AllowEmptyBody bool
+
+	// AllowCcOnly accepts personalizations without to recipients, storing a
+	// message per cc and bcc recipient instead.
+	AllowCcOnly bool
+
+	// HTMLToTextMode generates a plain-text body for sends with HTML but no
+	// text content: "strip" or "readable". Empty leaves such sends HTML-only.
+	HTMLToTextMode string
+
+	// AllowedFromDomains restricts from.email to these domains. Empty
+	// allows any sender.
+	AllowedFromDomains []string
+
+	// FailOnMissingTemplate rejects a send whose template is unavailable
+	// instead of sending the request's own content unrendered.
+	FailOnMissingTemplate bool
+
+	// AssumeJSON treats a send without a Content-Type header as JSON
+	// instead of rejecting it with 415.
+	AssumeJSON bool
+
+	// VerboseResponses lists each recipient's status in the 202 body
+	// instead of SendGrid's plain acknowledgement.
+	VerboseResponses bool
+
+	// GreylistWindow enables greylisting simulation when positive: the first
+	// delivery to a recipient is recorded as deferred, and repeat sends within
+	// the window are delivered.
+	GreylistWindow time.Duration
+
+	// IPPools routes sends with a matching ip_pool_name to their own SMTP
+	// server, given as host:port. Other sends use SMTPServer and SMTPPort.
+	IPPools map[string]string
+
+	// DailyQuota simulates a sending limit when positive: once that many
+	// recipients have been sent to in the current UTC day, further
+	// recipients are dropped until the day rolls over.
+	DailyQuota int
+
+	// PerDomainRPS simulates provider throttling when positive: each
+	// recipient domain accepts this many sends per second, and recipients
+	// over the rate are recorded as deferred.
+	PerDomainRPS float64
+
+	// DefaultSubstitutions are applied to every personalization; a
+	// personalization's own substitution for the same key wins.
+	DefaultSubstitutions map[string]string
+
+	// CaptureHeaders lists incoming request headers to store on each
+	// message. Authorization is only captured when listed explicitly.
+	CaptureHeaders []string
+
+	// Clock dates stored messages and their events, drives the scheduler
+	// and times greylisting, the daily quota and the per-domain throttle.
+	// Nil means the real clock.
+	Clock clock.Clock
+
+	// SchedulePollInterval is how often scheduled sends are checked for
+	// delivery. Zero uses DefaultSchedulePollInterval.
+	SchedulePollInterval time.Duration
 }
 
 // Service implements the mail sending functionality.
@@ -39,25 +135,100 @@ type Service struct {
 	listenAddr    string
 	attachmentDir string
 	authKey       string
+	apiKeyID      string // store.KeyID of authKey, recorded on messages; empty without auth
+	accessLog     *slog.Logger
 	smtpUser      string
 	smtpPass      string
+	ipPools       map[string]string
+	defaultSubs   map[string]string
+	openTracking  bool
+	clickTracking bool
+	clickKey      []byte // HMAC key signing click tracking links
+	skipPlainText bool
+	allowEmpty    bool
+	allowCcOnly   bool
+	htmlToText    string // text generation mode for HTML-only sends; empty generates none
+	allowedFrom   []string
+	failMissing   bool
+	assumeJSON    bool
+	verbose       bool
+	maxAttachment int64
+	greylist      *greylist       // nil when greylisting is not simulated
+	quota         *quota          // nil when no daily quota is simulated
+	throttle      *domainThrottle // nil when per-domain throttling is not simulated
+	capture       []string        // canonical names of request headers to store
 	tpl           template.Templater
 	store         store.MessageStore
+	clock         clock.Clock
+	eventMu       sync.Mutex // serializes open and click count updates
+	hookMu        sync.Mutex // guards onMessage
+	onMessage     []func(*store.Message)
+	scheduler     *scheduler // nil without a message store
 }
 
 // New creates a new SendMail service with the given configuration.
 func New(cfg Config, tpl template.Templater, msgStore store.MessageStore) *Service {
-	return &Service{
+	clk := cfg.Clock
+	if clk == nil {
+		clk = clock.RealClock{}
+	}
+	var gl *greylist
+	if cfg.GreylistWindow > 0 {
+		gl = newGreylist(clk, cfg.GreylistWindow)
+	}
+	var q *quota
+	if cfg.DailyQuota > 0 {
+		q = newQuota(clk, cfg.DailyQuota)
+	}
+	var th *domainThrottle
+	if cfg.PerDomainRPS > 0 {
+		th = newDomainThrottle(clk, cfg.PerDomainRPS)
+	}
+	capture := make([]string, 0, len(cfg.CaptureHeaders))
+	for _, h := range cfg.CaptureHeaders {
+		capture = append(capture, http.CanonicalHeaderKey(h))
+	}
+	s := &Service{
 		smtpServer:    cfg.SMTPServer,
 		smtpPort:      cfg.SMTPPort,
 		listenAddr:    cfg.ListenAddr,
 		attachmentDir: cfg.AttachmentDir,
 		authKey:       cfg.AuthKey,
+		accessLog:     cfg.AccessLog,
 		smtpUser:      cfg.SMTPUser,
 		smtpPass:      cfg.SMTPPass,
+		openTracking:  !cfg.DisableOpenTracking,
+		clickTracking: !cfg.DisableClickTracking,
+		skipPlainText: cfg.SkipPlainTextTracking,
+		allowEmpty:    cfg.AllowEmptyBody,
+		allowCcOnly:   cfg.AllowCcOnly,
+		htmlToText:    cfg.HTMLToTextMode,
+		allowedFrom:   cfg.AllowedFromDomains,
+		failMissing:   cfg.FailOnMissingTemplate,
+		assumeJSON:    cfg.AssumeJSON,
+		verbose:       cfg.VerboseResponses,
+		maxAttachment: cfg.MaxAttachmentBytes,
+		greylist:      gl,
+		quota:         q,
+		throttle:      th,
+		ipPools:       cfg.IPPools,
+		defaultSubs:   cfg.DefaultSubstitutions,
+		capture:       capture,
 		tpl:           tpl,
 		store:         msgStore,
+		clock:         clk,
+	}
+	if cfg.AuthKey != "" {
+		s.apiKeyID = store.KeyID(cfg.AuthKey)
+	}
+	s.clickKey = []byte(cfg.ClickSecret)
+	if len(s.clickKey) == 0 {
+		s.clickKey = defaultClickKey(cfg.AuthKey)
 	}
+	if msgStore != nil {
+		s.scheduler = s.startScheduler(cfg.SchedulePollInterval)
+	}
+	return s
 }
 
 // authMiddleware returns a middleware that checks for valid authorization.
@@ -66,7 +237,7 @@ func (s *Service) authMiddleware() middleware.Middleware {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if err := s.checkAuth(r); err != nil {
 				slog.Warn("authorization failed", "err", err, "path", r.URL.Path)
-				writeJSON(w, http.StatusUnauthorized, objects.GetErrorResponse(err.Error(), nil, nil))
+				httpjson.Write(w, http.StatusUnauthorized, objects.GetErrorResponse(err.Error(), nil, nil))
 				return
 			}
 			next.ServeHTTP(w, r)
@@ -82,52 +253,101 @@ func (s *Service) checkAuth(r *http.Request) error {
 	authHeader := r.Header.Get("Authorization")
 	expected := "Bearer " + s.authKey
 	if authHeader != expected {
-		return fmt.Errorf("the provided authorization grant is invalid, expired, or revoked")
+		return errors.New(objects.UnauthorizedMessage)
 	}
 	return nil
 }
 
 // handleSend processes POST /v3/mail/send requests.
 func (s *Service) handleSend(w http.ResponseWriter, r *http.Request) {
-	if !validateContentType(w, r, "application/json") {
+	if !validateContentType(w, r, "application/json", s.assumeJSON) {
 		return
 	}
 
 	pr, err := decodePostRequest(r)
 	if err != nil {
 		slog.Error("failed to decode request body", "err", err)
-		writeJSON(w, http.StatusBadRequest, objects.GetErrorResponse("Failed to decode request body: "+err.Error(), nil, nil))
+		httpjson.Write(w, http.StatusBadRequest, objects.GetErrorResponse("Failed to decode request body: "+err.Error(), nil, nil))
 		return
 	}
 
 	if err := s.renderTemplate(pr); err != nil {
 		slog.Error("failed to render template", "err", err)
-		writeJSON(w, http.StatusInternalServerError, objects.GetErrorResponse("Failed to render template: "+err.Error(), nil, nil))
+		httpjson.Write(w, http.StatusInternalServerError, objects.GetErrorResponse("Failed to render template: "+err.Error(), nil, nil))
 		return
 	}
 
 	if code, errResp := pr.Validate(); code != http.StatusAccepted {
 		slog.Warn("validation failed", "status", code)
-		writeJSON(w, code, errResp)
+		httpjson.Write(w, code, errResp)
+		return
+	}
+
+	if code, errResp := pr.ValidateRecipients(s.allowCcOnly); code != http.StatusAccepted {
+		slog.Warn("rejected send without to recipients", "status", code)
+		httpjson.Write(w, code, errResp)
 		return
 	}
+	if len(s.allowedFrom) > 0 {
+		if code, errResp := pr.ValidateFromDomain(s.allowedFrom); code != http.StatusAccepted {
+			slog.Warn("rejected send from disallowed domain", "from", pr.From.Email)
+			httpjson.Write(w, code, errResp)
+			return
+		}
+	}
+
+	if !s.allowEmpty {
+		if code, errResp := pr.ValidateBody(); code != http.StatusAccepted {
+			slog.Warn("rejected send with empty body", "status", code)
+			httpjson.Write(w, code, errResp)
+			return
+		}
+	}
 
-	if code, errResp := s.sendMail(pr); code != http.StatusAccepted {
-		slog.Error("failed to send email", "status", code)
-		writeJSON(w, code, errResp)
+	result := s.sendMail(pr, s.capturedHeaders(r))
+	if !result.OK() {
+		slog.Error("failed to send email", "status", result.StatusCode)
+	}
+	if s.verbose {
+		result.WriteVerbose(w)
 		return
 	}
+	result.Write(w)
+}
 
-	w.WriteHeader(http.StatusAccepted)
-	if err := json.NewEncoder(w).Encode(map[string]string{"message": "Email sent successfully"}); err != nil {
-		slog.Error("failed to encode success response", "err", err)
+// capturedHeaders returns the configured request headers present on r, or
+// nil when none are.
+func (s *Service) capturedHeaders(r *http.Request) map[string]string {
+	var headers map[string]string
+	for _, name := range s.capture {
+		v := r.Header.Values(name)
+		if len(v) == 0 {
+			continue
+		}
+		if headers == nil {
+			headers = make(map[string]string, len(s.capture))
+		}
+		headers[name] = strings.Join(v, ", ")
 	}
+	return headers
 }
 
-// handleTrackOpen serves the tracking pixel and logs the open event.
+// handleTrackOpen serves the tracking pixel and records the open on the
+// message named by id. The pixel is served even when id is missing or
+// unknown, so the image never shows as broken; nothing is recorded then.
 func (s *Service) handleTrackOpen(w http.ResponseWriter, r *http.Request) {
 	qry := r.URL.Query()
-	slog.Info("email open tracked", "id", qry.Get("id"), "to", qry.Get("to"))
+	msgID := qry.Get("id")
+	switch err := s.recordEvent(msgID, func(msg *store.Message) { msg.OpensCount++ }); {
+	case msgID == "":
+		slog.Debug("open tracked without a message id", "to", qry.Get("to"))
+	case errors.Is(err, store.ErrNotFound):
+		slog.Warn("open tracked for unknown message", "id", msgID, "to", qry.Get("to"))
+	case err != nil:
+		slog.Warn("failed to record open", "id", msgID, "err", err)
+	default:
+		slog.Info("email open tracked", "id", msgID, "to", qry.Get("to"))
+	}
 
 	pixel, err := base64.StdEncoding.DecodeString(trackingPixelB64)
 	if err != nil {
@@ -141,36 +361,213 @@ func (s *Service) handleTrackOpen(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(pixel)
 }
 
+// recordEvent applies an engagement update to the stored message and stamps
+// its last event time.
+func (s *Service) recordEvent(msgID string, apply func(*store.Message)) error {
+	if msgID == "" {
+		return errors.New("missing message id")
+	}
+	s.eventMu.Lock()
+	defer s.eventMu.Unlock()
+
+	msgs, err := s.store.GetMSG(store.GetQuery{ID: msgID})
+	if err != nil {
+		return err
+	}
+	if len(msgs) == 0 {
+		return store.ErrNotFound
+	}
+	msg := msgs[0]
+	apply(msg)
+	msg.LastEventTime = s.clock.Now().Unix()
+	return s.store.SaveMSG(msg)
+}
+
 // sendMail iterates over personalizations and sends an email for each.
-func (s *Service) sendMail(pr *objects.PostRequest) (int, objects.ErrorResponse) {
-	auth := s.smtpAuth()
+// The result carries the outcome of every recipient processed. reqHeaders
+// are the captured request headers stored on each message.
+func (s *Service) sendMail(pr *objects.PostRequest, reqHeaders map[string]string) SendResult {
+	addr := s.smtpAddr(pr.IPPoolName)
+	auth := s.smtpAuth(addr)
+	var recipients []RecipientResult
 
 	for _, p := range pr.Personalizations {
-		e := s.buildEmail(pr, p)
+		ccOnly := len(p.To) == 0
+		p, invalid := splitInvalidRecipients(p)
+		var throttled, overQuota objects.Personalization
+		if !pr.MailSettings.SandboxMode.Enable {
+			// Sandboxed sends are never delivered, so they neither count
+			// against the per-domain rate nor use up the daily quota
+			p, throttled = s.splitThrottled(p)
+			p, overQuota = s.splitOverQuota(p)
+		}
+		m := mergePersonalization(pr, p, s.defaultSubs)
+		e := s.buildEmail(pr, p, m)
+
+		// Messages are stored per to recipient, or per cc and bcc recipient
+		// of a cc-only personalization
+		rec := p
+		if ccOnly {
+			rec.To = slices.Concat(p.Cc, p.Bcc)
+		}
+
+		// Message IDs are fixed before sending so tracked links can carry them
+		msgIDs, err := newMessageIDs(len(rec.To))
+		if err != nil {
+			slog.Error("failed to generate message IDs", "err", err)
+			res := errorResult(http.StatusInternalServerError, objects.GetErrorResponse("Failed to send email", nil, nil))
+			res.Recipients = recipients
+			return res
+		}
+		if len(rec.To) > 0 {
+			s.trackClicks(e, pr.TrackingSettings.ClickTracking, msgIDs[0], rec.To[0].Email)
+		}
+		s.injectTrackingPixels(e, pr.TrackingSettings.OpenTracking, rec, msgIDs)
+
+		if res := s.attachFiles(e, pr.Attachments); !res.OK() {
+			res.Recipients = recipients
+			return res
+		}
 
-		s.injectTrackingPixels(e, p)
+		for _, drop := range []struct {
+			p      objects.Personalization
+			status store.MessageStatus
+			reason string
+		}{
+			{invalid, store.StatusDropped, store.DropReasonInvalid},
+			{throttled, store.StatusDeferred, throttleReason},
+			{overQuota, store.StatusDropped, store.DropReasonQuota},
+		} {
+			if len(drop.p.To) == 0 {
+				continue
+			}
+			saved, err := s.saveMessages(pr, drop.p, nil, m, e, reqHeaders, drop.status, drop.reason, nil)
+			if err != nil {
+				slog.Error("failed to save messages", "err", err)
+			}
+			recipients = append(recipients, saved...)
+		}
+		if len(p.To) == 0 && !ccOnly {
+			continue
+		}
 
-		if code, errResp := s.attachFiles(e, pr.Attachments); code != http.StatusAccepted {
-			return code, errResp
+		if pr.MailSettings.SandboxMode.Enable || s.scheduled(pr) {
+			// Sandboxed sends are validated and recorded but never delivered;
+			// scheduled ones are left for the scheduler to deliver when due,
+			// from the mail rendered now
+			var sched *store.ScheduledMail
+			if !pr.MailSettings.SandboxMode.Enable {
+				if sched, err = scheduledMail(e, m.AMP, pr.SendAt); err != nil {
+					slog.Error("failed to render scheduled email", "err", err)
+					res := errorResult(http.StatusInternalServerError, objects.GetErrorResponse("Failed to send email", nil, nil))
+					res.Recipients = recipients
+					return res
+				}
+			}
+			saved, err := s.saveMessages(pr, rec, msgIDs, m, e, reqHeaders, store.StatusProcessed, "", sched)
+			if err != nil {
+				slog.Error("failed to save messages", "err", err)
+			}
+			recipients = append(recipients, saved...)
+			continue
 		}
 
-		sendErr := e.Send(s.smtpAddr(), auth)
+		sendErr := (&ampEmail{Email: e, AMP: []byte(m.AMP)}).Send(addr, auth)
 		status, reason := classifyDeliveryResult(sendErr)
 
-		if err := s.saveMessages(pr, p, e, status, reason); err != nil {
+		saved, err := s.saveMessages(pr, rec, msgIDs, m, e, reqHeaders, status, reason, nil)
+		if err != nil {
 			slog.Error("failed to save messages", "err", err)
 		}
+		recipients = append(recipients, saved...)
 
 		if sendErr != nil {
 			slog.Error("failed to send email", "err", sendErr)
-			return http.StatusInternalServerError, objects.GetErrorResponse("Failed to send email: "+sendErr.Error(), nil, nil)
+			res := errorResult(http.StatusInternalServerError, objects.GetErrorResponse("Failed to send email: "+sendErr.Error(), nil, nil))
+			res.Recipients = recipients
+			return res
+		}
+	}
+
+	res := acceptedResult()
+	res.Sandbox = pr.MailSettings.SandboxMode.Enable
+	res.Recipients = recipients
+	return res
+}
+
+// Resend delivers a stored message again from its saved sender, recipient,
+// subject and bodies, and records the new outcome on the same message.
+// Attachments and custom headers are not stored, so they are not resent.
+// Like any send, a resend is subject to the per-domain throttle and the
+// daily quota, and the updated message is passed to the OnMessage hooks.
+func (s *Service) Resend(msg *store.Message) (*store.Message, error) {
+	pr := &objects.PostRequest{
+		From:       objects.EmailAddress{Email: msg.FromEmail, Name: msg.FromName},
+		Subject:    msg.Subject,
+		IPPoolName: msg.IPPool,
+	}
+	if msg.TextBody != "" {
+		pr.Content = append(pr.Content, objects.Content{Type: "text/plain", Value: msg.TextBody})
+	}
+	if msg.AMPBody != "" {
+		pr.Content = append(pr.Content, objects.Content{Type: ampContentType, Value: msg.AMPBody})
+	}
+	if msg.HTMLBody != "" {
+		pr.Content = append(pr.Content, objects.Content{Type: "text/html", Value: msg.HTMLBody})
+	}
+	p := objects.Personalization{To: []objects.EmailAddress{{Email: msg.ToEmail, Name: msg.ToName}}}
+
+	var updated *store.Message
+	var err error
+	if _, throttled := s.splitThrottled(p); len(throttled.To) > 0 {
+		updated, err = s.recordStatus(msg, store.StatusDeferred, throttleReason)
+	} else if _, over := s.splitOverQuota(p); len(over.To) > 0 {
+		updated, err = s.recordStatus(msg, store.StatusDropped, store.DropReasonQuota)
+	} else {
+		// The stored content is already rendered: it carries its tracking
+		// pixel and substitutions, so neither is applied again
+		m := mergePersonalization(pr, p, nil)
+		e := s.buildEmail(pr, p, m)
+		addr := s.smtpAddr(pr.IPPoolName)
+		sendErr := (&ampEmail{Email: e, AMP: []byte(m.AMP)}).Send(addr, s.smtpAuth(addr))
+		if sendErr != nil {
+			slog.Warn("resend failed", "msg_id", msg.MsgID, "err", sendErr)
 		}
+		updated, err = s.recordDelivery(msg, sendErr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.notifyMessages([]*store.Message{updated})
+	return updated, nil
+}
+
+// recordDelivery saves the outcome of delivering msg, classified from the
+// send error, on a copy of it and returns the copy.
+func (s *Service) recordDelivery(msg *store.Message, sendErr error) (*store.Message, error) {
+	status, reason := classifyDeliveryResult(sendErr)
+	status, reason = s.applyGreylist(msg.ToEmail, status, reason)
+	return s.recordStatus(msg, status, reason)
+}
+
+// recordStatus saves status and reason on a copy of msg and returns the
+// copy. A message with a new outcome no longer needs its scheduled mail, so
+// it is dropped.
+func (s *Service) recordStatus(msg *store.Message, status store.MessageStatus, reason string) (*store.Message, error) {
+	updated := *msg
+	updated.Status = status
+	updated.Reason = reason
+	updated.LastEventTime = s.clock.Now().Unix()
+	updated.Scheduled = nil
+	if err := s.store.SaveMSG(&updated); err != nil {
+		return nil, fmt.Errorf("save message: %w", err)
 	}
-	return http.StatusAccepted, objects.GetErrorResponse("", nil, nil)
+	return &updated, nil
 }
 
-// buildEmail constructs an email.Email from the request and personalization.
-func (s *Service) buildEmail(pr *objects.PostRequest, p objects.Personalization) *email.Email {
+// buildEmail constructs an email.Email from the request, personalization and
+// its merged effective content.
+func (s *Service) buildEmail(pr *objects.PostRequest, p objects.Personalization, m mergedPersonalization) *email.Email {
 	e := email.NewEmail()
 	e.From = formatAddress(pr.From.Name, pr.From.Email)
 
@@ -184,60 +581,109 @@ func (s *Service) buildEmail(pr *objects.PostRequest, p objects.Personalization)
 		e.Bcc = append(e.Bcc, formatAddress(bcc.Name, bcc.Email))
 	}
 
-	replacer := buildReplacer(p.Substitutions)
-	e.Subject = s.resolveSubject(pr, p, replacer)
-
-	for _, c := range pr.Content {
-		if c.Type == "text/html" {
-			e.HTML = []byte(replacer.Replace(c.Value))
-		} else {
-			e.Text = []byte(replacer.Replace(c.Value))
-		}
+	e.Subject = m.Subject
+	if m.HTML != "" {
+		e.HTML = []byte(m.HTML)
+	}
+	if m.Text != "" {
+		e.Text = []byte(m.Text)
+	} else if m.HTML != "" && s.htmlToText != "" {
+		e.Text = []byte(htmlToText(m.HTML, s.htmlToText))
+	}
+	for k, v := range m.Headers {
+		e.Headers.Set(k, v)
 	}
 
 	return e
 }
 
-// injectTrackingPixels adds tracking pixels to the email HTML body.
-func (s *Service) injectTrackingPixels(e *email.Email, p objects.Personalization) {
+// injectTrackingPixels adds a tracking pixel per recipient to the email HTML
+// body, identified by the recipient's message ID in msgIDs. It leaves the
+// body untouched when open tracking is disabled, by the request's setting ot
+// or globally, or when the send is text-only and plain-text tracking is
+// skipped. The pixels replace ot.SubstitutionTag when the body contains it.
+func (s *Service) injectTrackingPixels(e *email.Email, ot objects.OpenTracking, p objects.Personalization, msgIDs []string) {
+	if !enabled(ot.Enable, s.openTracking) || (s.skipPlainText && len(e.HTML) == 0) || len(p.To) == 0 {
+		return
+	}
 	base := s.trackingBaseURL()
+	var pixels strings.Builder
 	for idx, to := range p.To {
-		ensureHTMLBody(e)
-		id := generateTrackingID(idx)
-		trackURL := buildTrackingURL(base, id, to.Email)
-		pixel := fmt.Sprintf(`<img src="%s" alt="" width="1" height="1" style="display:none;"/>`, trackURL)
-		injectPixel(e, pixel)
+		trackURL := buildTrackingURL(base, msgIDs[idx], to.Email)
+		fmt.Fprintf(&pixels, `<img src="%s" alt="" width="1" height="1" style="display:none;"/>`, trackURL)
+	}
+	ensureHTMLBody(e)
+	injectPixel(e, pixels.String(), ot.SubstitutionTag)
+}
+
+// enabled returns the request's setting, or def when the request leaves it
+// unset.
+func enabled(setting *bool, def bool) bool {
+	if setting == nil {
+		return def
 	}
+	return *setting
 }
 
-// attachFiles decodes and attaches files to the email.
-func (s *Service) attachFiles(e *email.Email, attachments []objects.Attachment) (int, objects.ErrorResponse) {
+// attachFiles decodes and attaches files to the email. Inline attachments
+// with a content_id are embedded alongside the HTML body.
+func (s *Service) attachFiles(e *email.Email, attachments []objects.Attachment) SendResult {
+	if res := validateContentIDs(attachments); !res.OK() {
+		return res
+	}
+
 	for i, att := range attachments {
+		if att.Encoding != "" && att.Encoding != encodingBase64 && att.Encoding != encodingQuotedPrintable {
+			return errorResult(http.StatusBadRequest, objects.GetErrorResponse(
+				"The attachment encoding must be base64 or quoted-printable.",
+				"attachments."+strconv.Itoa(i)+".encoding",
+				nil,
+			))
+		}
 		dir, err := s.saveAttachment(att.Filename, att.Content)
+		if errors.Is(err, errAttachmentTooLarge) {
+			slog.Warn("attachment too large", "filename", att.Filename, "max_bytes", s.maxAttachment)
+			return errorResult(http.StatusBadRequest, objects.GetErrorResponse(
+				"The attachment exceeds the maximum size of "+strconv.FormatInt(s.maxAttachment, 10)+" bytes.",
+				"attachments."+strconv.Itoa(i)+".content",
+				nil,
+			))
+		}
 		if err != nil {
 			slog.Error("attachment error", "filename", att.Filename, "err", err)
-			return http.StatusBadRequest, objects.GetErrorResponse(
+			return errorResult(http.StatusBadRequest, objects.GetErrorResponse(
 				"The attachment content must be base64 encoded.",
 				"attachments."+strconv.Itoa(i)+".content",
 				"http://sendgrid.com/docs/API_Reference/Web_API_v3/Mail/errors.html#message.attachments.content",
-			)
+			))
 		}
 		path := filepath.Join(dir, filepath.Base(att.Filename))
-		if _, err := e.AttachFile(path); err != nil {
+		a, err := e.AttachFile(path)
+		if err != nil {
 			slog.Error("failed to attach file", "filename", att.Filename, "err", err)
-			return http.StatusInternalServerError, objects.GetErrorResponse("Failed to attach file: "+err.Error(), nil, nil)
+			return errorResult(http.StatusInternalServerError, objects.GetErrorResponse("Failed to attach file: "+err.Error(), nil, nil))
+		}
+		if att.Encoding == encodingQuotedPrintable {
+			a.Header.Set("Content-Transfer-Encoding", encodingQuotedPrintable)
+		}
+		if att.Disposition == "inline" && att.ContentId != "" {
+			// Inline parts go next to the HTML body so cid: references
+			// resolve; without HTML there is nothing to relate them to
+			a.Header.Set("Content-ID", "<"+att.ContentId+">")
+			a.HTMLRelated = len(e.HTML) > 0
 		}
 	}
-	return http.StatusAccepted, objects.GetErrorResponse("", nil, nil)
+	return acceptedResult()
 }
 
-// saveAttachment decodes base64 content and writes it to a temporary directory.
-func (s *Service) saveAttachment(filename, b64Content string) (string, error) {
-	data, err := base64.StdEncoding.DecodeString(b64Content)
-	if err != nil {
-		return "", fmt.Errorf("decode base64: %w", err)
-	}
+// errAttachmentTooLarge is returned by saveAttachment when the decoded
+// content exceeds the configured maximum.
+var errAttachmentTooLarge = errors.New("attachment exceeds maximum size")
 
+// saveAttachment stream-decodes base64 content into a file in a new temporary
+// directory and returns the directory. Decoding stops as soon as the content
+// exceeds the maximum attachment size. On error nothing is left on disk.
+func (s *Service) saveAttachment(filename, b64Content string) (string, error) {
 	safeName := filepath.Base(filename)
 	dir := filepath.Join(s.attachmentDir, "attachment_"+strconv.FormatInt(time.Now().UnixNano(), 10))
 
@@ -245,145 +691,285 @@ func (s *Service) saveAttachment(filename, b64Content string) (string, error) {
 		return "", fmt.Errorf("create directory: %w", err)
 	}
 
-	path := filepath.Join(dir, safeName)
-	if err := os.WriteFile(path, data, 0o600); err != nil {
-		return "", fmt.Errorf("write file: %w", err)
+	if err := s.writeAttachment(filepath.Join(dir, safeName), b64Content); err != nil {
+		_ = os.RemoveAll(dir)
+		return "", err
 	}
-
 	return dir, nil
 }
 
+// writeAttachment decodes b64Content into path, enforcing the size limit.
+func (s *Service) writeAttachment(path, b64Content string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("create file: %w", err)
+	}
+
+	src := base64.NewDecoder(base64.StdEncoding, strings.NewReader(b64Content))
+	if s.maxAttachment > 0 {
+		// Read one byte past the limit so oversized content is detectable
+		src = io.LimitReader(src, s.maxAttachment+1)
+	}
+
+	n, err := io.Copy(f, src)
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		return fmt.Errorf("write file: %w", closeErr)
+	}
+	if err != nil {
+		return fmt.Errorf("decode base64: %w", err)
+	}
+	if s.maxAttachment > 0 && n > s.maxAttachment {
+		return errAttachmentTooLarge
+	}
+	return nil
+}
+
 // renderTemplate applies template rendering if a templater is configured.
+// An unavailable template is skipped unless the service fails on them.
 func (s *Service) renderTemplate(pr *objects.PostRequest) error {
 	if s.tpl == nil {
 		return nil
 	}
-	return template.RenderAndPopulateFromTemplate(pr, s.tpl)
-}
-
-// resolveSubject returns the subject from personalization or request, with substitutions applied.
-func (s *Service) resolveSubject(pr *objects.PostRequest, p objects.Personalization, r *strings.Replacer) string {
-	if p.Subject != "" {
-		return r.Replace(p.Subject)
+	err := template.RenderAndPopulateFromTemplate(pr, s.tpl)
+	if errors.Is(err, template.ErrTemplateUnavailable) && !s.failMissing {
+		slog.Warn("template unavailable, sending without rendering", "template_id", pr.TemplateID, "err", err)
+		return nil
 	}
-	return r.Replace(pr.Subject)
+	return err
 }
 
-// smtpAddr returns the SMTP server address in host:port format.
-func (s *Service) smtpAddr() string {
+// smtpAddr returns the host:port of the SMTP server for the given IP pool,
+// falling back to the default server for unknown or empty pools.
+func (s *Service) smtpAddr(pool string) string {
+	if addr, ok := s.ipPools[pool]; ok && pool != "" {
+		return addr
+	}
 	return s.smtpServer + ":" + strconv.Itoa(s.smtpPort)
 }
 
-// smtpAuth returns SMTP authentication if credentials are configured.
+// smtpAuth returns SMTP authentication for the server at addr if
+// credentials are configured.
 // Returns nil if no credentials are set (anonymous SMTP).
-func (s *Service) smtpAuth() smtp.Auth {
+func (s *Service) smtpAuth(addr string) smtp.Auth {
 	if s.smtpUser == "" || s.smtpPass == "" {
 		return nil
 	}
-	return smtp.PlainAuth("", s.smtpUser, s.smtpPass, s.smtpServer)
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = s.smtpServer
+	}
+	return smtp.PlainAuth("", s.smtpUser, s.smtpPass, host)
 }
 
-// saveMessages persists message records for each recipient.
-func (s *Service) saveMessages(pr *objects.PostRequest, p objects.Personalization, e *email.Email, status store.MessageStatus, reason string) error {
-	now := time.Now().Unix()
+// saveMessages persists message records for each recipient and returns
+// their outcomes. msgIDs holds the message ID of each recipient in p.To;
+// nil generates new ones. sched is the mail a scheduled send is delivered
+// from, or nil.
+func (s *Service) saveMessages(pr *objects.PostRequest, p objects.Personalization, msgIDs []string, m mergedPersonalization, e *email.Email, reqHeaders map[string]string, status store.MessageStatus, reason string, sched *store.ScheduledMail) ([]RecipientResult, error) {
+	now := s.clock.Now().Unix()
+	atts := attachmentMetadata(pr.Attachments)
+	results := make([]RecipientResult, 0, len(p.To))
+	saved := make([]*store.Message, 0, len(p.To))
 
-	for _, to := range p.To {
-		msgID, err := store.GenerateMessageID()
-		if err != nil {
-			return fmt.Errorf("generate message ID: %w", err)
+	if msgIDs == nil {
+		var err error
+		if msgIDs, err = newMessageIDs(len(p.To)); err != nil {
+			return results, err
 		}
+	}
+	for i, to := range p.To {
+		msgID := msgIDs[i]
+		status, reason := s.applyGreylist(to.Email, status, reason)
+		results = append(results, RecipientResult{
+			Email:  to.Email,
+			MsgID:  msgID,
+			Status: status,
+			Reason: reason,
+		})
 
 		msg := &store.Message{
-			MsgID:         msgID,
-			FromEmail:     pr.From.Email,
-			ToEmail:       to.Email,
-			Subject:       e.Subject,
-			HTMLBody:      string(e.HTML),
-			TextBody:      string(e.Text),
-			Status:        status,
-			Reason:        reason,
-			Timestamp:     now,
-			LastEventTime: now,
+			MsgID:          msgID,
+			FromEmail:      pr.From.Email,
+			FromName:       pr.From.Name,
+			ToEmail:        to.Email,
+			ToName:         to.Name,
+			Subject:        m.Subject,
+			HTMLBody:       string(e.HTML),
+			TextBody:       string(e.Text),
+			AMPBody:        m.AMP,
+			Status:         status,
+			Reason:         reason,
+			Timestamp:      now,
+			LastEventTime:  now,
+			Attachments:    atts,
+			ThreadKey:      store.ThreadKey(m.Subject, pr.From.Email, to.Email),
+			IPPool:         pr.IPPoolName,
+			RequestHeaders: reqHeaders,
+			APIKeyID:       s.apiKeyID,
+			Scheduled:      sched,
+		}
+		if !pr.MailSettings.SandboxMode.Enable {
+			msg.SendAt = pr.SendAt
 		}
 
 		if err := s.store.SaveMSG(msg); err != nil {
 			slog.Error("failed to save message", "err", err, "msg_id", msgID)
+			continue
 		}
+		saved = append(saved, msg)
 	}
 
-	return nil
+	s.notifyMessages(saved)
+	return results, nil
 }
 
-// trackingBaseURL builds the base URL for tracking endpoints.
-func (s *Service) trackingBaseURL() string {
-	base := s.listenAddr
-	if !strings.HasPrefix(base, "http://") && !strings.HasPrefix(base, "https://") {
-		base = "http://" + strings.ReplaceAll(base, "0.0.0.0", "localhost")
-	}
-	return strings.TrimRight(base, "/")
+// OnMessage registers fn to be called with every message a send stores,
+// synchronously once the personalization's messages are saved and before the
+// send responds. fn must not modify the message.
+func (s *Service) OnMessage(fn func(*store.Message)) {
+	s.hookMu.Lock()
+	defer s.hookMu.Unlock()
+	s.onMessage = append(s.onMessage, fn)
 }
 
-// --- Pure helper functions (stateless, reusable) ---
-
-const trackingPixelB64 = "R0lGODlhAQABAIAAAAAAAP///yH5BAEAAAAALAAAAAABAAEAAAIBRAA7"
-
-// classifyDeliveryResult determines the message status based on SMTP response.
-func classifyDeliveryResult(err error) (store.MessageStatus, string) {
-	if err == nil {
-		return store.StatusDelivered, ""
+// notifyMessages passes each saved message to the OnMessage hooks.
+func (s *Service) notifyMessages(msgs []*store.Message) {
+	s.hookMu.Lock()
+	hooks := s.onMessage
+	s.hookMu.Unlock()
+	for _, msg := range msgs {
+		for _, fn := range hooks {
+			fn(msg)
+		}
 	}
+}
 
-	errStr := err.Error()
+// newMessageIDs generates n message IDs.
+func newMessageIDs(n int) ([]string, error) {
+	ids := make([]string, n)
+	for i := range ids {
+		id, err := store.GenerateMessageID()
+		if err != nil {
+			return nil, fmt.Errorf("generate message ID: %w", err)
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
 
-	// Permanent failures (5xx) - hard bounce
-	if strings.Contains(errStr, "550") ||
-		strings.Contains(errStr, "551") ||
-		strings.Contains(errStr, "552") ||
-		strings.Contains(errStr, "553") ||
-		strings.Contains(errStr, "554") {
-		return store.StatusBounce, errStr
+// applyGreylist downgrades a delivered status to deferred when the simulated
+// greylist has not seen the recipient recently. Other outcomes pass through.
+func (s *Service) applyGreylist(addr string, status store.MessageStatus, reason string) (store.MessageStatus, string) {
+	if s.greylist == nil || status != store.StatusDelivered {
+		return status, reason
 	}
+	if s.greylist.shouldDefer(addr) {
+		return store.StatusDeferred, greylistReason
+	}
+	return status, reason
+}
 
-	// Temporary failures (4xx) - soft bounce / blocked
-	if strings.Contains(errStr, "421") ||
-		strings.Contains(errStr, "450") ||
-		strings.Contains(errStr, "451") ||
-		strings.Contains(errStr, "452") {
-		return store.StatusBlocked, errStr
+// splitOverQuota moves the recipients that exceed the daily quota out of p,
+// counting the rest against it.
+func (s *Service) splitOverQuota(p objects.Personalization) (within, over objects.Personalization) {
+	if s.quota == nil {
+		return p, objects.Personalization{}
+	}
+	within, over = p, p
+	within.To, over.To = nil, nil
+	for _, to := range p.To {
+		if s.quota.take() {
+			within.To = append(within.To, to)
+		} else {
+			over.To = append(over.To, to)
+		}
 	}
+	return within, over
+}
 
-	// Connection errors - deferred
-	if strings.Contains(errStr, "connection") ||
-		strings.Contains(errStr, "timeout") ||
-		strings.Contains(errStr, "dial") {
-		return store.StatusDeferred, errStr
+// splitThrottled moves the recipients whose domain is over the simulated
+// per-domain rate out of p.
+func (s *Service) splitThrottled(p objects.Personalization) (within, over objects.Personalization) {
+	if s.throttle == nil {
+		return p, objects.Personalization{}
+	}
+	within, over = p, p
+	within.To, over.To = nil, nil
+	for _, to := range p.To {
+		if s.throttle.allow(to.Email) {
+			within.To = append(within.To, to)
+		} else {
+			over.To = append(over.To, to)
+		}
 	}
+	return within, over
+}
 
-	// Default to bounce for unknown errors
-	return store.StatusBounce, errStr
+// trackingBaseURL builds the base URL for tracking endpoints.
+func (s *Service) trackingBaseURL() string {
+	base := s.listenAddr
+	if !strings.HasPrefix(base, "http://") && !strings.HasPrefix(base, "https://") {
+		base = "http://" + strings.ReplaceAll(base, "0.0.0.0", "localhost")
+	}
+	return strings.TrimRight(base, "/")
 }
 
-// writeJSON encodes a response as JSON and writes it to the response writer.
-func writeJSON(w http.ResponseWriter, status int, v interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	if err := json.NewEncoder(w).Encode(v); err != nil {
-		slog.Error("failed to encode response", "err", err)
+// --- Pure helper functions (stateless, reusable) ---
+
+const trackingPixelB64 = "R0lGODlhAQABAIAAAAAAAP///yH5BAEAAAAALAAAAAABAAEAAAIBRAA7"
+
+// splitInvalidRecipients moves To addresses that cannot be parsed out of p.
+// They are returned in their own personalization so they can be recorded as
+// dropped rather than failing the whole send.
+func splitInvalidRecipients(p objects.Personalization) (valid, invalid objects.Personalization) {
+	valid, invalid = p, p
+	valid.To, invalid.To = nil, nil
+	for _, to := range p.To {
+		if _, err := mail.ParseAddress(to.Email); err != nil {
+			invalid.To = append(invalid.To, to)
+			continue
+		}
+		valid.To = append(valid.To, to)
 	}
+	return valid, invalid
 }
 
 // validateContentType checks the Content-Type header and writes an error if invalid.
-func validateContentType(w http.ResponseWriter, r *http.Request, expected string) bool {
+// A missing Content-Type is accepted as expected when assumeMissing is set.
+func validateContentType(w http.ResponseWriter, r *http.Request, expected string, assumeMissing bool) bool {
 	ct := r.Header.Get("Content-Type")
+	if ct == "" && assumeMissing {
+		return true
+	}
 	if ct != expected {
 		slog.Warn("invalid content-type", "got", ct, "expected", expected)
-		writeJSON(w, http.StatusUnsupportedMediaType, objects.GetErrorResponse(
+		httpjson.Write(w, http.StatusUnsupportedMediaType, objects.GetErrorResponse(
 			"Content-Type should be "+expected, nil, nil))
 		return false
 	}
 	return true
 }
 
+// validateContentIDs rejects attachments sharing a non-empty content_id, since
+// a cid: reference to a duplicated id is ambiguous.
+func validateContentIDs(attachments []objects.Attachment) SendResult {
+	seen := make(map[string]struct{}, len(attachments))
+	for i, att := range attachments {
+		if att.ContentId == "" {
+			continue
+		}
+		if _, dup := seen[att.ContentId]; dup {
+			return errorResult(http.StatusBadRequest, objects.GetErrorResponse(
+				"The content_id "+att.ContentId+" is used by more than one attachment.",
+				"attachments."+strconv.Itoa(i)+".content_id",
+				"http://sendgrid.com/docs/API_Reference/Web_API_v3/Mail/errors.html#message.attachments.content_id",
+			))
+		}
+		seen[att.ContentId] = struct{}{}
+	}
+	return acceptedResult()
+}
+
 // decodePostRequest decodes the JSON request body into a PostRequest.
 func decodePostRequest(r *http.Request) (*objects.PostRequest, error) {
 	pr := &objects.PostRequest{}
@@ -393,6 +979,30 @@ func decodePostRequest(r *http.Request) (*objects.PostRequest, error) {
 	return pr, nil
 }
 
+// attachmentMetadata describes the request's attachments without their content.
+func attachmentMetadata(attachments []objects.Attachment) []store.AttachmentMeta {
+	if len(attachments) == 0 {
+		return nil
+	}
+	meta := make([]store.AttachmentMeta, 0, len(attachments))
+	for _, att := range attachments {
+		meta = append(meta, store.AttachmentMeta{
+			Filename:    filepath.Base(att.Filename),
+			Type:        att.Type,
+			Size:        decodedSize(att.Content),
+			Disposition: att.Disposition,
+			ContentID:   att.ContentId,
+		})
+	}
+	return meta
+}
+
+// decodedSize returns the byte length of base64 content without decoding it.
+func decodedSize(b64Content string) int {
+	n := base64.StdEncoding.DecodedLen(len(b64Content))
+	return n - strings.Count(b64Content[max(0, len(b64Content)-2):], "=")
+}
+
 // formatAddress formats an email address with optional name.
 func formatAddress(name, email string) string {
 	if name == "" {
@@ -401,20 +1011,26 @@ func formatAddress(name, email string) string {
 	return name + " <" + email + ">"
 }
 
-// buildReplacer creates a strings.Replacer from a substitution map.
-func buildReplacer(subs map[string]string) *strings.Replacer {
-	pairs := make([]string, 0, len(subs)*2)
+// buildReplacer creates a strings.Replacer from defaults overlaid with subs,
+// so subs wins for keys present in both. With two wrappers, each key only
+// matches when enclosed by them, e.g. %name%.
+func buildReplacer(subs, defaults map[string]string, wrappers []string) *strings.Replacer {
+	var prefix, suffix string
+	if len(wrappers) == 2 {
+		prefix, suffix = wrappers[0], wrappers[1]
+	}
+	pairs := make([]string, 0, (len(subs)+len(defaults))*2)
+	for k, v := range defaults {
+		if _, ok := subs[k]; !ok {
+			pairs = append(pairs, prefix+k+suffix, v)
+		}
+	}
 	for k, v := range subs {
-		pairs = append(pairs, k, v)
+		pairs = append(pairs, prefix+k+suffix, v)
 	}
 	return strings.NewReplacer(pairs...)
 }
 
-// generateTrackingID returns a unique identifier for tracking.
-func generateTrackingID(idx int) string {
-	return strconv.FormatInt(time.Now().UnixNano(), 10) + "-" + strconv.Itoa(idx)
-}
-
 // buildTrackingURL constructs a full tracking URL with query parameters.
 func buildTrackingURL(base, id, to string) string {
 	vals := url.Values{}
@@ -430,10 +1046,14 @@ func ensureHTMLBody(e *email.Email) {
 	}
 }
 
-// injectPixel inserts the tracking pixel before </body> or appends it.
-func injectPixel(e *email.Email, pixel string) {
+// injectPixel replaces the first occurrence of tag with the tracking pixel.
+// Without a tag, or when the body lacks it, the pixel is inserted before
+// </body> or appended.
+func injectPixel(e *email.Email, pixel, tag string) {
 	html := string(e.HTML)
-	if strings.Contains(strings.ToLower(html), "</body>") {
+	if tag != "" && strings.Contains(html, tag) {
+		e.HTML = []byte(strings.Replace(html, tag, pixel, 1))
+	} else if strings.Contains(strings.ToLower(html), "</body>") {
 		e.HTML = []byte(strings.Replace(html, "</body>", pixel+"</body>", 1))
 	} else {
 		e.HTML = []byte(html + pixel)
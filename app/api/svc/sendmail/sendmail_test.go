@@ -3,12 +3,23 @@ package sendmail_test
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"html"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/mustur/mockgrid/app/api/store"
 	"github.com/mustur/mockgrid/app/api/svc/sendmail"
+	"github.com/mustur/mockgrid/app/template"
+	"github.com/mustur/mockgrid/internal/clock"
 	"github.com/mustur/mockgrid/internal/testutil"
 )
 
@@ -86,6 +97,23 @@ func TestAuthMiddleware_MalformedToken_Returns401(t *testing.T) {
 	}
 }
 
+func TestAuthMiddleware_RejectedRequestHasRequestID(t *testing.T) {
+	svc := newTestService(t, "test-secret")
+
+	mux := buildServiceMux(svc)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// Logging runs ahead of auth, so rejected requests are logged too
+	resp := postSend(t, srv.URL, minimalSendPayload(), "")
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("X-Request-ID") == "" {
+		t.Error("expected an X-Request-ID header on the 401 response")
+	}
+}
+
 // --- Route Tests ---
 
 func TestRoutes_PostSend_Exists(t *testing.T) {
@@ -186,6 +214,58 @@ func TestSend_WrongContentType_Returns400(t *testing.T) {
 	}
 }
 
+func TestSend_MissingContentType(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		assumeJSON bool
+		want       int
+	}{
+		{"strict by default", false, http.StatusUnsupportedMediaType},
+		{"assumed JSON", true, http.StatusAccepted},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			host, port := testutil.StartSMTPServer(t)
+			svc, _ := newConfiguredTestService(t, func(cfg *sendmail.Config) {
+				cfg.SMTPServer, cfg.SMTPPort = host, port
+				cfg.AssumeJSON = tc.assumeJSON
+			})
+			srv := httptest.NewServer(buildServiceMux(svc))
+			defer srv.Close()
+
+			body, _ := json.Marshal(minimalSendPayload())
+			req, _ := http.NewRequest("POST", srv.URL+"/send", bytes.NewReader(body))
+			req.Header.Del("Content-Type")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.want {
+				t.Errorf("expected %d, got %d", tc.want, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestSend_AssumeJSON_StillRejectsWrongContentType(t *testing.T) {
+	svc, _ := newConfiguredTestService(t, func(cfg *sendmail.Config) {
+		cfg.AssumeJSON = true
+	})
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	req, _ := http.NewRequest("POST", srv.URL+"/send", bytes.NewReader([]byte("{}")))
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("expected 415, got %d", resp.StatusCode)
+	}
+}
+
 func TestSend_InvalidJSON_Returns400(t *testing.T) {
 	svc := newTestService(t, "")
 
@@ -250,50 +330,1560 @@ func TestSend_MissingPersonalizations_ReturnsValidationError(t *testing.T) {
 	}
 }
 
-// --- Service Configuration Tests ---
+func TestSend_EmptyBody_Returns400(t *testing.T) {
+	svc, st := newConfiguredTestService(t, nil)
 
-func TestService_GetRoot_ReturnsCorrectPath(t *testing.T) {
-	svc := newTestService(t, "")
-	if svc.GetRoot() != "/v3/mail/" {
-		t.Errorf("expected root /v3/mail/, got %q", svc.GetRoot())
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	payload := minimalSendPayload()
+	delete(payload, "content")
+
+	resp := postSend(t, srv.URL, payload, "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", resp.StatusCode)
+	}
+
+	var errResp struct {
+		Errors []struct {
+			Field string `json:"field"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(errResp.Errors) != 1 || errResp.Errors[0].Field != "content" {
+		t.Errorf("expected a single error on field content, got %+v", errResp.Errors)
+	}
+	if n := len(st.Messages()); n != 0 {
+		t.Errorf("expected no stored messages, got %d", n)
 	}
 }
 
-// --- Test Helpers ---
+func TestSend_RecordsAPIKeyIDPerKey(t *testing.T) {
+	st := testutil.NewMockMessageStore()
+	for _, key := range []string{"key-one", "key-two"} {
+		svc := sendmail.New(sendmail.Config{
+			SMTPServer:    "localhost",
+			SMTPPort:      1025,
+			ListenAddr:    ":0",
+			AttachmentDir: t.TempDir(),
+			AuthKey:       key,
+		}, testutil.NewMockTemplater(), st)
+		t.Cleanup(func() { _ = svc.Close() })
+
+		srv := httptest.NewServer(buildServiceMux(svc))
+		postSend(t, srv.URL, minimalSendPayload(), "Bearer "+key).Body.Close()
+		srv.Close()
+	}
 
-func newTestService(t *testing.T, authKey string) *sendmail.Service {
-	t.Helper()
+	msgs := st.Messages()
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 stored messages, got %d", len(msgs))
+	}
+	ids := map[string]bool{}
+	for _, msg := range msgs {
+		if msg.APIKeyID == "" || strings.Contains(msg.APIKeyID, "key-") {
+			t.Errorf("expected a hashed key identifier, got %q", msg.APIKeyID)
+		}
+		ids[msg.APIKeyID] = true
+	}
+	if len(ids) != 2 {
+		t.Errorf("expected distinct key identifiers, got %v", ids)
+	}
+	if !ids[store.KeyID("key-one")] || !ids[store.KeyID("key-two")] {
+		t.Errorf("expected the identifiers of both keys, got %v", ids)
+	}
+}
 
-	cfg := sendmail.Config{
-		SMTPServer:    "localhost",
-		SMTPPort:      1025,
+func TestSend_CapturesConfiguredRequestHeaders(t *testing.T) {
+	svc, st := newConfiguredTestService(t, func(cfg *sendmail.Config) {
+		cfg.AuthKey = "secret"
+		cfg.CaptureHeaders = []string{"user-agent", "On-Behalf-Of"}
+	})
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	body, _ := json.Marshal(minimalSendPayload())
+	req, _ := http.NewRequest("POST", srv.URL+"/send", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("User-Agent", "sendgrid/6.0;go")
+	req.Header.Set("On-Behalf-Of", "subuser")
+	req.Header.Set("X-Other", "ignored")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	msgs := st.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 stored message, got %d", len(msgs))
+	}
+	want := map[string]string{"User-Agent": "sendgrid/6.0;go", "On-Behalf-Of": "subuser"}
+	if !reflect.DeepEqual(msgs[0].RequestHeaders, want) {
+		t.Errorf("expected captured headers %v, got %v", want, msgs[0].RequestHeaders)
+	}
+}
+
+func TestSend_StoresSenderAndRecipientNames(t *testing.T) {
+	svc, st := newConfiguredTestService(t, nil)
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	payload := minimalSendPayload()
+	payload["from"] = map[string]string{"email": "from@example.com", "name": "Ada Sender"}
+	payload["personalizations"] = []map[string]interface{}{
+		{"to": []map[string]string{
+			{"email": "to@example.com", "name": "Bob Recipient"},
+			{"email": "bare@example.com"},
+		}},
+	}
+	postSend(t, srv.URL, payload, "")
+
+	msgs := st.Messages()
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 stored messages, got %d", len(msgs))
+	}
+	names := map[string]string{}
+	for _, m := range msgs {
+		if m.FromEmail != "from@example.com" || m.FromName != "Ada Sender" {
+			t.Errorf("unexpected sender %q <%s>", m.FromName, m.FromEmail)
+		}
+		names[m.ToEmail] = m.ToName
+	}
+	want := map[string]string{"to@example.com": "Bob Recipient", "bare@example.com": ""}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("expected recipient names %v, got %v", want, names)
+	}
+}
+
+func TestSend_InvalidSubstitutionWrappers_Returns400(t *testing.T) {
+	svc, _ := newConfiguredTestService(t, nil)
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	payload := minimalSendPayload()
+	payload["substitution_wrappers"] = []string{"%"}
+
+	resp := postSend(t, srv.URL, payload, "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", resp.StatusCode)
+	}
+
+	var errResp struct {
+		Errors []struct {
+			Field string `json:"field"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(errResp.Errors) != 1 || errResp.Errors[0].Field != "substitution_wrappers" {
+		t.Errorf("expected a single error on substitution_wrappers, got %+v", errResp.Errors)
+	}
+}
+
+func TestSend_EmptyBody_AllowedWhenConfigured(t *testing.T) {
+	host, port := testutil.StartSMTPServer(t)
+	svc, st := newConfiguredTestService(t, func(cfg *sendmail.Config) {
+		cfg.SMTPServer, cfg.SMTPPort = host, port
+		cfg.AllowEmptyBody = true
+	})
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	payload := minimalSendPayload()
+	delete(payload, "content")
+
+	resp := postSend(t, srv.URL, payload, "")
+	if resp.StatusCode != http.StatusAccepted {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("expected 202, got %d: %s", resp.StatusCode, body)
+	}
+	assertSingleStatus(t, st, store.StatusDelivered)
+}
+
+func TestSend_EmptyTo_Returns400(t *testing.T) {
+	svc, st := newConfiguredTestService(t, nil)
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	payload := minimalSendPayload()
+	payload["personalizations"] = []map[string]interface{}{
+		{"to": []map[string]string{{"email": "to@example.com"}}},
+		{"to": []map[string]string{}, "cc": []map[string]string{{"email": "cc@example.com"}}},
+	}
+
+	resp := postSend(t, srv.URL, payload, "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", resp.StatusCode)
+	}
+
+	var errResp struct {
+		Errors []struct {
+			Field string `json:"field"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(errResp.Errors) != 1 || errResp.Errors[0].Field != "personalizations.1.to" {
+		t.Errorf("expected a single error on personalizations.1.to, got %+v", errResp.Errors)
+	}
+	if n := len(st.Messages()); n != 0 {
+		t.Errorf("expected no stored messages, got %d", n)
+	}
+}
+
+func TestSend_CcOnly_DeliveredWhenAllowed(t *testing.T) {
+	host, port, rcpts := testutil.StartRecordingSMTPServer(t)
+	svc, st := newConfiguredTestService(t, func(cfg *sendmail.Config) {
+		cfg.SMTPServer, cfg.SMTPPort = host, port
+		cfg.AllowCcOnly = true
+	})
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	payload := minimalSendPayload()
+	payload["personalizations"] = []map[string]interface{}{
+		{"cc": []map[string]string{{"email": "cc@example.com"}}},
+	}
+
+	resp := postSend(t, srv.URL, payload, "")
+	if resp.StatusCode != http.StatusAccepted {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("expected 202, got %d: %s", resp.StatusCode, body)
+	}
+	if got := rcpts(); !slices.Equal(got, []string{"cc@example.com"}) {
+		t.Errorf("expected delivery to cc@example.com, got %v", got)
+	}
+	assertSingleStatus(t, st, store.StatusDelivered)
+	if got := st.Messages()[0].ToEmail; got != "cc@example.com" {
+		t.Errorf("expected the message stored for cc@example.com, got %q", got)
+	}
+}
+
+func TestSend_CcOnlyWithSendAt_DeliveredWhenDue(t *testing.T) {
+	host, port, rcpts := testutil.StartRecordingSMTPServer(t)
+	clk := clock.NewMockClock(time.Unix(1700000000, 0))
+	svc, st := newConfiguredTestService(t, func(cfg *sendmail.Config) {
+		cfg.SMTPServer, cfg.SMTPPort = host, port
+		cfg.AllowCcOnly = true
+		cfg.Clock = clk
+		cfg.SchedulePollInterval = time.Minute
+	})
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	payload := minimalSendPayload()
+	payload["send_at"] = clk.Now().Add(time.Hour).Unix()
+	payload["personalizations"] = []map[string]interface{}{
+		{"cc": []map[string]string{{"email": "cc@example.com"}}},
+	}
+
+	resp := postSend(t, srv.URL, payload, "")
+	if resp.StatusCode != http.StatusAccepted {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("expected 202, got %d: %s", resp.StatusCode, body)
+	}
+	assertSingleStatus(t, st, store.StatusProcessed)
+
+	clk.Add(time.Hour)
+	assertSingleStatus(t, st, store.StatusDelivered)
+	if got := rcpts(); !slices.Equal(got, []string{"cc@example.com"}) {
+		t.Errorf("expected delivery to cc@example.com, got %v", got)
+	}
+}
+
+func TestSend_AllowedFromDomain_Accepted(t *testing.T) {
+	host, port := testutil.StartSMTPServer(t)
+	svc, st := newConfiguredTestService(t, func(cfg *sendmail.Config) {
+		cfg.SMTPServer, cfg.SMTPPort = host, port
+		cfg.AllowedFromDomains = []string{"other.test", "EXAMPLE.com"}
+	})
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	resp := postSend(t, srv.URL, minimalSendPayload(), "")
+	if resp.StatusCode != http.StatusAccepted {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("expected 202, got %d: %s", resp.StatusCode, body)
+	}
+	assertSingleStatus(t, st, store.StatusDelivered)
+}
+
+func TestSend_DisallowedFromDomain_Returns403(t *testing.T) {
+	svc, st := newConfiguredTestService(t, func(cfg *sendmail.Config) {
+		cfg.AllowedFromDomains = []string{"example.com"}
+	})
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	payload := minimalSendPayload()
+	payload["from"] = map[string]string{"email": "from@sub.example.com"}
+
+	resp := postSend(t, srv.URL, payload, "")
+	if resp.StatusCode != http.StatusForbidden {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("expected 403, got %d: %s", resp.StatusCode, body)
+	}
+
+	var errResp struct {
+		Errors []struct {
+			Field string `json:"field"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(errResp.Errors) != 1 || errResp.Errors[0].Field != "from.email" {
+		t.Errorf("expected a single error on from.email, got %+v", errResp.Errors)
+	}
+	if n := len(st.Messages()); n != 0 {
+		t.Errorf("expected no stored messages, got %d", n)
+	}
+}
+
+// --- Sandbox Mode Tests ---
+
+func TestSend_SandboxMode_RecordsWithoutDelivering(t *testing.T) {
+	// Nothing listens on the configured SMTP port, so a delivery attempt
+	// would fail the send
+	svc, st := newConfiguredTestService(t, func(cfg *sendmail.Config) {
+		cfg.SMTPServer, cfg.SMTPPort = "127.0.0.1", 1
+	})
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	payload := minimalSendPayload()
+	payload["mail_settings"] = map[string]interface{}{"sandbox_mode": map[string]bool{"enable": true}}
+
+	resp := postSend(t, srv.URL, payload, "")
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("expected 200, got %d: %s", resp.StatusCode, body)
+	}
+	var body map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if body["message"] != "Email sent successfully" {
+		t.Errorf("expected the normal success body, got %v", body)
+	}
+	assertSingleStatus(t, st, store.StatusProcessed)
+}
+
+func TestSend_SandboxMode_StillValidates(t *testing.T) {
+	svc, st := newConfiguredTestService(t, nil)
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	payload := minimalSendPayload()
+	payload["mail_settings"] = map[string]interface{}{"sandbox_mode": map[string]bool{"enable": true}}
+	delete(payload, "content")
+
+	resp := postSend(t, srv.URL, payload, "")
+	if resp.StatusCode != http.StatusBadRequest {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("expected 400, got %d: %s", resp.StatusCode, body)
+	}
+	if n := len(st.Messages()); n != 0 {
+		t.Errorf("expected no stored messages, got %d", n)
+	}
+}
+
+func TestSend_SandboxMode_SkipsQuotaAndThrottle(t *testing.T) {
+	host, port := testutil.StartSMTPServer(t)
+	svc, st := newConfiguredTestService(t, func(cfg *sendmail.Config) {
+		cfg.SMTPServer, cfg.SMTPPort = host, port
+		cfg.DailyQuota = 1
+		cfg.PerDomainRPS = 1
+		cfg.Clock = clock.NewMockClock(time.Unix(1700000000, 0))
+	})
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	sandboxed := minimalSendPayload()
+	sandboxed["mail_settings"] = map[string]interface{}{"sandbox_mode": map[string]bool{"enable": true}}
+	for range 3 {
+		st.Reset()
+		postSend(t, srv.URL, sandboxed, "")
+		assertSingleStatus(t, st, store.StatusProcessed)
+	}
+
+	st.Reset()
+	postSend(t, srv.URL, minimalSendPayload(), "")
+	assertSingleStatus(t, st, store.StatusDelivered)
+}
+
+// --- Scheduled Send Tests ---
+
+func TestSend_FutureSendAt_DeliveredWhenDue(t *testing.T) {
+	host, port := testutil.StartSMTPServer(t)
+	start := time.Unix(1700000000, 0)
+	clk := clock.NewMockClock(start)
+	svc, st := newConfiguredTestService(t, func(cfg *sendmail.Config) {
+		cfg.SMTPServer, cfg.SMTPPort = host, port
+		cfg.Clock = clk
+		cfg.SchedulePollInterval = time.Minute
+	})
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	payload := minimalSendPayload()
+	payload["send_at"] = start.Add(time.Hour).Unix()
+	resp := postSend(t, srv.URL, payload, "")
+	if resp.StatusCode != http.StatusAccepted {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("expected 202, got %d: %s", resp.StatusCode, body)
+	}
+
+	// Polls before send_at leave the message alone
+	for range 3 {
+		clk.Add(time.Minute)
+	}
+	assertSingleStatus(t, st, store.StatusProcessed)
+
+	// The poll fired by advancing the clock delivers synchronously
+	clk.Add(time.Hour)
+	assertSingleStatus(t, st, store.StatusDelivered)
+	msg := st.Messages()[0]
+	if msg.Timestamp != start.Unix() {
+		t.Errorf("expected the send time %d as timestamp, got %d", start.Unix(), msg.Timestamp)
+	}
+	if want := clk.Now().Unix(); msg.LastEventTime != want {
+		t.Errorf("expected the delivery time %d as last event time, got %d", want, msg.LastEventTime)
+	}
+}
+
+func TestSend_FutureSendAt_DeliversEveryPage(t *testing.T) {
+	host, port := testutil.StartSMTPServer(t)
+	clk := clock.NewMockClock(time.Unix(1700000000, 0))
+	svc, st := newConfiguredTestService(t, func(cfg *sendmail.Config) {
+		cfg.SMTPServer, cfg.SMTPPort = host, port
+		cfg.Clock = clk
+		cfg.SchedulePollInterval = time.Minute
+	})
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	// More recipients than the scheduler reads from the store at a time
+	to := make([]map[string]string, 250)
+	for i := range to {
+		to[i] = map[string]string{"email": fmt.Sprintf("to%d@example.com", i)}
+	}
+	payload := minimalSendPayload()
+	payload["send_at"] = clk.Now().Add(time.Hour).Unix()
+	payload["personalizations"] = []map[string]interface{}{{"to": to}}
+	postSend(t, srv.URL, payload, "")
+
+	clk.Add(time.Hour)
+	msgs := st.Messages()
+	if len(msgs) != len(to) {
+		t.Fatalf("expected %d stored messages, got %d", len(to), len(msgs))
+	}
+	for _, msg := range msgs {
+		if msg.Status != store.StatusDelivered {
+			t.Fatalf("expected every message delivered, %s is %q", msg.ToEmail, msg.Status)
+		}
+	}
+}
+
+func TestSend_FutureSendAt_DeliversCcAndAttachmentsIntact(t *testing.T) {
+	host, port, mails := testutil.StartCapturingSMTPServer(t)
+	clk := clock.NewMockClock(time.Unix(1700000000, 0))
+	svc, st := newConfiguredTestService(t, func(cfg *sendmail.Config) {
+		cfg.SMTPServer, cfg.SMTPPort = host, port
+		cfg.Clock = clk
+		cfg.SchedulePollInterval = time.Minute
+	})
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	payload := minimalSendPayload()
+	payload["send_at"] = clk.Now().Add(time.Hour).Unix()
+	payload["personalizations"] = []map[string]interface{}{{
+		"to": []map[string]string{{"email": "to@example.com"}},
+		"cc": []map[string]string{{"email": "cc@example.com"}},
+	}}
+	payload["headers"] = map[string]string{"X-Campaign": "spring"}
+	payload["attachments"] = []map[string]string{
+		{"content": "aGVsbG8gd29ybGQ=", "filename": "hello.txt", "type": "text/plain"},
+	}
+	resp := postSend(t, srv.URL, payload, "")
+	if resp.StatusCode != http.StatusAccepted {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("expected 202, got %d: %s", resp.StatusCode, body)
+	}
+
+	clk.Add(time.Hour)
+	assertSingleStatus(t, st, store.StatusDelivered)
+
+	got := mails()
+	if len(got) != 1 {
+		t.Fatalf("expected one delivery, got %d", len(got))
+	}
+	if want := []string{"to@example.com", "cc@example.com"}; !slices.Equal(got[0].Rcpts, want) {
+		t.Errorf("expected recipients %v, got %v", want, got[0].Rcpts)
+	}
+	for _, want := range []string{"Cc: <cc@example.com>", "X-Campaign: spring", `filename="hello.txt"`, "aGVsbG8gd29ybGQ="} {
+		if !strings.Contains(got[0].Data, want) {
+			t.Errorf("expected delivered mail to contain %q:\n%s", want, got[0].Data)
+		}
+	}
+}
+
+func TestSend_PastSendAt_DeliversImmediately(t *testing.T) {
+	host, port := testutil.StartSMTPServer(t)
+	clk := clock.NewMockClock(time.Unix(1700000000, 0))
+	svc, st := newConfiguredTestService(t, func(cfg *sendmail.Config) {
+		cfg.SMTPServer, cfg.SMTPPort = host, port
+		cfg.Clock = clk
+	})
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	payload := minimalSendPayload()
+	payload["send_at"] = clk.Now().Add(-time.Hour).Unix()
+	postSend(t, srv.URL, payload, "")
+	assertSingleStatus(t, st, store.StatusDelivered)
+}
+
+func TestClose_IsIdempotent(t *testing.T) {
+	svc, _ := newConfiguredTestService(t, nil)
+	if err := svc.Close(); err != nil {
+		t.Fatalf("first Close: %v", err)
+	}
+	if err := svc.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+}
+
+// --- Template Tests ---
+
+func TestSend_BesteffortTemplateUnavailable_SendsProvidedContent(t *testing.T) {
+	host, port := testutil.StartSMTPServer(t)
+	st := testutil.NewMockMessageStore()
+	svc := sendmail.New(sendmail.Config{
+		SMTPServer:    host,
+		SMTPPort:      port,
 		ListenAddr:    ":0",
 		AttachmentDir: t.TempDir(),
-		AuthKey:       authKey,
-	}
+	}, template.NewBesteffortTemplate(t.TempDir(), "", "", ""), st)
+	t.Cleanup(func() { _ = svc.Close() })
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	payload := minimalSendPayload()
+	payload["template_id"] = "d-missing"
 
-	return sendmail.New(cfg, testutil.NewMockTemplater(), testutil.NewMockMessageStore())
+	resp := postSend(t, srv.URL, payload, "")
+	if resp.StatusCode != http.StatusAccepted {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("expected 202, got %d: %s", resp.StatusCode, body)
+	}
+	assertSingleStatus(t, st, store.StatusDelivered)
+	if got := st.Messages()[0].TextBody; !strings.Contains(got, "Test body") {
+		t.Errorf("expected the provided content to be sent, got %q", got)
+	}
 }
 
-// buildServiceMux applies the service's middleware chain to the mux.
-// This simulates how MockGrid builds the route with StripPrefix.
-func buildServiceMux(svc *sendmail.Service) *http.ServeMux {
-	// The service mux is used directly without StripPrefix here because
-	// we're testing the service in isolation. The root path stripping is
-	// tested in api_test.go.
-	chain := svc.Chain()
-	rawMux := svc.GetMux()
+func TestSend_BesteffortTemplateUnavailable_FailMode_Returns500(t *testing.T) {
+	st := testutil.NewMockMessageStore()
+	svc := sendmail.New(sendmail.Config{
+		SMTPServer:            "localhost",
+		SMTPPort:              1025,
+		ListenAddr:            ":0",
+		AttachmentDir:         t.TempDir(),
+		FailOnMissingTemplate: true,
+	}, template.NewBesteffortTemplate(t.TempDir(), "", "", ""), st)
+	t.Cleanup(func() { _ = svc.Close() })
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
 
-	// Wrap with chain
-	wrappedMux := http.NewServeMux()
-	wrappedMux.Handle("/", chain(rawMux))
+	payload := minimalSendPayload()
+	payload["template_id"] = "d-missing"
 
-	return wrappedMux
+	resp := postSend(t, srv.URL, payload, "")
+	if resp.StatusCode != http.StatusInternalServerError {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("expected 500, got %d: %s", resp.StatusCode, body)
+	}
+	if n := len(st.Messages()); n != 0 {
+		t.Errorf("expected no stored messages, got %d", n)
+	}
 }
 
-func minimalSendPayload() map[string]interface{} {
-	return map[string]interface{}{
-		"from": map[string]string{"email": "from@example.com"},
+// --- Attachment Tests ---
+
+func TestSend_DuplicateContentID_Returns400(t *testing.T) {
+	svc := newTestService(t, "")
+
+	mux := buildServiceMux(svc)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	payload := minimalSendPayload()
+	payload["attachments"] = []map[string]string{
+		{"content": "aGVsbG8=", "filename": "a.png", "disposition": "inline", "content_id": "logo"},
+		{"content": "aGVsbG8=", "filename": "b.png", "disposition": "inline", "content_id": "logo"},
+	}
+
+	resp := postSend(t, srv.URL, payload, "")
+	if resp.StatusCode != http.StatusBadRequest {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("expected 400, got %d: %s", resp.StatusCode, body)
+	}
+
+	var errResp struct {
+		Errors []struct {
+			Field string `json:"field"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+		t.Fatalf("failed to decode error response: %v", err)
+	}
+	if len(errResp.Errors) != 1 || errResp.Errors[0].Field != "attachments.1.content_id" {
+		t.Errorf("expected field attachments.1.content_id, got %+v", errResp.Errors)
+	}
+}
+
+func TestSend_UniqueContentIDs_PassValidation(t *testing.T) {
+	svc := newTestService(t, "")
+
+	mux := buildServiceMux(svc)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	payload := minimalSendPayload()
+	payload["attachments"] = []map[string]string{
+		{"content": "aGVsbG8=", "filename": "a.png", "disposition": "inline", "content_id": "logo"},
+		{"content": "aGVsbG8=", "filename": "b.png", "disposition": "inline", "content_id": "banner"},
+		{"content": "aGVsbG8=", "filename": "c.txt"},
+		{"content": "aGVsbG8=", "filename": "d.txt"},
+	}
+
+	// SMTP is not reachable in tests, so anything but a 400 means validation passed
+	resp := postSend(t, srv.URL, payload, "")
+	if resp.StatusCode == http.StatusBadRequest {
+		body, _ := io.ReadAll(resp.Body)
+		t.Errorf("expected unique content ids to pass validation, got 400: %s", body)
+	}
+}
+
+func TestSend_StoresAttachmentMetadataWithoutContent(t *testing.T) {
+	svc, st := newConfiguredTestService(t, nil)
+
+	mux := buildServiceMux(svc)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	content := "aGVsbG8gd29ybGQ=" // "hello world"
+	payload := minimalSendPayload()
+	payload["attachments"] = []map[string]string{
+		{"content": content, "type": "text/plain", "filename": "hello.txt", "disposition": "attachment"},
+	}
+
+	postSend(t, srv.URL, payload, "")
+
+	msgs := st.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 stored message, got %d", len(msgs))
+	}
+	atts := msgs[0].Attachments
+	if len(atts) != 1 {
+		t.Fatalf("expected 1 attachment, got %d", len(atts))
+	}
+	if atts[0].Filename != "hello.txt" || atts[0].Type != "text/plain" || atts[0].Size != 11 || atts[0].Disposition != "attachment" {
+		t.Errorf("unexpected attachment metadata: %+v", atts[0])
+	}
+
+	data, err := json.Marshal(msgs[0])
+	if err != nil {
+		t.Fatalf("failed to marshal message: %v", err)
+	}
+	if strings.Contains(string(data), content) {
+		t.Error("stored message must not contain attachment content")
+	}
+}
+
+// --- Tracking Tests ---
+
+func TestTrackOpen_MissingID_ServesPixelWithoutCounting(t *testing.T) {
+	svc, st := newConfiguredTestService(t, nil)
+	st.SaveMSG(&store.Message{MsgID: "msg-1", ToEmail: "to@example.com"})
+	logs := captureLogs(t)
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	assertPixel(t, srv.URL+"/track/open?to=to@example.com")
+	if n := st.Messages()[0].OpensCount; n != 0 {
+		t.Errorf("expected no open recorded, got %d", n)
+	}
+	out := logs.String()
+	if !strings.Contains(out, `level=DEBUG msg="open tracked without a message id"`) || strings.Contains(out, "level=WARN") {
+		t.Errorf("expected a missing id to log at debug only, got:\n%s", out)
+	}
+}
+
+func TestTrackOpen_UnknownID_ServesPixelAndWarns(t *testing.T) {
+	svc, st := newConfiguredTestService(t, nil)
+	st.SaveMSG(&store.Message{MsgID: "msg-1", ToEmail: "to@example.com"})
+	logs := captureLogs(t)
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	assertPixel(t, srv.URL+"/track/open?id=msg-unknown&to=to@example.com")
+	if msgs := st.Messages(); len(msgs) != 1 || msgs[0].OpensCount != 0 {
+		t.Errorf("expected nothing recorded, got %+v", msgs)
+	}
+	if out := logs.String(); !strings.Contains(out, "level=WARN") || !strings.Contains(out, "id=msg-unknown") {
+		t.Errorf("expected a warning for the unknown id, got:\n%s", out)
+	}
+}
+
+func TestSend_OpenTrackingDisabled_NoPixelInjected(t *testing.T) {
+	svc, st := newConfiguredTestService(t, func(cfg *sendmail.Config) {
+		cfg.DisableOpenTracking = true
+	})
+
+	mux := buildServiceMux(svc)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	html := "<html><body><p>Golden</p></body></html>"
+	payload := minimalSendPayload()
+	payload["content"] = []map[string]string{{"type": "text/html", "value": html}}
+
+	postSend(t, srv.URL, payload, "")
+
+	msgs := st.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 stored message, got %d", len(msgs))
+	}
+	if msgs[0].HTMLBody != html {
+		t.Errorf("expected HTML body unchanged, got %q", msgs[0].HTMLBody)
+	}
+}
+
+func TestSend_OpenTrackingEnabled_InjectsPixel(t *testing.T) {
+	svc, st := newConfiguredTestService(t, nil)
+
+	mux := buildServiceMux(svc)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	payload := minimalSendPayload()
+	payload["content"] = []map[string]string{{"type": "text/html", "value": "<html><body><p>Hi</p></body></html>"}}
+
+	postSend(t, srv.URL, payload, "")
+
+	msgs := st.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 stored message, got %d", len(msgs))
+	}
+	if !strings.Contains(msgs[0].HTMLBody, "/v3/mail/track/open?") {
+		t.Errorf("expected tracking pixel in HTML body, got %q", msgs[0].HTMLBody)
+	}
+}
+
+func TestSend_SkipPlainTextTracking_StaysTextOnly(t *testing.T) {
+	svc, st := newConfiguredTestService(t, func(cfg *sendmail.Config) {
+		cfg.SkipPlainTextTracking = true
+	})
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	payload := minimalSendPayload()
+	payload["content"] = []map[string]string{{"type": "text/plain", "value": "Just text"}}
+
+	postSend(t, srv.URL, payload, "")
+
+	msgs := st.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 stored message, got %d", len(msgs))
+	}
+	if msgs[0].HTMLBody != "" {
+		t.Errorf("expected no HTML part, got %q", msgs[0].HTMLBody)
+	}
+	if msgs[0].TextBody != "Just text" {
+		t.Errorf("expected text body unchanged, got %q", msgs[0].TextBody)
+	}
+}
+
+func TestSend_HTMLToTextMode_GeneratesReadableText(t *testing.T) {
+	svc, st := newConfiguredTestService(t, func(cfg *sendmail.Config) {
+		cfg.HTMLToTextMode = "readable"
+	})
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	payload := minimalSendPayload()
+	payload["content"] = []map[string]string{{"type": "text/html",
+		"value": `<p>Hello,</p><p>See <a href="https://example.com/offer">the offer</a>.</p>`}}
+
+	postSend(t, srv.URL, payload, "")
+
+	msgs := st.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 stored message, got %d", len(msgs))
+	}
+	if want := "Hello,\n\nSee the offer (https://example.com/offer)."; msgs[0].TextBody != want {
+		t.Errorf("expected text body %q, got %q", want, msgs[0].TextBody)
+	}
+	if !strings.Contains(msgs[0].HTMLBody, "/v3/mail/track/click?") {
+		t.Errorf("expected the HTML link to still be tracked, got %q", msgs[0].HTMLBody)
+	}
+}
+
+func TestSend_ClickTracking_RewritesLinksAndRedirects(t *testing.T) {
+	svc, st := newConfiguredTestService(t, nil)
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	body := `<html><body><a href="https://example.com/page?ref=mail&amp;x=1">Go</a> ` +
+		`<a href="mailto:help@example.com">Mail</a> <a href='#top'>Top</a></body></html>`
+	payload := minimalSendPayload()
+	payload["content"] = []map[string]string{{"type": "text/html", "value": body}}
+
+	postSend(t, srv.URL, payload, "")
+
+	msgs := st.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 stored message, got %d", len(msgs))
+	}
+	stored := msgs[0].HTMLBody
+	for _, kept := range []string{`href="mailto:help@example.com"`, `href='#top'`, "/v3/mail/track/open?"} {
+		if !strings.Contains(stored, kept) {
+			t.Errorf("expected %q to be kept, got %q", kept, stored)
+		}
+	}
+	if strings.Contains(stored, `href="https://example.com/page`) {
+		t.Errorf("expected the http link to be rewritten, got %q", stored)
+	}
+
+	m := regexp.MustCompile(`href="[^"]*(/v3/mail/track/click\?[^"]*)"`).FindStringSubmatch(stored)
+	if m == nil {
+		t.Fatalf("expected a click tracking link, got %q", stored)
+	}
+	clickPath := strings.TrimPrefix(html.UnescapeString(m[1]), "/v3/mail")
+
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := noRedirect.Get(srv.URL + clickPath)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("expected 302, got %d", resp.StatusCode)
+	}
+	if loc := resp.Header.Get("Location"); loc != "https://example.com/page?ref=mail&x=1" {
+		t.Errorf("expected redirect to the original URL with its query, got %q", loc)
+	}
+	if got := st.Messages()[0].ClicksCount; got != 1 {
+		t.Errorf("expected 1 click recorded, got %d", got)
+	}
+}
+
+func TestTrackClick_NonHTTPURL_Returns400(t *testing.T) {
+	svc, _ := newConfiguredTestService(t, nil)
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/track/click?url=javascript:alert(1)&msg_id=x")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", resp.StatusCode)
+	}
+}
+
+func TestTrackClick_UnsignedOrTamperedLink_Returns400(t *testing.T) {
+	svc, st := newConfiguredTestService(t, func(cfg *sendmail.Config) {
+		cfg.ClickSecret = "click-secret"
+	})
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	payload := minimalSendPayload()
+	payload["content"] = []map[string]string{{"type": "text/html", "value": `<a href="https://example.com/">Go</a>`}}
+	postSend(t, srv.URL, payload, "")
+
+	m := regexp.MustCompile(`href="[^"]*(/v3/mail/track/click\?[^"]*)"`).FindStringSubmatch(st.Messages()[0].HTMLBody)
+	if m == nil {
+		t.Fatalf("expected a click tracking link, got %q", st.Messages()[0].HTMLBody)
+	}
+	signed := strings.TrimPrefix(html.UnescapeString(m[1]), "/v3/mail")
+
+	for name, path := range map[string]string{
+		"unsigned": "/track/click?url=https%3A%2F%2Fevil.example%2F&msg_id=x&to=a%40example.com",
+		"tampered": strings.Replace(signed, "url=https%3A%2F%2Fexample.com", "url=https%3A%2F%2Fevil.example", 1),
+	} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", name, resp.StatusCode)
+		}
+	}
+	if got := st.Messages()[0].ClicksCount; got != 0 {
+		t.Errorf("expected no clicks recorded, got %d", got)
+	}
+}
+
+func TestTrackClick_DefaultKey_SurvivesRestart(t *testing.T) {
+	cfg := sendmail.Config{
+		SMTPServer:    "localhost",
+		SMTPPort:      1025,
+		ListenAddr:    ":0",
+		AttachmentDir: t.TempDir(),
+	}
+	st := testutil.NewMockMessageStore()
+	before := sendmail.New(cfg, testutil.NewMockTemplater(), st)
+	t.Cleanup(func() { _ = before.Close() })
+
+	srv := httptest.NewServer(buildServiceMux(before))
+	payload := minimalSendPayload()
+	payload["content"] = []map[string]string{{"type": "text/html", "value": `<a href="https://example.com/">Go</a>`}}
+	postSend(t, srv.URL, payload, "")
+	srv.Close()
+
+	m := regexp.MustCompile(`href="[^"]*(/v3/mail/track/click\?[^"]*)"`).FindStringSubmatch(st.Messages()[0].HTMLBody)
+	if m == nil {
+		t.Fatalf("expected a click tracking link, got %q", st.Messages()[0].HTMLBody)
+	}
+
+	// A new service with the same config must accept the old link
+	after := sendmail.New(cfg, testutil.NewMockTemplater(), st)
+	t.Cleanup(func() { _ = after.Close() })
+	srv = httptest.NewServer(buildServiceMux(after))
+	defer srv.Close()
+
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := noRedirect.Get(srv.URL + strings.TrimPrefix(html.UnescapeString(m[1]), "/v3/mail"))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Errorf("expected 302 after a restart, got %d", resp.StatusCode)
+	}
+}
+
+func TestSend_ClickTrackingDisabled_LinksUnchanged(t *testing.T) {
+	svc, st := newConfiguredTestService(t, func(cfg *sendmail.Config) {
+		cfg.DisableClickTracking = true
+	})
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	payload := minimalSendPayload()
+	payload["content"] = []map[string]string{{"type": "text/html", "value": `<a href="https://example.com/">Go</a>`}}
+
+	postSend(t, srv.URL, payload, "")
+
+	msgs := st.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 stored message, got %d", len(msgs))
+	}
+	if !strings.Contains(msgs[0].HTMLBody, `href="https://example.com/"`) {
+		t.Errorf("expected link unchanged, got %q", msgs[0].HTMLBody)
+	}
+}
+
+func TestSend_TrackingSettingsDisabled_BodyUnchanged(t *testing.T) {
+	svc, st := newConfiguredTestService(t, nil)
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	body := `<html><body><a href="https://example.com/">Go</a></body></html>`
+	payload := minimalSendPayload()
+	payload["content"] = []map[string]string{{"type": "text/html", "value": body}}
+	payload["tracking_settings"] = map[string]any{
+		"open_tracking":  map[string]any{"enable": false},
+		"click_tracking": map[string]any{"enable": false},
+	}
+
+	postSend(t, srv.URL, payload, "")
+
+	msgs := st.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 stored message, got %d", len(msgs))
+	}
+	if msgs[0].HTMLBody != body {
+		t.Errorf("expected HTML body unchanged, got %q", msgs[0].HTMLBody)
+	}
+}
+
+func TestSend_TrackingSettingsEnabled_OverridesGlobalDisable(t *testing.T) {
+	svc, st := newConfiguredTestService(t, func(cfg *sendmail.Config) {
+		cfg.DisableOpenTracking = true
+		cfg.DisableClickTracking = true
+	})
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	payload := minimalSendPayload()
+	payload["content"] = []map[string]string{{"type": "text/html", "value": `<html><body><a href="https://example.com/">Go</a></body></html>`}}
+	payload["tracking_settings"] = map[string]any{
+		"open_tracking":  map[string]any{"enable": true},
+		"click_tracking": map[string]any{"enable": true},
+	}
+
+	postSend(t, srv.URL, payload, "")
+
+	msgs := st.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 stored message, got %d", len(msgs))
+	}
+	for _, want := range []string{"/v3/mail/track/open?", "/v3/mail/track/click?"} {
+		if !strings.Contains(msgs[0].HTMLBody, want) {
+			t.Errorf("expected %q in HTML body, got %q", want, msgs[0].HTMLBody)
+		}
+	}
+}
+
+func TestSend_OpenTrackingSubstitutionTag_PixelAtMarker(t *testing.T) {
+	svc, st := newConfiguredTestService(t, nil)
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	payload := minimalSendPayload()
+	payload["content"] = []map[string]string{{"type": "text/html", "value": "<html><body><p>[pixel]</p><p>Bye</p></body></html>"}}
+	payload["tracking_settings"] = map[string]any{
+		"open_tracking": map[string]any{"enable": true, "substitution_tag": "[pixel]"},
+	}
+
+	postSend(t, srv.URL, payload, "")
+
+	msgs := st.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 stored message, got %d", len(msgs))
+	}
+	stored := msgs[0].HTMLBody
+	if strings.Contains(stored, "[pixel]") {
+		t.Errorf("expected the marker to be replaced, got %q", stored)
+	}
+	if !regexp.MustCompile(`^<html><body><p><img src="[^"]*/v3/mail/track/open\?[^"]*"[^>]*/></p><p>Bye</p></body></html>$`).MatchString(stored) {
+		t.Errorf("expected the pixel at the marker, got %q", stored)
+	}
+}
+
+// --- Message Hook Tests ---
+
+func TestOnMessage_CalledForEachStoredRecipient(t *testing.T) {
+	host, port := testutil.StartSMTPServer(t)
+	svc, _ := newConfiguredTestService(t, func(cfg *sendmail.Config) {
+		cfg.SMTPServer, cfg.SMTPPort = host, port
+	})
+	var got []*store.Message
+	svc.OnMessage(func(msg *store.Message) { got = append(got, msg) })
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	payload := minimalSendPayload()
+	payload["personalizations"] = []map[string]interface{}{
+		{"to": []map[string]string{{"email": "a@example.com"}, {"email": "b@example.com"}}},
+		{"to": []map[string]string{{"email": "not-an-address"}}},
+	}
+	postSend(t, srv.URL, payload, "").Body.Close()
+
+	want := map[string]store.MessageStatus{
+		"a@example.com":  store.StatusDelivered,
+		"b@example.com":  store.StatusDelivered,
+		"not-an-address": store.StatusDropped,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d hook calls, got %d", len(want), len(got))
+	}
+	for _, msg := range got {
+		if msg.Status != want[msg.ToEmail] {
+			t.Errorf("expected %s for %q, got %s", want[msg.ToEmail], msg.ToEmail, msg.Status)
+		}
+	}
+}
+
+func TestOnMessage_CalledOnResend(t *testing.T) {
+	host, port := testutil.StartSMTPServer(t)
+	svc, st := newConfiguredTestService(t, func(cfg *sendmail.Config) {
+		cfg.SMTPServer, cfg.SMTPPort = host, port
+	})
+	failed := testutil.NewMessageBuilder("msg-1").
+		WithStatus(store.StatusBounce).
+		WithTextBody("hello").
+		Build()
+	if err := st.SaveMSG(failed); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	var got []*store.Message
+	svc.OnMessage(func(msg *store.Message) { got = append(got, msg) })
+
+	if _, err := svc.Resend(failed); err != nil {
+		t.Fatalf("Resend failed: %v", err)
+	}
+	if len(got) != 1 || got[0].MsgID != "msg-1" || got[0].Status != store.StatusDelivered {
+		t.Errorf("expected one hook call for delivered msg-1, got %+v", got)
+	}
+}
+
+// --- Dropped Tests ---
+
+func TestSend_InvalidRecipient_DroppedWithCanonicalReason(t *testing.T) {
+	host, port := testutil.StartSMTPServer(t)
+	d := testutil.NewRecordingDispatcher()
+	wrapper := store.NewStoreWrapper(testutil.NewMockMessageStore(), d)
+	svc := sendmail.New(sendmail.Config{
+		SMTPServer:    host,
+		SMTPPort:      port,
+		ListenAddr:    ":0",
+		AttachmentDir: t.TempDir(),
+	}, testutil.NewMockTemplater(), wrapper)
+	t.Cleanup(func() { _ = svc.Close() })
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	payload := minimalSendPayload()
+	payload["personalizations"] = []map[string]interface{}{
+		{"to": []map[string]string{{"email": "to@example.com"}, {"email": "not an address"}}},
+		{"to": []map[string]string{{"email": "@@"}}},
+	}
+	resp := postSend(t, srv.URL, payload, "")
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", resp.StatusCode)
+	}
+
+	got := map[string]testutil.DispatchedEvent{}
+	for _, ev := range d.Events() {
+		got[ev.Email] = ev
+	}
+	if ev := got["to@example.com"]; ev.Status != string(store.StatusDelivered) {
+		t.Errorf("expected valid recipient delivered, got %+v", ev)
+	}
+	for _, addr := range []string{"not an address", "@@"} {
+		ev := got[addr]
+		if ev.Status != string(store.StatusDropped) || ev.Reason != store.DropReasonInvalid {
+			t.Errorf("%q: expected dropped with reason %q, got %+v", addr, store.DropReasonInvalid, ev)
+		}
+	}
+}
+
+func TestSend_VerboseResponse_ListsEachRecipient(t *testing.T) {
+	host, port := testutil.StartSMTPServer(t)
+	svc, _ := newConfiguredTestService(t, func(cfg *sendmail.Config) {
+		cfg.SMTPServer, cfg.SMTPPort = host, port
+		cfg.VerboseResponses = true
+	})
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	payload := minimalSendPayload()
+	payload["personalizations"] = []map[string]interface{}{
+		{"to": []map[string]string{{"email": "to@example.com"}, {"email": "not an address"}}},
+	}
+	resp := postSend(t, srv.URL, payload, "")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Recipients []sendmail.RecipientResult `json:"recipients"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	got := map[string]store.MessageStatus{}
+	for _, r := range body.Recipients {
+		if r.MsgID == "" {
+			t.Errorf("recipient %q has no msg_id", r.Email)
+		}
+		got[r.Email] = r.Status
+	}
+	want := map[string]store.MessageStatus{"to@example.com": store.StatusDelivered, "not an address": store.StatusDropped}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected recipients %v, got %v", want, got)
+	}
+}
+
+// --- IP Pool Tests ---
+
+func TestSend_IPPool_RoutesToPoolSMTPAndEchoesPool(t *testing.T) {
+	host, port := testutil.StartSMTPServer(t)
+	d := testutil.NewRecordingDispatcher()
+	st := testutil.NewMockMessageStore()
+	svc := sendmail.New(sendmail.Config{
+		SMTPServer:    "127.0.0.1",
+		SMTPPort:      1, // nothing listens here, so only pooled sends deliver
+		ListenAddr:    ":0",
+		AttachmentDir: t.TempDir(),
+		IPPools:       map[string]string{"marketing": fmt.Sprintf("%s:%d", host, port)},
+	}, testutil.NewMockTemplater(), store.NewStoreWrapper(st, d))
+	t.Cleanup(func() { _ = svc.Close() })
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	payload := minimalSendPayload()
+	payload["ip_pool_name"] = "marketing"
+	postSend(t, srv.URL, payload, "")
+
+	events := d.Events()
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(events))
+	}
+	if events[0].Status != string(store.StatusDelivered) || events[0].Pool != "marketing" {
+		t.Errorf("expected delivered event from pool marketing, got %+v", events[0])
+	}
+	if msgs := st.Messages(); len(msgs) != 1 || msgs[0].IPPool != "marketing" {
+		t.Errorf("expected stored message with ip pool marketing, got %+v", msgs)
+	}
+
+	// Unknown pools fall back to the default server
+	st.Reset()
+	payload["ip_pool_name"] = "unknown"
+	postSend(t, srv.URL, payload, "")
+	if msgs := st.Messages(); len(msgs) != 1 || msgs[0].Status == store.StatusDelivered {
+		t.Errorf("expected send through the default server to fail, got %+v", msgs)
+	}
+}
+
+// --- Quota Tests ---
+
+func TestSend_DailyQuota_DropsUntilDayRollsOver(t *testing.T) {
+	host, port := testutil.StartSMTPServer(t)
+	clk := clock.NewMockClock(time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC))
+	svc, st := newConfiguredTestService(t, func(cfg *sendmail.Config) {
+		cfg.SMTPServer, cfg.SMTPPort = host, port
+		cfg.DailyQuota = 2
+		cfg.Clock = clk
+	})
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	for range 2 {
+		st.Reset()
+		postSend(t, srv.URL, minimalSendPayload(), "")
+		assertSingleStatus(t, st, store.StatusDelivered)
+	}
+
+	st.Reset()
+	postSend(t, srv.URL, minimalSendPayload(), "")
+	assertSingleStatus(t, st, store.StatusDropped)
+	if reason := st.Messages()[0].Reason; reason != store.DropReasonQuota {
+		t.Errorf("expected reason %q, got %q", store.DropReasonQuota, reason)
+	}
+
+	st.Reset()
+	clk.Add(24 * time.Hour)
+	postSend(t, srv.URL, minimalSendPayload(), "")
+	assertSingleStatus(t, st, store.StatusDelivered)
+}
+
+func TestResend_OverDailyQuota_Dropped(t *testing.T) {
+	host, port := testutil.StartSMTPServer(t)
+	svc, st := newConfiguredTestService(t, func(cfg *sendmail.Config) {
+		cfg.SMTPServer, cfg.SMTPPort = host, port
+		cfg.DailyQuota = 1
+	})
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	postSend(t, srv.URL, minimalSendPayload(), "")
+	assertSingleStatus(t, st, store.StatusDelivered)
+
+	got, err := svc.Resend(st.Messages()[0])
+	if err != nil {
+		t.Fatalf("Resend failed: %v", err)
+	}
+	if got.Status != store.StatusDropped || got.Reason != store.DropReasonQuota {
+		t.Errorf("expected the resend dropped over quota, got %s (%q)", got.Status, got.Reason)
+	}
+}
+
+// --- Throttle Tests ---
+
+func TestSend_PerDomainRPS_DefersExcessForThatDomainOnly(t *testing.T) {
+	host, port := testutil.StartSMTPServer(t)
+	d := testutil.NewRecordingDispatcher()
+	clk := clock.NewMockClock(time.Unix(1700000000, 0))
+	svc := sendmail.New(sendmail.Config{
+		SMTPServer:    host,
+		SMTPPort:      port,
+		ListenAddr:    ":0",
+		AttachmentDir: t.TempDir(),
+		PerDomainRPS:  2,
+		Clock:         clk,
+	}, testutil.NewMockTemplater(), store.NewStoreWrapper(testutil.NewMockMessageStore(), d))
+	t.Cleanup(func() { _ = svc.Close() })
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	payload := minimalSendPayload()
+	payload["personalizations"] = []map[string]interface{}{{"to": []map[string]string{
+		{"email": "a@busy.test"}, {"email": "b@busy.test"}, {"email": "c@BUSY.test"}, {"email": "d@busy.test"},
+		{"email": "a@quiet.test"},
+	}}}
+	if resp := postSend(t, srv.URL, payload, ""); resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", resp.StatusCode)
+	}
+
+	got := map[string]testutil.DispatchedEvent{}
+	for _, ev := range d.Events() {
+		got[ev.Email] = ev
+	}
+	for _, addr := range []string{"a@busy.test", "b@busy.test", "a@quiet.test"} {
+		if ev := got[addr]; ev.Status != string(store.StatusDelivered) {
+			t.Errorf("%s: expected delivered, got %+v", addr, ev)
+		}
+	}
+	for _, addr := range []string{"c@BUSY.test", "d@busy.test"} {
+		ev := got[addr]
+		if ev.Status != string(store.StatusDeferred) || !strings.HasPrefix(ev.Reason, "421 ") {
+			t.Errorf("%s: expected deferred with a 421 reason, got %+v", addr, ev)
+		}
+	}
+
+	// The bucket refills with time
+	clk.Add(time.Second)
+	payload["personalizations"] = []map[string]interface{}{{"to": []map[string]string{{"email": "e@busy.test"}}}}
+	postSend(t, srv.URL, payload, "")
+	events := d.Events()
+	if last := events[len(events)-1]; last.Email != "e@busy.test" || last.Status != string(store.StatusDelivered) {
+		t.Errorf("expected e@busy.test delivered after a second, got %+v", last)
+	}
+}
+
+func TestResend_OverPerDomainRPS_Deferred(t *testing.T) {
+	host, port := testutil.StartSMTPServer(t)
+	svc, st := newConfiguredTestService(t, func(cfg *sendmail.Config) {
+		cfg.SMTPServer, cfg.SMTPPort = host, port
+		cfg.PerDomainRPS = 1
+		cfg.Clock = clock.NewMockClock(time.Unix(1700000000, 0))
+	})
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	postSend(t, srv.URL, minimalSendPayload(), "")
+	assertSingleStatus(t, st, store.StatusDelivered)
+
+	got, err := svc.Resend(st.Messages()[0])
+	if err != nil {
+		t.Fatalf("Resend failed: %v", err)
+	}
+	if got.Status != store.StatusDeferred || !strings.HasPrefix(got.Reason, "421 ") {
+		t.Errorf("expected the resend deferred by the throttle, got %s (%q)", got.Status, got.Reason)
+	}
+}
+
+// --- Greylist Tests ---
+
+func TestSend_Greylist_DefersFirstThenDelivers(t *testing.T) {
+	host, port := testutil.StartSMTPServer(t)
+	clk := clock.NewMockClock(time.Unix(1700000000, 0))
+	svc, st := newConfiguredTestService(t, func(cfg *sendmail.Config) {
+		cfg.SMTPServer, cfg.SMTPPort = host, port
+		cfg.GreylistWindow = 5 * time.Minute
+		cfg.Clock = clk
+	})
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	postSend(t, srv.URL, minimalSendPayload(), "")
+	assertSingleStatus(t, st, store.StatusDeferred)
+
+	st.Reset()
+	clk.Add(time.Minute)
+	postSend(t, srv.URL, minimalSendPayload(), "")
+	assertSingleStatus(t, st, store.StatusDelivered)
+}
+
+func TestSend_Greylist_ExpiredEntryDefersAgain(t *testing.T) {
+	host, port := testutil.StartSMTPServer(t)
+	clk := clock.NewMockClock(time.Unix(1700000000, 0))
+	svc, st := newConfiguredTestService(t, func(cfg *sendmail.Config) {
+		cfg.SMTPServer, cfg.SMTPPort = host, port
+		cfg.GreylistWindow = 5 * time.Minute
+		cfg.Clock = clk
+	})
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	postSend(t, srv.URL, minimalSendPayload(), "")
+	st.Reset()
+	clk.Add(10 * time.Minute)
+	postSend(t, srv.URL, minimalSendPayload(), "")
+	assertSingleStatus(t, st, store.StatusDeferred)
+}
+
+func TestSend_GreylistDisabled_Delivers(t *testing.T) {
+	host, port := testutil.StartSMTPServer(t)
+	svc, st := newConfiguredTestService(t, func(cfg *sendmail.Config) {
+		cfg.SMTPServer, cfg.SMTPPort = host, port
+	})
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	postSend(t, srv.URL, minimalSendPayload(), "")
+	assertSingleStatus(t, st, store.StatusDelivered)
+}
+
+// --- Content-Type Tests ---
+
+func TestResponses_SetContentType(t *testing.T) {
+	host, port := testutil.StartSMTPServer(t)
+	svc, _ := newConfiguredTestService(t, func(cfg *sendmail.Config) {
+		cfg.SMTPServer, cfg.SMTPPort = host, port
+	})
+
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	accepted := postSend(t, srv.URL, minimalSendPayload(), "")
+	invalid := postSend(t, srv.URL, map[string]interface{}{}, "")
+	pixel, err := http.Get(srv.URL + "/track/open?id=test&to=test@example.com")
+	if err != nil {
+		t.Fatalf("GET /track/open failed: %v", err)
+	}
+	defer pixel.Body.Close()
+
+	for _, tc := range []struct {
+		name       string
+		resp       *http.Response
+		wantStatus int
+		wantType   string
+	}{
+		{"accepted send", accepted, http.StatusAccepted, "application/json"},
+		{"validation error", invalid, http.StatusBadRequest, "application/json"},
+		{"tracking pixel", pixel, http.StatusOK, "image/gif"},
+	} {
+		if tc.resp.StatusCode != tc.wantStatus {
+			t.Errorf("%s: expected %d, got %d", tc.name, tc.wantStatus, tc.resp.StatusCode)
+		}
+		if got := tc.resp.Header.Get("Content-Type"); got != tc.wantType {
+			t.Errorf("%s: expected Content-Type %q, got %q", tc.name, tc.wantType, got)
+		}
+	}
+}
+
+// --- Service Configuration Tests ---
+
+func TestService_GetRoot_ReturnsCorrectPath(t *testing.T) {
+	svc := newTestService(t, "")
+	if svc.GetRoot() != "/v3/mail/" {
+		t.Errorf("expected root /v3/mail/, got %q", svc.GetRoot())
+	}
+}
+
+// --- Test Helpers ---
+
+func newTestService(t *testing.T, authKey string) *sendmail.Service {
+	t.Helper()
+
+	cfg := sendmail.Config{
+		SMTPServer:    "localhost",
+		SMTPPort:      1025,
+		ListenAddr:    ":0",
+		AttachmentDir: t.TempDir(),
+		AuthKey:       authKey,
+	}
+
+	svc := sendmail.New(cfg, testutil.NewMockTemplater(), testutil.NewMockMessageStore())
+	t.Cleanup(func() { _ = svc.Close() })
+	return svc
+}
+
+// newConfiguredTestService builds a service whose config can be adjusted by
+// mutate, returning the backing mock store for assertions.
+func newConfiguredTestService(t *testing.T, mutate func(*sendmail.Config)) (*sendmail.Service, *testutil.MockMessageStore) {
+	t.Helper()
+
+	cfg := sendmail.Config{
+		SMTPServer:    "localhost",
+		SMTPPort:      1025,
+		ListenAddr:    ":0",
+		AttachmentDir: t.TempDir(),
+	}
+	if mutate != nil {
+		mutate(&cfg)
+	}
+
+	st := testutil.NewMockMessageStore()
+	svc := sendmail.New(cfg, testutil.NewMockTemplater(), st)
+	t.Cleanup(func() { _ = svc.Close() })
+	return svc, st
+}
+
+// buildServiceMux applies the service's middleware chain to the mux.
+// This simulates how MockGrid builds the route with StripPrefix.
+func buildServiceMux(svc *sendmail.Service) *http.ServeMux {
+	// The service mux is used directly without StripPrefix here because
+	// we're testing the service in isolation. The root path stripping is
+	// tested in api_test.go.
+	chain := svc.Chain()
+	rawMux := svc.GetMux()
+
+	// Wrap with chain
+	wrappedMux := http.NewServeMux()
+	wrappedMux.Handle("/", chain(rawMux))
+
+	return wrappedMux
+}
+
+// assertSingleStatus checks that exactly one message is stored with the given status.
+func assertSingleStatus(t *testing.T, st *testutil.MockMessageStore, want store.MessageStatus) {
+	t.Helper()
+	msgs := st.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 stored message, got %d", len(msgs))
+	}
+	if msgs[0].Status != want {
+		t.Errorf("expected status %q, got %q (reason %q)", want, msgs[0].Status, msgs[0].Reason)
+	}
+}
+
+func minimalSendPayload() map[string]interface{} {
+	return map[string]interface{}{
+		"from": map[string]string{"email": "from@example.com"},
 		"personalizations": []map[string]interface{}{
 			{"to": []map[string]string{{"email": "to@example.com"}}},
 		},
@@ -304,6 +1894,30 @@ func minimalSendPayload() map[string]interface{} {
 	}
 }
 
+// captureLogs routes the default slog logger to a buffer for the rest of
+// the test, including debug records.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return &buf
+}
+
+// assertPixel fetches url and checks it returns the tracking GIF.
+func assertPixel(t *testing.T, url string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/gif" {
+		t.Errorf("expected a 200 image/gif pixel, got %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+}
+
 func postSend(t *testing.T, baseURL string, payload interface{}, authHeader string) *http.Response {
 	t.Helper()
 
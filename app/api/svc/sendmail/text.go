@@ -0,0 +1,65 @@
+package sendmail
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Modes for generating a plain-text body from an HTML-only send.
+const (
+	textModeStrip    = "strip"    // drop the tags, leaving the words on one line
+	textModeReadable = "readable" // keep block structure and link targets
+)
+
+var (
+	// htmlHidden matches markup whose content is never shown as text.
+	htmlHidden = regexp.MustCompile(`(?is)<!--.*?-->|<head\b.*?</head\s*>|<script\b.*?</script\s*>|<style\b.*?</style\s*>`)
+	// htmlLink matches an anchor with an href, capturing the quoted URL and
+	// the link text.
+	htmlLink = regexp.MustCompile(`(?is)<a\b[^>]*?\bhref\s*=\s*(?:"([^"]*)"|'([^']*)')[^>]*>(.*?)</a\s*>`)
+	// htmlParagraph matches tags that separate paragraphs by a blank line.
+	htmlParagraph = regexp.MustCompile(`(?i)</?(?:p|h[1-6]|ul|ol|table|blockquote|pre)\b[^>]*>|<hr\b[^>]*>`)
+	// htmlLine matches tags that end a line.
+	htmlLine = regexp.MustCompile(`(?i)<br\b[^>]*>|</?(?:div|tr|section|article|header|footer)\b[^>]*>`)
+	// htmlListItem matches the start of a list item.
+	htmlListItem = regexp.MustCompile(`(?i)<li\b[^>]*>`)
+	htmlTag      = regexp.MustCompile(`<[^>]*>`)
+	whitespace   = regexp.MustCompile(`\s+`)
+	blankLines   = regexp.MustCompile(`\n{3,}`)
+)
+
+// htmlToText renders an HTML body as plain text. The strip mode removes the
+// markup and joins the words with single spaces. The readable mode also keeps
+// paragraphs apart by blank lines, puts list items and line breaks on their
+// own lines, and writes links as "text (url)".
+func htmlToText(body, mode string) string {
+	body = htmlHidden.ReplaceAllString(body, " ")
+	// Source line breaks are just spaces in HTML
+	body = whitespace.ReplaceAllString(body, " ")
+	if mode != textModeReadable {
+		text := html.UnescapeString(htmlTag.ReplaceAllString(body, " "))
+		return strings.TrimSpace(whitespace.ReplaceAllString(text, " "))
+	}
+
+	body = htmlLink.ReplaceAllStringFunc(body, func(tag string) string {
+		m := htmlLink.FindStringSubmatch(tag)
+		url := html.UnescapeString(m[1] + m[2])
+		text := strings.TrimSpace(htmlTag.ReplaceAllString(m[3], " "))
+		if text == "" || html.UnescapeString(text) == url {
+			return url
+		}
+		return text + " (" + url + ")"
+	})
+	body = htmlListItem.ReplaceAllString(body, "\n- ")
+	body = htmlParagraph.ReplaceAllString(body, "\n\n")
+	body = htmlLine.ReplaceAllString(body, "\n")
+	body = html.UnescapeString(htmlTag.ReplaceAllString(body, ""))
+
+	lines := strings.Split(body, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(strings.ReplaceAll(line, "\u00a0", " "))
+	}
+	text := blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(text)
+}
@@ -0,0 +1,33 @@
+package sendmail
+
+import "testing"
+
+func TestHTMLToText(t *testing.T) {
+	body := `<html><head><title>Ignored</title><style>p { color: red; }</style></head>
+<body>
+  <h1>Welcome</h1>
+  <p>Thanks for
+     signing up.<br>Your code is <b>1234</b>.</p>
+  <p>Read the <a href="https://example.com/docs?a=1&amp;b=2">docs</a> or visit
+     <a href="https://example.com">https://example.com</a>.</p>
+  <ul><li>One</li><li>Two &amp; three</li></ul>
+  <!-- footer --><script>track()</script>
+</body></html>`
+
+	cases := []struct {
+		mode string
+		want string
+	}{
+		{textModeStrip, "Welcome Thanks for signing up. Your code is 1234 . Read the docs or visit https://example.com . One Two & three"},
+		{textModeReadable, "Welcome\n\nThanks for signing up.\nYour code is 1234.\n\n" +
+			"Read the docs (https://example.com/docs?a=1&b=2) or visit https://example.com.\n\n" +
+			"- One\n- Two & three"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.mode, func(t *testing.T) {
+			if got := htmlToText(body, tc.mode); got != tc.want {
+				t.Errorf("expected\n%q\ngot\n%q", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package sendmail
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mustur/mockgrid/internal/clock"
+)
+
+// throttleReason mimics the SMTP reply a provider gives when a sender
+// exceeds its per-domain rate.
+const throttleReason = "421 4.7.28 Rate limited for this recipient domain, please try again later"
+
+// sweepInterval is how often the throttle forgets domains that have gone
+// quiet.
+const sweepInterval = time.Minute
+
+// domainThrottle simulates provider-side rate limits: each recipient domain
+// has a token bucket refilled at rps tokens per second, holding up to one
+// second's worth (at least one). A recipient is throttled when its domain's
+// bucket is empty.
+type domainThrottle struct {
+	mu      sync.Mutex
+	clock   clock.Clock
+	rps     float64
+	burst   float64
+	buckets map[string]*bucket // lowercased domain -> bucket
+	swept   time.Time          // when idle buckets were last dropped
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newDomainThrottle creates a throttle allowing rps sends per second to each
+// recipient domain.
+func newDomainThrottle(clk clock.Clock, rps float64) *domainThrottle {
+	return &domainThrottle{
+		clock:   clk,
+		rps:     rps,
+		burst:   max(rps, 1),
+		buckets: make(map[string]*bucket),
+		swept:   clk.Now(),
+	}
+}
+
+// allow reports whether a send to addr fits its domain's rate, taking a
+// token if so.
+func (t *domainThrottle) allow(addr string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	domain := strings.ToLower(addr[strings.LastIndex(addr, "@")+1:])
+	now := t.clock.Now()
+	if now.Sub(t.swept) >= sweepInterval {
+		t.sweep(now)
+	}
+	b, ok := t.buckets[domain]
+	if !ok {
+		b = &bucket{tokens: t.burst, last: now}
+		t.buckets[domain] = b
+	}
+	b.tokens = min(t.burst, b.tokens+now.Sub(b.last).Seconds()*t.rps)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweep drops the buckets that have refilled completely. A full bucket acts
+// exactly like a new one, so forgetting it only frees the memory held for
+// domains no longer sent to.
+func (t *domainThrottle) sweep(now time.Time) {
+	for domain, b := range t.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*t.rps >= t.burst {
+			delete(t.buckets, domain)
+		}
+	}
+	t.swept = now
+}
@@ -0,0 +1,37 @@
+package sendmail
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mustur/mockgrid/internal/clock"
+)
+
+func TestDomainThrottle_ForgetsIdleDomains(t *testing.T) {
+	clk := clock.NewMockClock(time.Unix(1700000000, 0))
+	th := newDomainThrottle(clk, 1)
+
+	for i := range 100 {
+		th.allow(fmt.Sprintf("to@domain-%d.test", i))
+	}
+	if n := len(th.buckets); n != 100 {
+		t.Fatalf("expected 100 buckets, got %d", n)
+	}
+
+	// busy.test keeps sending and stays throttled; the rest refill and are
+	// forgotten at the next sweep
+	clk.Add(sweepInterval)
+	th.allow("a@busy.test")
+	if th.allow("b@busy.test") {
+		t.Error("expected the second send to busy.test to be throttled")
+	}
+	if n := len(th.buckets); n != 1 {
+		t.Errorf("expected only busy.test left after the sweep, got %d buckets", n)
+	}
+
+	// A forgotten domain starts again with a full bucket
+	if !th.allow("to@domain-0.test") {
+		t.Error("expected a send to a forgotten domain to be allowed")
+	}
+}
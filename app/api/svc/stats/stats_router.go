@@ -0,0 +1,39 @@
+package stats
+
+import (
+	"net/http"
+
+	"github.com/mustur/mockgrid/app/api"
+	"github.com/mustur/mockgrid/app/api/middleware"
+)
+
+// GetMux returns the service's HTTP multiplexer.
+func (s *Service) GetMux() *http.ServeMux {
+	return s.mux().ServeMux
+}
+
+// Patterns lists the patterns registered on the service's mux.
+func (s *Service) Patterns() []string {
+	return s.mux().Patterns()
+}
+
+// mux registers the service's handlers.
+func (s *Service) mux() *api.Mux {
+	mux := api.NewMux()
+	mux.HandleFunc("GET /{$}", s.handleSummary)
+	mux.HandleFunc("GET /daily", s.handleDaily)
+	return mux
+}
+
+// GetRoot returns the root path prefix for this service.
+func (s *Service) GetRoot() string {
+	return "/v3/stats/"
+}
+
+// Chain returns the middleware chain for this service.
+func (s *Service) Chain() middleware.Middleware {
+	return middleware.Chain(
+		middleware.Logging(s.accessLog),
+		middleware.BearerAuth(s.authKey),
+	)
+}
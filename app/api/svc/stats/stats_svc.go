@@ -0,0 +1,136 @@
+// Package stats provides SendGrid-style aggregate statistics endpoints.
+package stats
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/mustur/mockgrid/app/api/objects"
+	"github.com/mustur/mockgrid/app/api/store"
+	"github.com/mustur/mockgrid/internal/httpjson"
+)
+
+// Config holds configuration for the stats service.
+type Config struct {
+	AuthKey   string
+	AccessLog *slog.Logger // logs each request; nil means slog.Default()
+}
+
+// DeliveryMonitor reports the backlog of the webhook dispatcher.
+type DeliveryMonitor interface {
+	QueueDepth() int
+	InFlight() int
+}
+
+// Service serves aggregate statistics derived from stored messages.
+type Service struct {
+	authKey    string
+	accessLog  *slog.Logger
+	store      store.MessageStore
+	deliveries DeliveryMonitor // nil reports an idle dispatcher
+}
+
+// Summary is the response of GET /v3/stats.
+type Summary struct {
+	Webhooks WebhookStats `json:"webhooks"`
+}
+
+// WebhookStats describes webhook delivery backpressure.
+type WebhookStats struct {
+	QueueDepth int `json:"queue_depth"` // deliveries waiting for a slot
+	InFlight   int `json:"in_flight"`   // deliveries being sent or retried
+}
+
+// DayStats is one day of statistics in SendGrid's response format.
+type DayStats struct {
+	Date  string        `json:"date"`
+	Stats []MetricsItem `json:"stats"`
+}
+
+// MetricsItem wraps the metrics for a day.
+type MetricsItem struct {
+	Metrics Metrics `json:"metrics"`
+}
+
+// Metrics holds the event counts for a day.
+type Metrics struct {
+	Processed int `json:"processed"`
+	Delivered int `json:"delivered"`
+	Bounces   int `json:"bounces"`
+	Opens     int `json:"opens"`
+	Clicks    int `json:"clicks"`
+}
+
+// New creates a new stats service reading from msgStore.
+func New(cfg Config, msgStore store.MessageStore) *Service {
+	return &Service{
+		authKey:   cfg.AuthKey,
+		accessLog: cfg.AccessLog,
+		store:     msgStore,
+	}
+}
+
+// WithDeliveryMonitor reports m's queue depth and in-flight count in GET /v3/stats.
+func (s *Service) WithDeliveryMonitor(m DeliveryMonitor) *Service {
+	s.deliveries = m
+	return s
+}
+
+// handleSummary processes GET /v3/stats, reporting live webhook delivery counts.
+func (s *Service) handleSummary(w http.ResponseWriter, _ *http.Request) {
+	var resp Summary
+	if s.deliveries != nil {
+		resp.Webhooks.QueueDepth = s.deliveries.QueueDepth()
+		resp.Webhooks.InFlight = s.deliveries.InFlight()
+	}
+	httpjson.Write(w, http.StatusOK, resp)
+}
+
+// handleDaily processes GET /v3/stats/daily?start=YYYY-MM-DD[&end=YYYY-MM-DD].
+// Days are UTC and end is inclusive; without end every day from start is returned.
+func (s *Service) handleDaily(w http.ResponseWriter, r *http.Request) {
+	qry := r.URL.Query()
+
+	start, err := time.Parse(time.DateOnly, qry.Get("start"))
+	if err != nil {
+		httpjson.Write(w, http.StatusBadRequest, objects.GetErrorResponse("start must be a date in YYYY-MM-DD format", "start", nil))
+		return
+	}
+
+	var to int64
+	if v := qry.Get("end"); v != "" {
+		end, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			httpjson.Write(w, http.StatusBadRequest, objects.GetErrorResponse("end must be a date in YYYY-MM-DD format", "end", nil))
+			return
+		}
+		if end.Before(start) {
+			httpjson.Write(w, http.StatusBadRequest, objects.GetErrorResponse("end must not be before start", "end", nil))
+			return
+		}
+		to = end.AddDate(0, 0, 1).Unix()
+	}
+
+	daily, err := s.store.DailyStats(start.Unix(), to)
+	if err != nil {
+		slog.Error("failed to aggregate daily stats", "err", err)
+		httpjson.Write(w, http.StatusInternalServerError, objects.GetErrorResponse("Failed to aggregate stats", nil, nil))
+		return
+	}
+
+	resp := make([]DayStats, 0, len(daily))
+	for _, d := range daily {
+		resp = append(resp, DayStats{
+			Date: d.Date,
+			Stats: []MetricsItem{{Metrics: Metrics{
+				Processed: d.Processed,
+				Delivered: d.Delivered,
+				Bounces:   d.Bounces,
+				Opens:     d.Opens,
+				Clicks:    d.Clicks,
+			}}},
+		})
+	}
+	httpjson.Write(w, http.StatusOK, resp)
+}
@@ -0,0 +1,191 @@
+package stats_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mustur/mockgrid/app/api/store"
+	"github.com/mustur/mockgrid/app/api/svc/stats"
+	"github.com/mustur/mockgrid/app/api/svc/webhook"
+	"github.com/mustur/mockgrid/internal/testutil"
+)
+
+// --- Summary Tests ---
+
+func TestSummary_ReportsWebhookBacklogUntilDrained(t *testing.T) {
+	const hooks = 3
+	started := make(chan struct{}, hooks)
+	release := make(chan struct{})
+	hookSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer hookSrv.Close()
+
+	var configs []*store.WebhookConfig
+	for i := range hooks {
+		configs = append(configs, &store.WebhookConfig{
+			ID:      fmt.Sprintf("wh_%d", i),
+			URL:     hookSrv.URL,
+			Enabled: true,
+			Events:  []string{"delivered"},
+		})
+	}
+	d := webhook.NewDispatcher(testutil.NewMockWebhookStore(configs...), webhook.DispatcherConfig{Concurrency: 1})
+
+	svc := stats.New(stats.Config{}, testutil.NewMockMessageStore()).WithDeliveryMonitor(d)
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	if got := getSummary(t, srv.URL); got.Webhooks != (stats.WebhookStats{}) {
+		t.Fatalf("expected an idle dispatcher, got %+v", got.Webhooks)
+	}
+
+	d.DispatchMessageEvent("msg-1", "to@example.com", "from@example.com", "Hi", "delivered", "", "")
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for webhook delivery")
+	}
+
+	// One delivery holds the only slot while the other two wait
+	want := stats.WebhookStats{QueueDepth: hooks - 1, InFlight: 1}
+	if got := getSummary(t, srv.URL); got.Webhooks != want {
+		t.Errorf("expected %+v while blocked, got %+v", want, got.Webhooks)
+	}
+
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for getSummary(t, srv.URL).Webhooks != (stats.WebhookStats{}) {
+		if time.Now().After(deadline) {
+			t.Fatalf("backlog not drained, got %+v", getSummary(t, srv.URL).Webhooks)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// --- Daily Stats Tests ---
+
+func TestDaily_BucketsMessagesByDay(t *testing.T) {
+	st := testutil.NewMockMessageStore()
+	day1 := time.Date(2024, 5, 10, 9, 0, 0, 0, time.UTC).Unix()
+	day2 := time.Date(2024, 5, 11, 18, 0, 0, 0, time.UTC).Unix()
+	seed(t, st,
+		testutil.NewMessageBuilder("m1").WithStatus(store.StatusDelivered).WithTimestamp(day1).Build(),
+		testutil.NewMessageBuilder("m2").WithStatus(store.StatusBounce).WithTimestamp(day1+30).Build(),
+		testutil.NewMessageBuilder("m3").WithStatus(store.StatusDelivered).WithTimestamp(day2).Build(),
+	)
+
+	srv := httptest.NewServer(buildServiceMux(stats.New(stats.Config{}, st)))
+	defer srv.Close()
+
+	resp := getDaily(t, srv.URL, "?start=2024-05-10&end=2024-05-11")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	var got []stats.DayStats
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 days, got %+v", got)
+	}
+
+	want := []struct {
+		date    string
+		metrics stats.Metrics
+	}{
+		{"2024-05-10", stats.Metrics{Processed: 2, Delivered: 1, Bounces: 1}},
+		{"2024-05-11", stats.Metrics{Processed: 1, Delivered: 1}},
+	}
+	for i, w := range want {
+		if got[i].Date != w.date || got[i].Stats[0].Metrics != w.metrics {
+			t.Errorf("day %d: expected %s %+v, got %s %+v", i, w.date, w.metrics, got[i].Date, got[i].Stats[0].Metrics)
+		}
+	}
+}
+
+func TestDaily_EndIsInclusiveBound(t *testing.T) {
+	st := testutil.NewMockMessageStore()
+	seed(t, st,
+		testutil.NewMessageBuilder("m1").WithTimestamp(time.Date(2024, 5, 10, 23, 59, 0, 0, time.UTC).Unix()).Build(),
+		testutil.NewMessageBuilder("m2").WithTimestamp(time.Date(2024, 5, 11, 0, 0, 0, 0, time.UTC).Unix()).Build(),
+	)
+
+	srv := httptest.NewServer(buildServiceMux(stats.New(stats.Config{}, st)))
+	defer srv.Close()
+
+	var got []stats.DayStats
+	resp := getDaily(t, srv.URL, "?start=2024-05-10&end=2024-05-10")
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(got) != 1 || got[0].Date != "2024-05-10" {
+		t.Errorf("expected only 2024-05-10, got %+v", got)
+	}
+}
+
+func TestDaily_InvalidStart_Returns400(t *testing.T) {
+	srv := httptest.NewServer(buildServiceMux(stats.New(stats.Config{}, testutil.NewMockMessageStore())))
+	defer srv.Close()
+
+	for _, qs := range []string{"", "?start=10-05-2024", "?start=2024-05-10&end=2024-05-09"} {
+		if resp := getDaily(t, srv.URL, qs); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%q: expected 400, got %d", qs, resp.StatusCode)
+		}
+	}
+}
+
+// --- Test Helpers ---
+
+// buildServiceMux applies the service's middleware chain to the mux.
+func buildServiceMux(svc *stats.Service) *http.ServeMux {
+	wrappedMux := http.NewServeMux()
+	wrappedMux.Handle("/", svc.Chain()(svc.GetMux()))
+	return wrappedMux
+}
+
+// seed saves the given messages into the store.
+func seed(t *testing.T, st store.MessageStore, msgs ...*store.Message) {
+	t.Helper()
+	for _, m := range msgs {
+		if err := st.SaveMSG(m); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+}
+
+// getSummary requests GET / and decodes the response.
+func getSummary(t *testing.T, baseURL string) stats.Summary {
+	t.Helper()
+	resp, err := http.Get(baseURL + "/")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var sum stats.Summary
+	if err := json.NewDecoder(resp.Body).Decode(&sum); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return sum
+}
+
+// getDaily requests /daily with the given query string.
+func getDaily(t *testing.T, baseURL, query string) *http.Response {
+	t.Helper()
+	resp, err := http.Get(baseURL + "/daily" + query)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
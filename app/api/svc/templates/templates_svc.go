@@ -0,0 +1,72 @@
+// Package templates provides template tooling endpoints.
+package templates
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/mustur/mockgrid/app/api/objects"
+	"github.com/mustur/mockgrid/app/template"
+	"github.com/mustur/mockgrid/internal/httpjson"
+)
+
+// Config holds configuration for the templates service.
+type Config struct {
+	AuthKey   string
+	AccessLog *slog.Logger // logs each request; nil means slog.Default()
+}
+
+// Service serves template tooling such as validation.
+type Service struct {
+	authKey   string
+	accessLog *slog.Logger
+}
+
+// ValidateRequest holds the template parts to validate.
+type ValidateRequest struct {
+	Subject string `json:"subject"`
+	HTML    string `json:"html"`
+	Plain   string `json:"plain"`
+}
+
+// ValidateResponse reports whether every part parsed, with one error per
+// part that did not.
+type ValidateResponse struct {
+	OK     bool         `json:"ok"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError is the parse error of a single template part.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// New creates a new templates service.
+func New(cfg Config) *Service {
+	return &Service{authKey: cfg.AuthKey, accessLog: cfg.AccessLog}
+}
+
+// handleValidate processes POST /v3/templates/validate, parsing each part
+// with the Handlebars engine used for rendering without sending anything.
+func (s *Service) handleValidate(w http.ResponseWriter, r *http.Request) {
+	var req ValidateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpjson.Write(w, http.StatusBadRequest, objects.GetErrorResponse("Invalid request body", nil, nil))
+		return
+	}
+
+	resp := ValidateResponse{OK: true}
+	for _, part := range []struct{ field, src string }{
+		{"subject", req.Subject},
+		{"html", req.HTML},
+		{"plain", req.Plain},
+	} {
+		if err := template.Validate(part.src); err != nil {
+			resp.OK = false
+			resp.Errors = append(resp.Errors, FieldError{Field: part.field, Message: err.Error()})
+		}
+	}
+	httpjson.Write(w, http.StatusOK, resp)
+}
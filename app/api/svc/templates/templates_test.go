@@ -0,0 +1,84 @@
+package templates_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mustur/mockgrid/app/api/svc/templates"
+)
+
+// --- Validate Tests ---
+
+func TestValidate_ValidTemplate(t *testing.T) {
+	got := postValidate(t, templates.ValidateRequest{
+		Subject: "Hello {{name}}",
+		HTML:    "<ul>{{#each items}}<li>{{this}}</li>{{/each}}</ul>",
+		Plain:   "{{#if name}}Hi {{name}}{{/if}}",
+	})
+
+	if !got.OK || len(got.Errors) != 0 {
+		t.Errorf("expected ok with no errors, got %+v", got)
+	}
+}
+
+func TestValidate_UnterminatedBlock_ReportsField(t *testing.T) {
+	got := postValidate(t, templates.ValidateRequest{
+		Subject: "Hello {{name}}",
+		HTML:    "<ul>{{#each items}}<li>{{this}}</li></ul>",
+	})
+
+	if got.OK {
+		t.Fatal("expected validation to fail")
+	}
+	if len(got.Errors) != 1 || got.Errors[0].Field != "html" || got.Errors[0].Message == "" {
+		t.Errorf("expected one error on html, got %+v", got.Errors)
+	}
+}
+
+func TestValidate_InvalidBody_Returns400(t *testing.T) {
+	srv := httptest.NewServer(buildServiceMux(templates.New(templates.Config{})))
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/validate", "application/json", bytes.NewBufferString("{"))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", resp.StatusCode)
+	}
+}
+
+// --- Test Helpers ---
+
+func buildServiceMux(svc *templates.Service) *http.ServeMux {
+	wrappedMux := http.NewServeMux()
+	wrappedMux.Handle("/", svc.Chain()(svc.GetMux()))
+	return wrappedMux
+}
+
+// postValidate posts req to /validate and decodes the 200 response.
+func postValidate(t *testing.T, req templates.ValidateRequest) templates.ValidateResponse {
+	t.Helper()
+	srv := httptest.NewServer(buildServiceMux(templates.New(templates.Config{})))
+	defer srv.Close()
+
+	body, _ := json.Marshal(req)
+	resp, err := http.Post(srv.URL+"/validate", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	var got templates.ValidateResponse
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return got
+}
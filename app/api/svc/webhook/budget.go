@@ -0,0 +1,60 @@
+package webhook
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/mustur/mockgrid/internal/clock"
+)
+
+// retryBudget is a token bucket shared by every webhook that paces retry
+// attempts to rate per second. A retry over budget reserves the next token
+// and waits until it is available, so excess retries are deferred, not dropped.
+type retryBudget struct {
+	mu     sync.Mutex
+	clock  clock.Clock
+	rate   float64 // tokens added per second
+	burst  float64 // bucket capacity
+	tokens float64 // may go negative while retries are waiting
+	last   time.Time
+}
+
+// newRetryBudget returns a budget allowing rate retries per second, or nil
+// (no limit) when rate is not positive. The burst is one second's worth of
+// tokens, at least one.
+func newRetryBudget(clk clock.Clock, rate float64) *retryBudget {
+	if rate <= 0 {
+		return nil
+	}
+	burst := math.Max(1, math.Floor(rate))
+	return &retryBudget{
+		clock:  clk,
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   clk.Now(),
+	}
+}
+
+// wait blocks until a retry may be attempted. A nil budget never waits.
+func (b *retryBudget) wait() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	now := b.clock.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens--
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+
+	if delay > 0 {
+		b.clock.Sleep(delay)
+	}
+}
@@ -3,24 +3,94 @@ package webhook
 
 import (
 	"bytes"
+	"context"
+	"crypto/ecdsa"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mustur/mockgrid/app/api/store"
+	"github.com/mustur/mockgrid/internal/clock"
+	"github.com/mustur/mockgrid/internal/metrics"
 )
 
+// Headers set on signed webhook requests. SignatureHeader carries the HMAC
+// signature; ECDSA signatures use ECDSASignatureHeader.
+const (
+	SignatureHeader = "X-Twilio-Signature"
+	TimestampHeader = "X-Twilio-Email-Event-Webhook-Timestamp"
+)
+
+// defaultMaxRetryAfter caps how long a consumer's Retry-After may delay the next attempt.
+const defaultMaxRetryAfter = 30 * time.Second
+
+// DispatcherConfig holds configuration for the webhook Dispatcher.
+type DispatcherConfig struct {
+	// MaxRetryAfter caps the wait honored from a Retry-After response header.
+	// Zero means defaultMaxRetryAfter.
+	MaxRetryAfter time.Duration
+
+	// RetryRate caps retry attempts per second across all webhooks; retries
+	// over the budget wait for their turn. Zero means unlimited.
+	RetryRate float64
+
+	// Concurrency is how many webhooks one event is delivered to at once,
+	// each with its own retries. Zero means one at a time.
+	Concurrency int
+
+	// RequireSigned refuses to deliver to webhooks whose events would go
+	// unsigned: HMAC webhooks without a secret, or ECDSA ones without a
+	// SigningKey.
+	RequireSigned bool
+
+	// SigningKey signs deliveries to webhooks in SignatureModeECDSA, which
+	// becomes the default mode. Nil leaves webhooks on HMAC signing.
+	SigningKey *ecdsa.PrivateKey
+
+	// Clock is used for timestamps and retry waits. Nil means the real clock.
+	Clock clock.Clock
+}
+
 // Dispatcher sends webhook events to registered endpoints.
 // It implements store.EventDispatcher.
 type Dispatcher struct {
-	webhookStore store.WebhookStore
-	httpClient   *http.Client
+	webhookStore  store.WebhookStore
+	httpClient    *http.Client
+	clock         clock.Clock
+	maxRetryAfter time.Duration
+	retryBudget   *retryBudget // nil means retries are not rate limited
+	concurrency   int
+	requireSigned bool
+	signingKey    *ecdsa.PrivateKey // nil without ECDSA signing
+	eventSeq      atomic.Uint64     // distinguishes repeated events of the same type
+	queued        atomic.Int64      // deliveries waiting for a concurrency slot
+	inFlight      atomic.Int64      // deliveries being sent or retried
+
+	mu      sync.Mutex
+	pending int           // dispatched events whose deliveries have not finished
+	idle    chan struct{} // closed when pending drops to zero
+}
+
+// deliveryError is returned by send when the consumer rejected the event.
+// retryAfter carries the consumer's requested wait, if any.
+type deliveryError struct {
+	statusCode int
+	retryAfter time.Duration
+}
+
+func (e *deliveryError) Error() string {
+	return fmt.Sprintf("webhook returned status %d", e.statusCode)
 }
 
 // Event represents a webhook event payload (SendGrid format)
@@ -34,25 +104,135 @@ type Event struct {
 	Subject   string `json:"subject,omitempty"`
 	Status    string `json:"status,omitempty"`
 	Reason    string `json:"reason,omitempty"`
+	Pool      *Pool  `json:"pool,omitempty"`
+}
+
+// Pool identifies the IP pool a message was sent from.
+type Pool struct {
+	Name string `json:"name"`
+}
+
+// Envelope wraps events for webhooks configured with envelope enabled.
+type Envelope struct {
+	Events       []*Event `json:"events"`
+	WebhookID    string   `json:"webhook_id"`
+	DispatchedAt int64    `json:"dispatched_at"`
 }
 
 // NewDispatcher creates a new event dispatcher
-func NewDispatcher(store store.WebhookStore) *Dispatcher {
+func NewDispatcher(store store.WebhookStore, cfg DispatcherConfig) *Dispatcher {
+	clk := cfg.Clock
+	if clk == nil {
+		clk = clock.RealClock{}
+	}
+	maxRetryAfter := cfg.MaxRetryAfter
+	if maxRetryAfter <= 0 {
+		maxRetryAfter = defaultMaxRetryAfter
+	}
+	concurrency := cfg.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
 	return &Dispatcher{
 		webhookStore: store,
 		httpClient: &http.Client{
 			Timeout: 10 * time.Second,
 		},
+		clock:         clk,
+		maxRetryAfter: maxRetryAfter,
+		retryBudget:   newRetryBudget(clk, cfg.RetryRate),
+		concurrency:   concurrency,
+		requireSigned: cfg.RequireSigned,
+		signingKey:    cfg.SigningKey,
 	}
 }
 
 // DispatchMessageEvent sends an event to all registered webhooks that match the event type
 // This runs in a goroutine to avoid blocking the caller
-func (d *Dispatcher) DispatchMessageEvent(msgID, email, from, subject string, status string, reason string) {
-	go d.dispatchAsync(msgID, email, from, subject, status, reason)
+func (d *Dispatcher) DispatchMessageEvent(msgID, email, from, subject string, status string, reason string, pool string) {
+	event := d.newEvent(msgID, email, from, subject, status, reason)
+	if pool != "" {
+		event.Pool = &Pool{Name: pool}
+	}
+	d.begin()
+	go func() {
+		defer d.end()
+		d.dispatchAsync(event)
+	}()
+}
+
+// begin counts an event as pending until end is called.
+func (d *Dispatcher) begin() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.pending == 0 {
+		d.idle = make(chan struct{})
+	}
+	d.pending++
 }
 
-func (d *Dispatcher) dispatchAsync(msgID, email, from, subject string, status string, reason string) {
+// end marks a pending event as done, waking Drain when it was the last.
+func (d *Dispatcher) end() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pending--
+	if d.pending == 0 {
+		close(d.idle)
+	}
+}
+
+// Drain waits until every dispatched event has finished delivering,
+// including retries, or ctx is done. It returns ctx's error when deliveries
+// were still pending; they carry on in the background.
+func (d *Dispatcher) Drain(ctx context.Context) error {
+	d.mu.Lock()
+	if d.pending == 0 {
+		d.mu.Unlock()
+		return nil
+	}
+	idle := d.idle
+	d.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// newEvent builds the payload for one logical event. Its EventID and
+// Timestamp are fixed here so every delivery attempt carries the same values,
+// letting consumers deduplicate retries by event_id.
+func (d *Dispatcher) newEvent(msgID, email, from, subject, status, reason string) *Event {
+	return &Event{
+		EventID:   eventID(msgID, status, d.eventSeq.Add(1)),
+		Type:      status,
+		Timestamp: d.clock.Now().Unix(),
+		MessageID: msgID,
+		Email:     email,
+		From:      from,
+		Subject:   subject,
+		Status:    status,
+		Reason:    reason,
+	}
+}
+
+// eventID derives a deterministic event id from the message id, event type
+// and per-event sequence number.
+func eventID(msgID, eventType string, seq uint64) string {
+	h := sha256.New()
+	h.Write([]byte(msgID))
+	h.Write([]byte{0})
+	h.Write([]byte(eventType))
+	h.Write([]byte{0})
+	h.Write([]byte(strconv.FormatUint(seq, 10)))
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+func (d *Dispatcher) dispatchAsync(event *Event) {
+	status := event.Type
+
 	// Get all enabled webhooks
 	webhooks, err := d.webhookStore.ListEnabledWebhooks()
 	if err != nil {
@@ -65,82 +245,123 @@ func (d *Dispatcher) dispatchAsync(msgID, email, from, subject string, status st
 		return
 	}
 
+	// Check which webhooks are subscribed to this event type
+	var targets []*store.WebhookConfig
 	for _, hook := range webhooks {
-		// Check if this webhook is subscribed to this event type
 		if !isSubscribed(hook, status) {
 			slog.Debug("webhook not subscribed to event",
 				"webhook_id", hook.ID, "event_type", status)
 			continue
 		}
+		targets = append(targets, hook)
+	}
+	d.queued.Add(int64(len(targets)))
 
+	// Deliver to up to d.concurrency webhooks at once
+	sem := make(chan struct{}, d.concurrency)
+	var wg sync.WaitGroup
+	for _, hook := range targets {
 		// Send to this webhook with retries
-		d.sendWithRetry(hook, msgID, email, from, subject, status, reason)
+		sem <- struct{}{}
+		d.queued.Add(-1)
+		d.inFlight.Add(1)
+		wg.Go(func() {
+			defer func() { <-sem }()
+			defer d.inFlight.Add(-1)
+			d.sendWithRetry(hook, event)
+		})
 	}
+	wg.Wait()
 }
 
-// sendWithRetry sends an event with exponential backoff retries
-func (d *Dispatcher) sendWithRetry(hook *store.WebhookConfig, msgID, email, from, subject, status, reason string) {
+// QueueDepth returns the number of webhook deliveries waiting for a free
+// delivery slot, as limited by DispatcherConfig.Concurrency.
+func (d *Dispatcher) QueueDepth() int {
+	return int(d.queued.Load())
+}
+
+// InFlight returns the number of webhook deliveries currently being sent,
+// including those waiting between retries.
+func (d *Dispatcher) InFlight() int {
+	return int(d.inFlight.Load())
+}
+
+// sendWithRetry sends an event with exponential backoff retries.
+// A Retry-After header on a failed attempt extends the wait, up to maxRetryAfter,
+// and the shared retry budget may defer a retry further.
+func (d *Dispatcher) sendWithRetry(hook *store.WebhookConfig, event *Event) {
 	maxRetries := 3
 	backoff := time.Second
+	status := event.Type
+
+	if d.requireSigned && !isSigned(hook, d.signingKey) {
+		slog.Error("refusing to deliver unsigned webhook", "webhook_id", hook.ID, "event_type", status)
+		metrics.WebhookDeliveries.WithLabelValues("failure").Inc()
+		return
+	}
 
 	for attempt := 0; attempt < maxRetries; attempt++ {
-		if err := d.send(hook, msgID, email, from, subject, status, reason); err == nil {
+		_, err := d.send(context.Background(), hook, event)
+		if err == nil {
 			slog.Info("webhook delivered", "webhook_id", hook.ID, "event_type", status)
+			metrics.WebhookDeliveries.WithLabelValues("success").Inc()
 			return
-		} else {
-			slog.Warn("webhook delivery failed",
-				"webhook_id", hook.ID,
-				"attempt", attempt+1,
-				"err", err)
 		}
+		slog.Warn("webhook delivery failed",
+			"webhook_id", hook.ID,
+			"attempt", attempt+1,
+			"err", err)
 
 		if attempt < maxRetries-1 {
-			time.Sleep(backoff)
+			d.clock.Sleep(d.retryWait(backoff, err))
 			backoff *= 2 // exponential backoff
+			d.retryBudget.wait()
 		}
 	}
 
 	slog.Error("webhook delivery failed after retries",
 		"webhook_id", hook.ID,
 		"event_type", status)
+	metrics.WebhookDeliveries.WithLabelValues("failure").Inc()
 }
 
-// send delivers the event to a single webhook endpoint
-func (d *Dispatcher) send(hook *store.WebhookConfig, msgID, email, from, subject, status, reason string) error {
-	event := &Event{
-		EventID:   fmt.Sprintf("%d-%s", time.Now().UnixNano(), msgID),
-		Type:      status,
-		Timestamp: time.Now().Unix(),
-		MessageID: msgID,
-		Email:     email,
-		From:      from,
-		Subject:   subject,
-		Status:    status,
-		Reason:    reason,
-	}
+// send delivers the event to a single webhook endpoint, giving up when ctx
+// is done. It returns the consumer's HTTP status, or 0 when no response was
+// received.
+func (d *Dispatcher) send(ctx context.Context, hook *store.WebhookConfig, event *Event) (int, error) {
+	now := d.clock.Now()
 
-	payload, err := json.Marshal(event)
+	payload, err := marshalPayload(hook, event, now)
 	if err != nil {
-		return fmt.Errorf("marshal event: %w", err)
+		return 0, fmt.Errorf("marshal event: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(payload))
+	req, err := http.NewRequestWithContext(ctx, "POST", hook.URL, bytes.NewReader(payload))
 	if err != nil {
-		return fmt.Errorf("create request: %w", err)
+		return 0, fmt.Errorf("create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("User-Agent", "mockgrid/1.0")
 
-	// Add HMAC signature if secret is configured
-	if hook.Secret != "" {
-		signature := d.generateSignature(payload, hook.Secret)
-		req.Header.Set("X-Twilio-Signature", signature)
+	if err := d.sign(req, hook, now, payload); err != nil {
+		return 0, fmt.Errorf("sign request: %w", err)
+	}
+
+	// A per-webhook timeout replaces the shared client timeout for this request
+	client := d.httpClient
+	if hook.TimeoutMS > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), time.Duration(hook.TimeoutMS)*time.Millisecond)
+		defer cancel()
+		req = req.WithContext(ctx)
+		perHook := *d.httpClient
+		perHook.Timeout = 0
+		client = &perHook
 	}
 
-	resp, err := d.httpClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("send request: %w", err)
+		return 0, fmt.Errorf("send request: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -149,15 +370,87 @@ func (d *Dispatcher) send(hook *store.WebhookConfig, msgID, email, from, subject
 
 	// Only accept 2xx responses
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+		return resp.StatusCode, &deliveryError{
+			statusCode: resp.StatusCode,
+			retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), now),
+		}
 	}
 
+	return resp.StatusCode, nil
+}
+
+// marshalPayload encodes the request body for hook: the bare event, or an
+// Envelope around it when the webhook asks for one. The signature is computed
+// over these exact bytes.
+func marshalPayload(hook *store.WebhookConfig, event *Event, now time.Time) ([]byte, error) {
+	if !hook.Envelope {
+		return json.Marshal(event)
+	}
+	return json.Marshal(Envelope{
+		Events:       []*Event{event},
+		WebhookID:    hook.ID,
+		DispatchedAt: now.Unix(),
+	})
+}
+
+// retryWait returns how long to wait before the next attempt: the backoff, or
+// the consumer's Retry-After if longer, capped at maxRetryAfter.
+func (d *Dispatcher) retryWait(backoff time.Duration, err error) time.Duration {
+	var derr *deliveryError
+	if !errors.As(err, &derr) || derr.retryAfter <= backoff {
+		return backoff
+	}
+	return min(derr.retryAfter, d.maxRetryAfter)
+}
+
+// parseRetryAfter parses a Retry-After header given either as delay seconds
+// or as an HTTP-date. It returns zero when the header is absent or invalid.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// sign adds the signature headers to req when deliveries to hook are
+// signed. The timestamp is part of the signed content so consumers can reject
+// replayed requests.
+func (d *Dispatcher) sign(req *http.Request, hook *store.WebhookConfig, now time.Time, payload []byte) error {
+	if !isSigned(hook, d.signingKey) {
+		return nil
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set(TimestampHeader, timestamp)
+	if signatureMode(hook, d.signingKey) == SignatureModeHMAC {
+		req.Header.Set(SignatureHeader, d.generateSignature(timestamp, payload, hook.Secret))
+		return nil
+	}
+	signature, err := signECDSA(d.signingKey, timestamp, payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(ECDSASignatureHeader, signature)
 	return nil
 }
 
-// generateSignature creates an HMAC-SHA256 signature for the payload (SendGrid style)
-func (d *Dispatcher) generateSignature(payload []byte, secret string) string {
+// generateSignature creates a hex HMAC-SHA256 signature over timestamp+payload
+// (SendGrid style). Consumers verify it by concatenating the
+// TimestampHeader value with the raw request body.
+func (d *Dispatcher) generateSignature(timestamp string, payload []byte, secret string) string {
 	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
 	h.Write(payload)
 	return hex.EncodeToString(h.Sum(nil))
 }
@@ -0,0 +1,51 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mustur/mockgrid/app/api/store"
+	"github.com/mustur/mockgrid/internal/testutil"
+)
+
+func TestSend_PerWebhookTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(200 * time.Millisecond):
+		case <-r.Context().Done():
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	d := NewDispatcher(testutil.NewMockWebhookStore(), DispatcherConfig{})
+	hook := &store.WebhookConfig{ID: "wh_slow", URL: srv.URL, TimeoutMS: 50}
+
+	start := time.Now()
+	_, err := d.send(context.Background(), hook, d.newEvent("msg-1", "to@example.com", "from@example.com", "Hi", "delivered", ""))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= 200*time.Millisecond {
+		t.Errorf("expected request to be cut off near 50ms, took %s", elapsed)
+	}
+}
+
+func TestSend_NoPerWebhookTimeout_UsesClientDefault(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	d := NewDispatcher(testutil.NewMockWebhookStore(), DispatcherConfig{})
+	hook := &store.WebhookConfig{ID: "wh_default", URL: srv.URL}
+
+	if _, err := d.send(context.Background(), hook, d.newEvent("msg-1", "to@example.com", "from@example.com", "Hi", "delivered", "")); err != nil {
+		t.Errorf("expected delivery within the shared timeout, got %v", err)
+	}
+}
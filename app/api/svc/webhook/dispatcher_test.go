@@ -0,0 +1,516 @@
+package webhook_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mustur/mockgrid/app/api/store"
+	"github.com/mustur/mockgrid/app/api/svc/webhook"
+	"github.com/mustur/mockgrid/internal/clock"
+	"github.com/mustur/mockgrid/internal/testutil"
+)
+
+func TestDispatcher_RetryAfter_Honored(t *testing.T) {
+	var mu sync.Mutex
+	attempts := 0
+	delivered := make(chan struct{})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts == 1 {
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		close(delivered)
+	}))
+	defer srv.Close()
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewMockClock(start)
+	d := webhook.NewDispatcher(newHookStore(srv.URL), webhook.DispatcherConfig{Clock: clk})
+
+	d.DispatchMessageEvent("msg-1", "to@example.com", "from@example.com", "Hi", "delivered", "", "")
+	waitFor(t, delivered)
+
+	if got := clk.Now().Sub(start); got != 2*time.Second {
+		t.Errorf("expected dispatcher to wait 2s honoring Retry-After, waited %s", got)
+	}
+}
+
+func TestDispatcher_RetryAfter_Capped(t *testing.T) {
+	var mu sync.Mutex
+	attempts := 0
+	delivered := make(chan struct{})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts == 1 {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		close(delivered)
+	}))
+	defer srv.Close()
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewMockClock(start)
+	d := webhook.NewDispatcher(newHookStore(srv.URL), webhook.DispatcherConfig{
+		Clock:         clk,
+		MaxRetryAfter: 5 * time.Second,
+	})
+
+	d.DispatchMessageEvent("msg-1", "to@example.com", "from@example.com", "Hi", "delivered", "", "")
+	waitFor(t, delivered)
+
+	if got := clk.Now().Sub(start); got != 5*time.Second {
+		t.Errorf("expected Retry-After to be capped at 5s, waited %s", got)
+	}
+}
+
+func TestDispatcher_Signature_CoversTimestampAndPayload(t *testing.T) {
+	type captured struct {
+		header http.Header
+		body   []byte
+	}
+	got := make(chan captured, 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- captured{header: r.Header.Clone(), body: body}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	secret := "s3cret"
+	hooks := testutil.NewMockWebhookStore(&store.WebhookConfig{
+		ID:      "wh_signed",
+		URL:     srv.URL,
+		Enabled: true,
+		Events:  []string{"delivered"},
+		Secret:  secret,
+	})
+	clk := clock.NewMockClock(time.Unix(1700000000, 0))
+	d := webhook.NewDispatcher(hooks, webhook.DispatcherConfig{Clock: clk})
+
+	d.DispatchMessageEvent("msg-1", "to@example.com", "from@example.com", "Hi", "delivered", "", "")
+
+	var c captured
+	select {
+	case c = <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for webhook delivery")
+	}
+
+	timestamp := c.header.Get(webhook.TimestampHeader)
+	if timestamp != "1700000000" {
+		t.Fatalf("expected timestamp header 1700000000, got %q", timestamp)
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write(c.body)
+	want := hex.EncodeToString(mac.Sum(nil))
+
+	if sig := c.header.Get(webhook.SignatureHeader); sig != want {
+		t.Errorf("signature mismatch: expected %q, got %q", want, sig)
+	}
+}
+
+func TestDispatcher_HMACMode_SignsWithSecretDespiteSigningKey(t *testing.T) {
+	got := make(chan http.Header, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Clone()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	hooks := testutil.NewMockWebhookStore(&store.WebhookConfig{
+		ID:            "wh_hmac",
+		URL:           srv.URL,
+		Enabled:       true,
+		Events:        []string{"delivered"},
+		Secret:        "s3cret",
+		SignatureMode: webhook.SignatureModeHMAC,
+	})
+	d := webhook.NewDispatcher(hooks, webhook.DispatcherConfig{SigningKey: key})
+	d.DispatchMessageEvent("msg-1", "to@example.com", "from@example.com", "Hi", "delivered", "", "")
+
+	var header http.Header
+	select {
+	case header = <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for webhook delivery")
+	}
+	if header.Get(webhook.SignatureHeader) == "" {
+		t.Error("expected an HMAC signature")
+	}
+	if sig := header.Get(webhook.ECDSASignatureHeader); sig != "" {
+		t.Errorf("expected no ECDSA signature, got %q", sig)
+	}
+}
+
+func TestDispatcher_Retries_KeepEventID(t *testing.T) {
+	ids := make(chan string, 2)
+	var mu sync.Mutex
+	attempts := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev webhook.Event
+		_ = json.NewDecoder(r.Body).Decode(&ev)
+		ids <- ev.EventID
+
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	clk := clock.NewMockClock(time.Unix(1700000000, 0))
+	d := webhook.NewDispatcher(newHookStore(srv.URL), webhook.DispatcherConfig{Clock: clk})
+
+	d.DispatchMessageEvent("msg-1", "to@example.com", "from@example.com", "Hi", "delivered", "", "")
+
+	var got []string
+	for len(got) < 2 {
+		select {
+		case id := <-ids:
+			got = append(got, id)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for delivery attempts, got %d", len(got))
+		}
+	}
+
+	if got[0] == "" || got[0] != got[1] {
+		t.Errorf("expected retries to share the same event_id, got %q and %q", got[0], got[1])
+	}
+}
+
+func TestDispatcher_DistinctEvents_HaveDistinctIDs(t *testing.T) {
+	ids := make(chan string, 2)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev webhook.Event
+		_ = json.NewDecoder(r.Body).Decode(&ev)
+		ids <- ev.EventID
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	d := webhook.NewDispatcher(newHookStore(srv.URL), webhook.DispatcherConfig{})
+	d.DispatchMessageEvent("msg-1", "to@example.com", "from@example.com", "Hi", "delivered", "", "")
+	d.DispatchMessageEvent("msg-1", "to@example.com", "from@example.com", "Hi", "delivered", "", "")
+
+	first, second := <-ids, <-ids
+	if first == second {
+		t.Errorf("expected distinct events to have distinct ids, both were %q", first)
+	}
+}
+
+func TestDispatcher_Envelope_WrapsSignedPayload(t *testing.T) {
+	type captured struct {
+		header http.Header
+		body   []byte
+	}
+	got := make(chan captured, 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- captured{header: r.Header.Clone(), body: body}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	secret := "s3cret"
+	hooks := testutil.NewMockWebhookStore(&store.WebhookConfig{
+		ID:       "wh_env",
+		URL:      srv.URL,
+		Enabled:  true,
+		Events:   []string{"delivered"},
+		Secret:   secret,
+		Envelope: true,
+	})
+	clk := clock.NewMockClock(time.Unix(1700000000, 0))
+	d := webhook.NewDispatcher(hooks, webhook.DispatcherConfig{Clock: clk})
+
+	d.DispatchMessageEvent("msg-1", "to@example.com", "from@example.com", "Hi", "delivered", "", "")
+
+	var c captured
+	select {
+	case c = <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for webhook delivery")
+	}
+
+	var env webhook.Envelope
+	if err := json.Unmarshal(c.body, &env); err != nil {
+		t.Fatalf("failed to decode envelope: %v", err)
+	}
+	if env.WebhookID != "wh_env" || env.DispatchedAt != 1700000000 {
+		t.Errorf("unexpected envelope metadata: %+v", env)
+	}
+	if len(env.Events) != 1 || env.Events[0].MessageID != "msg-1" || env.Events[0].Type != "delivered" {
+		t.Errorf("expected the delivered event inside the envelope, got %+v", env.Events)
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(c.header.Get(webhook.TimestampHeader)))
+	mac.Write(c.body)
+	if sig := c.header.Get(webhook.SignatureHeader); sig != hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("signature does not cover the enveloped body")
+	}
+}
+
+func TestDispatcher_Pool_EchoedInEvent(t *testing.T) {
+	bodies := make(chan []byte, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	d := webhook.NewDispatcher(newHookStore(srv.URL), webhook.DispatcherConfig{})
+	d.DispatchMessageEvent("msg-1", "to@example.com", "from@example.com", "Hi", "delivered", "", "marketing")
+	d.DispatchMessageEvent("msg-2", "to@example.com", "from@example.com", "Hi", "delivered", "", "")
+
+	pools := map[string]*webhook.Pool{}
+	for range 2 {
+		select {
+		case body := <-bodies:
+			var ev webhook.Event
+			if err := json.Unmarshal(body, &ev); err != nil {
+				t.Fatalf("failed to decode event: %v", err)
+			}
+			pools[ev.MessageID] = ev.Pool
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for webhook delivery")
+		}
+	}
+	if p := pools["msg-1"]; p == nil || p.Name != "marketing" {
+		t.Errorf("expected pool marketing, got %+v", p)
+	}
+	if p := pools["msg-2"]; p != nil {
+		t.Errorf("expected no pool without ip_pool_name, got %+v", p)
+	}
+}
+
+func TestDispatcher_RequireSigned_SkipsUnsignedWebhook(t *testing.T) {
+	var unsigned atomic.Int32
+	signed := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/unsigned" {
+			unsigned.Add(1)
+		} else {
+			signed <- struct{}{}
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	hooks := testutil.NewMockWebhookStore(
+		&store.WebhookConfig{ID: "wh_unsigned", URL: srv.URL + "/unsigned", Enabled: true, Events: []string{"delivered"}},
+		&store.WebhookConfig{ID: "wh_signed", URL: srv.URL + "/signed", Enabled: true, Events: []string{"delivered"}, Secret: "s3cret"},
+	)
+	d := webhook.NewDispatcher(hooks, webhook.DispatcherConfig{RequireSigned: true})
+	d.DispatchMessageEvent("msg-1", "to@example.com", "from@example.com", "Hi", "delivered", "", "")
+
+	waitFor(t, signed)
+	deadline := time.Now().Add(5 * time.Second)
+	for d.InFlight() > 0 || d.QueueDepth() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for dispatch to finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := unsigned.Load(); n != 0 {
+		t.Errorf("expected no delivery to the unsigned webhook, got %d", n)
+	}
+}
+
+func TestDispatcher_RetryBudget_CapsRetryRate(t *testing.T) {
+	const hooks = 10
+	const rate = 0.2 // one retry every 5s across all webhooks
+
+	clk := clock.NewMockClock(time.Unix(1700000000, 0))
+
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	var retryTimes []time.Time
+	requests := 0
+	done := make(chan struct{})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		// The first request to each hook is the initial attempt; the rest are retries
+		if seen[r.URL.Path] {
+			retryTimes = append(retryTimes, clk.Now())
+		}
+		seen[r.URL.Path] = true
+		requests++
+		if requests == hooks*3 {
+			close(done)
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	var configs []*store.WebhookConfig
+	for i := range hooks {
+		configs = append(configs, &store.WebhookConfig{
+			ID:      fmt.Sprintf("wh_%d", i),
+			URL:     fmt.Sprintf("%s/hook-%d", srv.URL, i),
+			Enabled: true,
+			Events:  []string{"delivered"},
+		})
+	}
+	d := webhook.NewDispatcher(testutil.NewMockWebhookStore(configs...), webhook.DispatcherConfig{
+		Clock:     clk,
+		RetryRate: rate,
+	})
+
+	d.DispatchMessageEvent("msg-1", "to@example.com", "from@example.com", "Hi", "delivered", "", "")
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out waiting for retries, got %d requests", requests)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(retryTimes) != hooks*2 {
+		t.Fatalf("expected %d retries, got %d", hooks*2, len(retryTimes))
+	}
+	minGap := time.Duration(float64(time.Second)/rate) - time.Millisecond
+	for i := 1; i < len(retryTimes); i++ {
+		if gap := retryTimes[i].Sub(retryTimes[i-1]); gap < minGap {
+			t.Errorf("retries %d and %d only %s apart, budget allows one per %s", i-1, i, gap, minGap)
+		}
+	}
+}
+
+func TestDispatcher_Concurrency_DeliversInParallel(t *testing.T) {
+	const hooks = 4
+	const delay = 300 * time.Millisecond
+
+	var wg sync.WaitGroup
+	wg.Add(hooks)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		w.WriteHeader(http.StatusOK)
+		wg.Done()
+	}))
+	defer srv.Close()
+
+	var configs []*store.WebhookConfig
+	for i := range hooks {
+		configs = append(configs, &store.WebhookConfig{
+			ID:      fmt.Sprintf("wh_%d", i),
+			URL:     srv.URL,
+			Enabled: true,
+			Events:  []string{"delivered"},
+		})
+	}
+	d := webhook.NewDispatcher(testutil.NewMockWebhookStore(configs...), webhook.DispatcherConfig{
+		Concurrency: hooks,
+	})
+
+	start := time.Now()
+	d.DispatchMessageEvent("msg-1", "to@example.com", "from@example.com", "Hi", "delivered", "", "")
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	waitFor(t, done)
+
+	// Sequential delivery would take hooks*delay
+	if elapsed := time.Since(start); elapsed >= 2*delay {
+		t.Errorf("expected parallel delivery in about %s, took %s", delay, elapsed)
+	}
+}
+
+func TestDispatcher_Drain_WaitsForPendingDeliveries(t *testing.T) {
+	release := make(chan struct{})
+	var delivered atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		delivered.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	d := webhook.NewDispatcher(newHookStore(srv.URL), webhook.DispatcherConfig{})
+	if err := d.Drain(context.Background()); err != nil {
+		t.Fatalf("expected an idle dispatcher to drain at once, got %v", err)
+	}
+	d.DispatchMessageEvent("msg-1", "to@example.com", "from@example.com", "Hi", "delivered", "", "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := d.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline while the delivery hangs, got %v", err)
+	}
+
+	close(release)
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := d.Drain(ctx); err != nil {
+		t.Fatalf("Drain failed: %v", err)
+	}
+	if n := delivered.Load(); n != 1 {
+		t.Errorf("expected the event delivered before Drain returned, got %d deliveries", n)
+	}
+}
+
+// --- Test Helpers ---
+
+func newHookStore(url string) *testutil.MockWebhookStore {
+	return testutil.NewMockWebhookStore(&store.WebhookConfig{
+		ID:      "wh_test",
+		URL:     url,
+		Enabled: true,
+		Events:  []string{"delivered"},
+	})
+}
+
+func waitFor(t *testing.T, ch <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for webhook delivery")
+	}
+}
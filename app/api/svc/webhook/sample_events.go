@@ -0,0 +1,63 @@
+package webhook
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mustur/mockgrid/app/api/store"
+)
+
+// testDeadline bounds a whole test delivery. It stays below the API server's
+// 20s WriteTimeout, so the results are written before the connection is cut.
+const testDeadline = 15 * time.Second
+
+// testMessageID is the sg_message_id carried by sample events, so consumers
+// can tell them apart from real ones.
+const testMessageID = "mockgrid-test-event"
+
+// sampleReasons are the reasons sample events of these types carry.
+var sampleReasons = map[string]string{
+	string(store.StatusDeferred): "421 4.7.0 Try again later",
+	string(store.StatusBounce):   "550 5.1.1 The email account that you tried to reach does not exist",
+	string(store.StatusBlocked):  "452 4.2.2 The email account that you tried to reach is over quota",
+	string(store.StatusDropped):  store.DropReasonBounced,
+}
+
+// TestDelivery is the outcome of sending one sample event to a webhook.
+type TestDelivery struct {
+	Event      string `json:"event"`
+	Delivered  bool   `json:"delivered"`
+	StatusCode int    `json:"status_code,omitempty"` // consumer's HTTP status; omitted when it did not respond
+	Error      string `json:"error,omitempty"`
+}
+
+// TestWebhook sends a sample event of each type hook is subscribed to,
+// signed as real deliveries are, and waits for the outcomes. The events are
+// sent at once, each without retries, and abandoned when ctx is done or
+// after testDeadline, whichever comes first.
+func (d *Dispatcher) TestWebhook(ctx context.Context, hook *store.WebhookConfig) []TestDelivery {
+	ctx, cancel := context.WithTimeout(ctx, testDeadline)
+	defer cancel()
+
+	results := make([]TestDelivery, len(hook.Events))
+	var wg sync.WaitGroup
+	for i, eventType := range hook.Events {
+		results[i].Event = eventType
+		if d.requireSigned && !isSigned(hook, d.signingKey) {
+			results[i].Error = "refusing to deliver unsigned webhook"
+			continue
+		}
+		wg.Go(func() {
+			event := d.newEvent(testMessageID, "example@test.com", "sender@test.com", "Test event", eventType, sampleReasons[eventType])
+			code, err := d.send(ctx, hook, event)
+			results[i].StatusCode = code
+			results[i].Delivered = err == nil
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+		})
+	}
+	wg.Wait()
+	return results
+}
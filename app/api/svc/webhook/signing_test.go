@@ -0,0 +1,58 @@
+package webhook_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mustur/mockgrid/app/api/svc/webhook"
+)
+
+func TestLoadSigningKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	sec1, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal SEC 1: %v", err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal PKCS #8: %v", err)
+	}
+
+	for name, block := range map[string]*pem.Block{
+		"sec1":  {Type: "EC PRIVATE KEY", Bytes: sec1},
+		"pkcs8": {Type: "PRIVATE KEY", Bytes: pkcs8},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "key.pem")
+			if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+				t.Fatalf("write key: %v", err)
+			}
+			loaded, err := webhook.LoadSigningKey(path)
+			if err != nil {
+				t.Fatalf("LoadSigningKey failed: %v", err)
+			}
+			if !loaded.Equal(key) {
+				t.Error("loaded key differs from the written one")
+			}
+		})
+	}
+
+	t.Run("not pem", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "key.pem")
+		if err := os.WriteFile(path, []byte("not a key"), 0o600); err != nil {
+			t.Fatalf("write key: %v", err)
+		}
+		if _, err := webhook.LoadSigningKey(path); err == nil {
+			t.Error("expected an error for a non-PEM file")
+		}
+	})
+}
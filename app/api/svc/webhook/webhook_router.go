@@ -4,18 +4,33 @@ package webhook
 import (
 	"net/http"
 
+	"github.com/mustur/mockgrid/app/api"
 	"github.com/mustur/mockgrid/app/api/middleware"
 )
 
 // GetMux returns the service's HTTP multiplexer.
 func (s *Service) GetMux() *http.ServeMux {
-	mux := http.NewServeMux()
+	return s.mux().ServeMux
+}
+
+// Patterns lists the patterns registered on the service's mux.
+func (s *Service) Patterns() []string {
+	return s.mux().Patterns()
+}
+
+// mux registers the service's handlers.
+func (s *Service) mux() *api.Mux {
+	mux := api.NewMux()
 	mux.HandleFunc("POST /", s.HandleCreateWebhook)
 	mux.HandleFunc("GET /", s.HandleListWebhooks)
+	mux.HandleFunc("DELETE /{$}", s.HandleDeleteAllWebhooks)
+	mux.HandleFunc("GET /event/settings/signed", s.HandleGetSigningKey)
 	mux.HandleFunc("GET /{id}", s.HandleGetWebhook)
 	mux.HandleFunc("PUT /{id}", s.HandleUpdateWebhook)
 	mux.HandleFunc("DELETE /{id}", s.HandleDeleteWebhook)
 	mux.HandleFunc("POST /{id}/toggle", s.HandleToggleWebhook)
+	mux.HandleFunc("POST /{id}/rotate-secret", s.HandleRotateSecret)
+	mux.HandleFunc("POST /{id}/test", s.HandleTestWebhook)
 	return mux
 }
 
@@ -27,16 +42,12 @@ func (s *Service) GetRoot() string {
 // Chain returns the middleware chain for this service.
 func (s *Service) Chain() middleware.Middleware {
 	return middleware.Chain(
+		middleware.Logging(s.accessLog),
 		s.authMiddleware(),
 	)
 }
 
-// authMiddleware returns an authentication middleware
+// authMiddleware returns a middleware that checks the configured API key.
 func (s *Service) authMiddleware() middleware.Middleware {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// noop, TODO
-			next.ServeHTTP(w, r)
-		})
-	}
+	return middleware.BearerAuth(s.authKey)
 }
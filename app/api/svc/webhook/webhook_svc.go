@@ -2,25 +2,47 @@
 package webhook
 
 import (
+	"context"
+	"crypto/ecdsa"
 	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"strings"
-	"time"
 
+	"github.com/mustur/mockgrid/app/api/objects"
 	"github.com/mustur/mockgrid/app/api/store"
+	"github.com/mustur/mockgrid/internal/httpjson"
 )
 
 // ErrNotFound is returned when a webhook is not found
 var ErrNotFound = errors.New("webhook not found")
 
+// errSecretRequired is the error message for a webhook without a secret
+// while signing is required.
+const errSecretRequired = "secret is required when webhook signing is required"
+
+// errNoSigningKey is the error message for an ECDSA webhook while no signing
+// key is configured.
+const errNoSigningKey = "signature_mode ecdsa requires a configured signing key"
+
+// DefaultMaxBodyBytes caps webhook create and update bodies unless
+// WithMaxBodyBytes sets another limit.
+const DefaultMaxBodyBytes = 1 << 20
+
 // Service manages webhook configurations
 type Service struct {
-	store      store.WebhookStore
-	dispatcher store.EventDispatcher
+	store         store.WebhookStore
+	dispatcher    store.EventDispatcher
+	authKey       string
+	accessLog     *slog.Logger // nil means slog.Default()
+	maxBody       int64
+	newID         func() string
+	requireSigned bool
+	signingKey    *ecdsa.PrivateKey // nil without ECDSA signing
 }
 
 // NewService creates a new webhook service
@@ -28,7 +50,52 @@ func NewService(store store.WebhookStore, dispatcher store.EventDispatcher) *Ser
 	return &Service{
 		store:      store,
 		dispatcher: dispatcher,
+		maxBody:    DefaultMaxBodyBytes,
+		newID:      generateID,
+	}
+}
+
+// WithAuthKey requires "Authorization: Bearer <key>" on every webhook
+// endpoint. An empty key disables the check.
+func (s *Service) WithAuthKey(key string) *Service {
+	s.authKey = key
+	return s
+}
+
+// WithAccessLog logs each request to logger instead of slog.Default().
+func (s *Service) WithAccessLog(logger *slog.Logger) *Service {
+	s.accessLog = logger
+	return s
+}
+
+// WithIDGenerator replaces the UUID generator used for new webhook IDs,
+// e.g. with a deterministic one in tests.
+func (s *Service) WithIDGenerator(gen func() string) *Service {
+	s.newID = gen
+	return s
+}
+
+// WithMaxBodyBytes limits the size of webhook create and update bodies;
+// larger requests are rejected with 413. n <= 0 keeps DefaultMaxBodyBytes.
+func (s *Service) WithMaxBodyBytes(n int64) *Service {
+	if n > 0 {
+		s.maxBody = n
 	}
+	return s
+}
+
+// WithRequireSigned rejects creating or updating a webhook without a secret,
+// so no webhook can receive unsigned events.
+func (s *Service) WithRequireSigned(required bool) *Service {
+	s.requireSigned = required
+	return s
+}
+
+// WithSigningKey sets the ECDSA key the dispatcher signs with, making
+// SignatureModeECDSA available and serving its public key.
+func (s *Service) WithSigningKey(key *ecdsa.PrivateKey) *Service {
+	s.signingKey = key
+	return s
 }
 
 // CreateWebhookRequest is the request body for creating a webhook (SendGrid format)
@@ -36,17 +103,38 @@ type CreateWebhookRequest struct {
 	URL    string   `json:"url"`
 	Events []string `json:"events"` // e.g., ["processed", "delivered", "bounce", "deferred", "blocked", "dropped"]
 	Secret string   `json:"secret,omitempty"`
+	// TimeoutMS overrides the dispatcher's request timeout for this endpoint
+	TimeoutMS int `json:"timeout_ms,omitempty"`
+	// Envelope wraps deliveries in {"events":[...],"webhook_id":...,"dispatched_at":...}
+	Envelope *bool `json:"envelope,omitempty"`
+	// SignatureMode selects "ecdsa" or "hmac" signing; empty uses ECDSA when
+	// a signing key is configured and HMAC otherwise
+	SignatureMode string `json:"signature_mode,omitempty"`
+	// UpdatedAt is the modified value the client last read. An update is
+	// rejected with 409 when the webhook has changed since; ignored on create.
+	UpdatedAt *int64 `json:"updated_at,omitempty"`
 }
 
 // WebhookResponse is the response format for webhook endpoints (SendGrid format)
 type WebhookResponse struct {
-	ID       string   `json:"id"`
-	URL      string   `json:"url"`
-	Events   []string `json:"events"`
-	Enabled  bool     `json:"enabled"`
-	Secret   string   `json:"secret,omitempty"` // Only in responses when just created
-	Created  int64    `json:"created,omitempty"`
-	Modified int64    `json:"modified,omitempty"`
+	ID        string   `json:"id"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	Enabled   bool     `json:"enabled"`
+	Secret    string   `json:"secret,omitempty"` // Only in responses when just created
+	TimeoutMS int      `json:"timeout_ms,omitempty"`
+	Envelope  bool     `json:"envelope"`
+	Created   int64    `json:"created,omitempty"`
+	Modified  int64    `json:"modified,omitempty"`
+
+	SignatureMode string `json:"signature_mode,omitempty"`
+}
+
+// SigningKeyResponse is the response for GET /webhooks/event/settings/signed,
+// shaped like SendGrid's signed Event Webhook settings.
+type SigningKeyResponse struct {
+	Enabled   bool   `json:"enabled"`
+	PublicKey string `json:"public_key"` // base64 DER; empty when not enabled
 }
 
 // ListResponse wraps the webhook list
@@ -54,12 +142,24 @@ type ListResponse struct {
 	Result []*WebhookResponse `json:"result"`
 }
 
+// TestResponse lists the outcome of each sample event sent by
+// POST /webhooks/{id}/test.
+type TestResponse struct {
+	Result []TestDelivery `json:"result"`
+}
+
+// webhookTester is implemented by dispatchers that can send sample events,
+// such as Dispatcher.
+type webhookTester interface {
+	TestWebhook(ctx context.Context, hook *store.WebhookConfig) []TestDelivery
+}
+
 // HandleListWebhooks handles GET /webhooks
 func (s *Service) HandleListWebhooks(w http.ResponseWriter, r *http.Request) {
 	hooks, err := s.store.ListWebhooks()
 	if err != nil {
 		slog.Error("failed to list webhooks", "err", err)
-		http.Error(w, `{"error":"failed to list webhooks"}`, http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "failed to list webhooks")
 		return
 	}
 
@@ -68,7 +168,7 @@ func (s *Service) HandleListWebhooks(w http.ResponseWriter, r *http.Request) {
 		resp.Result = append(resp.Result, webhookToResponse(hook))
 	}
 
-	writeJSONResponse(w, http.StatusOK, resp)
+	httpjson.Write(w, http.StatusOK, resp)
 }
 
 // HandleGetWebhooks handles GET /webhooks/{id}
@@ -78,49 +178,61 @@ func (s *Service) HandleGetWebhook(w http.ResponseWriter, r *http.Request) {
 	hook, err := s.store.GetWebhook(id)
 	if err != nil {
 		slog.Error("failed to get webhook(s)", "id", id, "err", err)
-		http.Error(w, `{"error":"failed to get webhook(s)"}`, http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "failed to get webhook(s)")
 		return
 	}
 
-	writeJSONResponse(w, http.StatusOK, webhookToResponse(hook))
+	httpjson.Write(w, http.StatusOK, webhookToResponse(hook))
 }
 
 // HandleCreateWebhook handles POST /webhooks
 func (s *Service) HandleCreateWebhook(w http.ResponseWriter, r *http.Request) {
 
 	var req CreateWebhookRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+	if !s.decodeBody(w, r, &req) {
 		return
 	}
 
 	if req.URL == "" {
-		http.Error(w, `{"error":"url is required"}`, http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "url is required")
 		return
 	}
 
 	if len(req.Events) == 0 {
-		http.Error(w, `{"error":"events array is required"}`, http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "events array is required")
+		return
+	}
+
+	if req.TimeoutMS < 0 {
+		writeJSONError(w, http.StatusBadRequest, "timeout_ms must not be negative")
 		return
 	}
 
 	config := &store.WebhookConfig{
-		ID:      generateID(),
-		URL:     req.URL,
-		Enabled: true,
-		Events:  req.Events,
-		Secret:  req.Secret,
+		ID:            s.newID(),
+		URL:           req.URL,
+		Enabled:       true,
+		Events:        req.Events,
+		Secret:        req.Secret,
+		TimeoutMS:     req.TimeoutMS,
+		Envelope:      req.Envelope != nil && *req.Envelope,
+		SignatureMode: req.SignatureMode,
+	}
+	if msg := s.validateSigning(config); msg != "" {
+		writeJSONError(w, http.StatusBadRequest, msg)
+		return
 	}
 
 	if err := s.store.Create(config); err != nil {
 		slog.Error("failed to create webhook", "err", err)
-		http.Error(w, `{"error":"failed to create webhook"}`, http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "failed to create webhook")
 		return
 	}
 
 	resp := webhookToResponse(config)
 	resp.Secret = req.Secret // Include secret in creation response
-	writeJSONResponse(w, http.StatusCreated, resp)
+	w.Header().Set("Location", s.GetRoot()+config.ID)
+	httpjson.Write(w, http.StatusCreated, resp)
 }
 
 // HandleUpdateWebhook handles PUT /webhooks/{id}
@@ -128,22 +240,23 @@ func (s *Service) HandleUpdateWebhook(w http.ResponseWriter, r *http.Request) {
 
 	id := extractID(r.URL.Path)
 	if id == "" {
-		http.Error(w, `{"error":"webhook id is required"}`, http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "webhook id is required")
 		return
 	}
 
 	var req CreateWebhookRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+	if !s.decodeBody(w, r, &req) {
 		return
 	}
 
 	hook, err := s.store.GetWebhook(id)
 	if err != nil || hook == nil {
-		http.Error(w, `{"error":"webhook not found"}`, http.StatusNotFound)
+		writeJSONError(w, http.StatusNotFound, "webhook not found")
 		return
 	}
-	//
+	if req.UpdatedAt != nil {
+		hook.UpdatedAt = *req.UpdatedAt
+	}
 
 	if req.URL != "" {
 		hook.URL = req.URL
@@ -154,35 +267,49 @@ func (s *Service) HandleUpdateWebhook(w http.ResponseWriter, r *http.Request) {
 	if req.Secret != "" {
 		hook.Secret = req.Secret
 	}
+	if req.TimeoutMS > 0 {
+		hook.TimeoutMS = req.TimeoutMS
+	}
+	if req.Envelope != nil {
+		hook.Envelope = *req.Envelope
+	}
+	if req.SignatureMode != "" {
+		hook.SignatureMode = req.SignatureMode
+	}
+	// Updates keep the stored secret, so a missing one only rejects webhooks
+	// that were created before signing was required
+	if msg := s.validateSigning(hook); msg != "" {
+		writeJSONError(w, http.StatusBadRequest, msg)
+		return
+	}
 
 	if err := s.store.UpdateWebhook(hook); err != nil {
-		slog.Error("failed to update webhook", "id", id, "err", err)
-		http.Error(w, `{"error":"failed to update webhook"}`, http.StatusInternalServerError)
+		writeUpdateError(w, id, "failed to update webhook", err)
 		return
 	}
 
-	writeJSONResponse(w, http.StatusOK, webhookToResponse(hook))
+	httpjson.Write(w, http.StatusOK, webhookToResponse(hook))
 }
 
 // HandleDeleteWebhook handles DELETE /webhooks/{id}
 func (s *Service) HandleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 
 	id := extractID(r.URL.Path)
 	if id == "" {
-		http.Error(w, `{"error":"webhook id is required"}`, http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "webhook id is required")
 		return
 	}
 
 	if err := s.store.DeleteWebhook(id); err != nil {
 		slog.Error("failed to delete webhook", "id", id, "err", err)
-		if errors.Is(err, ErrNotFound) {
-			http.Error(w, `{"error":"webhook not found"}`, http.StatusNotFound)
+		if errors.Is(err, ErrNotFound) || errors.Is(err, store.ErrNotFound) {
+			writeJSONError(w, http.StatusNotFound, "webhook not found")
 		} else {
-			http.Error(w, `{"error":"failed to delete webhook"}`, http.StatusInternalServerError)
+			writeJSONError(w, http.StatusInternalServerError, "failed to delete webhook")
 		}
 		return
 	}
@@ -190,46 +317,182 @@ func (s *Service) HandleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// HandleDeleteAllWebhooks handles DELETE /webhooks/, removing every webhook
+func (s *Service) HandleDeleteAllWebhooks(w http.ResponseWriter, _ *http.Request) {
+	if err := s.store.DeleteAllWebhooks(); err != nil {
+		slog.Error("failed to delete webhooks", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to delete webhooks")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // HandleToggleWebhook handles POST /webhooks/{id}/toggle to enable/disable
 func (s *Service) HandleToggleWebhook(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 
 	id := extractID(r.URL.Path)
 	if id == "" {
-		http.Error(w, `{"error":"webhook id is required"}`, http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "webhook id is required")
 		return
 	}
 
 	hook, err := s.store.GetWebhook(id)
 	if err != nil || hook == nil {
-		http.Error(w, `{"error":"webhook not found"}`, http.StatusNotFound)
+		writeJSONError(w, http.StatusNotFound, "webhook not found")
 		return
 	}
 
 	hook.Enabled = !hook.Enabled
 	if err := s.store.UpdateWebhook(hook); err != nil {
-		slog.Error("failed to toggle webhook", "id", id, "err", err)
-		http.Error(w, `{"error":"failed to toggle webhook"}`, http.StatusInternalServerError)
+		writeUpdateError(w, id, "failed to toggle webhook", err)
 		return
 	}
 
-	writeJSONResponse(w, http.StatusOK, webhookToResponse(hook))
+	httpjson.Write(w, http.StatusOK, webhookToResponse(hook))
+}
+
+// HandleRotateSecret handles POST /webhooks/{id}/rotate-secret.
+// It replaces the signing secret with a new random one and returns it once.
+func (s *Service) HandleRotateSecret(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		writeJSONError(w, http.StatusBadRequest, "webhook id is required")
+		return
+	}
+
+	hook, err := s.store.GetWebhook(id)
+	if err != nil || hook == nil {
+		writeJSONError(w, http.StatusNotFound, "webhook not found")
+		return
+	}
+
+	secret, err := generateSecret()
+	if err != nil {
+		slog.Error("failed to generate webhook secret", "id", id, "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to rotate secret")
+		return
+	}
+
+	hook.Secret = secret
+	if err := s.store.UpdateWebhook(hook); err != nil {
+		writeUpdateError(w, id, "failed to rotate secret", err)
+		return
+	}
+
+	resp := webhookToResponse(hook)
+	resp.Secret = secret // Only returned once, like on creation
+	httpjson.Write(w, http.StatusOK, resp)
+}
+
+// HandleTestWebhook handles POST /webhooks/{id}/test. It sends a sample event
+// of each subscribed type to the webhook's URL and reports how each went, so
+// users can check their endpoint without sending mail. Deliveries still
+// pending when the client goes away are abandoned.
+func (s *Service) HandleTestWebhook(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == "" {
+		writeJSONError(w, http.StatusBadRequest, "webhook id is required")
+		return
+	}
+
+	hook, err := s.store.GetWebhook(id)
+	if err != nil || hook == nil {
+		writeJSONError(w, http.StatusNotFound, "webhook not found")
+		return
+	}
+
+	tester, ok := s.dispatcher.(webhookTester)
+	if !ok {
+		writeJSONError(w, http.StatusNotImplemented, "webhook test delivery is not supported")
+		return
+	}
+	httpjson.Write(w, http.StatusOK, TestResponse{Result: tester.TestWebhook(r.Context(), hook)})
+}
+
+// HandleGetSigningKey handles GET /webhooks/event/settings/signed. It
+// returns the public key consumers verify ECDSA-signed events with.
+func (s *Service) HandleGetSigningKey(w http.ResponseWriter, _ *http.Request) {
+	if s.signingKey == nil {
+		httpjson.Write(w, http.StatusOK, SigningKeyResponse{})
+		return
+	}
+	publicKey, err := encodePublicKey(s.signingKey)
+	if err != nil {
+		slog.Error("failed to encode webhook public key", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to encode public key")
+		return
+	}
+	httpjson.Write(w, http.StatusOK, SigningKeyResponse{Enabled: true, PublicKey: publicKey})
 }
 
 // Helper functions
 
+// validateSigning returns the error message for a webhook whose signing
+// setup is invalid, or "" when it is valid: the mode must be known, ECDSA
+// needs the signing key, and while signing is required its events must be
+// signed.
+func (s *Service) validateSigning(hook *store.WebhookConfig) string {
+	switch hook.SignatureMode {
+	case "", SignatureModeHMAC:
+	case SignatureModeECDSA:
+		if s.signingKey == nil {
+			return errNoSigningKey
+		}
+	default:
+		return fmt.Sprintf("unsupported signature_mode %q (want ecdsa or hmac)", hook.SignatureMode)
+	}
+	if s.requireSigned && !isSigned(hook, s.signingKey) {
+		return errSecretRequired
+	}
+	return ""
+}
+
+// ValidateWebhook reports whether hook could have been created through this
+// service, checking its signing setup the way create and update do. It lets
+// webhooks written by other means, like an admin import, follow the same rules.
+func (s *Service) ValidateWebhook(hook *store.WebhookConfig) error {
+	if msg := s.validateSigning(hook); msg != "" {
+		return errors.New(msg)
+	}
+	return nil
+}
+
 func webhookToResponse(hook *store.WebhookConfig) *WebhookResponse {
 	return &WebhookResponse{
-		ID:      hook.ID,
-		URL:     hook.URL,
-		Events:  hook.Events,
-		Enabled: hook.Enabled,
+		ID:        hook.ID,
+		URL:       hook.URL,
+		Events:    hook.Events,
+		Enabled:   hook.Enabled,
+		TimeoutMS: hook.TimeoutMS,
+		Envelope:  hook.Envelope,
+		Created:   hook.CreatedAt,
+		Modified:  hook.UpdatedAt,
+
+		SignatureMode: hook.SignatureMode,
 	}
 }
 
+// decodeBody decodes the size-limited request body into v, writing a 413
+// when it exceeds the limit or a 400 when it is not valid JSON.
+func (s *Service) decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, s.maxBody)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit))
+			return false
+		}
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return false
+	}
+	return true
+}
+
 func extractID(path string) string {
 	// Parse /webhooks/{id} or /webhooks/{id}/toggle
 	parts := strings.Split(strings.TrimSuffix(path, "/"), "/")
@@ -242,18 +505,40 @@ func extractID(path string) string {
 	return ""
 }
 
+// generateID returns a random RFC 4122 version 4 UUID, the format SendGrid
+// uses for webhook IDs.
 func generateID() string {
-	b := make([]byte, 4)
+	var b [16]byte
+	_, _ = rand.Read(b[:])  // crypto/rand.Read never returns an error
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// generateSecret returns a random hex-encoded 32-byte signing secret.
+func generateSecret() (string, error) {
+	b := make([]byte, 32)
 	if _, err := rand.Read(b); err != nil {
-		return fmt.Sprintf("wh_%d", time.Now().UnixNano())
+		return "", fmt.Errorf("generate random bytes: %w", err)
 	}
-	return fmt.Sprintf("wh_%d_%x", time.Now().UnixNano(), b)
+	return hex.EncodeToString(b), nil
+}
+
+// writeJSONError writes a SendGrid-style error body with the given status.
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	httpjson.Write(w, status, objects.GetErrorResponse(message, nil, nil))
 }
 
-func writeJSONResponse(w http.ResponseWriter, status int, payload interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	if err := json.NewEncoder(w).Encode(payload); err != nil {
-		slog.Error("failed to encode response", "err", err)
+// writeUpdateError reports a failed UpdateWebhook: 409 when the webhook was
+// changed by another request, 404 when it is gone, otherwise 500 with msg.
+func writeUpdateError(w http.ResponseWriter, id, msg string, err error) {
+	switch {
+	case errors.Is(err, store.ErrConflict):
+		writeJSONError(w, http.StatusConflict, "webhook was modified by another request")
+	case errors.Is(err, store.ErrNotFound):
+		writeJSONError(w, http.StatusNotFound, "webhook not found")
+	default:
+		slog.Error(msg, "id", id, "err", err)
+		writeJSONError(w, http.StatusInternalServerError, msg)
 	}
 }
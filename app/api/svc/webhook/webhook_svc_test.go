@@ -0,0 +1,875 @@
+package webhook_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mustur/mockgrid/app/api/objects"
+	"github.com/mustur/mockgrid/app/api/store"
+	"github.com/mustur/mockgrid/app/api/store/filesystem"
+	"github.com/mustur/mockgrid/app/api/store/memory"
+	"github.com/mustur/mockgrid/app/api/store/sqlite"
+	"github.com/mustur/mockgrid/app/api/svc/webhook"
+	"github.com/mustur/mockgrid/internal/testutil"
+)
+
+// --- Auth Tests ---
+
+func TestAuth_InvalidToken_ReturnsJSON401(t *testing.T) {
+	svc := webhook.NewService(testutil.NewMockWebhookStore(), &store.NoOpDispatcher{}).WithAuthKey("secret")
+	srv := httptest.NewServer(buildServiceMux(svc))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected application/json, got %q", ct)
+	}
+	var body objects.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(body.Errors) != 1 || body.Errors[0].Message != objects.UnauthorizedMessage {
+		t.Errorf("unexpected error body %+v", body)
+	}
+}
+
+func TestAuth_WebhookEndpoints(t *testing.T) {
+	hooks := testutil.NewMockWebhookStore()
+	srv := httptest.NewServer(buildServiceMux(webhook.NewService(hooks, &store.NoOpDispatcher{}).WithAuthKey("secret")))
+	defer srv.Close()
+
+	endpoints := []struct {
+		method, path string
+	}{
+		{http.MethodPost, "/"},
+		{http.MethodGet, "/"},
+		{http.MethodDelete, "/"},
+		{http.MethodGet, "/missing"},
+		{http.MethodPut, "/missing"},
+		{http.MethodDelete, "/missing"},
+		{http.MethodPost, "/missing/toggle"},
+		{http.MethodPost, "/missing/rotate-secret"},
+	}
+	tokens := []struct {
+		name   string
+		header string
+		authed bool
+	}{
+		{"valid", "Bearer secret", true},
+		{"invalid", "Bearer wrong", false},
+		{"missing", "", false},
+	}
+
+	for _, ep := range endpoints {
+		for _, tok := range tokens {
+			t.Run(ep.method+" "+ep.path+"/"+tok.name, func(t *testing.T) {
+				body := strings.NewReader(`{"url":"http://example.com/hook","events":["delivered"]}`)
+				req, _ := http.NewRequest(ep.method, srv.URL+ep.path, body)
+				req.Header.Set("Content-Type", "application/json")
+				if tok.header != "" {
+					req.Header.Set("Authorization", tok.header)
+				}
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatalf("request failed: %v", err)
+				}
+				defer resp.Body.Close()
+
+				if got := resp.StatusCode == http.StatusUnauthorized; got == tok.authed {
+					t.Errorf("unexpected status %d", resp.StatusCode)
+				}
+			})
+		}
+	}
+}
+
+func TestAuth_EmptyKeyDisablesAuth(t *testing.T) {
+	srv := httptest.NewServer(buildServiceMux(webhook.NewService(testutil.NewMockWebhookStore(), &store.NoOpDispatcher{})))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200, got %d", resp.StatusCode)
+	}
+}
+
+// --- Create Tests ---
+
+func TestCreateWebhook_ReturnsCreatedAndModified(t *testing.T) {
+	st, err := sqlite.New(filepath.Join(t.TempDir(), "webhooks.db"), sqlite.PoolConfig{})
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	if err := st.Connect(); err != nil {
+		t.Fatalf("failed to connect store: %v", err)
+	}
+	defer st.Close()
+
+	srv := httptest.NewServer(webhook.NewService(st, &store.NoOpDispatcher{}).GetMux())
+	defer srv.Close()
+
+	before := time.Now().Unix()
+	body := strings.NewReader(`{"url":"http://example.com/hook","events":["delivered"]}`)
+	resp, err := http.Post(srv.URL+"/", "application/json", body)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.StatusCode)
+	}
+
+	var wr webhook.WebhookResponse
+	if err := json.NewDecoder(resp.Body).Decode(&wr); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if wr.Created < before || wr.Modified != wr.Created {
+		t.Errorf("expected created and modified set at creation, got %d/%d", wr.Created, wr.Modified)
+	}
+
+	stored, err := st.GetWebhook(wr.ID)
+	if err != nil {
+		t.Fatalf("GetWebhook failed: %v", err)
+	}
+	if stored.CreatedAt != wr.Created {
+		t.Errorf("expected stored created_at %d, got %d", wr.Created, stored.CreatedAt)
+	}
+}
+
+func TestCreateWebhook_LocationResolvesToWebhook(t *testing.T) {
+	svc := webhook.NewService(testutil.NewMockWebhookStore(), &store.NoOpDispatcher{})
+	mux := http.NewServeMux()
+	mux.Handle(svc.GetRoot(), http.StripPrefix(strings.TrimSuffix(svc.GetRoot(), "/"), svc.GetMux()))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	body := strings.NewReader(`{"url":"http://example.com/hook","events":["delivered"]}`)
+	resp, err := http.Post(srv.URL+svc.GetRoot(), "application/json", body)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.StatusCode)
+	}
+	var created webhook.WebhookResponse
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	location := resp.Header.Get("Location")
+	if location != "/v3/webhooks/"+created.ID {
+		t.Fatalf("expected Location /v3/webhooks/%s, got %q", created.ID, location)
+	}
+
+	got, err := http.Get(srv.URL + location)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer got.Body.Close()
+	if got.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 from Location, got %d", got.StatusCode)
+	}
+	var fetched webhook.WebhookResponse
+	if err := json.NewDecoder(got.Body).Decode(&fetched); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if fetched.ID != created.ID || fetched.URL != created.URL {
+		t.Errorf("expected the created webhook, got %+v", fetched)
+	}
+}
+
+func TestCreateWebhook_IDIsUUIDv4(t *testing.T) {
+	st, err := filesystem.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	if err := st.Connect(); err != nil {
+		t.Fatalf("failed to connect store: %v", err)
+	}
+	defer st.Close()
+
+	srv := httptest.NewServer(webhook.NewService(st, &store.NoOpDispatcher{}).GetMux())
+	defer srv.Close()
+
+	uuidV4 := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	seen := map[string]bool{}
+	for range 3 {
+		wr := createWebhook(t, srv.URL)
+		if !uuidV4.MatchString(wr.ID) {
+			t.Errorf("expected a version 4 UUID, got %q", wr.ID)
+		}
+		if seen[wr.ID] {
+			t.Errorf("duplicate ID %q", wr.ID)
+		}
+		seen[wr.ID] = true
+
+		// The ID doubles as the filesystem store's file name
+		if _, err := st.GetWebhook(wr.ID); err != nil {
+			t.Errorf("GetWebhook(%q) failed: %v", wr.ID, err)
+		}
+	}
+}
+
+func TestCreateWebhook_InjectedIDGenerator(t *testing.T) {
+	hooks := testutil.NewMockWebhookStore()
+	svc := webhook.NewService(hooks, &store.NoOpDispatcher{}).
+		WithIDGenerator(func() string { return "77d4a5da-7015-11ed-a1eb-0242ac120002" })
+	srv := httptest.NewServer(svc.GetMux())
+	defer srv.Close()
+
+	if wr := createWebhook(t, srv.URL); wr.ID != "77d4a5da-7015-11ed-a1eb-0242ac120002" {
+		t.Errorf("expected injected ID, got %q", wr.ID)
+	}
+}
+
+func TestCreateWebhook_OversizedBody_Returns413(t *testing.T) {
+	hooks := testutil.NewMockWebhookStore()
+	srv := httptest.NewServer(webhook.NewService(hooks, &store.NoOpDispatcher{}).WithMaxBodyBytes(64).GetMux())
+	defer srv.Close()
+
+	body := `{"url":"http://example.com/hook","events":["delivered"],"secret":"` + strings.Repeat("x", 128) + `"}`
+	for _, req := range []struct{ method, path string }{
+		{http.MethodPost, "/"},
+		{http.MethodPut, "/wh_1"},
+	} {
+		r, _ := http.NewRequest(req.method, srv.URL+req.path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s %s: expected 413, got %d", req.method, req.path, resp.StatusCode)
+		}
+	}
+
+	if list, _ := hooks.ListWebhooks(); len(list) != 0 {
+		t.Errorf("expected no webhook to be created, got %d", len(list))
+	}
+}
+
+func TestCreateWebhook_RequireSigned_RejectsMissingSecret(t *testing.T) {
+	hooks := testutil.NewMockWebhookStore()
+	srv := httptest.NewServer(webhook.NewService(hooks, &store.NoOpDispatcher{}).WithRequireSigned(true).GetMux())
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/", "application/json", strings.NewReader(`{"url":"http://example.com/hook","events":["delivered"]}`))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 without a secret, got %d", resp.StatusCode)
+	}
+	if list, _ := hooks.ListWebhooks(); len(list) != 0 {
+		t.Fatalf("expected no webhook to be created, got %d", len(list))
+	}
+
+	resp, err = http.Post(srv.URL+"/", "application/json", strings.NewReader(`{"url":"http://example.com/hook","events":["delivered"],"secret":"s3cret"}`))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("expected 201 with a secret, got %d", resp.StatusCode)
+	}
+}
+
+func TestUpdateWebhook_RequireSigned_RejectsUnsignedWebhook(t *testing.T) {
+	hooks := testutil.NewMockWebhookStore(&store.WebhookConfig{
+		ID: "wh_1", URL: "http://example.com/hook", Enabled: true, Events: []string{"delivered"},
+	})
+	srv := httptest.NewServer(webhook.NewService(hooks, &store.NoOpDispatcher{}).WithRequireSigned(true).GetMux())
+	defer srv.Close()
+
+	if resp := updateWebhook(t, srv.URL+"/wh_1", `{"url":"http://example.com/other"}`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 for a webhook without a secret, got %d", resp.StatusCode)
+	}
+	if resp := updateWebhook(t, srv.URL+"/wh_1", `{"secret":"s3cret"}`); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 when the update adds a secret, got %d", resp.StatusCode)
+	}
+}
+
+// --- Error Response Tests ---
+
+func TestErrorPaths_ReturnSendGridJSON(t *testing.T) {
+	hooks := testutil.NewMockWebhookStore()
+	srv := httptest.NewServer(webhook.NewService(hooks, &store.NoOpDispatcher{}).WithMaxBodyBytes(256).GetMux())
+	defer srv.Close()
+
+	cases := []struct {
+		name    string
+		method  string
+		path    string
+		body    string
+		status  int
+		message string
+	}{
+		{"invalid body", http.MethodPost, "/", `{`, http.StatusBadRequest, "invalid request body"},
+		{"missing url", http.MethodPost, "/", `{"events":["delivered"]}`, http.StatusBadRequest, "url is required"},
+		{"missing events", http.MethodPost, "/", `{"url":"http://example.com"}`, http.StatusBadRequest, "events array is required"},
+		{"negative timeout", http.MethodPost, "/", `{"url":"http://example.com","events":["delivered"],"timeout_ms":-1}`, http.StatusBadRequest, "timeout_ms must not be negative"},
+		{"oversized body", http.MethodPost, "/", `{"url":"` + strings.Repeat("x", 300) + `"}`, http.StatusRequestEntityTooLarge, "request body exceeds 256 bytes"},
+		{"update unknown", http.MethodPut, "/missing", `{}`, http.StatusNotFound, "webhook not found"},
+		{"delete unknown", http.MethodDelete, "/missing", "", http.StatusNotFound, "webhook not found"},
+		{"toggle unknown", http.MethodPost, "/missing/toggle", "", http.StatusNotFound, "webhook not found"},
+		{"rotate unknown", http.MethodPost, "/missing/rotate-secret", "", http.StatusNotFound, "webhook not found"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tc.method, srv.URL+tc.path, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tc.status {
+				t.Errorf("expected %d, got %d", tc.status, resp.StatusCode)
+			}
+			if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+				t.Errorf("expected application/json, got %q", ct)
+			}
+			var body objects.ErrorResponse
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("response is not valid JSON: %v", err)
+			}
+			if len(body.Errors) != 1 || body.Errors[0].Message != tc.message {
+				t.Errorf("expected error %q, got %+v", tc.message, body)
+			}
+		})
+	}
+}
+
+// --- Delete Tests ---
+
+func TestDeleteAllWebhooks_EmptiesList(t *testing.T) {
+	for name, newStore := range webhookStores() {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(webhook.NewService(newStore(t), &store.NoOpDispatcher{}).GetMux())
+			defer srv.Close()
+
+			for range 3 {
+				createWebhook(t, srv.URL)
+			}
+			if got := listWebhooks(t, srv.URL); len(got.Result) != 3 {
+				t.Fatalf("expected 3 webhooks before delete, got %d", len(got.Result))
+			}
+
+			req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/", nil)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusNoContent {
+				t.Fatalf("expected 204, got %d", resp.StatusCode)
+			}
+
+			if got := listWebhooks(t, srv.URL); len(got.Result) != 0 {
+				t.Errorf("expected no webhooks after delete, got %d", len(got.Result))
+			}
+		})
+	}
+}
+
+// --- Update Tests ---
+
+func TestUpdateWebhook_StaleUpdatedAt_Returns409(t *testing.T) {
+	for name, newStore := range webhookStores() {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(webhook.NewService(newStore(t), &store.NoOpDispatcher{}).GetMux())
+			defer srv.Close()
+
+			created := createWebhook(t, srv.URL)
+			stale := created.Modified
+
+			// Both updates are based on the same read; only the first may win
+			first := updateWebhook(t, srv.URL+"/"+created.ID, fmt.Sprintf(`{"url":"http://example.com/first","updated_at":%d}`, stale))
+			if first.StatusCode != http.StatusOK {
+				t.Fatalf("expected 200 for the first update, got %d", first.StatusCode)
+			}
+			second := updateWebhook(t, srv.URL+"/"+created.ID, fmt.Sprintf(`{"url":"http://example.com/second","updated_at":%d}`, stale))
+			if second.StatusCode != http.StatusConflict {
+				t.Fatalf("expected 409 for the stale update, got %d", second.StatusCode)
+			}
+
+			got := listWebhooks(t, srv.URL)
+			if len(got.Result) != 1 || got.Result[0].URL != "http://example.com/first" {
+				t.Errorf("expected the first update to be kept, got %+v", got.Result)
+			}
+			if got.Result[0].Modified <= stale {
+				t.Errorf("expected modified to advance past %d, got %d", stale, got.Result[0].Modified)
+			}
+
+			// Without updated_at the webhook as just read is the base
+			if resp := updateWebhook(t, srv.URL+"/"+created.ID, `{"url":"http://example.com/third"}`); resp.StatusCode != http.StatusOK {
+				t.Errorf("expected 200 without updated_at, got %d", resp.StatusCode)
+			}
+		})
+	}
+}
+
+// --- Rotate Secret Tests ---
+
+func TestRotateSecret_ReturnsNewSecretAndSignsWithIt(t *testing.T) {
+	type delivery struct {
+		header http.Header
+		body   []byte
+	}
+	got := make(chan delivery, 1)
+	consumer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- delivery{header: r.Header.Clone(), body: body}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer consumer.Close()
+
+	hooks := testutil.NewMockWebhookStore(&store.WebhookConfig{
+		ID:      "wh_rotate",
+		URL:     consumer.URL,
+		Enabled: true,
+		Events:  []string{"delivered"},
+		Secret:  "old-secret",
+	})
+	d := webhook.NewDispatcher(hooks, webhook.DispatcherConfig{})
+	srv := httptest.NewServer(webhook.NewService(hooks, d).GetMux())
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/wh_rotate/rotate-secret", "application/json", nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	var wr webhook.WebhookResponse
+	if err := json.NewDecoder(resp.Body).Decode(&wr); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if wr.Secret == "" || wr.Secret == "old-secret" {
+		t.Fatalf("expected a new secret, got %q", wr.Secret)
+	}
+
+	d.DispatchMessageEvent("msg-1", "to@example.com", "from@example.com", "Hi", "delivered", "", "")
+
+	var dl delivery
+	select {
+	case dl = <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for webhook delivery")
+	}
+
+	mac := hmac.New(sha256.New, []byte(wr.Secret))
+	mac.Write([]byte(dl.header.Get(webhook.TimestampHeader)))
+	mac.Write(dl.body)
+	if sig := dl.header.Get(webhook.SignatureHeader); sig != hex.EncodeToString(mac.Sum(nil)) {
+		t.Error("delivery was not signed with the rotated secret")
+	}
+}
+
+func TestRotateSecret_UnknownWebhook_Returns404(t *testing.T) {
+	hooks := testutil.NewMockWebhookStore()
+	srv := httptest.NewServer(webhook.NewService(hooks, &store.NoOpDispatcher{}).GetMux())
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/missing/rotate-secret", "application/json", nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", resp.StatusCode)
+	}
+}
+
+// --- Test Delivery Tests ---
+
+func TestTestWebhook_SendsSignedSampleOfEachEvent(t *testing.T) {
+	var mu sync.Mutex
+	received := map[string]bool{} // event type -> signature valid
+	consumer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var event webhook.Event
+		_ = json.Unmarshal(body, &event)
+
+		mac := hmac.New(sha256.New, []byte("s3cret"))
+		mac.Write([]byte(r.Header.Get(webhook.TimestampHeader)))
+		mac.Write(body)
+		mu.Lock()
+		received[event.Type] = r.Header.Get(webhook.SignatureHeader) == hex.EncodeToString(mac.Sum(nil))
+		mu.Unlock()
+
+		if event.Type == "bounce" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer consumer.Close()
+
+	hooks := testutil.NewMockWebhookStore(&store.WebhookConfig{
+		ID:      "wh_test",
+		URL:     consumer.URL,
+		Enabled: true,
+		Events:  []string{"delivered", "bounce"},
+		Secret:  "s3cret",
+	})
+	d := webhook.NewDispatcher(hooks, webhook.DispatcherConfig{})
+	srv := httptest.NewServer(webhook.NewService(hooks, d).GetMux())
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/wh_test/test", "application/json", nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var tr webhook.TestResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	want := []webhook.TestDelivery{
+		{Event: "delivered", Delivered: true, StatusCode: http.StatusOK},
+		{Event: "bounce", StatusCode: http.StatusInternalServerError, Error: "webhook returned status 500"},
+	}
+	if fmt.Sprint(tr.Result) != fmt.Sprint(want) {
+		t.Errorf("expected %+v, got %+v", want, tr.Result)
+	}
+	// The results are returned after delivery, so the consumer has seen both
+	mu.Lock()
+	defer mu.Unlock()
+	for _, event := range []string{"delivered", "bounce"} {
+		if valid, ok := received[event]; !ok || !valid {
+			t.Errorf("expected a signed %s sample event, received=%v valid=%v", event, ok, valid)
+		}
+	}
+}
+
+func TestTestWebhook_HangingEndpoint_GivesUpWithRequest(t *testing.T) {
+	release := make(chan struct{})
+	consumer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer consumer.Close()
+	defer close(release)
+
+	hooks := testutil.NewMockWebhookStore(&store.WebhookConfig{
+		ID:      "wh_hang",
+		URL:     consumer.URL,
+		Enabled: true,
+		Events:  []string{"delivered", "bounce", "open"},
+	})
+	mux := webhook.NewService(hooks, webhook.NewDispatcher(hooks, webhook.DispatcherConfig{})).GetMux()
+
+	// The events are sent at once, so all of them give up with the request
+	// rather than one after another
+	const timeout = 200 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req := httptest.NewRequestWithContext(ctx, http.MethodPost, "/wh_hang/test", nil)
+	rec := httptest.NewRecorder()
+	start := time.Now()
+	mux.ServeHTTP(rec, req)
+	if elapsed := time.Since(start); elapsed > 2*timeout {
+		t.Errorf("expected the test to end with its request after %v, took %v", timeout, elapsed)
+	}
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var tr webhook.TestResponse
+	if err := json.NewDecoder(rec.Body).Decode(&tr); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(tr.Result) != 3 {
+		t.Fatalf("expected 3 results, got %+v", tr.Result)
+	}
+	for _, res := range tr.Result {
+		if res.Delivered || !strings.Contains(res.Error, "context deadline exceeded") {
+			t.Errorf("expected %s to time out, got %+v", res.Event, res)
+		}
+	}
+}
+
+func TestTestWebhook_UnknownWebhook_Returns404(t *testing.T) {
+	hooks := testutil.NewMockWebhookStore()
+	srv := httptest.NewServer(webhook.NewService(hooks, webhook.NewDispatcher(hooks, webhook.DispatcherConfig{})).GetMux())
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/missing/test", "application/json", nil)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", resp.StatusCode)
+	}
+}
+
+// --- Signing Key Tests ---
+
+func TestSigningKey_PublicKeyVerifiesDeliveries(t *testing.T) {
+	type delivery struct {
+		header http.Header
+		body   []byte
+	}
+	got := make(chan delivery, 1)
+	consumer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- delivery{header: r.Header.Clone(), body: body}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer consumer.Close()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	hooks := testutil.NewMockWebhookStore()
+	d := webhook.NewDispatcher(hooks, webhook.DispatcherConfig{SigningKey: key})
+	srv := httptest.NewServer(webhook.NewService(hooks, d).WithSigningKey(key).GetMux())
+	defer srv.Close()
+
+	// A webhook with a secret is still signed with ECDSA by default
+	resp, err := http.Post(srv.URL+"/", "application/json", strings.NewReader(fmt.Sprintf(`{"url":%q,"events":["delivered"],"secret":"s3cret"}`, consumer.URL)))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(srv.URL + "/event/settings/signed")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var kr webhook.SigningKeyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&kr); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if !kr.Enabled {
+		t.Fatal("expected signing to be enabled")
+	}
+	der, err := base64.StdEncoding.DecodeString(kr.PublicKey)
+	if err != nil {
+		t.Fatalf("public key is not base64: %v", err)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		t.Fatalf("public key is not DER: %v", err)
+	}
+	pub, ok := parsed.(*ecdsa.PublicKey)
+	if !ok {
+		t.Fatalf("expected an ECDSA public key, got %T", parsed)
+	}
+
+	d.DispatchMessageEvent("msg-1", "to@example.com", "from@example.com", "Hi", "delivered", "", "")
+
+	var dl delivery
+	select {
+	case dl = <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for webhook delivery")
+	}
+	if dl.header.Get(webhook.SignatureHeader) != "" {
+		t.Error("expected no HMAC signature on an ECDSA delivery")
+	}
+	sig, err := base64.StdEncoding.DecodeString(dl.header.Get(webhook.ECDSASignatureHeader))
+	if err != nil {
+		t.Fatalf("signature is not base64: %v", err)
+	}
+	digest := sha256.Sum256(append([]byte(dl.header.Get(webhook.TimestampHeader)), dl.body...))
+	if !ecdsa.VerifyASN1(pub, digest[:], sig) {
+		t.Error("delivery signature does not verify with the published public key")
+	}
+}
+
+func TestSigningKey_NotConfigured_ReportsDisabled(t *testing.T) {
+	srv := httptest.NewServer(webhook.NewService(testutil.NewMockWebhookStore(), &store.NoOpDispatcher{}).GetMux())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/event/settings/signed")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var kr webhook.SigningKeyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&kr); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if kr.Enabled || kr.PublicKey != "" {
+		t.Errorf("expected signing to be disabled, got %+v", kr)
+	}
+}
+
+func TestCreateWebhook_SignatureMode(t *testing.T) {
+	srv := httptest.NewServer(webhook.NewService(testutil.NewMockWebhookStore(), &store.NoOpDispatcher{}).GetMux())
+	defer srv.Close()
+
+	for body, want := range map[string]int{
+		`{"url":"http://example.com/hook","events":["delivered"],"signature_mode":"ecdsa"}`: http.StatusBadRequest,
+		`{"url":"http://example.com/hook","events":["delivered"],"signature_mode":"rsa"}`:   http.StatusBadRequest,
+		`{"url":"http://example.com/hook","events":["delivered"],"signature_mode":"hmac"}`:  http.StatusCreated,
+	} {
+		resp, err := http.Post(srv.URL+"/", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("%s: expected %d, got %d", body, want, resp.StatusCode)
+		}
+	}
+}
+
+func TestUpdateWebhook_SignatureModePersisted(t *testing.T) {
+	for name, newStore := range webhookStores() {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(webhook.NewService(newStore(t), &store.NoOpDispatcher{}).GetMux())
+			defer srv.Close()
+
+			created := createWebhook(t, srv.URL)
+			if resp := updateWebhook(t, srv.URL+"/"+created.ID, `{"signature_mode":"hmac"}`); resp.StatusCode != http.StatusOK {
+				t.Fatalf("expected 200, got %d", resp.StatusCode)
+			}
+			if list := listWebhooks(t, srv.URL); len(list.Result) != 1 || list.Result[0].SignatureMode != webhook.SignatureModeHMAC {
+				t.Errorf("expected signature_mode hmac to be stored, got %+v", list.Result)
+			}
+		})
+	}
+}
+
+// --- Test Helpers ---
+
+func buildServiceMux(svc *webhook.Service) *http.ServeMux {
+	wrappedMux := http.NewServeMux()
+	wrappedMux.Handle("/", svc.Chain()(svc.GetMux()))
+	return wrappedMux
+}
+
+// webhookStores returns constructors for every webhook store implementation.
+func webhookStores() map[string]func(t *testing.T) store.WebhookStore {
+	return map[string]func(t *testing.T) store.WebhookStore{
+		"mock":   func(t *testing.T) store.WebhookStore { return testutil.NewMockWebhookStore() },
+		"memory": func(t *testing.T) store.WebhookStore { return memory.New() },
+		"sqlite": func(t *testing.T) store.WebhookStore {
+			st, err := sqlite.New(filepath.Join(t.TempDir(), "webhooks.db"), sqlite.PoolConfig{})
+			if err != nil {
+				t.Fatalf("failed to create store: %v", err)
+			}
+			if err := st.Connect(); err != nil {
+				t.Fatalf("failed to connect store: %v", err)
+			}
+			t.Cleanup(func() { st.Close() })
+			return st
+		},
+		"filesystem": func(t *testing.T) store.WebhookStore {
+			st, err := filesystem.New(t.TempDir())
+			if err != nil {
+				t.Fatalf("failed to create store: %v", err)
+			}
+			if err := st.Connect(); err != nil {
+				t.Fatalf("failed to connect store: %v", err)
+			}
+			t.Cleanup(func() { st.Close() })
+			return st
+		},
+	}
+}
+
+// updateWebhook sends body as a PUT to url and returns the closed response.
+func updateWebhook(t *testing.T, url, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPut, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func listWebhooks(t *testing.T, baseURL string) webhook.ListResponse {
+	t.Helper()
+	resp, err := http.Get(baseURL + "/")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var lr webhook.ListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&lr); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return lr
+}
+
+func createWebhook(t *testing.T, baseURL string) webhook.WebhookResponse {
+	t.Helper()
+	body := strings.NewReader(`{"url":"http://example.com/hook","events":["delivered"]}`)
+	resp, err := http.Post(baseURL+"/", "application/json", body)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.StatusCode)
+	}
+	var wr webhook.WebhookResponse
+	if err := json.NewDecoder(resp.Body).Decode(&wr); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return wr
+}
@@ -1,11 +1,16 @@
 package config
 
 import (
+	"crypto/tls"
 	"errors"
+	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pterm/pterm"
 	yaml "sigs.k8s.io/yaml/goyaml.v2"
@@ -17,16 +22,80 @@ type Config struct {
 	SMTPPort     int               `yaml:"smtp_port"`
 	MockgridHost string            `yaml:"mockgrid_host"`
 	MockgridPort int               `yaml:"mockgrid_port"`
+	AdminAddr    string            `yaml:"admin_addr"`              // separate host:port for admin endpoints; empty serves them with the API
+	MaxInFlight  int               `yaml:"max_in_flight"`           // concurrent request cap; 0 means unlimited
+	AllowEmpty   bool              `yaml:"allow_empty_body"`        // accept sends with no content and no template_id
+	AllowCcOnly  bool              `yaml:"allow_cc_only"`           // accept personalizations with cc or bcc but no to recipients, stored per cc and bcc recipient
+	HTMLToText   string            `yaml:"html_to_text_mode"`       // "strip" or "readable" to generate a text body for HTML-only sends; empty generates none
+	RequireSign  bool              `yaml:"require_signed_webhooks"` // reject webhooks without a secret and never deliver unsigned events
+	AllowedFrom  []string          `yaml:"allowed_from_domains"`    // from.email domains accepted by sends; empty allows any
+	Verbose      bool              `yaml:"verbose_responses"`       // list per-recipient statuses in the 202 send response
+	AssumeJSON   bool              `yaml:"assume_json"`             // treat sends without a Content-Type as application/json
+	Capture      []string          `yaml:"capture_request_headers"` // request headers stored on each message
+	DailyQuota   int               `yaml:"daily_quota"`             // recipients sent to per UTC day before further sends are dropped; 0 means unlimited
+	PerDomainRPS float64           `yaml:"per_domain_rps"`          // sends per second to each recipient domain before further recipients are deferred; 0 means unlimited
+	IPPools      map[string]string `yaml:"ip_pools"`                // ip_pool_name -> SMTP host:port; other sends use smtp_server
+	DefaultSubs  map[string]string `yaml:"default_substitutions"`   // substitutions applied to every personalization unless it sets the key
+	Server       *ServerConfig     `yaml:"server"`
+	TLS          *TLSConfig        `yaml:"tls"`
+	Middleware   *MiddlewareConfig `yaml:"middleware"`
+	CORS         *CORSConfig       `yaml:"cors"`
 	Templates    *TemplateConfig   `yaml:"templates"`
 	Attachments  *AttachmentConfig `yaml:"attachments"`
 	Auth         *Auth             `yaml:"auth"`
 	Storage      *StorageConfig    `yaml:"storage"`
+	Webhooks     *WebhookSettings  `yaml:"webhooks"`
+	Tracking     *TrackingConfig   `yaml:"tracking"`
+	Greylist     *GreylistConfig   `yaml:"simulate_greylist"`
+	Engagement   *EngagementConfig `yaml:"simulate_engagement"`
+}
+
+// ServerConfig holds HTTP server lifecycle settings.
+type ServerConfig struct {
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"` // wait for in-flight requests, then pending webhook deliveries, on shutdown, e.g. "15s"
+}
+
+// TLSConfig enables HTTPS on every listener.
+type TLSConfig struct {
+	CertFile   string `yaml:"cert_file"`
+	KeyFile    string `yaml:"key_file"`
+	MinVersion string `yaml:"min_version"` // "1.2" or "1.3"
+}
+
+// MinTLSVersion returns the tls package constant for MinVersion.
+func (t *TLSConfig) MinTLSVersion() (uint16, error) {
+	switch t.MinVersion {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported tls.min_version %q (want 1.2 or 1.3)", t.MinVersion)
+	}
+}
+
+// MiddlewareConfig enables middleware applied to every service.
+type MiddlewareConfig struct {
+	Logging         *bool         `yaml:"logging"`           // log every request; nil means enabled
+	CORSOrigin      string        `yaml:"cors_origin"`       // allowed CORS origin, e.g. "*"; ignored when cors.allowed_origins is set
+	RateLimit       int           `yaml:"rate_limit"`        // requests per rate_limit_window across all services before 429s; 0 means unlimited
+	RateLimitWindow time.Duration `yaml:"rate_limit_window"` // window rate_limit applies to, e.g. "1m"; 0 means one minute
+}
+
+// CORSConfig lets browser apps on other origins call the API.
+type CORSConfig struct {
+	AllowedOrigins []string      `yaml:"allowed_origins"` // e.g. ["http://localhost:3000"], or ["*"] for any; empty disables CORS
+	AllowedMethods []string      `yaml:"allowed_methods"` // methods allowed in preflight; empty allows GET, POST, PUT, PATCH, DELETE, OPTIONS
+	AllowedHeaders []string      `yaml:"allowed_headers"` // request headers allowed in preflight; empty allows Authorization, Content-Type
+	MaxAge         time.Duration `yaml:"max_age"`         // how long browsers cache a preflight, e.g. "10m"; 0 omits the header
 }
 
 type TemplateConfig struct {
-	Mode        string `yaml:"mode"`         // "local", "sendgrid", "besteffort"
-	Directory   string `yaml:"directory"`    // local templates directory
-	TemplateKey string `yaml:"template_key"` // SendGrid API key for template fetching
+	Mode        string `yaml:"mode"`                  // "local", "sendgrid", "besteffort"
+	Directory   string `yaml:"directory"`             // local templates directory
+	TemplateKey string `yaml:"template_key"`          // SendGrid API key for template fetching
+	Extension   string `yaml:"file_extension"`        // local template file extension; empty means .html
+	MissingMode string `yaml:"template_missing_mode"` // "skip" or "fail" when a besteffort template is unavailable; empty means skip
 }
 
 type Auth struct {
@@ -36,13 +105,109 @@ type Auth struct {
 }
 
 type AttachmentConfig struct {
-	Dir string `yaml:"dir"`
+	Dir      string `yaml:"dir"`
+	MaxBytes int64  `yaml:"max_bytes"` // decoded size limit per attachment; 0 means unlimited
 }
 
 // StorageConfig holds configuration for message persistence.
 type StorageConfig struct {
-	Type string `yaml:"type"` // "none", "sqlite", "filesystem"
-	Path string `yaml:"path"` // path to sqlite db or filesystem directory
+	Type           string        `yaml:"type"`            // "none", "memory", "sqlite", "filesystem", "postgres"
+	Path           string        `yaml:"path"`            // path to sqlite db or filesystem directory, or the postgres DSN
+	ReadyTimeout   time.Duration `yaml:"ready_timeout"`   // how long to wait for the store on startup
+	RecentSize     int           `yaml:"recent_size"`     // messages kept in memory for GET /v3/messages/recent
+	CompressBodies bool          `yaml:"compress_bodies"` // gzip HTML and text bodies in the filesystem and sqlite stores
+	SQLite         *SQLitePool   `yaml:"sqlite"`          // connection pool limits for the sqlite store
+}
+
+// SQLitePool limits the sqlite store's connection pool. Zero values use the
+// store's small defaults.
+type SQLitePool struct {
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"` // e.g. "30m"; 0 keeps connections open
+}
+
+// WebhookSettings holds configuration for outbound webhook delivery.
+type WebhookSettings struct {
+	MaxRetryAfter time.Duration `yaml:"max_retry_after"`  // cap on a consumer's Retry-After wait, e.g. "30s"
+	RetryRate     float64       `yaml:"retry_rate"`       // retry attempts per second across all webhooks; 0 means unlimited
+	Concurrency   int           `yaml:"concurrency"`      // webhooks one event is delivered to at once; 0 means one at a time
+	MaxBodyBytes  int64         `yaml:"max_body_bytes"`   // size cap on webhook create/update bodies; 0 means 1 MiB
+	SigningKey    string        `yaml:"signing_key_file"` // PEM ECDSA private key for SendGrid-style signed events; empty keeps HMAC signing
+}
+
+// TrackingConfig holds global engagement tracking settings.
+type TrackingConfig struct {
+	Open  *OpenTrackingConfig  `yaml:"open"`
+	Click *ClickTrackingConfig `yaml:"click"`
+}
+
+// OpenTrackingConfig controls tracking-pixel injection.
+type OpenTrackingConfig struct {
+	Enable        *bool `yaml:"enable"`          // nil means enabled
+	SkipPlainText bool  `yaml:"skip_plain_text"` // keep text-only sends text-only instead of adding an HTML part for the pixel
+}
+
+// ClickTrackingConfig controls link rewriting for click tracking.
+type ClickTrackingConfig struct {
+	Enable *bool  `yaml:"enable"` // nil means enabled
+	Secret string `yaml:"secret"` // HMAC key signing tracked links; empty derives one from auth.sendgrid_key
+}
+
+// GreylistConfig controls greylisting simulation for outbound sends.
+type GreylistConfig struct {
+	Enable bool          `yaml:"enable"`
+	Window time.Duration `yaml:"window"` // how long a first contact is remembered, e.g. "5m"
+}
+
+// EngagementConfig controls simulated opens and clicks after delivery.
+type EngagementConfig struct {
+	OpenRate  float64 `yaml:"open_rate"`  // probability in [0, 1] that a delivered message is opened
+	ClickRate float64 `yaml:"click_rate"` // probability in [0, 1] that a delivered message is clicked
+	DelayMS   int     `yaml:"delay_ms"`   // delay after delivery before the events occur
+	Seed      uint64  `yaml:"seed"`       // fixed seed for reproducible outcomes; 0 is random
+}
+
+// OpenTrackingEnabled reports whether tracking pixels should be injected.
+// Open tracking is enabled unless explicitly disabled.
+func (c *Config) OpenTrackingEnabled() bool {
+	if c.Tracking == nil || c.Tracking.Open == nil || c.Tracking.Open.Enable == nil {
+		return true
+	}
+	return *c.Tracking.Open.Enable
+}
+
+// SkipPlainTextTracking reports whether text-only sends should go out
+// without an open-tracking pixel.
+func (c *Config) SkipPlainTextTracking() bool {
+	return c.Tracking != nil && c.Tracking.Open != nil && c.Tracking.Open.SkipPlainText
+}
+
+// ClickTrackingEnabled reports whether links should be rewritten for click
+// tracking. Click tracking is enabled unless explicitly disabled.
+func (c *Config) ClickTrackingEnabled() bool {
+	if c.Tracking == nil || c.Tracking.Click == nil || c.Tracking.Click.Enable == nil {
+		return true
+	}
+	return *c.Tracking.Click.Enable
+}
+
+// RequestLoggingEnabled reports whether every service logs its requests.
+// Request logging is enabled unless explicitly disabled.
+func (c *Config) RequestLoggingEnabled() bool {
+	if c.Middleware == nil || c.Middleware.Logging == nil {
+		return true
+	}
+	return *c.Middleware.Logging
+}
+
+// ClickTrackingSecret returns the key click tracking links are signed with,
+// or "" when none is configured.
+func (c *Config) ClickTrackingSecret() string {
+	if c.Tracking == nil || c.Tracking.Click == nil {
+		return ""
+	}
+	return c.Tracking.Click.Secret
 }
 
 func LoadEmailServiceConfig(path string) (*Config, error) {
@@ -71,6 +236,20 @@ func LoadEmailServiceConfig(path string) (*Config, error) {
 	return &cfg, nil
 }
 
+// LoadConfigFiles loads each file in order and merges them with MergeConfig,
+// so values in later files override those in earlier ones.
+func LoadConfigFiles(paths ...string) (*Config, error) {
+	merged := &Config{}
+	for _, path := range paths {
+		cfg, err := LoadEmailServiceConfig(path)
+		if err != nil {
+			return nil, fmt.Errorf("load %s: %w", path, err)
+		}
+		merged = MergeConfig(merged, cfg)
+	}
+	return merged, nil
+}
+
 func (cfg *Config) WithDefaults() {
 
 	if cfg.MockgridHost == "" {
@@ -91,6 +270,24 @@ func (cfg *Config) WithDefaults() {
 	if cfg.Storage == nil {
 		cfg.Storage = &StorageConfig{Type: "none"}
 	}
+	if cfg.Storage.ReadyTimeout == 0 {
+		cfg.Storage.ReadyTimeout = 30 * time.Second
+	}
+	if cfg.Storage.RecentSize == 0 {
+		cfg.Storage.RecentSize = 100
+	}
+	if cfg.Server == nil {
+		cfg.Server = &ServerConfig{}
+	}
+	if cfg.Server.ShutdownTimeout == 0 {
+		cfg.Server.ShutdownTimeout = 15 * time.Second
+	}
+	if cfg.TLS != nil && cfg.TLS.MinVersion == "" {
+		cfg.TLS.MinVersion = "1.2"
+	}
+	if cfg.Greylist != nil && cfg.Greylist.Window == 0 {
+		cfg.Greylist.Window = 5 * time.Minute
+	}
 }
 
 func (c *Config) ValidateConfig() error {
@@ -98,6 +295,25 @@ func (c *Config) ValidateConfig() error {
 	if c.SMTPServer == "" {
 		return errors.New("SMTP server is not configured")
 	}
+	if c.TLS != nil {
+		if c.TLS.CertFile == "" || c.TLS.KeyFile == "" {
+			return errors.New("tls requires both cert_file and key_file")
+		}
+		if _, err := c.TLS.MinTLSVersion(); err != nil {
+			return err
+		}
+	}
+	if c.PerDomainRPS < 0 {
+		return errors.New("per_domain_rps must not be negative")
+	}
+	if c.CORS != nil && c.CORS.MaxAge < 0 {
+		return errors.New("cors.max_age must not be negative")
+	}
+	for name, addr := range c.IPPools {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("ip_pools: %q must be host:port: %w", name, err)
+		}
+	}
 	if c.Attachments == nil || c.Attachments.Dir == "" {
 		pterm.Warning.Println("Attachment directory is not configured, skipping attachment handling")
 	}
@@ -109,6 +325,16 @@ func (c *Config) ValidateConfig() error {
 	if c.Templates != nil {
 		templateDir = c.Templates.Directory
 		mode = c.Templates.Mode
+		switch c.Templates.MissingMode {
+		case "", "skip", "fail":
+		default:
+			return fmt.Errorf("unsupported templates.template_missing_mode %q (want skip or fail)", c.Templates.MissingMode)
+		}
+	}
+	switch c.HTMLToText {
+	case "", "strip", "readable":
+	default:
+		return fmt.Errorf("unsupported html_to_text_mode %q (want strip or readable)", c.HTMLToText)
 	}
 	if c.Auth != nil {
 		sendgridKey = c.Auth.SendgridKey
@@ -174,17 +400,66 @@ func (c *Config) PrintValues() {
 	pterm.Info.Println("SMTP Port:", strconv.Itoa(c.SMTPPort))
 	pterm.Info.Println("Mockgrid Host:", c.MockgridHost)
 	pterm.Info.Println("Mockgrid Port:", strconv.Itoa(c.MockgridPort))
+	pterm.Info.Println("Admin Address:", c.AdminAddr)
+	pterm.Info.Println("Max In-Flight Requests:", strconv.Itoa(c.MaxInFlight))
+	pterm.Info.Println("Allow Empty Body:", strconv.FormatBool(c.AllowEmpty))
+	pterm.Info.Println("Allow Cc Only:", strconv.FormatBool(c.AllowCcOnly))
+	pterm.Info.Println("HTML to Text Mode:", c.HTMLToText)
+	pterm.Info.Println("Require Signed Webhooks:", strconv.FormatBool(c.RequireSign))
+	pterm.Info.Println("Allowed From Domains:", strings.Join(c.AllowedFrom, ", "))
+	pterm.Info.Println("Verbose Responses:", strconv.FormatBool(c.Verbose))
+	pterm.Info.Println("Assume JSON:", strconv.FormatBool(c.AssumeJSON))
+	pterm.Info.Println("Captured Request Headers:", strings.Join(c.Capture, ", "))
+	pterm.Info.Println("Daily Quota:", strconv.Itoa(c.DailyQuota))
+	pterm.Info.Println("Per-Domain Rate:", strconv.FormatFloat(c.PerDomainRPS, 'g', -1, 64))
+	for name, addr := range c.IPPools {
+		pterm.Info.Println("IP Pool "+name+":", addr)
+	}
+	for key, val := range c.DefaultSubs {
+		pterm.Info.Println("Default Substitution "+key+":", val)
+	}
+
+	// tls
+	if c.TLS != nil {
+		pterm.Info.Println("TLS Cert File:", c.TLS.CertFile)
+		pterm.Info.Println("TLS Key File:", c.TLS.KeyFile)
+		pterm.Info.Println("TLS Min Version:", c.TLS.MinVersion)
+	}
+
+	// middleware
+	pterm.Info.Println("Request Logging:", strconv.FormatBool(c.RequestLoggingEnabled()))
+	if c.Middleware != nil {
+		pterm.Info.Println("CORS Origin:", c.Middleware.CORSOrigin)
+		pterm.Info.Println("Rate Limit:", strconv.Itoa(c.Middleware.RateLimit))
+		pterm.Info.Println("Rate Limit Window:", c.Middleware.RateLimitWindow.String())
+	}
+
+	// cors
+	if c.CORS != nil {
+		pterm.Info.Println("CORS Allowed Origins:", strings.Join(c.CORS.AllowedOrigins, ", "))
+		pterm.Info.Println("CORS Allowed Methods:", strings.Join(c.CORS.AllowedMethods, ", "))
+		pterm.Info.Println("CORS Allowed Headers:", strings.Join(c.CORS.AllowedHeaders, ", "))
+		pterm.Info.Println("CORS Max Age:", c.CORS.MaxAge.String())
+	}
 
 	// templates
 	if c.Templates != nil {
 		pterm.Info.Println("Templates Mode:", c.Templates.Mode)
 		pterm.Info.Println("Templates Directory:", c.Templates.Directory)
+		pterm.Info.Println("Templates File Extension:", c.Templates.Extension)
+		pterm.Info.Println("Templates Missing Mode:", c.Templates.MissingMode)
 		pterm.Info.Println("Templates Key:", maskSecret(c.Templates.TemplateKey))
 	}
 
 	// attachments
 	if c.Attachments != nil {
 		pterm.Info.Println("Attachments Directory:", c.Attachments.Dir)
+		pterm.Info.Println("Attachments Max Bytes:", strconv.FormatInt(c.Attachments.MaxBytes, 10))
+	}
+
+	// server
+	if c.Server != nil {
+		pterm.Info.Println("Server Shutdown Timeout:", c.Server.ShutdownTimeout.String())
 	}
 
 	// auth
@@ -197,7 +472,44 @@ func (c *Config) PrintValues() {
 	// storage
 	if c.Storage != nil {
 		pterm.Info.Println("Storage Type:", c.Storage.Type)
-		pterm.Info.Println("Storage Path:", c.Storage.Path)
+		pterm.Info.Println("Storage Path:", redactDSN(c.Storage.Path))
+		pterm.Info.Println("Storage Ready Timeout:", c.Storage.ReadyTimeout.String())
+		pterm.Info.Println("Storage Recent Size:", strconv.Itoa(c.Storage.RecentSize))
+		pterm.Info.Println("Storage Compress Bodies:", strconv.FormatBool(c.Storage.CompressBodies))
+		if c.Storage.SQLite != nil {
+			pterm.Info.Println("SQLite Max Open Conns:", strconv.Itoa(c.Storage.SQLite.MaxOpenConns))
+			pterm.Info.Println("SQLite Max Idle Conns:", strconv.Itoa(c.Storage.SQLite.MaxIdleConns))
+			pterm.Info.Println("SQLite Conn Max Lifetime:", c.Storage.SQLite.ConnMaxLifetime.String())
+		}
+	}
+
+	// webhooks
+	if c.Webhooks != nil {
+		pterm.Info.Println("Webhooks Max Retry-After:", c.Webhooks.MaxRetryAfter.String())
+		pterm.Info.Println("Webhooks Retry Rate:", strconv.FormatFloat(c.Webhooks.RetryRate, 'g', -1, 64))
+		pterm.Info.Println("Webhooks Concurrency:", strconv.Itoa(c.Webhooks.Concurrency))
+		pterm.Info.Println("Webhooks Max Body Bytes:", strconv.FormatInt(c.Webhooks.MaxBodyBytes, 10))
+		pterm.Info.Println("Webhooks Signing Key File:", c.Webhooks.SigningKey)
+	}
+
+	// tracking
+	pterm.Info.Println("Open Tracking Enabled:", strconv.FormatBool(c.OpenTrackingEnabled()))
+	pterm.Info.Println("Open Tracking Skip Plain Text:", strconv.FormatBool(c.SkipPlainTextTracking()))
+	pterm.Info.Println("Click Tracking Enabled:", strconv.FormatBool(c.ClickTrackingEnabled()))
+	if secret := c.ClickTrackingSecret(); secret != "" {
+		pterm.Info.Println("Click Tracking Secret:", maskSecret(secret))
+	}
+
+	// simulations
+	if c.Greylist != nil {
+		pterm.Info.Println("Simulate Greylist:", strconv.FormatBool(c.Greylist.Enable))
+		pterm.Info.Println("Greylist Window:", c.Greylist.Window.String())
+	}
+	if c.Engagement != nil {
+		pterm.Info.Println("Engagement Open Rate:", strconv.FormatFloat(c.Engagement.OpenRate, 'g', -1, 64))
+		pterm.Info.Println("Engagement Click Rate:", strconv.FormatFloat(c.Engagement.ClickRate, 'g', -1, 64))
+		pterm.Info.Println("Engagement Delay (ms):", strconv.Itoa(c.Engagement.DelayMS))
+		pterm.Info.Println("Engagement Seed:", strconv.FormatUint(c.Engagement.Seed, 10))
 	}
 }
 
@@ -210,12 +522,30 @@ func maskSecret(s string) string {
 	if len(s) <= 8 {
 		return strings.Repeat("*", len(s))
 	}
-	return strings.Repeat("*", 8)
+	return s[:4] + strings.Repeat("*", len(s)-8) + s[len(s)-4:]
+}
+
+// redactDSN hides the password in a database DSN, in URL or keyword/value
+// form. Other paths are returned unchanged.
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	fields := strings.Fields(dsn)
+	for i, f := range fields {
+		if strings.HasPrefix(f, "password=") {
+			fields[i] = "password=xxxxx"
+			return strings.Join(fields, " ")
+		}
+	}
+	return dsn
 }
 
 // LoadFromEnv constructs a Config by reading environment variables.
 // It only sets values that are present in the environment; zero values
 // indicate absence and can be overridden by a config file or flags.
+// The bind address falls back to the PaaS convention of HOST and PORT when
+// MOCKGRID_HOST and MOCKGRID_PORT are unset.
 func LoadFromEnv() *Config {
 	cfg := &Config{}
 
@@ -227,10 +557,10 @@ func LoadFromEnv() *Config {
 			cfg.SMTPPort = i
 		}
 	}
-	if v := os.Getenv("MOCKGRID_HOST"); v != "" {
+	if v := firstEnv("MOCKGRID_HOST", "HOST"); v != "" {
 		cfg.MockgridHost = v
 	}
-	if v := os.Getenv("MOCKGRID_PORT"); v != "" {
+	if v := firstEnv("MOCKGRID_PORT", "PORT"); v != "" {
 		if i, err := strconv.Atoi(v); err == nil {
 			cfg.MockgridPort = i
 		}
@@ -297,6 +627,16 @@ func LoadFromEnv() *Config {
 	return cfg
 }
 
+// firstEnv returns the value of the first of keys that is set and non-empty.
+func firstEnv(keys ...string) string {
+	for _, k := range keys {
+		if v := os.Getenv(k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
 // MergeConfig overlays non-zero values from 'over' onto 'base'.
 // Values in 'over' take precedence when set (non-empty string or non-zero int).
 func MergeConfig(base *Config, over *Config) *Config {
@@ -322,6 +662,113 @@ func MergeConfig(base *Config, over *Config) *Config {
 	if over.MockgridPort != 0 {
 		base.MockgridPort = over.MockgridPort
 	}
+	if over.AdminAddr != "" {
+		base.AdminAddr = over.AdminAddr
+	}
+	if over.MaxInFlight != 0 {
+		base.MaxInFlight = over.MaxInFlight
+	}
+	if over.AllowEmpty {
+		base.AllowEmpty = true
+	}
+	if over.AllowCcOnly {
+		base.AllowCcOnly = true
+	}
+	if over.HTMLToText != "" {
+		base.HTMLToText = over.HTMLToText
+	}
+	if over.RequireSign {
+		base.RequireSign = true
+	}
+	if over.Verbose {
+		base.Verbose = true
+	}
+	if over.AssumeJSON {
+		base.AssumeJSON = true
+	}
+	if len(over.AllowedFrom) > 0 {
+		base.AllowedFrom = over.AllowedFrom
+	}
+	if len(over.Capture) > 0 {
+		base.Capture = over.Capture
+	}
+	if over.DailyQuota != 0 {
+		base.DailyQuota = over.DailyQuota
+	}
+	if over.PerDomainRPS != 0 {
+		base.PerDomainRPS = over.PerDomainRPS
+	}
+	if len(over.IPPools) > 0 {
+		base.IPPools = over.IPPools
+	}
+	if len(over.DefaultSubs) > 0 {
+		base.DefaultSubs = over.DefaultSubs
+	}
+
+	// TLS
+	if over.TLS != nil {
+		if base.TLS == nil {
+			base.TLS = &TLSConfig{}
+		}
+		if over.TLS.CertFile != "" {
+			base.TLS.CertFile = over.TLS.CertFile
+		}
+		if over.TLS.KeyFile != "" {
+			base.TLS.KeyFile = over.TLS.KeyFile
+		}
+		if over.TLS.MinVersion != "" {
+			base.TLS.MinVersion = over.TLS.MinVersion
+		}
+	}
+
+	// Server
+	if over.Server != nil {
+		if base.Server == nil {
+			base.Server = &ServerConfig{}
+		}
+		if over.Server.ShutdownTimeout != 0 {
+			base.Server.ShutdownTimeout = over.Server.ShutdownTimeout
+		}
+	}
+
+	// Middleware
+	if over.Middleware != nil {
+		if base.Middleware == nil {
+			base.Middleware = &MiddlewareConfig{}
+		}
+		if over.Middleware.Logging != nil {
+			logging := *over.Middleware.Logging
+			base.Middleware.Logging = &logging
+		}
+		if over.Middleware.CORSOrigin != "" {
+			base.Middleware.CORSOrigin = over.Middleware.CORSOrigin
+		}
+		if over.Middleware.RateLimit != 0 {
+			base.Middleware.RateLimit = over.Middleware.RateLimit
+		}
+		if over.Middleware.RateLimitWindow != 0 {
+			base.Middleware.RateLimitWindow = over.Middleware.RateLimitWindow
+		}
+	}
+
+	// CORS
+	if over.CORS != nil {
+		if base.CORS == nil {
+			base.CORS = &CORSConfig{}
+		}
+		if len(over.CORS.AllowedOrigins) > 0 {
+			base.CORS.AllowedOrigins = over.CORS.AllowedOrigins
+		}
+		if len(over.CORS.AllowedMethods) > 0 {
+			base.CORS.AllowedMethods = over.CORS.AllowedMethods
+		}
+		if len(over.CORS.AllowedHeaders) > 0 {
+			base.CORS.AllowedHeaders = over.CORS.AllowedHeaders
+		}
+		if over.CORS.MaxAge != 0 {
+			base.CORS.MaxAge = over.CORS.MaxAge
+		}
+	}
 
 	// Templates
 	if over.Templates != nil {
@@ -337,14 +784,25 @@ func MergeConfig(base *Config, over *Config) *Config {
 		if over.Templates.TemplateKey != "" {
 			base.Templates.TemplateKey = over.Templates.TemplateKey
 		}
+		if over.Templates.Extension != "" {
+			base.Templates.Extension = over.Templates.Extension
+		}
+		if over.Templates.MissingMode != "" {
+			base.Templates.MissingMode = over.Templates.MissingMode
+		}
 	}
 
 	// Attachments
-	if over.Attachments != nil && over.Attachments.Dir != "" {
+	if over.Attachments != nil {
 		if base.Attachments == nil {
 			base.Attachments = &AttachmentConfig{}
 		}
-		base.Attachments.Dir = over.Attachments.Dir
+		if over.Attachments.Dir != "" {
+			base.Attachments.Dir = over.Attachments.Dir
+		}
+		if over.Attachments.MaxBytes != 0 {
+			base.Attachments.MaxBytes = over.Attachments.MaxBytes
+		}
 	}
 
 	// Auth
@@ -374,6 +832,115 @@ func MergeConfig(base *Config, over *Config) *Config {
 		if over.Storage.Path != "" {
 			base.Storage.Path = over.Storage.Path
 		}
+		if over.Storage.ReadyTimeout != 0 {
+			base.Storage.ReadyTimeout = over.Storage.ReadyTimeout
+		}
+		if over.Storage.RecentSize != 0 {
+			base.Storage.RecentSize = over.Storage.RecentSize
+		}
+		if over.Storage.CompressBodies {
+			base.Storage.CompressBodies = true
+		}
+		if over.Storage.SQLite != nil {
+			if base.Storage.SQLite == nil {
+				base.Storage.SQLite = &SQLitePool{}
+			}
+			if over.Storage.SQLite.MaxOpenConns != 0 {
+				base.Storage.SQLite.MaxOpenConns = over.Storage.SQLite.MaxOpenConns
+			}
+			if over.Storage.SQLite.MaxIdleConns != 0 {
+				base.Storage.SQLite.MaxIdleConns = over.Storage.SQLite.MaxIdleConns
+			}
+			if over.Storage.SQLite.ConnMaxLifetime != 0 {
+				base.Storage.SQLite.ConnMaxLifetime = over.Storage.SQLite.ConnMaxLifetime
+			}
+		}
+	}
+
+	// Webhooks
+	if over.Webhooks != nil {
+		if base.Webhooks == nil {
+			base.Webhooks = &WebhookSettings{}
+		}
+		if over.Webhooks.MaxRetryAfter != 0 {
+			base.Webhooks.MaxRetryAfter = over.Webhooks.MaxRetryAfter
+		}
+		if over.Webhooks.RetryRate != 0 {
+			base.Webhooks.RetryRate = over.Webhooks.RetryRate
+		}
+		if over.Webhooks.Concurrency != 0 {
+			base.Webhooks.Concurrency = over.Webhooks.Concurrency
+		}
+		if over.Webhooks.MaxBodyBytes != 0 {
+			base.Webhooks.MaxBodyBytes = over.Webhooks.MaxBodyBytes
+		}
+		if over.Webhooks.SigningKey != "" {
+			base.Webhooks.SigningKey = over.Webhooks.SigningKey
+		}
+	}
+
+	// Tracking
+	if over.Tracking != nil && over.Tracking.Open != nil {
+		if base.Tracking == nil {
+			base.Tracking = &TrackingConfig{}
+		}
+		if base.Tracking.Open == nil {
+			base.Tracking.Open = &OpenTrackingConfig{}
+		}
+		if over.Tracking.Open.Enable != nil {
+			enable := *over.Tracking.Open.Enable
+			base.Tracking.Open.Enable = &enable
+		}
+		if over.Tracking.Open.SkipPlainText {
+			base.Tracking.Open.SkipPlainText = true
+		}
+	}
+	if over.Tracking != nil && over.Tracking.Click != nil {
+		if base.Tracking == nil {
+			base.Tracking = &TrackingConfig{}
+		}
+		if base.Tracking.Click == nil {
+			base.Tracking.Click = &ClickTrackingConfig{}
+		}
+		if over.Tracking.Click.Enable != nil {
+			enable := *over.Tracking.Click.Enable
+			base.Tracking.Click.Enable = &enable
+		}
+		if over.Tracking.Click.Secret != "" {
+			base.Tracking.Click.Secret = over.Tracking.Click.Secret
+		}
+	}
+
+	// Greylist simulation
+	if over.Greylist != nil {
+		if base.Greylist == nil {
+			base.Greylist = &GreylistConfig{}
+		}
+		if over.Greylist.Enable {
+			base.Greylist.Enable = true
+		}
+		if over.Greylist.Window != 0 {
+			base.Greylist.Window = over.Greylist.Window
+		}
+	}
+
+	// Engagement simulation
+	if over.Engagement != nil {
+		if base.Engagement == nil {
+			base.Engagement = &EngagementConfig{}
+		}
+		if over.Engagement.OpenRate != 0 {
+			base.Engagement.OpenRate = over.Engagement.OpenRate
+		}
+		if over.Engagement.ClickRate != 0 {
+			base.Engagement.ClickRate = over.Engagement.ClickRate
+		}
+		if over.Engagement.DelayMS != 0 {
+			base.Engagement.DelayMS = over.Engagement.DelayMS
+		}
+		if over.Engagement.Seed != 0 {
+			base.Engagement.Seed = over.Engagement.Seed
+		}
 	}
 
 	return base
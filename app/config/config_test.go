@@ -0,0 +1,172 @@
+package config_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mustur/mockgrid/app/config"
+)
+
+func TestLoadConfigFiles_LaterFilesOverride(t *testing.T) {
+	dir := t.TempDir()
+	base := writeConfig(t, dir, "base.yaml", `
+smtp_server: smtp.shared.local
+smtp_port: 2525
+mockgrid_port: 5900
+storage:
+  type: sqlite
+  path: /data/shared.db
+`)
+	override := writeConfig(t, dir, "override.yaml", `
+smtp_port: 1025
+storage:
+  path: /tmp/local.db
+`)
+
+	cfg, err := config.LoadConfigFiles(base, override)
+	if err != nil {
+		t.Fatalf("LoadConfigFiles failed: %v", err)
+	}
+
+	if cfg.SMTPServer != "smtp.shared.local" {
+		t.Errorf("SMTPServer: expected value from base, got %q", cfg.SMTPServer)
+	}
+	if cfg.SMTPPort != 1025 {
+		t.Errorf("SMTPPort: expected override 1025, got %d", cfg.SMTPPort)
+	}
+	if cfg.MockgridPort != 5900 {
+		t.Errorf("MockgridPort: expected 5900, got %d", cfg.MockgridPort)
+	}
+	if cfg.Storage == nil || cfg.Storage.Type != "sqlite" || cfg.Storage.Path != "/tmp/local.db" {
+		t.Errorf("Storage: expected sqlite at /tmp/local.db, got %+v", cfg.Storage)
+	}
+}
+
+func TestLoadConfigFiles_NoFiles_ReturnsEmpty(t *testing.T) {
+	cfg, err := config.LoadConfigFiles()
+	if err != nil {
+		t.Fatalf("LoadConfigFiles failed: %v", err)
+	}
+	if cfg == nil || cfg.SMTPServer != "" {
+		t.Errorf("expected empty config, got %+v", cfg)
+	}
+}
+
+func TestLoadConfigFiles_MissingFile_ReturnsError(t *testing.T) {
+	if _, err := config.LoadConfigFiles(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+// --- Test Helpers ---
+
+func TestTLSConfig_MinVersion(t *testing.T) {
+	cfg := &config.Config{TLS: &config.TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem"}}
+	cfg.WithDefaults()
+	if cfg.TLS.MinVersion != "1.2" {
+		t.Errorf("expected default min_version 1.2, got %q", cfg.TLS.MinVersion)
+	}
+	if err := cfg.ValidateConfig(); err != nil {
+		t.Errorf("expected valid config, got %v", err)
+	}
+
+	cfg.TLS.MinVersion = "1.1"
+	if err := cfg.ValidateConfig(); err == nil {
+		t.Error("expected error for min_version 1.1")
+	}
+}
+
+func writeConfig(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfigFiles_CORSSection(t *testing.T) {
+	dir := t.TempDir()
+	base := writeConfig(t, dir, "base.yaml", `
+cors:
+  allowed_origins: ["http://localhost:3000"]
+  allowed_methods: [GET]
+`)
+	override := writeConfig(t, dir, "override.yaml", `
+cors:
+  allowed_origins: ["http://dashboard.test"]
+  max_age: 10m
+`)
+
+	cfg, err := config.LoadConfigFiles(base, override)
+	if err != nil {
+		t.Fatalf("LoadConfigFiles failed: %v", err)
+	}
+	if cfg.CORS == nil {
+		t.Fatal("expected a cors section")
+	}
+	if len(cfg.CORS.AllowedOrigins) != 1 || cfg.CORS.AllowedOrigins[0] != "http://dashboard.test" {
+		t.Errorf("AllowedOrigins: expected override, got %v", cfg.CORS.AllowedOrigins)
+	}
+	if len(cfg.CORS.AllowedMethods) != 1 || cfg.CORS.AllowedMethods[0] != "GET" {
+		t.Errorf("AllowedMethods: expected value from base, got %v", cfg.CORS.AllowedMethods)
+	}
+	if cfg.CORS.MaxAge != 10*time.Minute {
+		t.Errorf("MaxAge: expected 10m, got %v", cfg.CORS.MaxAge)
+	}
+}
+
+func TestLoadConfigFiles_RequestLogging(t *testing.T) {
+	dir := t.TempDir()
+	defaults, err := config.LoadConfigFiles(writeConfig(t, dir, "empty.yaml", "mockgrid_port: 5900\n"))
+	if err != nil {
+		t.Fatalf("LoadConfigFiles failed: %v", err)
+	}
+	if !defaults.RequestLoggingEnabled() {
+		t.Error("expected request logging to be enabled by default")
+	}
+
+	base := writeConfig(t, dir, "base.yaml", `
+middleware:
+  logging: false
+`)
+	override := writeConfig(t, dir, "override.yaml", `
+middleware:
+  cors_origin: "*"
+`)
+	cfg, err := config.LoadConfigFiles(base, override)
+	if err != nil {
+		t.Fatalf("LoadConfigFiles failed: %v", err)
+	}
+	if cfg.RequestLoggingEnabled() {
+		t.Error("expected logging: false to survive a later file without the key")
+	}
+}
+
+func TestLoadFromEnv_FallsBackToPORTAndHOST(t *testing.T) {
+	t.Setenv("MOCKGRID_HOST", "")
+	t.Setenv("MOCKGRID_PORT", "")
+	t.Setenv("HOST", "127.0.0.1")
+	t.Setenv("PORT", "8080")
+
+	cfg := config.LoadFromEnv()
+	cfg.WithDefaults()
+	if addr := fmt.Sprintf("%s:%d", cfg.MockgridHost, cfg.MockgridPort); addr != "127.0.0.1:8080" {
+		t.Errorf("expected listen address 127.0.0.1:8080, got %s", addr)
+	}
+}
+
+func TestLoadFromEnv_MockgridVarsWinOverPORT(t *testing.T) {
+	t.Setenv("MOCKGRID_HOST", "0.0.0.0")
+	t.Setenv("MOCKGRID_PORT", "5901")
+	t.Setenv("HOST", "127.0.0.1")
+	t.Setenv("PORT", "8080")
+
+	cfg := config.LoadFromEnv()
+	if cfg.MockgridHost != "0.0.0.0" || cfg.MockgridPort != 5901 {
+		t.Errorf("expected MOCKGRID_HOST and MOCKGRID_PORT to win, got %s:%d", cfg.MockgridHost, cfg.MockgridPort)
+	}
+}
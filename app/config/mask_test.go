@@ -0,0 +1,42 @@
+package config
+
+import "testing"
+
+func TestMaskSecret(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"empty", "", ""},
+		{"short", "abc", "***"},
+		{"exactly eight", "abcdefgh", "********"},
+		{"nine", "abcdefghi", "abcd*fghi"},
+		{"long", "SG.abcdefghijklmnop", "SG.a***********mnop"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := maskSecret(tc.in); got != tc.want {
+				t.Errorf("maskSecret(%q) = %q, want %q", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRedactDSN(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"file path", "./data/mockgrid.db", "./data/mockgrid.db"},
+		{"url", "postgres://mockgrid:s3cret@db:5432/mockgrid?sslmode=disable", "postgres://mockgrid:xxxxx@db:5432/mockgrid?sslmode=disable"},
+		{"url without password", "postgres://mockgrid@db/mockgrid", "postgres://mockgrid@db/mockgrid"},
+		{"keyword", "host=db user=mockgrid password=s3cret dbname=mockgrid", "host=db user=mockgrid password=xxxxx dbname=mockgrid"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := redactDSN(tc.in); got != tc.want {
+				t.Errorf("redactDSN(%q) = %q, want %q", tc.in, got, tc.want)
+			}
+		})
+	}
+}
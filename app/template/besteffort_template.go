@@ -1,13 +1,22 @@
 package template
 
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrTemplateUnavailable is returned by BesteffortTemplate when a template
+// can be found neither locally nor on SendGrid.
+var ErrTemplateUnavailable = errors.New("template unavailable")
+
 type BesteffortTemplate struct {
 	LocalTemplate
 	SendGridTemplate
 }
 
-func NewBesteffortTemplate(localDir, sendGridAPIKey string, sendGridURL string) *BesteffortTemplate {
+func NewBesteffortTemplate(localDir, localExt, sendGridAPIKey string, sendGridURL string) *BesteffortTemplate {
 	return &BesteffortTemplate{
-		LocalTemplate:    *NewLocalTemplate(localDir),
+		LocalTemplate:    *NewLocalTemplate(localDir, localExt),
 		SendGridTemplate: *NewSendGridTemplate(sendGridAPIKey, sendGridURL),
 	}
 }
@@ -17,6 +26,13 @@ func (bt BesteffortTemplate) GetTemplate(templateID string) (*TemplateVersion, e
 	if err == nil {
 		return tmpl, nil
 	}
+	if bt.sendgridKey == "" {
+		return nil, fmt.Errorf("%w: %s: no SendGrid key configured, local: %v", ErrTemplateUnavailable, templateID, err)
+	}
 
-	return bt.SendGridTemplate.GetTemplate(templateID)
+	tmpl, remoteErr := bt.SendGridTemplate.GetTemplate(templateID)
+	if remoteErr != nil {
+		return nil, fmt.Errorf("%w: %s: local: %v, sendgrid: %v", ErrTemplateUnavailable, templateID, err, remoteErr)
+	}
+	return tmpl, nil
 }
@@ -0,0 +1,31 @@
+package template_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/mustur/mockgrid/app/template"
+)
+
+func TestBesteffortTemplate_PrefersLocal(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplate(t, filepath.Join(dir, "d-welcome.html"))
+
+	tmpl, err := template.NewBesteffortTemplate(dir, "", "", "").GetTemplate("d-welcome")
+	if err != nil {
+		t.Fatalf("GetTemplate failed: %v", err)
+	}
+	if tmpl.Subject != "Welcome" {
+		t.Errorf("expected subject %q, got %q", "Welcome", tmpl.Subject)
+	}
+}
+
+func TestBesteffortTemplate_NoLocalFileNoKey_ReturnsUnavailable(t *testing.T) {
+	bt := template.NewBesteffortTemplate(t.TempDir(), "", "", "")
+
+	_, err := bt.GetTemplate("d-missing")
+	if !errors.Is(err, template.ErrTemplateUnavailable) {
+		t.Fatalf("expected ErrTemplateUnavailable, got %v", err)
+	}
+}
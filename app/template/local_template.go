@@ -9,13 +9,26 @@ import (
 	"strings"
 )
 
+// DefaultExtension is the file extension of local templates unless one is configured.
+const DefaultExtension = ".html"
+
 type LocalTemplate struct {
 	templateDir string
+	extension   string
 }
 
-func NewLocalTemplate(templateDir string) *LocalTemplate {
+// NewLocalTemplate loads templates from templateDir. ext is the template file
+// extension, with or without the leading dot; empty means DefaultExtension.
+func NewLocalTemplate(templateDir, ext string) *LocalTemplate {
+	if ext == "" {
+		ext = DefaultExtension
+	}
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
 	return &LocalTemplate{
 		templateDir: templateDir,
+		extension:   ext,
 	}
 }
 
@@ -23,9 +36,9 @@ func (lt LocalTemplate) GetTemplate(templateID string) (*TemplateVersion, error)
 	// sanitize templateID to prevent directory traversal and ensure it resolves
 	// under the configured templateDir
 	safeID := filepath.Clean("/" + templateID) // prefix slash to force relative cleaning
-	// ensure file has .html suffix
-	if !strings.HasSuffix(safeID, ".html") {
-		safeID = safeID + ".html"
+	// ensure file has the template extension
+	if !strings.HasSuffix(safeID, lt.extension) {
+		safeID = safeID + lt.extension
 	}
 	filePath := filepath.Join(lt.templateDir, safeID)
 
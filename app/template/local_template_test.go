@@ -0,0 +1,65 @@
+package template_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mustur/mockgrid/app/template"
+)
+
+const templateJSON = `{"versions":[{"subject":"Welcome","html_content":"<p>Hi</p>","active":1}]}`
+
+func TestLocalTemplate_CustomExtension(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplate(t, filepath.Join(dir, "d-welcome.json"))
+	writeTemplate(t, filepath.Join(dir, "d-legacy.html"))
+
+	for _, ext := range []string{".json", "json"} {
+		lt := template.NewLocalTemplate(dir, ext)
+		tmpl, err := lt.GetTemplate("d-welcome")
+		if err != nil {
+			t.Fatalf("extension %q: GetTemplate failed: %v", ext, err)
+		}
+		if tmpl.Subject != "Welcome" {
+			t.Errorf("extension %q: expected subject %q, got %q", ext, "Welcome", tmpl.Subject)
+		}
+		if _, err := lt.GetTemplate("d-legacy"); err == nil {
+			t.Errorf("extension %q: expected .html template to be ignored", ext)
+		}
+	}
+}
+
+func TestLocalTemplate_DefaultExtensionIsHTML(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplate(t, filepath.Join(dir, "d-legacy.html"))
+
+	if _, err := template.NewLocalTemplate(dir, "").GetTemplate("d-legacy"); err != nil {
+		t.Errorf("GetTemplate failed: %v", err)
+	}
+}
+
+func TestLocalTemplate_CustomExtension_BlocksTraversal(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "templates")
+	if err := os.Mkdir(dir, 0o750); err != nil {
+		t.Fatalf("failed to create template dir: %v", err)
+	}
+	writeTemplate(t, filepath.Join(root, "secret.json"))
+
+	lt := template.NewLocalTemplate(dir, ".json")
+	for _, id := range []string{"../secret", "../secret.json", "../../" + filepath.Base(root) + "/secret"} {
+		if _, err := lt.GetTemplate(id); err == nil {
+			t.Errorf("expected %q to stay inside the template directory", id)
+		}
+	}
+}
+
+// --- Test Helpers ---
+
+func writeTemplate(t *testing.T, path string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(templateJSON), 0o600); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+}
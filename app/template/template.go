@@ -25,6 +25,12 @@ type Templater interface {
 	GetTemplate(templateID string) (*TemplateVersion, error)
 }
 
+// Validate parses src as a Handlebars template and returns any syntax error.
+func Validate(src string) error {
+	_, err := raymond.Parse(src)
+	return err
+}
+
 // RenderAndPopulateFromTemplate fetches and renders templates for each personalization.
 func RenderAndPopulateFromTemplate(postRequest *objects.PostRequest, tpl Templater) error {
 	templateID := postRequest.TemplateID
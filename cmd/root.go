@@ -36,20 +36,16 @@ It supports SMTP for sending emails and can render templates similar to SendGrid
 		// Load config from env first (lowest priority)
 		envCfg := config.LoadFromEnv()
 
-		// Load config from file if provided
-		fileCfg := &config.Config{}
-		configPath, err := cmd.Flags().GetString("config")
+		// Load config from files if provided; later files override earlier ones
+		configPaths, err := cmd.Flags().GetStringArray("config")
 		if err != nil {
 			pterm.Error.Println("Failed to read --config flag:", err)
 			return err
 		}
-		if configPath != "" {
-			loaded, err := config.LoadEmailServiceConfig(configPath)
-			if err != nil {
-				pterm.Error.Println("Failed to load configuration:", err)
-				return err
-			}
-			fileCfg = loaded
+		fileCfg, err := config.LoadConfigFiles(configPaths...)
+		if err != nil {
+			pterm.Error.Println("Failed to load configuration:", err)
+			return err
 		}
 
 		// Load config from flags (highest priority)
@@ -169,7 +165,7 @@ func Execute() error {
 }
 
 func init() {
-	rootCmd.PersistentFlags().StringP("config", "c", "", "Path to configuration file")
+	rootCmd.PersistentFlags().StringArrayP("config", "c", nil, "Path to configuration file (repeatable; later files override earlier ones)")
 	// config override flags
 	rootCmd.PersistentFlags().String("smtp-server", "", "SMTP server hostname")
 	rootCmd.PersistentFlags().Int("smtp-port", 0, "SMTP server port")
@@ -182,7 +178,7 @@ func init() {
 	rootCmd.PersistentFlags().String("sendgrid-key", "", "Sendgrid API key")
 	rootCmd.PersistentFlags().String("smtp-user", "", "SMTP authentication username")
 	rootCmd.PersistentFlags().String("smtp-pass", "", "SMTP authentication password")
-	rootCmd.PersistentFlags().String("storage-type", "", "Storage type: none|sqlite|filesystem")
-	rootCmd.PersistentFlags().String("storage-path", "", "Storage path for sqlite or filesystem")
+	rootCmd.PersistentFlags().String("storage-type", "", "Storage type: none|memory|sqlite|filesystem|postgres")
+	rootCmd.PersistentFlags().String("storage-path", "", "Storage path for sqlite or filesystem, or the postgres DSN")
 	rootCmd.AddCommand(serveCmd)
 }
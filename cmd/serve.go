@@ -2,15 +2,29 @@ package cmd
 
 import (
 	"context"
+	"crypto/ecdsa"
 	"fmt"
 	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/mustur/mockgrid/app/api"
+	"github.com/mustur/mockgrid/app/api/engagement"
+	"github.com/mustur/mockgrid/app/api/middleware"
 	"github.com/mustur/mockgrid/app/api/store"
 	"github.com/mustur/mockgrid/app/api/store/filesystem"
+	"github.com/mustur/mockgrid/app/api/store/memory"
 	"github.com/mustur/mockgrid/app/api/store/noop"
+	"github.com/mustur/mockgrid/app/api/store/postgres"
 	"github.com/mustur/mockgrid/app/api/store/sqlite"
+	"github.com/mustur/mockgrid/app/api/svc/admin"
+	"github.com/mustur/mockgrid/app/api/svc/messages"
+	"github.com/mustur/mockgrid/app/api/svc/schema"
 	"github.com/mustur/mockgrid/app/api/svc/sendmail"
+	"github.com/mustur/mockgrid/app/api/svc/stats"
+	"github.com/mustur/mockgrid/app/api/svc/templates"
 	"github.com/mustur/mockgrid/app/api/svc/webhook"
 	"github.com/mustur/mockgrid/app/config"
 	"github.com/mustur/mockgrid/app/template"
@@ -32,7 +46,7 @@ var serveCmd = &cobra.Command{
 		pterm.Info.Println("Configuration values:")
 		cfg.PrintValues()
 
-		// Build backend store
+		// Build and connect the backend store
 		st, err := buildStore(cfg)
 		if err != nil {
 			return fmt.Errorf("initialize store: %w", err)
@@ -42,19 +56,24 @@ var serveCmd = &cobra.Command{
 				slog.Error("failed to close store", "err", err)
 			}
 		}()
-		err = st.Connect()
-		if err != nil {
-			return fmt.Errorf("connect store: %w", err)
-		}
 
 		tpl := buildTemplater(cfg)
 		listenAddr := fmt.Sprintf("%s:%d", cfg.MockgridHost, cfg.MockgridPort)
+		// The global stack writes the access log, so each service's own
+		// Logging only handles X-Request-ID
+		svcLog := slog.New(slog.DiscardHandler)
+
+		signingKey, err := webhookSigningKey(cfg)
+		if err != nil {
+			return fmt.Errorf("load webhook signing key: %w", err)
+		}
 
 		// Create webhook dispatcher backed by the same store
-		dispatcher := webhook.NewDispatcher(st)
+		dispatcher := webhook.NewDispatcher(st, dispatcherConfig(cfg, signingKey))
 
 		// Wrap the message store with a wrapper that dispatches events
-		wrappedMsgStore := store.NewStoreWrapper(st, dispatcher)
+		wrappedMsgStore := store.NewStoreWrapper(st, eventDispatcher(cfg, st, dispatcher)).
+			WithRecentCache(cfg.Storage.RecentSize)
 
 		mailSvc := sendmail.New(sendmail.Config{
 			SMTPServer:    cfg.SMTPServer,
@@ -64,37 +83,155 @@ var serveCmd = &cobra.Command{
 			AuthKey:       authKey(cfg),
 			SMTPUser:      smtpUser(cfg),
 			SMTPPass:      smtpPass(cfg),
+			AccessLog:     svcLog,
+
+			MaxAttachmentBytes:    maxAttachmentBytes(cfg),
+			AllowEmptyBody:        cfg.AllowEmpty,
+			AllowCcOnly:           cfg.AllowCcOnly,
+			HTMLToTextMode:        cfg.HTMLToText,
+			AllowedFromDomains:    cfg.AllowedFrom,
+			FailOnMissingTemplate: failOnMissingTemplate(cfg),
+			AssumeJSON:            cfg.AssumeJSON,
+			VerboseResponses:      cfg.Verbose,
+			CaptureHeaders:        cfg.Capture,
+			DisableOpenTracking:   !cfg.OpenTrackingEnabled(),
+			DisableClickTracking:  !cfg.ClickTrackingEnabled(),
+			ClickSecret:           cfg.ClickTrackingSecret(),
+			SkipPlainTextTracking: cfg.SkipPlainTextTracking(),
+			GreylistWindow:        greylistWindow(cfg),
+			DailyQuota:            cfg.DailyQuota,
+			PerDomainRPS:          cfg.PerDomainRPS,
+			IPPools:               cfg.IPPools,
+			DefaultSubstitutions:  cfg.DefaultSubs,
 		}, tpl, wrappedMsgStore)
+		// Stop the scheduler before the store it polls is closed
+		defer func() {
+			if err := mailSvc.Close(); err != nil {
+				slog.Error("failed to stop mail service", "err", err)
+			}
+		}()
 
 		// Build webhook service using the backend store and dispatcher
-		webhookSvc := webhook.NewService(st, dispatcher)
+		webhookSvc := webhook.NewService(st, dispatcher).
+			WithAuthKey(authKey(cfg)).
+			WithAccessLog(svcLog).
+			WithMaxBodyBytes(webhookMaxBodyBytes(cfg)).
+			WithRequireSigned(cfg.RequireSign).
+			WithSigningKey(signingKey)
 
-		// Create and start the server
-		mg := api.New(listenAddr, mailSvc, webhookSvc)
+		// Build messages service reading through the wrapper's recent cache
+		messagesSvc := messages.New(messages.Config{
+			AuthKey:   authKey(cfg),
+			AccessLog: svcLog,
+		}, st, wrappedMsgStore).WithResender(mailSvc)
+
+		adminSvc := admin.New(admin.Config{
+			AuthKey:   authKey(cfg),
+			AccessLog: svcLog,
+		}, st).WithWebhooks(st, webhookSvc)
+
+		statsSvc := stats.New(stats.Config{
+			AuthKey:   authKey(cfg),
+			AccessLog: svcLog,
+		}, st).WithDeliveryMonitor(dispatcher)
+
+		templatesSvc := templates.New(templates.Config{
+			AuthKey:   authKey(cfg),
+			AccessLog: svcLog,
+		})
+
+		schemaSvc := schema.New(schema.Config{
+			AuthKey:   authKey(cfg),
+			AccessLog: svcLog,
+		})
+
+		// Create the server, moving the admin endpoints to their own
+		// listener when configured
+		var mg *api.MockGrid
+		if cfg.AdminAddr != "" {
+			mg = api.New(listenAddr, mailSvc, webhookSvc, messagesSvc, statsSvc, templatesSvc, schemaSvc).
+				WithListener(cfg.AdminAddr, adminSvc)
+		} else {
+			mg = api.New(listenAddr, mailSvc, webhookSvc, messagesSvc, adminSvc, statsSvc, templatesSvc, schemaSvc)
+		}
+		adminSvc.WithRoutes(mg)
+		mg.WithReadiness(st, cfg.Storage.ReadyTimeout).
+			WithMaxInFlight(cfg.MaxInFlight).
+			WithDrainer(dispatcher).
+			WithHealthDetails(st, cfg.Storage.Type, nil).
+			WithMiddleware(globalMiddleware(cfg)...)
+		if cfg.TLS != nil {
+			minVersion, err := cfg.TLS.MinTLSVersion()
+			if err != nil {
+				return err
+			}
+			mg.WithTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile, minVersion)
+		}
+
+		// Stop every listener gracefully on interrupt, then deliver the
+		// webhook events still pending, all within the shutdown timeout
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		shutdownDone := make(chan struct{})
+		go func() {
+			defer close(shutdownDone)
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout(cfg))
+			defer cancel()
+			if err := mg.Shutdown(shutdownCtx); err != nil {
+				slog.Error("graceful shutdown failed", "err", err)
+			}
+		}()
 
 		slog.Info("starting mockgrid server", "address", listenAddr)
-		cmd.SetContext(context.Background())
-		return mg.Start()
+		cmd.SetContext(ctx)
+		if err := mg.Start(); err != nil {
+			return err
+		}
+		// Start returns as soon as the listeners close; wait for the rest
+		// of the shutdown before the process exits
+		<-shutdownDone
+		return nil
 	},
 }
 
 // buildTemplater creates the appropriate templater based on config.
 func buildTemplater(cfg *config.Config) template.Templater {
 	if cfg.Templates == nil {
-		return template.NewBesteffortTemplate("", "", "")
+		return template.NewBesteffortTemplate("", "", "", "")
 	}
 	switch cfg.Templates.Mode {
 	case "local":
-		return template.NewLocalTemplate(cfg.Templates.Directory)
+		return template.NewLocalTemplate(cfg.Templates.Directory, cfg.Templates.Extension)
 	case "sendgrid":
 		return template.NewSendGridTemplate(cfg.Templates.TemplateKey, "")
 	default:
-		return template.NewBesteffortTemplate(cfg.Templates.Directory, cfg.Templates.TemplateKey, "")
+		return template.NewBesteffortTemplate(cfg.Templates.Directory, cfg.Templates.Extension, cfg.Templates.TemplateKey, "")
 	}
 }
 
-// buildStore creates the appropriate backend store (messages + webhooks) based on config.
+// failOnMissingTemplate reports whether sends with an unavailable template
+// are rejected rather than sent unrendered.
+func failOnMissingTemplate(cfg *config.Config) bool {
+	return cfg.Templates != nil && cfg.Templates.MissingMode == "fail"
+}
+
+// buildStore creates the appropriate backend store (messages + webhooks) based
+// on config and connects it, so tables and directories exist before use.
 func buildStore(cfg *config.Config) (store.BackendStore, error) {
+	st, err := newStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := st.Connect(); err != nil {
+		_ = st.Close()
+		return nil, fmt.Errorf("connect store: %w", err)
+	}
+	return st, nil
+}
+
+// newStore creates the unconnected backend store selected by config.
+func newStore(cfg *config.Config) (store.BackendStore, error) {
 	if cfg.Storage == nil {
 		return noop.New(), nil
 	}
@@ -104,17 +241,142 @@ func buildStore(cfg *config.Config) (store.BackendStore, error) {
 		if cfg.Storage.Path == "" {
 			return nil, fmt.Errorf("sqlite storage requires a path")
 		}
-		return sqlite.New(cfg.Storage.Path)
+		pool := sqlitePool(cfg)
+		if cfg.Storage.Path == sqlite.MemoryPath {
+			// Each connection to :memory: opens its own empty database, so
+			// the pool is pinned to one connection that is never recycled
+			slog.Warn("sqlite storage path is :memory:, messages and webhooks are lost on exit")
+			if cfg.Storage.SQLite != nil {
+				slog.Warn("sqlite pool settings are ignored for :memory:, which uses a single connection")
+			}
+			pool = sqlite.PoolConfig{MaxOpenConns: 1, MaxIdleConns: 1}
+		}
+		st, err := sqlite.New(cfg.Storage.Path, pool)
+		if err != nil {
+			return nil, err
+		}
+		return st.WithCompression(cfg.Storage.CompressBodies), nil
 	case "filesystem":
 		if cfg.Storage.Path == "" {
 			return nil, fmt.Errorf("filesystem storage requires a path")
 		}
-		return filesystem.New(cfg.Storage.Path)
+		st, err := filesystem.New(cfg.Storage.Path)
+		if err != nil {
+			return nil, err
+		}
+		return st.WithCompression(cfg.Storage.CompressBodies), nil
+	case "memory":
+		slog.Warn("memory storage is ephemeral, messages and webhooks are lost on exit")
+		return memory.New(), nil
+	case "postgres":
+		if cfg.Storage.Path == "" {
+			return nil, fmt.Errorf("postgres storage requires a DSN as its path")
+		}
+		return postgres.New(cfg.Storage.Path)
 	default:
 		return noop.New(), nil
 	}
 }
 
+// sqlitePool extracts the sqlite connection pool limits from config.
+func sqlitePool(cfg *config.Config) sqlite.PoolConfig {
+	var pool sqlite.PoolConfig
+	if p := cfg.Storage.SQLite; p != nil {
+		pool.MaxOpenConns = p.MaxOpenConns
+		pool.MaxIdleConns = p.MaxIdleConns
+		pool.ConnMaxLifetime = p.ConnMaxLifetime
+	}
+	return pool
+}
+
+// shutdownTimeout extracts how long in-flight requests may take to finish on
+// shutdown from config.
+func shutdownTimeout(cfg *config.Config) time.Duration {
+	if cfg.Server != nil {
+		return cfg.Server.ShutdownTimeout
+	}
+	return 0
+}
+
+// dispatcherConfig extracts the webhook dispatcher settings from config.
+func dispatcherConfig(cfg *config.Config, signingKey *ecdsa.PrivateKey) webhook.DispatcherConfig {
+	dc := webhook.DispatcherConfig{RequireSigned: cfg.RequireSign, SigningKey: signingKey}
+	if cfg.Webhooks != nil {
+		dc.MaxRetryAfter = cfg.Webhooks.MaxRetryAfter
+		dc.RetryRate = cfg.Webhooks.RetryRate
+		dc.Concurrency = cfg.Webhooks.Concurrency
+	}
+	return dc
+}
+
+// webhookSigningKey loads the configured webhook ECDSA signing key, or
+// returns nil when none is configured.
+func webhookSigningKey(cfg *config.Config) (*ecdsa.PrivateKey, error) {
+	if cfg.Webhooks == nil || cfg.Webhooks.SigningKey == "" {
+		return nil, nil
+	}
+	return webhook.LoadSigningKey(cfg.Webhooks.SigningKey)
+}
+
+// webhookMaxBodyBytes extracts the webhook config body limit from config.
+func webhookMaxBodyBytes(cfg *config.Config) int64 {
+	if cfg.Webhooks != nil {
+		return cfg.Webhooks.MaxBodyBytes
+	}
+	return 0
+}
+
+// globalMiddleware builds the middleware applied to every service from config.
+func globalMiddleware(cfg *config.Config) []middleware.Middleware {
+	var mws []middleware.Middleware
+	if cfg.RequestLoggingEnabled() {
+		mws = append(mws, middleware.Logging(nil))
+	}
+	switch {
+	case cfg.CORS != nil && len(cfg.CORS.AllowedOrigins) > 0:
+		mws = append(mws, middleware.CORSWithConfig(middleware.CORSConfig{
+			AllowedOrigins: cfg.CORS.AllowedOrigins,
+			AllowedMethods: cfg.CORS.AllowedMethods,
+			AllowedHeaders: cfg.CORS.AllowedHeaders,
+			MaxAge:         cfg.CORS.MaxAge,
+		}))
+	case cfg.Middleware != nil && cfg.Middleware.CORSOrigin != "":
+		mws = append(mws, middleware.CORS(cfg.Middleware.CORSOrigin))
+	}
+	// After CORS, so browsers can read a 429 and its rate-limit headers
+	if cfg.Middleware != nil && cfg.Middleware.RateLimit > 0 {
+		window := cfg.Middleware.RateLimitWindow
+		if window <= 0 {
+			window = time.Minute
+		}
+		mws = append(mws, middleware.RateLimit(cfg.Middleware.RateLimit, window, nil))
+	}
+	return mws
+}
+
+// eventDispatcher layers the engagement simulator over the webhook
+// dispatcher when simulate_engagement is configured.
+func eventDispatcher(cfg *config.Config, st store.MessageStore, d store.EventDispatcher) store.EventDispatcher {
+	e := cfg.Engagement
+	if e == nil || (e.OpenRate <= 0 && e.ClickRate <= 0) {
+		return d
+	}
+	return engagement.New(engagement.Config{
+		OpenRate:  e.OpenRate,
+		ClickRate: e.ClickRate,
+		Delay:     time.Duration(e.DelayMS) * time.Millisecond,
+		Seed:      e.Seed,
+	}, st, d)
+}
+
+// greylistWindow returns the greylist window, or zero when the simulation is off.
+func greylistWindow(cfg *config.Config) time.Duration {
+	if cfg.Greylist != nil && cfg.Greylist.Enable {
+		return cfg.Greylist.Window
+	}
+	return 0
+}
+
 // attachmentDir extracts the attachment directory from config.
 func attachmentDir(cfg *config.Config) string {
 	if cfg.Attachments != nil {
@@ -123,6 +385,14 @@ func attachmentDir(cfg *config.Config) string {
 	return ""
 }
 
+// maxAttachmentBytes extracts the per-attachment size limit from config.
+func maxAttachmentBytes(cfg *config.Config) int64 {
+	if cfg.Attachments != nil {
+		return cfg.Attachments.MaxBytes
+	}
+	return 0
+}
+
 // authKey extracts the auth key from config.
 func authKey(cfg *config.Config) string {
 	if cfg.Auth != nil {
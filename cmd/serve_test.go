@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mustur/mockgrid/app/api"
+	"github.com/mustur/mockgrid/app/api/middleware"
+	"github.com/mustur/mockgrid/app/api/store"
+	"github.com/mustur/mockgrid/app/config"
+	"github.com/mustur/mockgrid/internal/testutil"
+)
+
+func TestBuildStore_SqliteInMemory_KeepsSavedData(t *testing.T) {
+	cfg := &config.Config{Storage: &config.StorageConfig{
+		Type:   "sqlite",
+		Path:   ":memory:",
+		SQLite: &config.SQLitePool{MaxOpenConns: 8, ConnMaxLifetime: time.Millisecond},
+	}}
+
+	st, err := buildStore(cfg)
+	if err != nil {
+		t.Fatalf("buildStore failed: %v", err)
+	}
+	defer st.Close()
+
+	// Concurrent reads must not open a second, empty database
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Go(func() {
+			msg := testutil.NewTestMessage(fmt.Sprintf("msg-%d", i))
+			if err := st.SaveMSG(msg); err != nil {
+				t.Errorf("SaveMSG failed: %v", err)
+				return
+			}
+			if got, err := st.GetMSG(store.GetQuery{ID: msg.MsgID}); err != nil || len(got) != 1 {
+				t.Errorf("saved message %s not visible: %v", msg.MsgID, err)
+			}
+		})
+	}
+	wg.Wait()
+
+	time.Sleep(5 * time.Millisecond)
+	if n, err := st.Count(); err != nil || n != 4 {
+		t.Errorf("expected 4 messages, got %d (%v)", n, err)
+	}
+}
+
+func TestBuildStore_SqliteIsConnected(t *testing.T) {
+	cfg := &config.Config{Storage: &config.StorageConfig{
+		Type: "sqlite",
+		Path: filepath.Join(t.TempDir(), "messages.db"),
+	}}
+
+	st, err := buildStore(cfg)
+	if err != nil {
+		t.Fatalf("buildStore failed: %v", err)
+	}
+	defer st.Close()
+
+	// Both tables must exist without calling Connect
+	if err := st.SaveMSG(testutil.NewTestMessage("msg-1")); err != nil {
+		t.Fatalf("SaveMSG failed: %v", err)
+	}
+	if err := st.Create(&store.WebhookConfig{ID: "wh_1", URL: "http://example.com", Events: []string{"delivered"}}); err != nil {
+		t.Fatalf("Create webhook failed: %v", err)
+	}
+}
+
+func TestMiddleware_GlobalStackWrapsEveryService(t *testing.T) {
+	// globalMiddleware logs through slog.Default
+	logs := &syncBuffer{}
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	logging := true
+	cfg := &config.Config{Middleware: &config.MiddlewareConfig{Logging: &logging}}
+
+	ok := func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) }
+	apiSvc := testutil.NewMockService("/api/").HandleFunc("GET /ping", ok)
+	// The service's own chain rejects everything; the global stack still runs
+	lockedSvc := testutil.NewMockService("/locked/").HandleFunc("GET /ping", ok).
+		WithChain(middleware.BearerAuth("secret"))
+
+	addr := freeAddr(t)
+	mg := api.New(addr, apiSvc, lockedSvc).WithMiddleware(globalMiddleware(cfg)...)
+	go func() { _ = mg.Start() }()
+	defer mg.Shutdown(context.Background())
+
+	for _, url := range []string{"http://" + addr + "/api/ping", "http://" + addr + "/locked/ping"} {
+		resp := waitForServer(t, url)
+		resp.Body.Close()
+	}
+
+	out := logs.String()
+	for _, want := range []string{"path=/api/ping status=200", "path=/locked/ping status=401"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected log line with %q, got:\n%s", want, out)
+		}
+	}
+}
+
+func TestGlobalMiddleware_LoggingOff(t *testing.T) {
+	logging := false
+	cfg := &config.Config{Middleware: &config.MiddlewareConfig{Logging: &logging}}
+
+	if mws := globalMiddleware(cfg); len(mws) != 0 {
+		t.Errorf("expected an empty global stack, got %d middleware", len(mws))
+	}
+}
+
+// --- Test Helpers ---
+
+// syncBuffer is a bytes.Buffer safe for concurrent writes by server goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to reserve port: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func waitForServer(t *testing.T, url string) *http.Response {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		resp, err := http.Get(url)
+		if err == nil {
+			return resp
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("server at %s did not start", url)
+	return nil
+}
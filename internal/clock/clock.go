@@ -1,12 +1,22 @@
 // Package clock provides a time abstraction for testable code.
 package clock
 
-import "time"
+import (
+	"sort"
+	"sync"
+	"time"
+)
 
 // Clock is an interface for time operations, allowing tests to control time.
 type Clock interface {
 	// Now returns the current time.
 	Now() time.Time
+
+	// Sleep pauses the caller for the given duration.
+	Sleep(d time.Duration)
+
+	// AfterFunc calls f in its own goroutine once d has elapsed.
+	AfterFunc(d time.Duration, f func())
 }
 
 // RealClock implements Clock using the real system time.
@@ -17,9 +27,28 @@ func (RealClock) Now() time.Time {
 	return time.Now()
 }
 
+// Sleep pauses the current goroutine for the given duration.
+func (RealClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// AfterFunc schedules f using time.AfterFunc.
+func (RealClock) AfterFunc(d time.Duration, f func()) {
+	time.AfterFunc(d, f)
+}
+
 // MockClock implements Clock with a fixed, controllable time.
+// It is safe for concurrent use.
 type MockClock struct {
+	mu      sync.Mutex
 	current time.Time
+	timers  []mockTimer
+}
+
+// mockTimer is a callback registered with MockClock.AfterFunc.
+type mockTimer struct {
+	at time.Time
+	f  func()
 }
 
 // NewMockClock creates a MockClock set to the given time.
@@ -29,15 +58,63 @@ func NewMockClock(t time.Time) *MockClock {
 
 // Now returns the mock's current time.
 func (m *MockClock) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	return m.current
 }
 
-// Set updates the mock's current time.
+// Sleep advances the mock's current time by d without blocking.
+func (m *MockClock) Sleep(d time.Duration) {
+	m.Add(d)
+}
+
+// AfterFunc registers f to run when the mock's time is moved to or past
+// now+d. Unlike the real clock, f runs synchronously in the goroutine that
+// advances the time, so its effects are visible once Add or Set returns.
+func (m *MockClock) AfterFunc(d time.Duration, f func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.timers = append(m.timers, mockTimer{at: m.current.Add(d), f: f})
+}
+
+// Set updates the mock's current time, firing any timers that have come due.
 func (m *MockClock) Set(t time.Time) {
+	m.mu.Lock()
 	m.current = t
+	due := m.takeDue()
+	m.mu.Unlock()
+	fire(due)
 }
 
-// Add advances the mock's current time by the given duration.
+// Add advances the mock's current time by the given duration, firing any
+// timers that have come due.
 func (m *MockClock) Add(d time.Duration) {
+	m.mu.Lock()
 	m.current = m.current.Add(d)
+	due := m.takeDue()
+	m.mu.Unlock()
+	fire(due)
+}
+
+// takeDue removes and returns the timers due at the current time, earliest
+// first. The caller must hold m.mu.
+func (m *MockClock) takeDue() []mockTimer {
+	var due, pending []mockTimer
+	for _, t := range m.timers {
+		if t.at.After(m.current) {
+			pending = append(pending, t)
+		} else {
+			due = append(due, t)
+		}
+	}
+	m.timers = pending
+	sort.SliceStable(due, func(i, j int) bool { return due[i].at.Before(due[j].at) })
+	return due
+}
+
+// fire runs each timer's callback in order.
+func fire(timers []mockTimer) {
+	for _, t := range timers {
+		t.f()
+	}
 }
@@ -0,0 +1,18 @@
+// Package httpjson writes JSON HTTP responses.
+package httpjson
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// Write encodes v as a JSON response with the given status. The status is
+// already sent when encoding fails, so the failure is only logged.
+func Write(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to encode response", "err", err)
+	}
+}
@@ -0,0 +1,52 @@
+// Package metrics holds mockgrid's Prometheus collectors and the registry
+// served at GET /metrics.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds every mockgrid collector plus the Go runtime and process
+// collectors. It is separate from the client library's default registry so
+// importing packages cannot leak their own metrics into ours.
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+// Collectors recorded by mockgrid.
+var (
+	HTTPRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "mockgrid_http_requests_total",
+		Help: "HTTP requests served, by service route, method and status code.",
+	}, []string{"route", "method", "code"})
+	HTTPDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mockgrid_http_request_duration_seconds",
+		Help:    "HTTP request latency, by service route and method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+	Emails = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "mockgrid_emails_total",
+		Help: "SMTP delivery attempts, by resulting message status.",
+	}, []string{"status"})
+	WebhookDeliveries = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "mockgrid_webhook_deliveries_total",
+		Help: "Webhook deliveries, by result (success or failure after retries).",
+	}, []string{"result"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler serves Registry in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
@@ -1,11 +1,18 @@
 package testutil
 
 import (
+	"fmt"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/mustur/mockgrid/app/api/store"
 )
 
+// LargeBody is an HTML body of about 1 MiB for body storage tests.
+var LargeBody = "<html><body>" + strings.Repeat("<p>A paragraph of a rather long newsletter.</p>\n", 22000) + "</body></html>"
+
 // StoreFactory creates a new MessageStore instance for testing.
 // The store should be empty and ready for use.
 type StoreFactory func(t *testing.T) store.MessageStore
@@ -48,6 +55,39 @@ func RunStoreContractTests(t *testing.T, name string, factory StoreFactory) {
 		}
 	})
 
+	t.Run(name+"/Save_LargeBody_RoundTrip", func(t *testing.T) {
+		s := factory(t)
+		defer s.Close()
+
+		msg := &store.Message{
+			MsgID:     "large",
+			Status:    store.StatusProcessed,
+			Timestamp: 1700000000,
+			HTMLBody:  LargeBody,
+			TextBody:  "plain " + LargeBody,
+		}
+		if err := s.SaveMSG(msg); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+
+		byID, err := s.GetMSG(store.GetQuery{ID: "large"})
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		listed, err := s.GetMSG(store.GetQuery{})
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		for _, got := range [][]*store.Message{byID, listed} {
+			if len(got) != 1 {
+				t.Fatalf("expected 1 message, got %d", len(got))
+			}
+			if got[0].HTMLBody != msg.HTMLBody || got[0].TextBody != msg.TextBody {
+				t.Errorf("bodies changed in a round trip: got %d and %d bytes", len(got[0].HTMLBody), len(got[0].TextBody))
+			}
+		}
+	})
+
 	t.Run(name+"/Get_NonExistent_ReturnsEmptyOrNotFound", func(t *testing.T) {
 		s := factory(t)
 		defer s.Close()
@@ -180,6 +220,330 @@ func RunStoreContractTests(t *testing.T, name string, factory StoreFactory) {
 		}
 	})
 
+	t.Run(name+"/Get_EqualTimestamps_OrderedByIDDesc", func(t *testing.T) {
+		s := factory(t)
+		defer s.Close()
+
+		for _, id := range []string{"tie-b", "tie-d", "tie-a", "tie-c"} {
+			msg := &store.Message{
+				MsgID:     id,
+				FromEmail: "a@b.com",
+				ToEmail:   "c@d.com",
+				Status:    store.StatusProcessed,
+				Timestamp: 1700000000,
+			}
+			if err := s.SaveMSG(msg); err != nil {
+				t.Fatalf("Save failed: %v", err)
+			}
+		}
+		newer := &store.Message{MsgID: "tie-0", Status: store.StatusProcessed, Timestamp: 1700000001}
+		if err := s.SaveMSG(newer); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+
+		want := []string{"tie-0", "tie-d", "tie-c", "tie-b", "tie-a"}
+		for run := 0; run < 3; run++ {
+			got, err := s.GetMSG(store.GetQuery{})
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			ids := make([]string, len(got))
+			for i, m := range got {
+				ids[i] = m.MsgID
+			}
+			if !slices.Equal(ids, want) {
+				t.Fatalf("run %d: expected order %v, got %v", run, want, ids)
+			}
+		}
+
+		page, err := s.GetMSG(store.GetQuery{Limit: 2, Offset: 1})
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if len(page) != 2 || page[0].MsgID != "tie-d" || page[1].MsgID != "tie-c" {
+			t.Errorf("expected page [tie-d tie-c], got %v", page)
+		}
+	})
+
+	t.Run(name+"/Get_FilterByHeader", func(t *testing.T) {
+		s := factory(t)
+		defer s.Close()
+
+		headers := map[string]map[string]string{
+			"hdr-spring": {"X-Campaign-Id": "spring", "User-Agent": "test"},
+			"hdr-autumn": {"X-Campaign-Id": "autumn"},
+			"hdr-none":   nil,
+		}
+		for id, h := range headers {
+			msg := &store.Message{
+				MsgID:          id,
+				Status:         store.StatusProcessed,
+				Timestamp:      1700000000,
+				RequestHeaders: h,
+			}
+			if err := s.SaveMSG(msg); err != nil {
+				t.Fatalf("Save failed: %v", err)
+			}
+		}
+
+		got, err := s.GetMSG(store.GetQuery{HeaderMatch: map[string]string{"X-Campaign-Id": "spring"}})
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if len(got) != 1 || got[0].MsgID != "hdr-spring" {
+			t.Fatalf("expected only hdr-spring, got %d messages", len(got))
+		}
+
+		got, err = s.GetMSG(store.GetQuery{HeaderMatch: map[string]string{"X-Campaign-Id": "spring", "User-Agent": "other"}})
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if len(got) != 0 {
+			t.Errorf("expected no messages when one header differs, got %d", len(got))
+		}
+
+		got, err = s.GetMSG(store.GetQuery{HeaderMatch: map[string]string{`X-"Campaign\Id`: "spring"}})
+		if err != nil {
+			t.Fatalf("Get with quotes in the header name failed: %v", err)
+		}
+		if len(got) != 0 {
+			t.Errorf("expected no messages for an unknown header, got %d", len(got))
+		}
+	})
+
+	t.Run(name+"/Get_FilterByTimeRange", func(t *testing.T) {
+		s := factory(t)
+		defer s.Close()
+
+		for i := range 6 {
+			status := store.StatusDelivered
+			if i%2 == 1 {
+				status = store.StatusBounce
+			}
+			msg := &store.Message{
+				MsgID:     fmt.Sprintf("time-%d", i),
+				Status:    status,
+				Timestamp: 1700000000 + int64(i)*3600,
+			}
+			if err := s.SaveMSG(msg); err != nil {
+				t.Fatalf("Save failed: %v", err)
+			}
+		}
+
+		for _, tc := range []struct {
+			query store.GetQuery
+			want  []string
+		}{
+			{store.GetQuery{Since: 1700000000 + 2*3600}, []string{"time-5", "time-4", "time-3", "time-2"}},
+			{store.GetQuery{Until: 1700000000 + 3600}, []string{"time-1", "time-0"}},
+			{store.GetQuery{Since: 1700000000 + 3600, Until: 1700000000 + 4*3600}, []string{"time-4", "time-3", "time-2", "time-1"}},
+			{store.GetQuery{Since: 1700000000 + 3600, Until: 1700000000 + 4*3600, Status: store.StatusBounce}, []string{"time-3", "time-1"}},
+			{store.GetQuery{Since: 1700000000 + 10*3600}, nil},
+		} {
+			got, err := s.GetMSG(tc.query)
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			ids := make([]string, len(got))
+			for i, m := range got {
+				ids[i] = m.MsgID
+			}
+			if !slices.Equal(ids, tc.want) {
+				t.Errorf("query %+v: expected %v, got %v", tc.query, tc.want, ids)
+			}
+		}
+	})
+
+	t.Run(name+"/Get_FilterBySendBefore", func(t *testing.T) {
+		s := factory(t)
+		defer s.Close()
+
+		for i, sendAt := range []int64{0, 1700000100, 1700000200, 1700000300} {
+			msg := &store.Message{
+				MsgID:     fmt.Sprintf("due-%d", i),
+				Status:    store.StatusProcessed,
+				Timestamp: int64(i + 1),
+				SendAt:    sendAt,
+			}
+			if err := s.SaveMSG(msg); err != nil {
+				t.Fatalf("Save failed: %v", err)
+			}
+		}
+
+		got, err := s.GetMSG(store.GetQuery{Status: store.StatusProcessed, SendBefore: 1700000200})
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		ids := make([]string, len(got))
+		for i, m := range got {
+			ids[i] = m.MsgID
+		}
+		if want := []string{"due-2", "due-1"}; !slices.Equal(ids, want) {
+			t.Errorf("expected %v, got %v", want, ids)
+		}
+	})
+
+	t.Run(name+"/Get_FilterByAPIKeyID", func(t *testing.T) {
+		s := factory(t)
+		defer s.Close()
+
+		for i, keyID := range []string{"key-a", "key-b", "key-a", ""} {
+			msg := &store.Message{
+				MsgID:     fmt.Sprintf("key-%d", i),
+				Status:    store.StatusProcessed,
+				Timestamp: int64(i + 1),
+				APIKeyID:  keyID,
+			}
+			if err := s.SaveMSG(msg); err != nil {
+				t.Fatalf("Save failed: %v", err)
+			}
+		}
+
+		got, err := s.GetMSG(store.GetQuery{APIKeyID: "key-a"})
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if len(got) != 2 || got[0].MsgID != "key-2" || got[1].MsgID != "key-0" {
+			t.Fatalf("expected key-2 and key-0, got %d messages", len(got))
+		}
+		if got[0].APIKeyID != "key-a" {
+			t.Errorf("expected APIKeyID key-a, got %q", got[0].APIKeyID)
+		}
+	})
+
+	t.Run(name+"/DistinctRecipients", func(t *testing.T) {
+		s := factory(t)
+		defer s.Close()
+
+		msgs := []*store.Message{
+			{MsgID: "r-1", FromEmail: "a@b.com", ToEmail: "x@example.com", Status: store.StatusProcessed, Timestamp: 1},
+			{MsgID: "r-2", FromEmail: "a@b.com", ToEmail: "y@example.com", Status: store.StatusProcessed, Timestamp: 2},
+			{MsgID: "r-3", FromEmail: "a@b.com", ToEmail: "x@example.com", Status: store.StatusDelivered, Timestamp: 3},
+		}
+		for _, m := range msgs {
+			if err := s.SaveMSG(m); err != nil {
+				t.Fatalf("Save failed: %v", err)
+			}
+		}
+
+		got, err := s.DistinctRecipients()
+		if err != nil {
+			t.Fatalf("DistinctRecipients failed: %v", err)
+		}
+		if len(got) != 2 || got["x@example.com"] != 2 || got["y@example.com"] != 1 {
+			t.Errorf("expected x=2 y=1, got %v", got)
+		}
+	})
+
+	t.Run(name+"/DailyStats", func(t *testing.T) {
+		s := factory(t)
+		defer s.Close()
+
+		day1 := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC).Unix()
+		day2 := time.Date(2024, 3, 2, 23, 0, 0, 0, time.UTC).Unix()
+		msgs := []*store.Message{
+			{MsgID: "d-1", FromEmail: "a@b.com", ToEmail: "x@example.com", Status: store.StatusDelivered, Timestamp: day1, OpensCount: 2, ClicksCount: 1},
+			{MsgID: "d-2", FromEmail: "a@b.com", ToEmail: "y@example.com", Status: store.StatusBounce, Timestamp: day1 + 60},
+			{MsgID: "d-3", FromEmail: "a@b.com", ToEmail: "z@example.com", Status: store.StatusDelivered, Timestamp: day2, OpensCount: 1},
+			{MsgID: "d-4", FromEmail: "a@b.com", ToEmail: "z@example.com", Status: store.StatusDelivered, Timestamp: day2 + 7200},
+		}
+		for _, m := range msgs {
+			if err := s.SaveMSG(m); err != nil {
+				t.Fatalf("Save failed: %v", err)
+			}
+		}
+
+		got, err := s.DailyStats(day1-3600, 0)
+		if err != nil {
+			t.Fatalf("DailyStats failed: %v", err)
+		}
+		want := []store.DailyStat{
+			{Date: "2024-03-01", Processed: 2, Delivered: 1, Bounces: 1, Opens: 2, Clicks: 1},
+			{Date: "2024-03-02", Processed: 1, Delivered: 1, Opens: 1},
+			{Date: "2024-03-03", Processed: 1, Delivered: 1},
+		}
+		if len(got) != len(want) {
+			t.Fatalf("expected %d days, got %+v", len(want), got)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("day %d: expected %+v, got %+v", i, want[i], got[i])
+			}
+		}
+
+		bounded, err := s.DailyStats(day1, day2)
+		if err != nil {
+			t.Fatalf("DailyStats failed: %v", err)
+		}
+		if len(bounded) != 1 || bounded[0].Date != "2024-03-01" {
+			t.Errorf("expected only 2024-03-01 within bounds, got %+v", bounded)
+		}
+	})
+
+	t.Run(name+"/Count", func(t *testing.T) {
+		s := factory(t)
+		defer s.Close()
+
+		if n, err := s.Count(); err != nil || n != 0 {
+			t.Fatalf("expected empty store, got %d (err %v)", n, err)
+		}
+		for _, id := range []string{"c-1", "c-2"} {
+			msg := &store.Message{MsgID: id, FromEmail: "a@b.com", ToEmail: "c@d.com", Status: store.StatusProcessed, Timestamp: 1}
+			if err := s.SaveMSG(msg); err != nil {
+				t.Fatalf("Save failed: %v", err)
+			}
+		}
+		// Updating an existing message must not change the count
+		update := &store.Message{MsgID: "c-1", FromEmail: "a@b.com", ToEmail: "c@d.com", Status: store.StatusDelivered, Timestamp: 1}
+		if err := s.SaveMSG(update); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+
+		n, err := s.Count()
+		if err != nil {
+			t.Fatalf("Count failed: %v", err)
+		}
+		if n != 2 {
+			t.Errorf("expected 2 messages, got %d", n)
+		}
+	})
+
+	t.Run(name+"/Iterate", func(t *testing.T) {
+		s := factory(t)
+		defer s.Close()
+
+		for _, id := range []string{"it-1", "it-2", "it-3"} {
+			msg := &store.Message{MsgID: id, FromEmail: "a@b.com", ToEmail: "c@d.com", Status: store.StatusProcessed, Timestamp: 1}
+			if err := s.SaveMSG(msg); err != nil {
+				t.Fatalf("Save failed: %v", err)
+			}
+		}
+
+		seen := map[string]bool{}
+		err := s.Iterate(func(m *store.Message) bool {
+			seen[m.MsgID] = true
+			return true
+		})
+		if err != nil {
+			t.Fatalf("Iterate failed: %v", err)
+		}
+		if len(seen) != 3 {
+			t.Errorf("expected 3 messages visited, got %v", seen)
+		}
+
+		visited := 0
+		err = s.Iterate(func(*store.Message) bool {
+			visited++
+			return false
+		})
+		if err != nil {
+			t.Fatalf("Iterate failed: %v", err)
+		}
+		if visited != 1 {
+			t.Errorf("expected iteration to stop after 1 message, visited %d", visited)
+		}
+	})
+
 	t.Run(name+"/Close_Idempotent", func(t *testing.T) {
 		s := factory(t)
 
@@ -198,10 +562,13 @@ func RunStoreContractTests(t *testing.T, name string, factory StoreFactory) {
 		msg := &store.Message{
 			MsgID:         "full-msg",
 			FromEmail:     "sender@example.com",
+			FromName:      "Sender Name",
 			ToEmail:       "recipient@example.com",
+			ToName:        "Recipient Name",
 			Subject:       "Test Subject",
 			HTMLBody:      "<html><body>Hello</body></html>",
 			TextBody:      "Hello",
+			AMPBody:       "<html amp4email><body>Hello</body></html>",
 			Status:        store.StatusDelivered,
 			SMTPResponse:  "250 OK",
 			Reason:        "",
@@ -209,6 +576,19 @@ func RunStoreContractTests(t *testing.T, name string, factory StoreFactory) {
 			LastEventTime: 1700000001,
 			OpensCount:    5,
 			ClicksCount:   2,
+			Attachments: []store.AttachmentMeta{
+				{Filename: "logo.png", Type: "image/png", Size: 1024, Disposition: "inline", ContentID: "logo"},
+			},
+			ThreadKey:      "thread-1",
+			IPPool:         "transactional",
+			RequestHeaders: map[string]string{"User-Agent": "sendgrid/6.0;go"},
+			SendAt:         1700000600,
+			Scheduled: &store.ScheduledMail{
+				Group:      "group-1",
+				From:       "sender@example.com",
+				Recipients: []string{"recipient@example.com", "cc@example.com"},
+				Raw:        []byte("Subject: Test Subject\r\n\r\nHello\r\n"),
+			},
 		}
 
 		if err := s.SaveMSG(msg); err != nil {
@@ -224,6 +604,9 @@ func RunStoreContractTests(t *testing.T, name string, factory StoreFactory) {
 		}
 
 		g := got[0]
+		if g.FromName != msg.FromName || g.ToName != msg.ToName {
+			t.Errorf("Names: expected %q/%q, got %q/%q", msg.FromName, msg.ToName, g.FromName, g.ToName)
+		}
 		if g.Subject != msg.Subject {
 			t.Errorf("Subject: expected %q, got %q", msg.Subject, g.Subject)
 		}
@@ -233,6 +616,9 @@ func RunStoreContractTests(t *testing.T, name string, factory StoreFactory) {
 		if g.TextBody != msg.TextBody {
 			t.Errorf("TextBody: expected %q, got %q", msg.TextBody, g.TextBody)
 		}
+		if g.AMPBody != msg.AMPBody {
+			t.Errorf("AMPBody: expected %q, got %q", msg.AMPBody, g.AMPBody)
+		}
 		if g.SMTPResponse != msg.SMTPResponse {
 			t.Errorf("SMTPResponse: expected %q, got %q", msg.SMTPResponse, g.SMTPResponse)
 		}
@@ -248,5 +634,25 @@ func RunStoreContractTests(t *testing.T, name string, factory StoreFactory) {
 		if g.ClicksCount != msg.ClicksCount {
 			t.Errorf("ClicksCount: expected %d, got %d", msg.ClicksCount, g.ClicksCount)
 		}
+		if len(g.Attachments) != 1 || g.Attachments[0] != msg.Attachments[0] {
+			t.Errorf("Attachments: expected %+v, got %+v", msg.Attachments, g.Attachments)
+		}
+		if g.ThreadKey != msg.ThreadKey {
+			t.Errorf("ThreadKey: expected %q, got %q", msg.ThreadKey, g.ThreadKey)
+		}
+		if g.IPPool != msg.IPPool {
+			t.Errorf("IPPool: expected %q, got %q", msg.IPPool, g.IPPool)
+		}
+		if g.SendAt != msg.SendAt {
+			t.Errorf("SendAt: expected %d, got %d", msg.SendAt, g.SendAt)
+		}
+		if len(g.RequestHeaders) != 1 || g.RequestHeaders["User-Agent"] != "sendgrid/6.0;go" {
+			t.Errorf("RequestHeaders: expected %v, got %v", msg.RequestHeaders, g.RequestHeaders)
+		}
+		if g.Scheduled == nil || g.Scheduled.Group != msg.Scheduled.Group ||
+			!slices.Equal(g.Scheduled.Recipients, msg.Scheduled.Recipients) ||
+			string(g.Scheduled.Raw) != string(msg.Scheduled.Raw) {
+			t.Errorf("Scheduled: expected %+v, got %+v", msg.Scheduled, g.Scheduled)
+		}
 	})
 }
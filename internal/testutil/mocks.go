@@ -168,6 +168,8 @@ type MockMessageStore struct {
 	GetErr   error
 }
 
+var _ store.MessageStore = (*MockMessageStore)(nil)
+
 // NewMockMessageStore creates an empty MockMessageStore.
 func NewMockMessageStore() *MockMessageStore {
 	return &MockMessageStore{
@@ -208,16 +210,78 @@ func (m *MockMessageStore) GetMSG(q store.GetQuery) ([]*store.Message, error) {
 		if q.Status != "" && msg.Status != q.Status {
 			continue
 		}
+		if q.APIKeyID != "" && msg.APIKeyID != q.APIKeyID {
+			continue
+		}
+		if !store.InTimeRange(msg.Timestamp, q.Since, q.Until) {
+			continue
+		}
+		if !store.DueBy(msg.SendAt, q.SendBefore) {
+			continue
+		}
+		if !store.MatchesHeaders(msg, q.HeaderMatch) {
+			continue
+		}
 		cp := *msg
 		result = append(result, &cp)
 	}
 
+	store.SortNewestFirst(result)
+	if q.Offset >= len(result) {
+		return []*store.Message{}, nil
+	}
+	result = result[q.Offset:]
 	if q.Limit > 0 && len(result) > q.Limit {
 		result = result[:q.Limit]
 	}
 	return result, nil
 }
 
+// DistinctRecipients counts stored messages per recipient address.
+func (m *MockMessageStore) DistinctRecipients() (map[string]int, error) {
+	if m.GetErr != nil {
+		return nil, m.GetErr
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts := make(map[string]int)
+	for _, msg := range m.messages {
+		counts[msg.ToEmail]++
+	}
+	return counts, nil
+}
+
+// DailyStats aggregates stored messages by day.
+func (m *MockMessageStore) DailyStats(from, to int64) ([]store.DailyStat, error) {
+	if m.GetErr != nil {
+		return nil, m.GetErr
+	}
+	return store.AggregateDaily(m.Messages(), from, to), nil
+}
+
+// Count returns the number of stored messages.
+func (m *MockMessageStore) Count() (int, error) {
+	if m.GetErr != nil {
+		return 0, m.GetErr
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.messages), nil
+}
+
+// Iterate calls fn with a copy of each stored message.
+func (m *MockMessageStore) Iterate(fn func(*store.Message) bool) error {
+	if m.GetErr != nil {
+		return m.GetErr
+	}
+	for _, msg := range m.Messages() {
+		if !fn(msg) {
+			return nil
+		}
+	}
+	return nil
+}
+
 // Close is a no-op for the mock.
 func (m *MockMessageStore) Close() error {
 	return nil
@@ -241,3 +305,156 @@ func (m *MockMessageStore) Reset() {
 	defer m.mu.Unlock()
 	m.messages = make(map[string]*store.Message)
 }
+
+// --- MockWebhookStore implements store.WebhookStore ---
+
+// MockWebhookStore is an in-memory mock for store.WebhookStore.
+type MockWebhookStore struct {
+	mu    sync.Mutex
+	hooks map[string]*store.WebhookConfig
+}
+
+var _ store.WebhookStore = (*MockWebhookStore)(nil)
+
+// NewMockWebhookStore creates a MockWebhookStore seeded with the given webhooks.
+func NewMockWebhookStore(hooks ...*store.WebhookConfig) *MockWebhookStore {
+	m := &MockWebhookStore{hooks: make(map[string]*store.WebhookConfig)}
+	for _, h := range hooks {
+		cp := *h
+		m.hooks[h.ID] = &cp
+	}
+	return m
+}
+
+// Create stores a new webhook in memory.
+func (m *MockWebhookStore) Create(hook *store.WebhookConfig) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cp := *hook
+	m.hooks[hook.ID] = &cp
+	return nil
+}
+
+// GetWebhook retrieves a webhook by ID or returns store.ErrNotFound.
+func (m *MockWebhookStore) GetWebhook(id string) (*store.WebhookConfig, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hook, ok := m.hooks[id]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	cp := *hook
+	return &cp, nil
+}
+
+// ListWebhooks returns all webhooks.
+func (m *MockWebhookStore) ListWebhooks() ([]*store.WebhookConfig, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := make([]*store.WebhookConfig, 0, len(m.hooks))
+	for _, hook := range m.hooks {
+		cp := *hook
+		result = append(result, &cp)
+	}
+	return result, nil
+}
+
+// ListEnabledWebhooks returns all enabled webhooks.
+func (m *MockWebhookStore) ListEnabledWebhooks() ([]*store.WebhookConfig, error) {
+	all, _ := m.ListWebhooks()
+	var result []*store.WebhookConfig
+	for _, hook := range all {
+		if hook.Enabled {
+			result = append(result, hook)
+		}
+	}
+	return result, nil
+}
+
+// UpdateWebhook replaces an existing webhook, returning store.ErrNotFound or,
+// when hook.UpdatedAt is stale, store.ErrConflict.
+func (m *MockWebhookStore) UpdateWebhook(hook *store.WebhookConfig) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current, ok := m.hooks[hook.ID]
+	if !ok {
+		return store.ErrNotFound
+	}
+	if current.UpdatedAt != hook.UpdatedAt {
+		return store.ErrConflict
+	}
+	hook.UpdatedAt = store.NextUpdatedAt(hook.UpdatedAt)
+	cp := *hook
+	m.hooks[hook.ID] = &cp
+	return nil
+}
+
+// DeleteWebhook removes a webhook or returns store.ErrNotFound.
+func (m *MockWebhookStore) DeleteWebhook(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.hooks[id]; !ok {
+		return store.ErrNotFound
+	}
+	delete(m.hooks, id)
+	return nil
+}
+
+// DeleteAllWebhooks removes every webhook.
+func (m *MockWebhookStore) DeleteAllWebhooks() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clear(m.hooks)
+	return nil
+}
+
+// Close is a no-op for the mock.
+func (m *MockWebhookStore) Close() error {
+	return nil
+}
+
+// --- RecordingDispatcher implements store.EventDispatcher ---
+
+// DispatchedEvent is a single call captured by RecordingDispatcher.
+type DispatchedEvent struct {
+	MsgID   string
+	Email   string
+	From    string
+	Subject string
+	Status  string
+	Reason  string
+	Pool    string
+}
+
+// RecordingDispatcher records every dispatched event for later assertions.
+type RecordingDispatcher struct {
+	mu     sync.Mutex
+	events []DispatchedEvent
+}
+
+// NewRecordingDispatcher creates an empty RecordingDispatcher.
+func NewRecordingDispatcher() *RecordingDispatcher {
+	return &RecordingDispatcher{}
+}
+
+// DispatchMessageEvent records the event.
+func (d *RecordingDispatcher) DispatchMessageEvent(msgID, email, from, subject string, status string, reason string, pool string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.events = append(d.events, DispatchedEvent{
+		MsgID:   msgID,
+		Email:   email,
+		From:    from,
+		Subject: subject,
+		Status:  status,
+		Reason:  reason,
+		Pool:    pool,
+	})
+}
+
+// Events returns a copy of the recorded events in dispatch order.
+func (d *RecordingDispatcher) Events() []DispatchedEvent {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]DispatchedEvent(nil), d.events...)
+}
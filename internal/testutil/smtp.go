@@ -0,0 +1,137 @@
+package testutil
+
+import (
+	"bufio"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// StartSMTPServer starts a minimal SMTP server that accepts every message and
+// discards it. It returns the host and port to send to and is closed when the
+// test finishes.
+func StartSMTPServer(t *testing.T) (string, int) {
+	t.Helper()
+	return startSMTPServer(t, func(string) {}, func(ReceivedMail) {})
+}
+
+// StartRecordingSMTPServer starts a server like StartSMTPServer that also
+// records the address of every RCPT TO command. rcpts returns the addresses
+// received so far.
+func StartRecordingSMTPServer(t *testing.T) (host string, port int, rcpts func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var got []string
+	host, port = startSMTPServer(t, func(addr string) {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, addr)
+	}, func(ReceivedMail) {})
+	return host, port, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(got)
+	}
+}
+
+// ReceivedMail is a message accepted by a capturing SMTP server.
+type ReceivedMail struct {
+	Rcpts []string // RCPT TO addresses of the transaction
+	Data  string   // message content as sent, without the final dot
+}
+
+// StartCapturingSMTPServer starts a server like StartSMTPServer that also
+// keeps every message it accepts. mails returns the messages received so far.
+func StartCapturingSMTPServer(t *testing.T) (host string, port int, mails func() []ReceivedMail) {
+	t.Helper()
+	var mu sync.Mutex
+	var got []ReceivedMail
+	host, port = startSMTPServer(t, func(string) {}, func(m ReceivedMail) {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, m)
+	})
+	return host, port, func() []ReceivedMail {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(got)
+	}
+}
+
+func startSMTPServer(t *testing.T, rcpt func(addr string), deliver func(ReceivedMail)) (string, int) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, rcpt, deliver)
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+// serveSMTP answers a single SMTP session with success replies, passing the
+// address of each RCPT TO command to rcpt and each message to deliver.
+func serveSMTP(conn net.Conn, rcpt func(addr string), deliver func(ReceivedMail)) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) bool {
+		_, err := conn.Write([]byte(line + "\r\n"))
+		return err == nil
+	}
+
+	if !reply("220 localhost ESMTP mock") {
+		return
+	}
+	var rcpts []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case cmd == "DATA":
+			reply("354 end data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if strings.TrimRight(l, "\r\n") == "." {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, "."))
+			}
+			deliver(ReceivedMail{Rcpts: rcpts, Data: data.String()})
+			rcpts = nil
+			reply("250 OK queued")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			addr := strings.Trim(strings.TrimSpace(strings.TrimSpace(line)[len("RCPT TO:"):]), "<>")
+			rcpt(addr)
+			rcpts = append(rcpts, addr)
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}